@@ -0,0 +1,56 @@
+package s3treeclone
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+)
+
+// Metadata keys recording which machine and run uploaded an object, with Annotate. They describe
+// the upload rather than the file, so they aren't compared against the source.
+const (
+	sourceHostMetadataKey = "source-host"
+	runIDMetadataKey      = "run-id"
+)
+
+// newRunID returns a random (version 4) UUID identifying a run.
+func newRunID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
+
+// setAnnotations records the host name and a new run ID to add to the metadata of each uploaded
+// object.
+func (stc *Cloner) setAnnotations() error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Unable to get the host name: %w", err)
+	}
+
+	runID, err := newRunID()
+	if err != nil {
+		return fmt.Errorf("Unable to generate a run ID: %w", err)
+	}
+
+	stc.sourceHost = hostname
+	stc.runID = runID
+	return nil
+}
+
+// addAnnotations adds the source host and run ID to the metadata of an object being uploaded, if
+// Annotate is in effect.
+func (stc *Cloner) addAnnotations(metadata map[string]string) {
+	if stc.runID == "" {
+		return
+	}
+
+	metadata[sourceHostMetadataKey] = stc.sourceHost
+	metadata[runIDMetadataKey] = stc.runID
+}
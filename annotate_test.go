@@ -0,0 +1,91 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestNewRunID(t *testing.T) {
+	uuidPattern := regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+
+	first, err := newRunID()
+	if err != nil {
+		t.Fatalf("newRunID failed: %v", err)
+	}
+
+	second, err := newRunID()
+	if err != nil {
+		t.Fatalf("newRunID failed: %v", err)
+	}
+
+	if !uuidPattern.MatchString(first) || !uuidPattern.MatchString(second) {
+		t.Errorf("Expected version 4 UUIDs: %#v %#v", first, second)
+	}
+
+	if first == second {
+		t.Errorf("Expected different run IDs: %#v", first)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-annotate-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"a.txt", "b.txt"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("Failed to get host name: %v", err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-annotate", tmpDir, "s3://hello"}, client, 0, nil, nil)
+
+	if len(bucket.Objects) != 3 {
+		t.Fatalf("Expected 3 objects: %d", len(bucket.Objects))
+	}
+
+	runID := ""
+	for key, object := range bucket.Objects {
+		if object.Metadata[sourceHostMetadataKey] != hostname {
+			t.Errorf("Expected source-host %#v for %s: %#v", hostname, key, object.Metadata[sourceHostMetadataKey])
+		}
+
+		if runID == "" {
+			runID = object.Metadata[runIDMetadataKey]
+		}
+
+		if object.Metadata[runIDMetadataKey] == "" || object.Metadata[runIDMetadataKey] != runID {
+			t.Errorf("Expected every object to have the same run-id: %s has %#v", key, object.Metadata[runIDMetadataKey])
+		}
+
+		// Pretend the object was uploaded elsewhere.
+		object.Metadata[sourceHostMetadataKey] = "elsewhere"
+	}
+
+	// The annotations aren't compared, so nothing is re-uploaded with or without -annotate.
+	runExpect(t, []string{"-annotate", tmpDir, "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0\n"))
+	runExpect(t, []string{tmpDir, "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0\n"))
+
+	// Without -annotate, uploaded objects aren't annotated.
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	runExpect(t, []string{tmpDir, "s3://hello"}, client, 0, nil, nil)
+
+	for key, object := range bucket.Objects {
+		if _, found := object.Metadata[runIDMetadataKey]; found {
+			t.Errorf("Expected no run-id for %s", key)
+		}
+	}
+}
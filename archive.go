@@ -0,0 +1,23 @@
+package s3treeclone
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// isArchived determines whether an object is in an archive storage class and hasn't been restored,
+// so its content can't be read. Its metadata can still be compared, but anything that reads the
+// object, such as CopyObject, fails until it is restored.
+func isArchived(hoo *s3.HeadObjectOutput) bool {
+	switch hoo.StorageClass {
+	case s3Types.StorageClassGlacier, s3Types.StorageClassDeepArchive:
+	default:
+		return false
+	}
+
+	// A restored copy is available once the restore is no longer ongoing.
+	return !strings.Contains(aws.ToString(hoo.Restore), `ongoing-request="false"`)
+}
@@ -0,0 +1,64 @@
+package s3treeclone
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestArchivedObjects(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-archived-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.bin"
+	err = ioutil.WriteFile(pathname, []byte("aaaaabbbbbccccc"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	if err = os.Chmod(pathname, 0644); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	args := []string{"-verify-parts", "-v", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	// The part checksums don't match, but the object is archived, so they aren't compared. Its
+	// metadata still is.
+	corrupted := [][]byte{[]byte("aaaaa"), []byte("bbbbB"), []byte("ccccc")}
+	object := bucket.Objects["hello.bin"]
+	makeMultipart(object, []int64{5, 5, 5}, &s3Types.Checksum{ChecksumSHA256: aws.String(compositeChecksum(sha256.New, corrupted))})
+	object.StorageClass = s3Types.StorageClassDeepArchive
+	runExpect(t, args, client, 0, []byte("Skipping part checksum verification of s3://hello/hello.bin: archived in DEEP_ARCHIVE storage"), []byte("Objects uploaded:    0"))
+
+	// While a restore is in progress, the object still can't be read.
+	object.Restore = aws.String(`ongoing-request="true"`)
+	runExpect(t, args, client, 0, []byte("Skipping part checksum verification"), []byte("Objects uploaded:    0"))
+
+	// Once it's restored, the checksums are compared.
+	object.Restore = aws.String(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+	runExpect(t, args, client, 0, nil, []byte("Part checksums differ for s3://hello/hello.bin"))
+
+	// When only the metadata of an archived object differs, it's uploaded again rather than copied
+	// onto itself, which S3 doesn't allow.
+	object = bucket.Objects["hello.bin"]
+	object.StorageClass = s3Types.StorageClassGlacier
+	if err = os.Chmod(pathname, 0600); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	nPuts := len(client.putInputs)
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	if len(client.copyInputs) != 0 || len(client.putInputs) != nPuts+1 {
+		t.Errorf("Expected the archived object to be uploaded again: %d copies, %d puts", len(client.copyInputs), len(client.putInputs)-nPuts)
+	}
+}
@@ -0,0 +1,167 @@
+package s3treeclone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// fatalS3ErrorCodes are the S3 error codes that indicate every request will fail the same way,
+// such as bad or expired credentials, missing permissions, or the wrong bucket or region.
+var fatalS3ErrorCodes = map[string]bool{
+	"AccessDenied":                 true,
+	"AccountProblem":               true,
+	"AllAccessDisabled":            true,
+	"AuthorizationHeaderMalformed": true,
+	"ExpiredToken":                 true,
+	"InvalidAccessKeyId":           true,
+	"InvalidToken":                 true,
+	"NoSuchBucket":                 true,
+	"PermanentRedirect":            true,
+	"SignatureDoesNotMatch":        true,
+	"TokenRefreshRequired":         true,
+}
+
+// isFatalS3Error determines whether an S3 error is one that retrying, or moving on to the next
+// object, won't fix.
+func isFatalS3Error(err error) bool {
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) && fatalS3ErrorCodes[apiError.ErrorCode()] {
+		return true
+	}
+
+	var responseError *awshttp.ResponseError
+	if errors.As(err, &responseError) {
+		status := responseError.HTTPStatusCode()
+		return status == http.StatusUnauthorized || status == http.StatusForbidden
+	}
+
+	return false
+}
+
+// isRetryableS3Error determines whether an S3 error is transient, such as throttling or a server
+// error, and says nothing about whether later requests will succeed.
+func isRetryableS3Error(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// recordS3Result updates the count of consecutive fatal S3 errors with the result of a request,
+// aborting the clone once it reaches the limit. Transient errors leave the count unchanged.
+func (stc *Cloner) recordS3Result(err error) {
+	switch {
+	case err == nil:
+		atomic.StoreInt64(&stc.nConsecutiveFailures, 0)
+	case isFatalS3Error(err):
+		if atomic.AddInt64(&stc.nConsecutiveFailures, 1) >= int64(stc.failureLimit) {
+			stc.abort(fmt.Errorf("Aborted after %d consecutive S3 failures: %w", stc.failureLimit, err), "Aborting: %d consecutive S3 requests failed: %v\n", stc.failureLimit, err)
+		}
+	case isRetryableS3Error(err) || errors.Is(err, context.Canceled):
+		// Throttling and server errors don't show whether requests can succeed.
+	default:
+		atomic.StoreInt64(&stc.nConsecutiveFailures, 0)
+	}
+}
+
+// abort stops the clone, such as after too many consecutive fatal S3 errors: requests in flight
+// are canceled and no further files are examined. The clone returns abortErr, and the message is
+// written to stderr. Only the first call has any effect.
+func (stc *Cloner) abort(abortErr error, format string, args ...interface{}) {
+	stc.abortOnce.Do(func() {
+		stc.abortErr = abortErr
+		stc.printf(stc.stderr, levelError, format, args...)
+		stc.cancel()
+	})
+}
+
+// circuitBreaker wraps an S3 client, passing the result of each request to the Cloner so a run that
+// can't succeed, such as one with expired credentials, is aborted early.
+type circuitBreaker struct {
+	S3Interface
+	stc *Cloner
+}
+
+func (cb *circuitBreaker) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	output, err := cb.S3Interface.AbortMultipartUpload(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	output, err := cb.S3Interface.CompleteMultipartUpload(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) CopyObject(ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	output, err := cb.S3Interface.CopyObject(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	output, err := cb.S3Interface.CreateMultipartUpload(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	output, err := cb.S3Interface.DeleteObjects(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, opts ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	output, err := cb.S3Interface.GetBucketLocation(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	output, err := cb.S3Interface.GetObject(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) GetObjectAttributes(ctx context.Context, input *s3.GetObjectAttributesInput, opts ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	output, err := cb.S3Interface.GetObjectAttributes(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) GetObjectLockConfiguration(ctx context.Context, input *s3.GetObjectLockConfigurationInput, opts ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	output, err := cb.S3Interface.GetObjectLockConfiguration(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	output, err := cb.S3Interface.HeadObject(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	output, err := cb.S3Interface.ListObjectsV2(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	output, err := cb.S3Interface.PutObject(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
+
+func (cb *circuitBreaker) UploadPart(ctx context.Context, input *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	output, err := cb.S3Interface.UploadPart(ctx, input, opts...)
+	cb.stc.recordS3Result(err)
+	return output, err
+}
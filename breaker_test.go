@@ -0,0 +1,171 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// failingClient fails every HeadObject and PutObject request with the error returned by makeError.
+type failingClient struct {
+	*s3TestClient
+	makeError func(operation string) error
+	nRequests int64
+}
+
+func (c *failingClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	atomic.AddInt64(&c.nRequests, 1)
+	return nil, c.makeError("HeadObject")
+}
+
+func (c *failingClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	atomic.AddInt64(&c.nRequests, 1)
+	return nil, c.makeError("PutObject")
+}
+
+func forbidden(operation string) error {
+	return makeS3Error(operation, 403, "Forbidden", "AccessDenied", "Access Denied")
+}
+
+func slowDown(operation string) error {
+	return makeS3Error(operation, 503, "Service Unavailable", "SlowDown", "Please reduce your request rate.")
+}
+
+func TestIsFatalS3Error(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		fatal bool
+	}{
+		{forbidden("PutObject"), true},
+		{makeS3Error("HeadObject", 403, "Forbidden", "Forbidden", "Forbidden"), true},
+		{makeS3Error("PutObject", 400, "Bad Request", "ExpiredToken", "The provided token has expired."), true},
+		{makeS3Error("ListObjectsV2", 404, "Not Found", "NoSuchBucket", "The specified bucket does not exist"), true},
+		{makeS3Error("HeadObject", 404, "Not Found", "NotFound", "Not Found"), false},
+		{slowDown("PutObject"), false},
+		{errors.New("connection reset"), false},
+	} {
+		if fatal := isFatalS3Error(tc.err); fatal != tc.fatal {
+			t.Errorf("isFatalS3Error(%v): expected %v, got %v", tc.err, tc.fatal, fatal)
+		}
+	}
+}
+
+func TestFailureLimit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-failure-limit-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	const nFiles = 50
+	for i := 0; i < nFiles; i++ {
+		filename := fmt.Sprintf("%s/file-%d.txt", tmpDir, i)
+		if err = ioutil.WriteFile(filename, []byte("hello"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s: %v", filename, err)
+		}
+	}
+
+	// Every request is denied, so the run stops after the fifth.
+	client := &failingClient{s3TestClient: newS3TestClient(), makeError: forbidden}
+	client.createBucket("hello")
+	args := []string{"-walk-workers", "1", "-max-consecutive-failures", "5", tmpDir + "/", "s3://hello"}
+	result, _, stderr := runCapture(args, client)
+
+	if result != 1 {
+		t.Errorf("Expected returncode 1, got %d", result)
+	}
+
+	if !bytes.Contains(stderr, []byte("Aborting: 5 consecutive S3 requests failed")) || !bytes.Contains(stderr, []byte("Aborted after 5 consecutive S3 failures")) {
+		t.Errorf("Expected the run to be aborted: %#v", string(stderr))
+	}
+
+	if nRequests := atomic.LoadInt64(&client.nRequests); nRequests > 10 {
+		t.Errorf("Expected the run to stop after 5 failed requests, got %d", nRequests)
+	}
+
+	// With the limit disabled, every file is attempted.
+	client = &failingClient{s3TestClient: newS3TestClient(), makeError: forbidden}
+	client.createBucket("hello")
+	args = []string{"-walk-workers", "1", "-max-consecutive-failures", "0", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 1, nil, []byte(fmt.Sprintf("%d of %d objects failed", nFiles, nFiles)))
+
+	// Throttling doesn't count toward the limit.
+	client = &failingClient{s3TestClient: newS3TestClient(), makeError: slowDown}
+	client.createBucket("hello")
+	args = []string{"-walk-workers", "1", "-max-consecutive-failures", "5", tmpDir + "/", "s3://hello"}
+	result, _, stderr = runCapture(args, client)
+
+	if result != 1 || bytes.Contains(stderr, []byte("Aborting")) {
+		t.Errorf("Expected throttled requests not to abort the run: returncode %d, stderr %#v", result, string(stderr))
+	}
+
+	if nRequests := atomic.LoadInt64(&client.nRequests); nRequests < 2*nFiles {
+		t.Errorf("Expected every file to be attempted, got %d requests", nRequests)
+	}
+
+	runExpect(t, []string{"-max-consecutive-failures", "-1", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -max-consecutive-failures value: -1"))
+}
+
+func TestStopOnError(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-stop-on-error-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	const nFiles = 20
+	for i := 0; i < nFiles; i++ {
+		filename := fmt.Sprintf("%s/file%02d.txt", tmpDir, i)
+		if err = ioutil.WriteFile(filename, []byte("hello"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s: %v", filename, err)
+		}
+	}
+
+	// Root can read anything, so use a dangling symbolic link that's followed instead.
+	unreadable := tmpDir + "/unreadable.txt"
+	expectedErr := "Unable to open " + unreadable
+	args := []string{"-walk-workers", "1", "-max-concurrent", "1"}
+	if os.Geteuid() == 0 {
+		err = os.Symlink("missing.txt", unreadable)
+		expectedErr = "Unable to follow symbolic link " + unreadable
+		args = append(args, "-links", "follow")
+	} else {
+		err = ioutil.WriteFile(unreadable, []byte("hello"), 0)
+	}
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", unreadable, err)
+	}
+
+	// By default, the failure is counted and every other file is uploaded.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, append(args, tmpDir+"/", "s3://hello"), client, 1, nil, []byte(expectedErr))
+	if len(bucket.Objects) != nFiles {
+		t.Errorf("Expected %d objects to be uploaded, got %d", nFiles, len(bucket.Objects))
+	}
+
+	result, _, stderr := runCapture(append(args, tmpDir+"/", "s3://hello"), client)
+	if result != 1 || !bytes.Contains(stderr, []byte("Objects failed:      1")) || bytes.Contains(stderr, []byte("Stopping")) {
+		t.Errorf("Expected the failure to be counted: returncode %d, stderr %#v", result, string(stderr))
+	}
+
+	// With -stop-on-error, the run stops at the failure.
+	client = newS3TestClient()
+	client.createBucket("hello")
+	args = append(args, "-stop-on-error", tmpDir+"/", "s3://hello")
+	result, _, stderr = runCapture(args, client)
+	if result != 1 || !bytes.Contains(stderr, []byte("Stopping at the first failure (-stop-on-error): "+unreadable)) || !bytes.Contains(stderr, []byte("Stopped after the first failure")) {
+		t.Errorf("Expected the run to stop: returncode %d, stderr %#v", result, string(stderr))
+	}
+
+	if bytes.Contains(stderr, []byte("Summary:")) {
+		t.Errorf("Expected no summary for a stopped run: %#v", string(stderr))
+	}
+}
@@ -0,0 +1,47 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSizeUnits maps the suffixes accepted by parseByteSize to their multipliers. Single-letter
+// and IEC suffixes are powers of 1024; SI suffixes are powers of 1000.
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kib": 1 << 10,
+	"kb":  1000,
+	"m":   1 << 20,
+	"mib": 1 << 20,
+	"mb":  1000 * 1000,
+	"g":   1 << 30,
+	"gib": 1 << 30,
+	"gb":  1000 * 1000 * 1000,
+	"t":   1 << 40,
+	"tib": 1 << 40,
+	"tb":  1000 * 1000 * 1000 * 1000,
+}
+
+// parseByteSize parses a human-readable byte count such as "16MiB", "1.5G", or "1000000".
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	numEnd := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if numEnd == -1 {
+		numEnd = len(s)
+	}
+
+	multiplier, found := byteSizeUnits[strings.ToLower(strings.TrimSpace(s[numEnd:]))]
+	if !found || numEnd == 0 {
+		return 0, fmt.Errorf("Invalid byte size: %s", s)
+	}
+
+	value, err := strconv.ParseFloat(s[:numEnd], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid byte size: %s", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
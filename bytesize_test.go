@@ -0,0 +1,31 @@
+package s3treeclone
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected int64
+	}{
+		{"0", 0},
+		{"1000", 1000},
+		{"512B", 512},
+		{"16MiB", 16 * 1024 * 1024},
+		{"16M", 16 * 1024 * 1024},
+		{"16mb", 16 * 1000 * 1000},
+		{"1.5GiB", 1536 * 1024 * 1024},
+		{"2 KiB", 2048},
+		{"1T", 1 << 40},
+	} {
+		value, err := parseByteSize(tc.s)
+		if err != nil || value != tc.expected {
+			t.Errorf("Expected %#v to parse as %d: %d %v", tc.s, tc.expected, value, err)
+		}
+	}
+
+	for _, s := range []string{"", "MiB", "16XB", "1.2.3M", "-5M"} {
+		if _, err := parseByteSize(s); err == nil {
+			t.Errorf("Expected %#v to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package s3treeclone
+
+import (
+	"os"
+)
+
+// fileChanged reports whether the open file's size or modification time differs from stat, which
+// was taken before the file was read. If the file can't be stat'd, it's assumed to be unchanged.
+func fileChanged(fd *os.File, stat *fileStat) bool {
+	fileinfo, err := fd.Stat()
+	if err != nil {
+		return false
+	}
+
+	current := getFileStat(fileinfo)
+	return current.Size != stat.Size || current.Mtime != stat.Mtime
+}
+
+// markChanged notes that a file changed while it was being uploaded. It returns false if the file
+// has already been retried once, in which case it should be treated as a failure instead.
+func (stc *Cloner) markChanged(pathname string) bool {
+	stc.changedMutex.Lock()
+	defer stc.changedMutex.Unlock()
+
+	if _, found := stc.changedFiles[pathname]; found {
+		return false
+	}
+
+	stc.changedFiles[pathname] = true
+	return true
+}
+
+// takeChanged reports whether a file was marked as changed and is waiting to be retried. The file
+// is only retried once.
+func (stc *Cloner) takeChanged(pathname string) bool {
+	stc.changedMutex.Lock()
+	defer stc.changedMutex.Unlock()
+
+	if !stc.changedFiles[pathname] {
+		return false
+	}
+
+	stc.changedFiles[pathname] = false
+	return true
+}
+
+// queueRetry queues a file that changed while it was being uploaded to be handled again once the
+// walk has sent the workers everything else queued so far. Like queueDir, this never blocks.
+func (stc *Cloner) queueRetry(job walkJob) {
+	stc.queueMutex.Lock()
+	stc.nPending++
+	stc.queuedRetries = append(stc.queuedRetries, job)
+	stc.queueMutex.Unlock()
+	stc.queueCond.Broadcast()
+}
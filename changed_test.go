@@ -0,0 +1,73 @@
+package s3treeclone
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// growingClient appends to a file while the first nGrowths objects are being uploaded, as if it
+// were still being written.
+type growingClient struct {
+	*s3TestClient
+	pathname string
+	nGrowths int64
+}
+
+func (c *growingClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if atomic.AddInt64(&c.nGrowths, -1) >= 0 {
+		fd, err := os.OpenFile(c.pathname, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = fd.Write([]byte(" world"))
+		fd.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.s3TestClient.PutObject(ctx, input, opts...)
+}
+
+func TestFileChangedDuringUpload(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-changed-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.log"
+	writeHello := func() {
+		if err := ioutil.WriteFile(pathname, []byte("hello"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s: %v", pathname, err)
+		}
+	}
+
+	// Without -retry-changed, the file is reported as a failure.
+	writeHello()
+	client := &growingClient{s3TestClient: newS3TestClient(), pathname: pathname, nGrowths: 1}
+	client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Warning: "+pathname+" changed while it was being uploaded"))
+
+	// With -retry-changed, it's uploaded again once it stops changing.
+	writeHello()
+	client = &growingClient{s3TestClient: newS3TestClient(), pathname: pathname, nGrowths: 1}
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-retry-changed", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("will retry"))
+
+	if body := string(bucket.Objects["hello.log"].Body); body != "hello world" {
+		t.Errorf("Expected the retry to upload the grown file: %#v", body)
+	}
+
+	// A file that keeps changing is only retried once.
+	writeHello()
+	client = &growingClient{s3TestClient: newS3TestClient(), pathname: pathname, nGrowths: 2}
+	client.createBucket("hello")
+	runExpect(t, []string{"-retry-changed", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Objects failed:      1"))
+}
@@ -0,0 +1,119 @@
+package s3treeclone
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestChecksum(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-checksum-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.txt"
+	err = ioutil.WriteFile(pathname, []byte("Hello world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	args := []string{"-checksum", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	// The content is the same but the mtime differs, so only the metadata is updated.
+	mtime := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(pathname, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set the times of %s: %v", pathname, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Updated the metadata of s3://hello/hello.txt from "+pathname))
+	if len(client.putInputs) != 1 {
+		t.Errorf("Expected the file not to be uploaded again: %d PutObject calls", len(client.putInputs))
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// Without hashes on the object, the content can't be compared. Normally it's assumed to match;
+	// with -checksum, it's uploaded again with its hashes.
+	for _, algorithm := range HashAlgorithms {
+		delete(bucket.Objects["hello.txt"].Metadata, algorithm)
+	}
+
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	if len(client.putInputs) != 2 || bucket.Objects["hello.txt"].Metadata["sha256"] == "" {
+		t.Errorf("Expected the file to be uploaded again with its hashes: %d PutObject calls, metadata %#v", len(client.putInputs), bucket.Objects["hello.txt"].Metadata)
+	}
+
+	// -hash-min-size is ignored, so small files are hashed, too.
+	client = &recordingClient{s3TestClient: newS3TestClient()}
+	bucket = client.createBucket("hello")
+	runExpect(t, []string{"-checksum", "-hash-min-size", "1KiB", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1"))
+	if bucket.Objects["hello.txt"].Metadata["sha256"] == "" {
+		t.Errorf("Expected the file to be hashed: %#v", bucket.Objects["hello.txt"].Metadata)
+	}
+}
+
+// multipartRecordingClient also counts the multipart uploads started.
+type multipartRecordingClient struct {
+	*recordingClient
+	multipartUploads int32
+}
+
+func (c *multipartRecordingClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	atomic.AddInt32(&c.multipartUploads, 1)
+	return c.recordingClient.CreateMultipartUpload(ctx, input, opts...)
+}
+
+func TestChecksumMetadataOnly(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-checksum-metadata-only-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	// However the content is compared, a file whose mtime alone changed has its metadata updated
+	// rather than being uploaded again.
+	for i, options := range [][]string{
+		{"-checksum"},
+		{"-checksum", "-checksum-algorithm", "CRC32C"},
+		{"-checksum", "-trust-etag"},
+		{"-checksum-algorithm", "SHA256"},
+		{"-trust-etag"},
+	} {
+		if err = os.Chtimes(tmpDir+"/hello.txt", time.Now(), time.Now()); err != nil {
+			t.Fatalf("Failed to set the times of %s/hello.txt: %v", tmpDir, err)
+		}
+
+		client := &multipartRecordingClient{recordingClient: &recordingClient{s3TestClient: newS3TestClient()}}
+		client.createBucket("hello")
+		args := append(options, tmpDir+"/", "s3://hello")
+		runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+		mtime := time.Now().Add(time.Duration(-i-1) * time.Hour)
+		if err = os.Chtimes(tmpDir+"/hello.txt", mtime, mtime); err != nil {
+			t.Fatalf("Failed to set the times of %s/hello.txt: %v", tmpDir, err)
+		}
+
+		puts, multipartUploads := len(client.putInputs), client.multipartUploads
+		runExpect(t, args, client, 0, nil, []byte("Updated the metadata of s3://hello/hello.txt from "+tmpDir+"/hello.txt"))
+		if len(client.putInputs) != puts || client.multipartUploads != multipartUploads || len(client.copyInputs) != 1 {
+			t.Errorf("%v: expected only the metadata to be updated: %d PutObject calls, %d multipart uploads, %d CopyObject calls", options, len(client.putInputs)-puts, client.multipartUploads-multipartUploads, len(client.copyInputs))
+		}
+
+		runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+	}
+}
@@ -0,0 +1,168 @@
+package s3treeclone
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3Checksum is one of the checksums S3 can compute for an object as it's uploaded, and keep with
+// it.
+type s3Checksum struct {
+	algorithm s3Types.ChecksumAlgorithm
+	newHash   func() hash.Hash
+	stored    func(checksum *s3Types.Checksum) *string
+}
+
+// s3Checksums are the checksums S3 supports, from strongest to weakest.
+var s3Checksums = []s3Checksum{
+	{s3Types.ChecksumAlgorithmSha256, sha256.New, func(checksum *s3Types.Checksum) *string { return checksum.ChecksumSHA256 }},
+	{s3Types.ChecksumAlgorithmSha1, sha1.New, func(checksum *s3Types.Checksum) *string { return checksum.ChecksumSHA1 }},
+	{s3Types.ChecksumAlgorithmCrc32c, func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }, func(checksum *s3Types.Checksum) *string { return checksum.ChecksumCRC32C }},
+	{s3Types.ChecksumAlgorithmCrc32, func() hash.Hash { return crc32.NewIEEE() }, func(checksum *s3Types.Checksum) *string { return checksum.ChecksumCRC32 }},
+}
+
+// checksumFor returns the checksum S3 computes with the given algorithm, or nil if it isn't one
+// S3 supports.
+func checksumFor(algorithm s3Types.ChecksumAlgorithm) *s3Checksum {
+	for i := range s3Checksums {
+		if s3Checksums[i].algorithm == algorithm {
+			return &s3Checksums[i]
+		}
+	}
+
+	return nil
+}
+
+// validChecksumAlgorithm reports whether algorithm can be used with -checksum-algorithm. The empty
+// algorithm means objects are uploaded without a checksum.
+func validChecksumAlgorithm(algorithm s3Types.ChecksumAlgorithm) bool {
+	return algorithm == "" || checksumFor(algorithm) != nil
+}
+
+// isMultipartETag determines whether an ETag is that of a multipart object, which ends in a dash
+// and the number of parts.
+func isMultipartETag(etag string) bool {
+	return strings.Contains(strings.Trim(etag, "\""), "-")
+}
+
+// readerChecksum returns the base64-encoded checksum of the content of r with the -checksum-algorithm
+// algorithm, as S3 reports it for an object uploaded with a single request.
+func (stc *Cloner) readerChecksum(r io.Reader) (string, error) {
+	buffer := stc.readBuffers.Get().(*[]byte)
+	defer stc.readBuffers.Put(buffer)
+
+	h := checksumFor(stc.checksumAlgorithm).newHash()
+	if _, err := io.CopyBuffer(h, r, *buffer); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// bodyChecksum returns the checksum of a body about to be uploaded with a single request, then
+// seeks it back to the start so it can be uploaded.
+func (stc *Cloner) bodyChecksum(body io.ReadSeeker) (string, error) {
+	checksum, err := stc.readerChecksum(body)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = body.Seek(0, io.SeekStart)
+	return checksum, err
+}
+
+// setPutObjectChecksum asks S3 to keep a checksum of an upload with the -checksum-algorithm
+// algorithm. The checksum of a body uploaded with a single request is computed beforehand and sent
+// with it, so S3 rejects the upload if the content it receives differs; multipart uploads have the
+// checksum of each part computed by the uploader. This replaces the checksum -verify-parts and
+// Object Lock ask for, since any algorithm serves them.
+func (stc *Cloner) setPutObjectChecksum(poi *s3.PutObjectInput, checksum string) {
+	if stc.checksumAlgorithm == "" {
+		return
+	}
+
+	poi.ChecksumAlgorithm = stc.checksumAlgorithm
+	if checksum == "" {
+		return
+	}
+
+	switch stc.checksumAlgorithm {
+	case s3Types.ChecksumAlgorithmSha256:
+		poi.ChecksumSHA256 = &checksum
+	case s3Types.ChecksumAlgorithmSha1:
+		poi.ChecksumSHA1 = &checksum
+	case s3Types.ChecksumAlgorithmCrc32c:
+		poi.ChecksumCRC32C = &checksum
+	case s3Types.ChecksumAlgorithmCrc32:
+		poi.ChecksumCRC32 = &checksum
+	}
+}
+
+// objectChecksum returns the checksum S3 keeps for an object with the -checksum-algorithm
+// algorithm, or "" if it has none that can be compared with a file: it was uploaded without one or
+// with another algorithm, it was uploaded in parts, whose checksum is made from the checksums of
+// the parts, or it's compressed.
+func (stc *Cloner) objectChecksum(hoo *s3.HeadObjectOutput) string {
+	if stc.checksumAlgorithm == "" || aws.ToString(hoo.ContentEncoding) == contentEncodingGzip {
+		return ""
+	}
+
+	if isMultipartETag(aws.ToString(hoo.ETag)) || hoo.PartsCount > 1 {
+		return ""
+	}
+
+	stored := aws.ToString(checksumFor(stc.checksumAlgorithm).stored(&s3Types.Checksum{
+		ChecksumCRC32:  hoo.ChecksumCRC32,
+		ChecksumCRC32C: hoo.ChecksumCRC32C,
+		ChecksumSHA1:   hoo.ChecksumSHA1,
+		ChecksumSHA256: hoo.ChecksumSHA256,
+	}))
+
+	if strings.Contains(stored, "-") {
+		return ""
+	}
+
+	return stored
+}
+
+// compareFileChecksum compares a file against the checksum S3 keeps for its object, as returned by
+// objectChecksum. Only that checksum is computed, unless the hash cache already has the hash.
+func (stc *Cloner) compareFileChecksum(stored, pathname string, stat *fileStat) (bool, error) {
+	// The SHA-1 and SHA-256 checksums are the hashes stored in the metadata, encoded in base64.
+	if hashes := stc.cachedFileHashes(pathname, stat); hashes != nil {
+		var digest []byte
+		switch stc.checksumAlgorithm {
+		case s3Types.ChecksumAlgorithmSha256:
+			digest = hashes.SHA256
+		case s3Types.ChecksumAlgorithmSha1:
+			digest = hashes.SHA1
+		}
+
+		if digest != nil {
+			return base64.StdEncoding.EncodeToString(digest) == stored, nil
+		}
+	}
+
+	fd, err := os.Open(pathname)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+
+	checksum, err := stc.readerChecksum(fd)
+	if err != nil {
+		return false, err
+	}
+
+	return checksum == stored, nil
+}
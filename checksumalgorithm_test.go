@@ -0,0 +1,169 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestChecksumAlgorithm(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-checksum-algorithm-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	sha256Sum := sha256.Sum256([]byte("hello world"))
+	crc32c := crc32.Checksum([]byte("hello world"), crc32.MakeTable(crc32.Castagnoli))
+	for _, tc := range []struct {
+		algorithm string
+		expected  *s3Types.Checksum
+	}{
+		{"SHA256", &s3Types.Checksum{ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sha256Sum[:]))}},
+		{"CRC32C", &s3Types.Checksum{ChecksumCRC32C: aws.String(base64.StdEncoding.EncodeToString([]byte{byte(crc32c >> 24), byte(crc32c >> 16), byte(crc32c >> 8), byte(crc32c)}))}},
+	} {
+		client := &recordingClient{s3TestClient: newS3TestClient()}
+		bucket := client.createBucket("hello")
+		args := []string{"-checksum-algorithm", tc.algorithm, tmpDir + "/", "s3://hello"}
+		runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+		// The checksum is computed beforehand and sent with the body, so S3 verifies it.
+		input := client.putInputs[0]
+		if input.ChecksumAlgorithm != s3Types.ChecksumAlgorithm(tc.algorithm) {
+			t.Errorf("%s: expected the upload to have checksum algorithm %s, got %q", tc.algorithm, tc.algorithm, input.ChecksumAlgorithm)
+		}
+
+		sent := &s3Types.Checksum{ChecksumCRC32C: input.ChecksumCRC32C, ChecksumSHA256: input.ChecksumSHA256}
+		if aws.ToString(sent.ChecksumSHA256) != aws.ToString(tc.expected.ChecksumSHA256) || aws.ToString(sent.ChecksumCRC32C) != aws.ToString(tc.expected.ChecksumCRC32C) {
+			t.Errorf("%s: expected checksum %v to be sent, got %v", tc.algorithm, tc.expected, sent)
+		}
+
+		// The checksum S3 keeps is compared instead of the hash metadata.
+		object := bucket.Objects["hello.txt"]
+		object.Metadata["sha512"] = "0000"
+		runExpect(t, args, client, 0, nil, []byte("Objects skipped:     1"))
+
+		object.Checksum = &s3Types.Checksum{ChecksumSHA256: aws.String("AAAA"), ChecksumCRC32C: aws.String("AAAA")}
+		runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	}
+}
+
+func TestChecksumAlgorithmFallback(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-checksum-algorithm-fallback-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	// Objects uploaded without the checksum are compared by their hash metadata.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	args := []string{"-checksum-algorithm", "SHA256", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects skipped:     1"))
+
+	bucket.Objects["hello.txt"].Metadata["sha512"] = "0000"
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	if bucket.Objects["hello.txt"].Checksum == nil {
+		t.Errorf("Expected the object to be uploaded again with a checksum")
+	}
+}
+
+func TestChecksumAlgorithmDedupe(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-checksum-algorithm-dedupe-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err = ioutil.WriteFile(tmpDir+"/"+name, []byte("hello world"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, name, err)
+		}
+	}
+
+	// The copy of the duplicate asks S3 for a checksum of the copy, so it can be compared too.
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	args := []string{"-dedupe", "-walk-workers", "1", "-checksum-algorithm", "CRC32", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    2"))
+
+	if len(client.copyInputs) != 1 || client.copyInputs[0].ChecksumAlgorithm != s3Types.ChecksumAlgorithmCrc32 {
+		t.Fatalf("Expected one copy with checksum algorithm CRC32: %v", client.copyInputs)
+	}
+
+	a, b := bucket.Objects["a.txt"].Checksum, bucket.Objects["b.txt"].Checksum
+	if a == nil || b == nil || aws.ToString(a.ChecksumCRC32) != aws.ToString(b.ChecksumCRC32) {
+		t.Errorf("Expected both objects to have the same CRC32 checksum: %v, %v", a, b)
+	}
+
+	bucket.Objects["b.txt"].Metadata["sha512"] = "0000"
+	runExpect(t, args, client, 0, nil, []byte("Objects skipped:     2"))
+}
+
+// multipartChecksumClient records the checksum algorithm of each multipart upload.
+type multipartChecksumClient struct {
+	*s3TestClient
+	mutex      sync.Mutex
+	algorithms []s3Types.ChecksumAlgorithm
+}
+
+func (c *multipartChecksumClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	c.mutex.Lock()
+	c.algorithms = append(c.algorithms, input.ChecksumAlgorithm)
+	c.mutex.Unlock()
+	return c.s3TestClient.CreateMultipartUpload(ctx, input, opts...)
+}
+
+func TestChecksumAlgorithmMultipart(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-checksum-algorithm-multipart-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = ioutil.WriteFile(tmpDir+"/large.bin", bytes.Repeat([]byte("x"), 12<<20), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/large.bin: %v", tmpDir, err)
+	}
+
+	// The uploader computes the checksum of each part, so the algorithm replaces the one
+	// -verify-parts would ask for.
+	client := &multipartChecksumClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	args := []string{"-verify-parts", "-checksum-algorithm", "CRC32C", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	if len(client.algorithms) != 1 || client.algorithms[0] != s3Types.ChecksumAlgorithmCrc32c {
+		t.Errorf("Expected one multipart upload with checksum algorithm CRC32C, got %v", client.algorithms)
+	}
+}
+
+func TestChecksumAlgorithmInvalid(t *testing.T) {
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	runExpect(t, []string{"-checksum-algorithm", "MD5", ".", "s3://hello"}, client, 1, nil, []byte("Invalid -checksum-algorithm value: MD5"))
+
+	if _, err := NewCloner(Options{ChecksumAlgorithm: "sha256"}, client); err == nil {
+		t.Errorf("Expected NewCloner to reject the checksum algorithm sha256")
+	}
+}
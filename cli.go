@@ -0,0 +1,1052 @@
+package s3treeclone
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// deadlineExitCode is returned when -deadline passed before the run finished.
+const deadlineExitCode = 4
+
+// Main runs the s3-tree-clone command with the given arguments and returns its exit code.
+func Main(arguments []string) int {
+	return run(context.Background(), arguments, nil)
+}
+
+// run executes s3-tree-clone, but allows for test injection.
+func run(ctx context.Context, arguments []string, s3Client S3Interface) int {
+	flagSet := flag.NewFlagSet("s3-tree-clone", flag.ContinueOnError)
+
+	checkBucket := flagSet.Bool("check-bucket", true, "Call GetBucketLocation to verify the bucket location.")
+	region := flagSet.String("region", "", "The AWS region to use. Defaults to $AWS_REGION, $AWS_DEFAULT_REGION, the configured region for the profile, or the instance region, whichever is appropriate.")
+	profile := flagSet.String("profile", "", "The credentials profile to use.")
+	accessKeyID := flagSet.String("access-key-id", "", "The AWS access key ID to use instead of the credential chain. Requires -secret-access-key.")
+	secretAccessKey := flagSet.String("secret-access-key", "", "The AWS secret access key to use with -access-key-id.")
+	sessionToken := flagSet.String("session-token", "", "The session token to use with -access-key-id, for temporary credentials.")
+	assumeRole := flagSet.String("assume-role", "", "The ARN of an IAM role to assume, using the credentials from the profile or environment, before accessing S3.")
+	roleSessionName := flagSet.String("role-session-name", defaultRoleSessionName, "The session name to use with -assume-role or -role-arn.")
+	externalID := flagSet.String("external-id", "", "The external ID to pass when assuming the -assume-role role, if the role's trust policy requires one.")
+	mfaSerial := flagSet.String("mfa-serial", "", "The serial number or ARN of the MFA device to use when assuming the -assume-role role, if the role requires MFA.")
+	mfaToken := flagSet.String("mfa-token", "", "The MFA code to use with -mfa-serial. If not given, or once the role's credentials expire, a code is prompted for.")
+	roleARN := flagSet.String("role-arn", "", "The ARN of an IAM role to assume with the OpenID Connect token in -web-identity-token-file, such as with EKS IAM roles for service accounts. Overrides AWS_ROLE_ARN.")
+	webIdentityTokenFile := flagSet.String("web-identity-token-file", "", "The file containing the OpenID Connect token to assume the -role-arn role with. Overrides AWS_WEB_IDENTITY_TOKEN_FILE.")
+	endpointURL := flagSet.String("endpoint-url", "", "Use the given S3-compatible endpoint URL instead of the AWS endpoint for the region. This disables -check-bucket.")
+	forcePathStyle := flagSet.Bool("force-path-style", false, "Use path-style S3 URLs (https://endpoint/bucket/key) instead of virtual-hosted style.")
+	useFIPSEndpoint := flagSet.Bool("use-fips-endpoint", false, "Use the FIPS endpoint for the bucket's region.")
+	requestPayer := flagSet.Bool("request-payer", false, "Accept the charges for requests to a requester-pays bucket.")
+	useDualStackEndpoint := flagSet.Bool("use-dualstack-endpoint", false, "Use the dual-stack (IPv4 and IPv6) endpoint for the bucket's region.")
+	storageClass := flagSet.String("storage-class", "STANDARD", "The S3 storage class to use. One of 'STANDARD', 'STANDARD_IA', 'ONEZONE_IA', 'INTELLIGENT_TIERING', 'GLACIER', 'DEEP_ARCHIVE', or 'OUTPOSTS', or a map by file extension such as 'default=STANDARD,.mp4=INTELLIGENT_TIERING'.")
+	storageClassRulesString := flagSet.String("storage-class-rules", "", "Upload files matching rules such as 'size>1GB:DEEP_ARCHIVE,age>90d:GLACIER' in the given storage class instead of -storage-class. The first rule a file matches applies.")
+	acl := flagSet.String("acl", "", "The canned ACL to apply to uploaded objects. One of 'private', 'public-read', 'public-read-write', 'authenticated-read', 'aws-exec-read', 'bucket-owner-read', or 'bucket-owner-full-control'. By default, no ACL is set.")
+	encAlg := flagSet.String("encryption-algorithm", "AES256", "The S3 server-side encryption algorithm to use. This must be 'AES256', 'aws:kms', or 'SSE-C' (a customer-provided key given by -sse-customer-key).")
+	kmsKey := flagSet.String("kms-key", DefaultKMSKey, "If -encryption-algorithm is 'aws:kms', the KMS key ID to use. Defaults to aws/s3.")
+	bucketKeyEnabled := flagSet.Bool("bucket-key-enabled", false, "If -encryption-algorithm is 'aws:kms', use an S3 Bucket Key to reduce KMS requests.")
+	sseCustomerKey := flagSet.String("sse-customer-key", "", "If -encryption-algorithm is 'SSE-C', the base64-encoded 256-bit key to encrypt objects with.")
+	objectLockMode := flagSet.String("object-lock-mode", "", "Place uploaded objects under Object Lock retention in this mode: 'GOVERNANCE' or 'COMPLIANCE'. Requires -object-lock-retain-until.")
+	retainUntilString := flagSet.String("object-lock-retain-until", "", "With -object-lock-mode, retain uploaded objects until the given RFC 3339 time, or for the given duration (such as '720h') from now.")
+	legalHold := flagSet.Bool("legal-hold", false, "Place a legal hold on uploaded objects.")
+	ignoreTimestamps := flagSet.Bool("ignore-timestamps", false, "Ignore file timestamps when comparing files.")
+	ignoreCtime := flagSet.Bool("ignore-ctime", false, "Ignore file ctimes, but not mtimes, when comparing files.")
+	ignoreContentType := flagSet.Bool("ignore-content-type", false, "Don't resync objects whose recorded content type differs from the one the file would be uploaded with now.")
+	timestampToleranceString := flagSet.String("timestamp-tolerance", "0s", "Consider file timestamps equal if they differ by at most this duration, such as '1s'.")
+	modifiedSinceString := flagSet.String("modified-since", "", "Skip files last modified before the given RFC 3339 time, or before the given duration (such as '24h') ago.")
+	retryFailed := flagSet.Int("retry-failed", 0, "After the walk, handle files that failed again, up to this many more times, waiting a little longer before each pass.")
+	retryChanged := flagSet.Bool("retry-changed", false, "Upload files that change while they are being uploaded once more before reporting them as failed.")
+	checksum := flagSet.Bool("checksum", false, "Upload files only when their content hashes differ from their objects', hashing every file and treating objects without stored hashes as different. Objects whose content matches but whose metadata differs have their metadata updated in place.")
+	sizeOnly := flagSet.Bool("size-only", false, "Compare files and objects by existence and size alone, without hashing files or storing hashes. A file that changed without changing size is not uploaded.")
+	verifyParts := flagSet.Bool("verify-parts", false, "Compare multipart objects against the checksums S3 keeps for their parts, and upload them with those checksums.")
+	checksumAlgorithm := flagSet.String("checksum-algorithm", "", "Upload objects with an S3 checksum computed with this algorithm, which S3 verifies and keeps, and compare files against it. One of 'SHA256', 'CRC32C', 'CRC32', or 'SHA1'.")
+	verifyAfterUpload := flagSet.Bool("verify-after-upload", false, "Read back the metadata of each uploaded object and verify it matches the source.")
+	maxConcurrent := flagSet.Int("max-concurrent", DefaultMaxConcurrent, "The maximum number of concurrent S3 requests to make.")
+	multipartPartSizeString := flagSet.String("multipart-part-size", "5MiB", "The size of each part of a multipart upload, such as '16MiB'. Must be at least 5MiB.")
+	multipartThresholdString := flagSet.String("multipart-threshold", "", "Upload files of at least this size, such as '64MiB', in parts. Defaults to the -multipart-part-size value.")
+	multipartConcurrency := flagSet.Int("multipart-concurrency", DefaultMultipartConcurrency, "The number of parts of a file to upload at once. Can't be greater than -max-concurrent; if not given, it's lowered to the -max-concurrent value.")
+	contentTypes := contentTypeMap{}
+	flagSet.Var(contentTypes, "content-type", "Upload files with the given extension as the given content type, given as .ext=type/subtype, instead of detecting it. May be repeated.")
+	defaultContentType := flagSet.String("default-content-type", DefaultContentType, "The content type for files whose type can't be detected.")
+	cacheControl := flagSet.String("cache-control", "", "The Cache-Control header to serve uploaded objects with, such as 'max-age=3600'.")
+	contentDisposition := flagSet.String("content-disposition", "", "The Content-Disposition header to serve uploaded objects with, such as 'attachment'.")
+	expiresString := flagSet.String("expires", "", "The Expires header to serve uploaded objects with, as an RFC 1123 time such as 'Mon, 02 Jan 2006 15:04:05 GMT', or a duration (such as '24h') from the start of the run.")
+	policyFile := flagSet.String("policy", "", "Read rules from the given JSON file that set the storage class, tags, ACL, Cache-Control header, or content type of the objects for matching paths. The first matching rule applies; other flags give the defaults.")
+	websiteRedirects := websiteRedirectMap{}
+	flagSet.Var(websiteRedirects, "website-redirect", "Set the website redirect location of the object with the given key, given as key=/path or key=https://host/path. May be repeated.")
+	var compress stringList
+	flagSet.Var(&compress, "compress", "Gzip files with the given extension (such as '.log') or content type (such as 'text/*') before uploading. May be repeated.")
+	compressMinSizeString := flagSet.String("compress-min-size", "1KiB", "Only compress files of at least this size.")
+	dedupe := flagSet.Bool("dedupe", false, "Copy files whose content was already uploaded during this run from the earlier object on the server instead of uploading them again.")
+	bwlimit := flagSet.String("bwlimit", "", "Limit the aggregate upload bandwidth to the given rate, such as '10MiB/s'.")
+	maxRPS := flagSet.Float64("max-rps", 0, "Limit the rate of S3 requests to this many per second, regardless of -max-concurrent. Zero means no limit.")
+	hashWorkers := flagSet.Int("hash-workers", 0, "The number of files hashed at once, ahead of the workers uploading them. Defaults to the -walk-workers value.")
+	walkWorkers := flagSet.Int("walk-workers", 0, "The number of workers examining files. Defaults to the -max-concurrent value.")
+	maxDepth := flagSet.Int("max-depth", 0, "Only copy this many levels below the source directory. Zero means no limit.")
+	maxRetries := flagSet.Int("max-retries", 10, "The maximum number of retries.")
+	failureLimit := flagSet.Int("max-consecutive-failures", 10, "Abort after this many S3 requests in a row fail with an error such as AccessDenied that retrying won't fix. Zero means never abort.")
+	stopOnError := flagSet.Bool("stop-on-error", false, "Abort the run at the first file that fails, such as one that can't be read, instead of counting the failure and continuing.")
+	maxBackoffDelayString := flagSet.String("max-backoff-delay", "60s", "The maximum retry backoff delay. Specify a duration such as '1.5m', '1m30s', etc.")
+	deadlineString := flagSet.String("deadline", "0s", "Stop the whole run after this long, canceling requests in flight, and exit with 4. Specify a duration such as '2h'. Zero means no deadline.")
+	requestTimeoutString := flagSet.String("request-timeout", "0s", "Give up on an attempt at an S3 request, including sending its body, after this long and retry it. Specify a duration such as '30s'. Zero means no timeout.")
+	rootSquash := flagSet.Bool("root-squash", false, "Change files owned by root to nfsnobody.")
+	rootUnsquash := flagSet.Bool("root-unsquash", false, "Treat objects owned by nfsnobody as owned by root when comparing and restoring.")
+	var uidMap, gidMap idMappingList
+	flagSet.Var(&uidMap, "map-uid", "Record files owned by one UID as owned by another, given as from:to. The from UID may be '*' to match any UID. May be repeated; mappings apply in order.")
+	flagSet.Var(&gidMap, "map-gid", "Record files with one GID as having another, given as from:to. The from GID may be '*' to match any GID. May be repeated; mappings apply in order.")
+	links := flagSet.String("links", LinksSkip, "How to handle symbolic links. One of 'skip', 'follow' (copy the file or directory the link points to), or 'store' (store the link as an empty object with the target in its metadata).")
+	hardLinks := flagSet.Bool("hard-links", false, "Store each hard link to a file after the first as an empty object whose file-hardlink-target metadata refers to the first.")
+	xattrs := flagSet.Bool("xattrs", false, "Store the user, security, and trusted extended attributes of files in their metadata, and compare them. Only supported on Linux.")
+	oneFileSystem := flagSet.Bool("one-file-system", false, "Don't descend into directories on other file systems, such as mount points.")
+	hashAlgorithmsString := flagSet.String("hash-algorithms", strings.Join(HashAlgorithms, ","), "The comma-separated hashes to compute for each file and store in its metadata. Any of 'md5', 'sha1', 'sha256', and 'sha512'.")
+	hashMinSizeString := flagSet.String("hash-min-size", "0", "Don't hash files smaller than this size; they're compared by size and timestamps alone.")
+	readBufferSizeString := flagSet.String("read-buffer-size", "1MiB", "The size of the buffer each file is read through to hash it.")
+	etagMetadata := flagSet.Bool("etag-metadata", false, "Store the ETag S3 gives each uploaded file, including the multipart ETag of files uploaded in -multipart-part-size parts, in its file-etag metadata.")
+	trustETag := flagSet.Bool("trust-etag", false, "Compare files against objects uploaded in a single part without SSE-KMS or SSE-C by the MD5 sum in their ETag, computing only the MD5 sum of the file.")
+	trustMtime := flagSet.Bool("trust-mtime", false, "Skip checking the objects of files that the -hash-cache file shows haven't changed since they were last synced to them. Requires -hash-cache.")
+	hashCachePath := flagSet.String("hash-cache", "", "Cache file hashes in the given file, keyed by path, size, and modification time, to avoid rehashing unchanged files.")
+	prelist := flagSet.Bool("prelist", false, "List the objects under the destination before walking, and only call HeadObject for objects that exist with the same size.")
+	filesFrom := flagSet.String("files-from", "", "Read the paths to copy, relative to the source, from the given file instead of walking the source directory. Use '-' to read them from stdin.")
+	var excludes stringList
+	flagSet.Var(&excludes, "exclude", "Skip files and directories whose path relative to the source matches the given glob pattern. May be repeated.")
+	excludeFrom := flagSet.String("exclude-from", "", "Read additional -exclude patterns from the given file, one per line. Blank lines and lines starting with '#' are ignored.")
+	respectIgnoreFiles := flagSet.Bool("respect-ignore-files", false, "Skip entries matching the patterns in .s3ignore files found in each directory. Patterns are relative to that directory, and a pattern starting with '!' re-includes entries.")
+	tags := tagMap{}
+	flagSet.Var(tags, "tag", "Tag uploaded objects with the given key=value. May be repeated.")
+	tagFromMetadata := flagSet.Bool("tag-from-metadata", false, "Also tag uploaded objects with their file-owner, file-group, and file-permissions.")
+	metadataScheme := flagSet.String("metadata-scheme", MetadataSchemeFileGateway, "The metadata keys and formats to record ownership, permissions, and timestamps with. One of 'filegateway', 's3fs', or 'goofys'.")
+	metadataUserAgent := flagSet.String("metadata-user-agent", DefaultMetadataUserAgent, "The name recorded, with the version, in the user-agent metadata of uploaded objects and sent in the User-Agent header of S3 requests.")
+	annotate := flagSet.Bool("annotate", false, "Record the host name and a unique run ID in the source-host and run-id metadata of each uploaded object.")
+	help := flagSet.Bool("help", false, "Show this usage information.")
+	quiet := flagSet.Bool("quiet", false, "Only show errors and the final summary.")
+	flagSet.BoolVar(quiet, "q", false, "Shorthand for -quiet.")
+	verbose := flagSet.Bool("verbose", false, "Also show comparisons, skipped entries, and directories walked.")
+	flagSet.BoolVar(verbose, "v", false, "Shorthand for -verbose.")
+	debug := flagSet.Bool("vv", false, "Like -verbose, but also show when hashes and metadata match.")
+	progress := flagSet.Bool("progress", false, "Show the running transfer counts and throughput on stderr, refreshed every second.")
+	logFile := flagSet.String("log-file", "", "Also write all output to the given file, appending to it if it exists.")
+	logFileOnly := flagSet.Bool("log-file-only", false, "Write output only to the -log-file file instead of also to the console.")
+	unicodeNormalize := flagSet.String("unicode-normalize", UnicodeNormalizeNone, "Convert the file and directory names in object keys to a Unicode normalization form, so the same names give the same keys on macOS and Linux. One of 'none', 'nfc', or 'nfd'.")
+	errorReport := flagSet.String("error-report", "", "At the end of the run, write the path of each file that failed to the given file, one per line, for use with -files-from. With -log-format json, each failure is written as a JSON object with its path, key, and error.")
+	logFormat := flagSet.String("log-format", LogFormatText, "The format of per-file log messages. Either 'text' or 'json' (one JSON object per line on stderr).")
+	dryRun := flagSet.Bool("dry-run", false, "Show what would be uploaded or deleted without making any changes.")
+	planOut := flagSet.String("plan-out", "", "With -dry-run, write what would be uploaded, updated, or deleted to the given file as a JSON array.")
+	deleteExtraneous := flagSet.Bool("delete", false, "Delete objects under the destination that do not exist in the source.")
+	deleteExcluded := flagSet.Bool("delete-excluded", false, "With -delete, also delete objects whose paths match the -exclude patterns. By default, they are kept.")
+	report := flagSet.Bool("report", false, "Write the differences between the source and destination to stdout instead of uploading. Exits with 3 if there are differences.")
+	prefixHostname := flagSet.Bool("prefix-hostname", false, "Add the hostname of this machine, or the -node-name value, to the end of the S3 prefix, so each host is cloned under its own prefix.")
+	nodeName := flagSet.String("node-name", "", "The name to use instead of the hostname with -prefix-hostname.")
+	restore := flagSet.Bool("restore", false, "Restore a tree from S3: the source is an S3 URL and the destination is a local directory.")
+	list := flagSet.Bool("list", false, "List the objects under the given S3 URL, with their sizes and storage classes, instead of copying anything.")
+	listLong := flagSet.Bool("l", false, "With -list, also show the permissions, owner, group, and mtime recorded in each object's metadata. Each object is fetched with HeadObject.")
+
+	if err := flagSet.Parse(arguments); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing arguments: %s\n", err)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *help {
+		flagSet.SetOutput(os.Stdout)
+		printUsage(flagSet)
+		return 0
+	}
+
+	args := flagSet.Args()
+	if len(args) == 0 && *list {
+		fmt.Fprintf(os.Stderr, "Missing S3 URL to list\n")
+		printUsage(flagSet)
+		return 2
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Missing source and destination\n")
+		printUsage(flagSet)
+		return 2
+	}
+
+	// Listing takes only the S3 URL to list.
+	if len(args) > 1 && *list {
+		fmt.Fprintf(os.Stderr, "Unexpected argument: %s\n", args[1])
+		printUsage(flagSet)
+		return 2
+	}
+
+	if len(args) == 1 && !*list {
+		fmt.Fprint(os.Stderr, "Missing destination\n")
+		printUsage(flagSet)
+		return 2
+	}
+
+	// Restores have a single source; uploads may have several, all cloned to the last argument.
+	if len(args) > 2 && *restore {
+		fmt.Fprintf(os.Stderr, "Unexpected argument: %s\n", args[2])
+		printUsage(flagSet)
+		return 2
+	}
+
+	sources, destination := args[:len(args)-1], args[len(args)-1]
+	if *list {
+		sources, destination = args, ""
+	}
+
+	if *listLong && !*list {
+		fmt.Fprintf(os.Stderr, "-l requires -list\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *list && (*restore || *report || *deleteExtraneous || *filesFrom != "" || *dryRun) {
+		fmt.Fprintf(os.Stderr, "-list cannot be used with -restore, -report, -delete, -files-from, or -dry-run\n")
+		printUsage(flagSet)
+		return 1
+	}
+	if len(sources) > 1 && (*deleteExtraneous || *report || *filesFrom != "") {
+		fmt.Fprintf(os.Stderr, "-delete, -report, and -files-from cannot be used with multiple sources\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *planOut != "" && (!*dryRun || *restore || len(sources) > 1) {
+		fmt.Fprintf(os.Stderr, "-plan-out requires -dry-run and cannot be used with -restore or multiple sources\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *quiet && (*verbose || *debug) {
+		fmt.Fprintf(os.Stderr, "-quiet cannot be used with -verbose or -vv\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	verbosity := VerbosityNormal
+	switch {
+	case *quiet:
+		verbosity = VerbosityQuiet
+	case *debug:
+		verbosity = VerbosityDebug
+	case *verbose:
+		verbosity = VerbosityVerbose
+	}
+
+	if *restore && (*deleteExtraneous || *filesFrom != "") {
+		fmt.Fprintf(os.Stderr, "-delete and -files-from cannot be used with -restore\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *acl != "" && !validACL(s3Types.ObjectCannedACL(*acl)) {
+		fmt.Fprintf(os.Stderr, "Invalid -acl value: %s\n", *acl)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if !validEncryptionAlgorithm(s3Types.ServerSideEncryption(*encAlg)) {
+		fmt.Fprintf(os.Stderr, "Invalid -encryption-algorithm value: %s\n", *encAlg)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if s3Types.ServerSideEncryption(*encAlg) == EncryptionSSEC {
+		if _, err := parseSSECustomerKey(*sseCustomerKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -sse-customer-key value: %v\n", err)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	if *bucketKeyEnabled && s3Types.ServerSideEncryption(*encAlg) != s3Types.ServerSideEncryptionAwsKms {
+		fmt.Fprintf(os.Stderr, "Warning: -bucket-key-enabled has no effect unless -encryption-algorithm is aws:kms\n")
+	}
+
+	if !validChecksumAlgorithm(s3Types.ChecksumAlgorithm(*checksumAlgorithm)) {
+		fmt.Fprintf(os.Stderr, "Invalid -checksum-algorithm value: %s\n", *checksumAlgorithm)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if !validObjectLockMode(s3Types.ObjectLockMode(*objectLockMode)) {
+		fmt.Fprintf(os.Stderr, "Invalid -object-lock-mode value: %s\n", *objectLockMode)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if (*objectLockMode == "") != (*retainUntilString == "") {
+		fmt.Fprintf(os.Stderr, "-object-lock-mode and -object-lock-retain-until must be used together\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if !validLinks(*links) {
+		fmt.Fprintf(os.Stderr, "Invalid -links value: %s\n", *links)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if !validUnicodeNormalize(*unicodeNormalize) {
+		fmt.Fprintf(os.Stderr, "Invalid -unicode-normalize value: %s\n", *unicodeNormalize)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if !validLogFormat(*logFormat) {
+		fmt.Fprintf(os.Stderr, "Invalid -log-format value: %s\n", *logFormat)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *logFileOnly && *logFile == "" {
+		fmt.Fprintf(os.Stderr, "-log-file-only requires -log-file\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if !validMetadataScheme(*metadataScheme) {
+		fmt.Fprintf(os.Stderr, "Invalid -metadata-scheme value: %s\n", *metadataScheme)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *deleteExcluded && !*deleteExtraneous {
+		fmt.Fprintf(os.Stderr, "-delete-excluded requires -delete\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *report && (*deleteExtraneous || *restore) {
+		fmt.Fprintf(os.Stderr, "-report cannot be used with -delete or -restore\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *trustMtime && *hashCachePath == "" {
+		fmt.Fprintf(os.Stderr, "-trust-mtime requires -hash-cache\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *sizeOnly && (*checksum || *dedupe || *verifyParts) {
+		fmt.Fprintf(os.Stderr, "-size-only cannot be used with -checksum, -dedupe, or -verify-parts\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *endpointURL != "" && (*useFIPSEndpoint || *useDualStackEndpoint) {
+		fmt.Fprintf(os.Stderr, "-use-fips-endpoint and -use-dualstack-endpoint cannot be used with -endpoint-url\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if (*accessKeyID == "") != (*secretAccessKey == "") || (*sessionToken != "" && *accessKeyID == "") {
+		fmt.Fprintf(os.Stderr, "-access-key-id and -secret-access-key must be used together, and are required by -session-token\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if (*mfaSerial != "" && *assumeRole == "") || (*mfaToken != "" && *mfaSerial == "") {
+		fmt.Fprintf(os.Stderr, "-mfa-serial requires -assume-role, and -mfa-token requires -mfa-serial\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if (*roleARN == "") != (*webIdentityTokenFile == "") {
+		fmt.Fprintf(os.Stderr, "-role-arn and -web-identity-token-file must be used together\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *roleARN != "" && (*accessKeyID != "" || *profile != "") {
+		fmt.Fprintf(os.Stderr, "-role-arn cannot be used with -access-key-id or -profile\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *accessKeyID != "" && *profile != "" {
+		fmt.Fprintf(os.Stderr, "-access-key-id cannot be used with -profile\n")
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -max-retries flag
+	if *maxRetries < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-retries value: %d\n", *maxRetries)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *failureLimit < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-consecutive-failures value: %d\n", *failureLimit)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *maxDepth < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-depth value: %d\n", *maxDepth)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if *maxRPS < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-rps value: %g\n", *maxRPS)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -hash-workers flag
+	if *hashWorkers < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -hash-workers value: %d\n", *hashWorkers)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -walk-workers flag
+	if *walkWorkers < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -walk-workers value: %d\n", *walkWorkers)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the multipart flags
+	multipartPartSize, err := parseByteSize(*multipartPartSizeString)
+	if err != nil || multipartPartSize < manager.MinUploadPartSize {
+		fmt.Fprintf(os.Stderr, "Invalid -multipart-part-size value: %s\n", *multipartPartSizeString)
+		printUsage(flagSet)
+		return 1
+	}
+
+	multipartThreshold := multipartPartSize
+	if *multipartThresholdString != "" {
+		multipartThreshold, err = parseByteSize(*multipartThresholdString)
+		if err != nil || multipartThreshold > maxPutObjectSize {
+			fmt.Fprintf(os.Stderr, "Invalid -multipart-threshold value: %s\n", *multipartThresholdString)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	if *multipartConcurrency < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid -multipart-concurrency value: %d\n", *multipartConcurrency)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Each part in flight holds one -max-concurrent slot. If -multipart-concurrency isn't given,
+	// NewCloner lowers the default to fit; if it is, it must fit.
+	explicitMultipartConcurrency := 0
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == "multipart-concurrency" {
+			explicitMultipartConcurrency = *multipartConcurrency
+		}
+	})
+
+	if *maxConcurrent > 0 && explicitMultipartConcurrency > *maxConcurrent {
+		fmt.Fprintf(os.Stderr, "-multipart-concurrency (%d) cannot be greater than -max-concurrent (%d)\n", *multipartConcurrency, *maxConcurrent)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -hash-algorithms flag
+	hashAlgorithms, err := ParseHashAlgorithms(*hashAlgorithmsString)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -hash-algorithms value: %s\n", *hashAlgorithmsString)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -hash-min-size flag
+	hashMinSize, err := parseByteSize(*hashMinSizeString)
+	if err != nil || hashMinSize < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -hash-min-size value: %s\n", *hashMinSizeString)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -read-buffer-size flag
+	readBufferSize, err := parseByteSize(*readBufferSizeString)
+	if err != nil || readBufferSize < 1 || readBufferSize > math.MaxInt32 {
+		fmt.Fprintf(os.Stderr, "Invalid -read-buffer-size value: %s\n", *readBufferSizeString)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the compression flags
+	for _, pattern := range compress {
+		if !validCompressPattern(pattern) {
+			fmt.Fprintf(os.Stderr, "Invalid -compress value: %s\n", pattern)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	compressMinSize, err := parseByteSize(*compressMinSizeString)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -compress-min-size value: %s\n", *compressMinSizeString)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -bwlimit flag
+	var bandwidthLimit int64
+	if *bwlimit != "" {
+		bandwidthLimit, err = parseBandwidth(*bwlimit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -bwlimit value: %s\n", *bwlimit)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	// Check the -timestamp-tolerance flag
+	timestampTolerance, err := time.ParseDuration(*timestampToleranceString)
+	if err != nil || timestampTolerance < time.Duration(0) {
+		fmt.Fprintf(os.Stderr, "Invalid -timestamp-tolerance value: %s\n", *timestampToleranceString)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -modified-since flag
+	var modifiedSince time.Time
+	if *modifiedSinceString != "" {
+		modifiedSince, err = parseModifiedSince(*modifiedSinceString, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -modified-since value: %s\n", *modifiedSinceString)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	// Check the -storage-class flag
+	defaultStorageClass, extStorageClasses, err := ParseStorageClasses(*storageClass)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -storage-class value: %v\n", err)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -storage-class-rules flag
+	var storageClassRules []StorageClassRule
+	if *storageClassRulesString != "" {
+		storageClassRules, err = ParseStorageClassRules(*storageClassRulesString)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -storage-class-rules value: %v\n", err)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	// Check the -policy flag
+	var policy []PolicyRule
+	if *policyFile != "" {
+		policy, err = LoadPolicy(*policyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -policy value: %v\n", err)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	// Check the -expires flag
+	var expires time.Time
+	var expiresIn time.Duration
+	if *expiresString != "" {
+		expires, expiresIn, err = parseExpires(*expiresString)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -expires value: %v\n", err)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	// Check the -object-lock-retain-until flag
+	var retainUntil time.Time
+	if *retainUntilString != "" {
+		retainUntil, err = parseRetainUntil(*retainUntilString, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -object-lock-retain-until value: %v\n", err)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	// Check the -max-backoff-delay flag
+	var maxBackoffDelay time.Duration
+	if *maxRetries > 0 {
+		maxBackoffDelay, err = time.ParseDuration(*maxBackoffDelayString)
+		if err != nil || maxBackoffDelay <= time.Duration(0) {
+			fmt.Fprintf(os.Stderr, "Invalid -max-backoff-delay value: %s\n", *maxBackoffDelayString)
+			printUsage(flagSet)
+			return 1
+		}
+	}
+
+	// Check the -request-timeout flag
+	requestTimeout, err := time.ParseDuration(*requestTimeoutString)
+	if err != nil || requestTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -request-timeout value: %s\n", *requestTimeoutString)
+		printUsage(flagSet)
+		return 1
+	}
+
+	// Check the -deadline flag
+	deadline, err := time.ParseDuration(*deadlineString)
+	if err != nil || deadline < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -deadline value: %s\n", *deadlineString)
+		printUsage(flagSet)
+		return 1
+	}
+
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if *logFile != "" {
+		fd, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open log file %s: %v\n", *logFile, err)
+			return 1
+		}
+		defer fd.Close()
+
+		// stdout and stderr share the file, so writes to it from the workers are serialized.
+		logWriter := &syncWriter{w: fd}
+		if *logFileOnly {
+			stdout, stderr = logWriter, logWriter
+		} else {
+			stdout, stderr = io.MultiWriter(os.Stdout, logWriter), io.MultiWriter(os.Stderr, logWriter)
+		}
+	}
+
+	options := Options{
+		Destination:          destination,
+		PrefixHostname:       *prefixHostname,
+		NodeName:             *nodeName,
+		StorageClass:         defaultStorageClass,
+		StorageClassRules:    storageClassRules,
+		ACL:                  s3Types.ObjectCannedACL(*acl),
+		EncryptionAlgorithm:  s3Types.ServerSideEncryption(*encAlg),
+		KMSKey:               *kmsKey,
+		BucketKeyEnabled:     *bucketKeyEnabled,
+		SSECustomerKey:       *sseCustomerKey,
+		IgnoreTimestamps:     *ignoreTimestamps,
+		IgnoreCtime:          *ignoreCtime,
+		IgnoreContentType:    *ignoreContentType,
+		TimestampTolerance:   timestampTolerance,
+		ModifiedSince:        modifiedSince,
+		VerifyAfterUpload:    *verifyAfterUpload,
+		RetryChanged:         *retryChanged,
+		RetryFailed:          *retryFailed,
+		VerifyParts:          *verifyParts,
+		Checksum:             *checksum,
+		SizeOnly:             *sizeOnly,
+		MaxConcurrent:        *maxConcurrent,
+		MultipartPartSize:    multipartPartSize,
+		MultipartThreshold:   multipartThreshold,
+		MultipartConcurrency: explicitMultipartConcurrency,
+		ChecksumAlgorithm:    s3Types.ChecksumAlgorithm(*checksumAlgorithm),
+		ContentTypes:         contentTypes,
+		WebsiteRedirects:     websiteRedirects,
+		Policy:               policy,
+		DefaultContentType:   *defaultContentType,
+		CacheControl:         *cacheControl,
+		ContentDisposition:   *contentDisposition,
+		Expires:              expires,
+		ExpiresIn:            expiresIn,
+		Compress:             compress,
+		Dedupe:               *dedupe,
+		CompressMinSize:      compressMinSize,
+		BandwidthLimit:       bandwidthLimit,
+		MaxRPS:               *maxRPS,
+		WalkWorkers:          *walkWorkers,
+		HashWorkers:          *hashWorkers,
+		MaxDepth:             *maxDepth,
+		FailureLimit:         *failureLimit,
+		StopOnError:          *stopOnError,
+		RequestPayer:         *requestPayer,
+		RootSquash:           *rootSquash,
+		RootUnsquash:         *rootUnsquash,
+		UIDMap:               uidMap,
+		GIDMap:               gidMap,
+		Links:                *links,
+		OneFileSystem:        *oneFileSystem,
+		Xattrs:               *xattrs,
+		HardLinks:            *hardLinks,
+		HashAlgorithms:       hashAlgorithms,
+		HashMinSize:          hashMinSize,
+		ReadBufferSize:       int(readBufferSize),
+		HashCache:            *hashCachePath,
+		TrustMtime:           *trustMtime,
+		TrustETag:            *trustETag,
+		ETagMetadata:         *etagMetadata,
+		Prelist:              *prelist,
+		FilesFrom:            *filesFrom,
+		Excludes:             excludes,
+		ExcludeFrom:          *excludeFrom,
+		IgnoreFiles:          *respectIgnoreFiles,
+		Tags:                 tags,
+		TagFromMetadata:      *tagFromMetadata,
+		Annotate:             *annotate,
+		MetadataScheme:       *metadataScheme,
+		MetadataUserAgent:    *metadataUserAgent,
+		Verbosity:            verbosity,
+		LogFormat:            *logFormat,
+		UnicodeNormalize:     *unicodeNormalize,
+		Progress:             *progress,
+		DryRun:               *dryRun,
+		PlanOut:              *planOut,
+		Delete:               *deleteExtraneous,
+		DeleteExcluded:       *deleteExcluded,
+		Report:               *report,
+		Restore:              *restore,
+		List:                 *list,
+		ListLong:             *listLong,
+		Stdout:               stdout,
+		Stderr:               stderr,
+
+		ObjectLockMode:        s3Types.ObjectLockMode(*objectLockMode),
+		ObjectLockRetainUntil: retainUntil,
+		LegalHold:             *legalHold,
+
+		ExtensionStorageClasses: extStorageClasses,
+	}
+
+	// Each source is cloned by its own Cloner. They're all created first so invalid options are
+	// reported before anything is copied.
+	var cloners []*Cloner
+	for _, source := range sources {
+		options.Source = source
+		cloner, err := NewCloner(options, s3Client)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			if errors.Is(err, ErrInvalidS3URL) {
+				return 2
+			}
+
+			return 1
+		}
+
+		cloners = append(cloners, cloner)
+	}
+
+	// The S3 client is configured once, by the first Cloner, and shared with the rest.
+	stc := cloners[0]
+
+	// If AWS_DEFAULT_REGION is set but AWS_REGION is not, set AWS_REGION to AWS_DEFAULT_REGION to be compatible with other SDKs.
+	if _, found := os.LookupEnv("AWS_REGION"); !found {
+		if aws_default_region, found := os.LookupEnv("AWS_DEFAULT_REGION"); found {
+			os.Setenv("AWS_REGION", aws_default_region)
+		}
+	}
+
+	var configOptions []func(*config.LoadOptions) error
+	if *region != "" {
+		configOptions = append(configOptions, config.WithRegion(*region))
+	}
+
+	if *profile != "" {
+		configOptions = append(configOptions, config.WithSharedConfigProfile(*profile))
+	}
+
+	if *accessKeyID != "" {
+		configOptions = append(configOptions, withStaticCredentials(*accessKeyID, *secretAccessKey, *sessionToken))
+	}
+
+	configOptions = append(configOptions, endpointConfigOptions(*useFIPSEndpoint, *useDualStackEndpoint)...)
+
+	s3Options := s3ClientOptions(*endpointURL, *forcePathStyle)
+	if requestTimeout > 0 {
+		s3Options = append(s3Options, withRequestTimeout(requestTimeout))
+	}
+
+	s3Options = append(s3Options, withUserAgent(*metadataUserAgent))
+
+	var retrierFunc func() aws.Retryer
+	if *maxRetries == 0 {
+		retrierFunc = func() aws.Retryer { return aws.NopRetryer{} }
+	} else {
+		retrierFunc = func() aws.Retryer {
+			return retry.NewStandard(func(opts *retry.StandardOptions) {
+				opts.MaxAttempts = *maxRetries
+				opts.MaxBackoff = maxBackoffDelay
+				opts.RateLimiter = ratelimit.NewTokenRateLimit(uint(*maxConcurrent))
+			})
+		}
+	}
+	configOptions = append(configOptions, config.WithRetryer(retrierFunc))
+
+	if s3Client == nil {
+		// With both, the web identity role's credentials are used to assume the -assume-role role.
+		if *roleARN != "" {
+			configOptions, err = withWebIdentity(ctx, configOptions, *roleARN, *webIdentityTokenFile, *roleSessionName)
+			if err != nil {
+				fmt.Fprintf(stderr, "Failed to load AWS config: %v\n", err)
+				return 1
+			}
+		}
+
+		if *assumeRole != "" {
+			configOptions, err = withAssumeRole(ctx, configOptions, *assumeRole, *roleSessionName, *externalID, *mfaSerial, mfaTokenProvider(*mfaToken))
+			if err != nil {
+				fmt.Fprintf(stderr, "Failed to load AWS config: %v\n", err)
+				return 1
+			}
+		}
+
+		awsConfig, err := config.LoadDefaultConfig(ctx, configOptions...)
+		if err != nil {
+			fmt.Fprintf(stderr, "Failed to load AWS config: %v\n", err)
+			return 1
+		}
+
+		if err = checkFIPSRegion(ctx, awsConfig); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+
+		stc.s3Client = s3.NewFromConfig(awsConfig, s3Options...)
+
+		// A custom endpoint has no notion of AWS regions, so don't try to find the bucket's region.
+		if *checkBucket && *endpointURL == "" {
+			err = stc.ReconfigureS3ClientFromBucketLocation(ctx, configOptions, s3Options)
+			if err != nil {
+				fmt.Fprintf(stderr, "%v\n", err)
+				return 1
+			}
+		}
+
+		// If the bucket isn't in the region we picked, S3 says where it is.
+		if *endpointURL == "" {
+			stc.FollowRegionRedirects(configOptions, s3Options)
+		}
+	}
+
+	var summary Summary
+	var failures []errorReportEntry
+	writeFailures := func() bool {
+		if *errorReport == "" {
+			return true
+		}
+
+		if err := writeErrorReport(*errorReport, failures, *logFormat); err != nil {
+			fmt.Fprintf(stderr, "Unable to write error report %s: %v\n", *errorReport, err)
+			return false
+		}
+
+		return true
+	}
+
+	for _, cloner := range cloners {
+		if cloner != stc {
+			cloner.s3Client = stc.s3Client
+			cloner.sourceHost, cloner.runID = stc.sourceHost, stc.runID
+		}
+
+		sourceSummary, err := cloner.Clone(ctx)
+		summary.add(sourceSummary)
+		failures = append(failures, cloner.errorReportEntries(sourceSummary.Errors)...)
+
+		// Past the deadline, report what was done rather than the cancellation.
+		if ctx.Err() == context.DeadlineExceeded {
+			break
+		}
+
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			writeFailures()
+			return 1
+		}
+	}
+
+	if !*restore && !*report && !*list {
+		stc.writeSummary(stderr, summary)
+	}
+
+	if !writeFailures() {
+		return 1
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintf(stderr, "Deadline of %v exceeded; partial sync\n", deadline)
+		return deadlineExitCode
+	}
+
+	if summary.Failed > 0 {
+		fmt.Fprintf(stderr, "%d of %d objects failed\n", summary.Failed, summary.Examined)
+		return 1
+	}
+
+	if summary.Differences > 0 {
+		return reportExitCode
+	}
+
+	return 0
+}
+
+// stringList is a flag.Value for flags that may be specified multiple times.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
+// idMappingList is a flag.Value for from:to ID mappings that may be specified multiple times.
+type idMappingList []IDMapping
+
+func (iml *idMappingList) String() string {
+	var mappings []string
+	for _, mapping := range *iml {
+		from := strconv.FormatUint(uint64(mapping.From), 10)
+		if mapping.Any {
+			from = "*"
+		}
+
+		mappings = append(mappings, fmt.Sprintf("%s:%d", from, mapping.To))
+	}
+
+	return strings.Join(mappings, ",")
+}
+
+func (iml *idMappingList) Set(value string) error {
+	mapping, err := ParseIDMapping(value)
+	if err != nil {
+		return err
+	}
+
+	*iml = append(*iml, mapping)
+	return nil
+}
+
+// contentTypeMap is a flag.Value for .ext=type/subtype content type overrides that may be specified
+// multiple times. A later override replaces an earlier one for the same extension.
+type contentTypeMap map[string]string
+
+func (ctm contentTypeMap) String() string {
+	var mappings []string
+	for ext, contentType := range ctm {
+		mappings = append(mappings, ext+"="+contentType)
+	}
+
+	sort.Strings(mappings)
+	return strings.Join(mappings, ",")
+}
+
+func (ctm contentTypeMap) Set(value string) error {
+	ext, contentType, err := ParseContentTypeMapping(value)
+	if err != nil {
+		return err
+	}
+
+	ctm[ext] = contentType
+	return nil
+}
+
+// websiteRedirectMap is a flag.Value for key=target website redirects that may be specified
+// multiple times. A later redirect replaces an earlier one for the same key.
+type websiteRedirectMap map[string]string
+
+func (wrm websiteRedirectMap) String() string {
+	var redirects []string
+	for key, target := range wrm {
+		redirects = append(redirects, key+"="+target)
+	}
+
+	sort.Strings(redirects)
+	return strings.Join(redirects, ",")
+}
+
+func (wrm websiteRedirectMap) Set(value string) error {
+	key, target, err := ParseWebsiteRedirect(value)
+	if err != nil {
+		return err
+	}
+
+	wrm[key] = target
+	return nil
+}
+
+// tagMap is a flag.Value for key=value tags that may be specified multiple times. A later tag
+// replaces an earlier one with the same key.
+type tagMap map[string]string
+
+func (tm tagMap) String() string {
+	var tags []string
+	for key, value := range tm {
+		tags = append(tags, key+"="+value)
+	}
+
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+func (tm tagMap) Set(value string) error {
+	key, tagValue, err := ParseTag(value)
+	if err != nil {
+		return err
+	}
+
+	tm[key] = tagValue
+	return nil
+}
+
+func printUsage(flagSet *flag.FlagSet) {
+	var out = flagSet.Output()
+	fmt.Fprintf(out,
+		`s3-tree-clone [options] <src-dir>... s3://<bucket>/<prefix>
+s3-tree-clone -restore [options] s3://<bucket>/<prefix> <dest-dir>
+s3-tree-clone -list [-l] [options] s3://<bucket>/<prefix>
+Copy the filesystem tree rooted at <src-dir> to the given S3 destination.
+If <prefix> is non-empty, it will have a slash appended if necessary.
+
+The <src-dir> argument is interpreted similarly to rsync: if it ends with a /,
+no directory is created in the S3 destination. If it does not end with a /,
+the directory at the end of <src-dir> is created. If several source directories
+are given, each is copied in turn.
+
+With -restore, the objects under <prefix> are downloaded into <dest-dir>, and
+their recorded ownership, permissions, and timestamps are re-applied.
+
+With -list, the objects under <prefix> are listed on stdout without changing
+anything.
+`)
+
+	flagSet.PrintDefaults()
+}
+
+// s3ClientOptions returns the options to apply to every S3 client we create.
+func s3ClientOptions(endpointURL string, forcePathStyle bool) []func(*s3.Options) {
+	var options []func(*s3.Options)
+
+	if endpointURL != "" {
+		options = append(options, func(o *s3.Options) {
+			o.EndpointResolver = s3.EndpointResolverFromURL(endpointURL)
+		})
+	}
+
+	if forcePathStyle {
+		options = append(options, func(o *s3.Options) {
+			o.UsePathStyle = true
+		})
+	}
+
+	return options
+}
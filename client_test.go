@@ -1,6 +1,7 @@
-package main
+package s3treeclone
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"crypto/rand"
@@ -9,8 +10,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,7 +28,9 @@ import (
 )
 
 type s3TestObject struct {
+	Body               []byte
 	CacheControl       *string
+	Checksum           *s3Types.Checksum
 	ContentDisposition *string
 	ContentEncoding    *string
 	ContentLanguage    *string
@@ -35,20 +42,30 @@ type s3TestObject struct {
 	LastModified       *time.Time
 	Metadata           map[string]string
 	MissingMeta        int32
+	Parts              []s3Types.ObjectPart
 	PartsCount         int32
+	Restore            *string
+	StorageClass       s3Types.StorageClass
 	VersionId          *string
+
+	WebsiteRedirectLocation *string
 }
 
 type s3TestBucket struct {
-	Name     string
-	Location s3Types.BucketLocationConstraint
-	Objects  map[string]*s3TestObject
-	Mutex    *sync.Mutex
+	Name              string
+	Location          s3Types.BucketLocationConstraint
+	ObjectLockEnabled bool
+	Objects           map[string]*s3TestObject
+	Mutex             *sync.Mutex
 }
 
 type s3TestClient struct {
 	Buckets map[string]*s3TestBucket
 	Mutex   *sync.Mutex
+
+	// PutObjectError, if set, is called before each PutObject; a non-nil return value causes the
+	// call to fail with that error.
+	PutObjectError func(input *s3.PutObjectInput) error
 }
 
 func newS3TestClient() *s3TestClient {
@@ -87,6 +104,71 @@ func (c *s3TestClient) CompleteMultipartUpload(ctx context.Context, input *s3.Co
 	}, nil
 }
 
+func (c *s3TestClient) CopyObject(ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	copySource, err := url.PathUnescape(*input.CopySource)
+	if err != nil {
+		return nil, makeS3Error("CopyObject", 400, "Bad Request", "InvalidArgument", "Invalid copy source")
+	}
+
+	sourceBucketName, sourceKey := copySource, ""
+	if slash := strings.IndexByte(copySource, '/'); slash != -1 {
+		sourceBucketName, sourceKey = copySource[:slash], copySource[slash+1:]
+	}
+
+	c.Mutex.Lock()
+	sourceBucket, sourceFound := c.Buckets[sourceBucketName]
+	bucket, found := c.Buckets[*input.Bucket]
+	c.Mutex.Unlock()
+	if !sourceFound || !found {
+		return nil, makeS3Error("CopyObject", 404, "Not Found", "NoSuchBucket", "The specified bucket does not exist")
+	}
+
+	sourceBucket.Mutex.Lock()
+	source, found := sourceBucket.Objects[sourceKey]
+	sourceBucket.Mutex.Unlock()
+	if !found {
+		return nil, makeS3Error("CopyObject", 404, "Not Found", "NoSuchKey", "The specified key does not exist.")
+	}
+
+	if isArchived(&s3.HeadObjectOutput{StorageClass: source.StorageClass, Restore: source.Restore}) {
+		return nil, makeS3Error("CopyObject", 403, "Forbidden", "InvalidObjectState", "The operation is not valid for the object's storage class")
+	}
+
+	// Only replacing the metadata is supported. Like S3, the copy is a single part, whose ETag is
+	// the MD5 sum of the content.
+	object := &s3TestObject{
+		Body:               source.Body,
+		CacheControl:       copyAWSString(input.CacheControl),
+		ContentDisposition: copyAWSString(input.ContentDisposition),
+		ContentEncoding:    copyAWSString(input.ContentEncoding),
+		ContentLanguage:    copyAWSString(input.ContentLanguage),
+		ContentLength:      source.ContentLength,
+		ContentType:        copyAWSString(input.ContentType),
+		Checksum:           testChecksum(input.ChecksumAlgorithm, source.Body),
+		ETag:               aws.String(fmt.Sprintf("\"%x\"", md5.Sum(source.Body))),
+		Expires:            copyAWSTime(input.Expires),
+		LastModified:       aws.Time(time.Now().UTC()),
+		Metadata:           copyAWSMapStringString(input.Metadata),
+		StorageClass:       input.StorageClass,
+		VersionId:          aws.String("000000000000"),
+
+		WebsiteRedirectLocation: copyAWSString(input.WebsiteRedirectLocation),
+	}
+
+	bucket.Mutex.Lock()
+	bucket.Objects[*input.Key] = object
+	bucket.Mutex.Unlock()
+	fmt.Fprintf(os.Stderr, "S3TestClient: Copied object s3://%s/%s to s3://%s/%s\n", sourceBucketName, sourceKey, *input.Bucket, *input.Key)
+
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &s3Types.CopyObjectResult{
+			ETag:         copyAWSString(object.ETag),
+			LastModified: copyAWSTime(object.LastModified),
+		},
+		VersionId: copyAWSString(object.VersionId),
+	}, nil
+}
+
 func (c *s3TestClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
 	return &s3.CreateMultipartUploadOutput{
 		Bucket:               input.Bucket,
@@ -96,6 +178,29 @@ func (c *s3TestClient) CreateMultipartUpload(ctx context.Context, input *s3.Crea
 	}, nil
 }
 
+func (c *s3TestClient) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	c.Mutex.Lock()
+	bucket, found := c.Buckets[*input.Bucket]
+	c.Mutex.Unlock()
+	if !found {
+		return nil, makeS3Error("DeleteObjects", 404, "Not Found", "NoSuchBucket", "The specified bucket does not exist")
+	}
+
+	result := &s3.DeleteObjectsOutput{}
+
+	bucket.Mutex.Lock()
+	defer bucket.Mutex.Unlock()
+
+	for _, object := range input.Delete.Objects {
+		delete(bucket.Objects, *object.Key)
+		if !input.Delete.Quiet {
+			result.Deleted = append(result.Deleted, s3Types.DeletedObject{Key: copyAWSString(object.Key)})
+		}
+	}
+
+	return result, nil
+}
+
 func (c *s3TestClient) GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, opts ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
 	if c.Buckets == nil {
 		c.Buckets = make(map[string]*s3TestBucket)
@@ -112,6 +217,114 @@ func (c *s3TestClient) GetBucketLocation(ctx context.Context, input *s3.GetBucke
 	}, nil
 }
 
+func (c *s3TestClient) GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.Mutex.Lock()
+	bucket, found := c.Buckets[*input.Bucket]
+	c.Mutex.Unlock()
+	if !found {
+		return nil, makeS3Error("GetObject", 404, "Not Found", "NoSuchBucket", "The specified bucket does not exist")
+	}
+
+	bucket.Mutex.Lock()
+	object, found := bucket.Objects[*input.Key]
+	bucket.Mutex.Unlock()
+	if !found {
+		return nil, makeS3Error("GetObject", 404, "Not Found", "NoSuchKey", "The specified key does not exist.")
+	}
+
+	return &s3.GetObjectOutput{
+		Body:               ioutil.NopCloser(bytes.NewReader(object.Body)),
+		CacheControl:       copyAWSString(object.CacheControl),
+		ContentDisposition: copyAWSString(object.ContentDisposition),
+		ContentEncoding:    copyAWSString(object.ContentEncoding),
+		ContentLanguage:    copyAWSString(object.ContentLanguage),
+		ContentLength:      int64(len(object.Body)),
+		ContentType:        copyAWSString(object.ContentType),
+		ETag:               copyAWSString(object.ETag),
+		Expires:            object.Expires,
+		LastModified:       copyAWSTime(object.LastModified),
+		Metadata:           copyAWSMapStringString(object.Metadata),
+		VersionId:          object.VersionId,
+
+		WebsiteRedirectLocation: copyAWSString(object.WebsiteRedirectLocation),
+	}, nil
+}
+
+// testMaxParts is the number of parts returned by each GetObjectAttributes call if MaxParts isn't
+// set. It's smaller than S3's limit of 1,000 so listing multiple pages can be tested.
+const testMaxParts = 2
+
+func (c *s3TestClient) GetObjectAttributes(ctx context.Context, input *s3.GetObjectAttributesInput, opts ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	c.Mutex.Lock()
+	bucket, found := c.Buckets[*input.Bucket]
+	c.Mutex.Unlock()
+	if !found {
+		return nil, makeS3Error("GetObjectAttributes", 404, "Not Found", "NoSuchBucket", "The specified bucket does not exist")
+	}
+
+	bucket.Mutex.Lock()
+	object, found := bucket.Objects[*input.Key]
+	bucket.Mutex.Unlock()
+	if !found {
+		return nil, makeS3Error("GetObjectAttributes", 404, "Not Found", "NoSuchKey", "The specified key does not exist.")
+	}
+
+	output := &s3.GetObjectAttributesOutput{
+		Checksum:   object.Checksum,
+		ETag:       copyAWSString(object.ETag),
+		ObjectSize: object.ContentLength,
+		VersionId:  object.VersionId,
+	}
+
+	if object.PartsCount > 0 {
+		maxParts := int(input.MaxParts)
+		if maxParts == 0 {
+			maxParts = testMaxParts
+		}
+
+		start := 0
+		if input.PartNumberMarker != nil {
+			fmt.Sscanf(*input.PartNumberMarker, "%d", &start)
+		}
+
+		end := start + maxParts
+		if end > len(object.Parts) {
+			end = len(object.Parts)
+		}
+
+		output.ObjectParts = &s3Types.GetObjectAttributesParts{
+			IsTruncated:      end < len(object.Parts),
+			MaxParts:         int32(maxParts),
+			PartNumberMarker: copyAWSString(input.PartNumberMarker),
+			Parts:            object.Parts[start:end],
+			TotalPartsCount:  object.PartsCount,
+		}
+
+		if end < len(object.Parts) {
+			output.ObjectParts.NextPartNumberMarker = aws.String(fmt.Sprintf("%d", end))
+		}
+	}
+
+	return output, nil
+}
+
+func (c *s3TestClient) GetObjectLockConfiguration(ctx context.Context, input *s3.GetObjectLockConfigurationInput, opts ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	c.Mutex.Lock()
+	bucket, found := c.Buckets[*input.Bucket]
+	c.Mutex.Unlock()
+	if !found {
+		return nil, makeS3Error("GetObjectLockConfiguration", 404, "Not Found", "NoSuchBucket", "The specified bucket does not exist")
+	}
+
+	if !bucket.ObjectLockEnabled {
+		return nil, makeS3Error("GetObjectLockConfiguration", 404, "Not Found", "ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket")
+	}
+
+	return &s3.GetObjectLockConfigurationOutput{
+		ObjectLockConfiguration: &s3Types.ObjectLockConfiguration{ObjectLockEnabled: s3Types.ObjectLockEnabledEnabled},
+	}, nil
+}
+
 func (c *s3TestClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
 	if c.Buckets == nil {
 		c.Buckets = make(map[string]*s3TestBucket)
@@ -131,7 +344,7 @@ func (c *s3TestClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput
 		return nil, makeS3Error("HeadObject", 404, "Not Found", "NotFound", "Not Found")
 	}
 
-	return &s3.HeadObjectOutput{
+	output := &s3.HeadObjectOutput{
 		CacheControl:       copyAWSString(object.CacheControl),
 		ContentDisposition: copyAWSString(object.ContentDisposition),
 		ContentEncoding:    copyAWSString(object.ContentEncoding),
@@ -145,11 +358,82 @@ func (c *s3TestClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput
 		Metadata:           copyAWSMapStringString(object.Metadata),
 		MissingMeta:        object.MissingMeta,
 		PartsCount:         object.PartsCount,
+		Restore:            copyAWSString(object.Restore),
+		StorageClass:       object.StorageClass,
 		VersionId:          object.VersionId,
-	}, nil
+
+		WebsiteRedirectLocation: copyAWSString(object.WebsiteRedirectLocation),
+	}
+
+	if input.ChecksumMode == s3Types.ChecksumModeEnabled && object.Checksum != nil {
+		output.ChecksumCRC32 = copyAWSString(object.Checksum.ChecksumCRC32)
+		output.ChecksumCRC32C = copyAWSString(object.Checksum.ChecksumCRC32C)
+		output.ChecksumSHA1 = copyAWSString(object.Checksum.ChecksumSHA1)
+		output.ChecksumSHA256 = copyAWSString(object.Checksum.ChecksumSHA256)
+	}
+
+	return output, nil
+}
+
+func (c *s3TestClient) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.Mutex.Lock()
+	bucket, found := c.Buckets[*input.Bucket]
+	c.Mutex.Unlock()
+	if !found {
+		return nil, makeS3Error("ListObjectsV2", 404, "Not Found", "NoSuchBucket", "The specified bucket does not exist")
+	}
+
+	prefix := aws.ToString(input.Prefix)
+	startAfter := aws.ToString(input.ContinuationToken)
+	maxKeys := int(input.MaxKeys)
+	if maxKeys <= 0 || maxKeys > 1000 {
+		maxKeys = 1000
+	}
+
+	bucket.Mutex.Lock()
+	defer bucket.Mutex.Unlock()
+
+	var keys []string
+	for key := range bucket.Objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := &s3.ListObjectsV2Output{
+		Name:   input.Bucket,
+		Prefix: input.Prefix,
+	}
+
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = aws.String(keys[len(keys)-1])
+	}
+
+	for _, key := range keys {
+		object := bucket.Objects[key]
+		result.Contents = append(result.Contents, s3Types.Object{
+			Key:          aws.String(key),
+			Size:         object.ContentLength,
+			ETag:         copyAWSString(object.ETag),
+			LastModified: copyAWSTime(object.LastModified),
+			StorageClass: s3Types.ObjectStorageClassStandard,
+		})
+	}
+	result.KeyCount = int32(len(result.Contents))
+
+	return result, nil
 }
 
 func (stc *s3TestClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if stc.PutObjectError != nil {
+		if err := stc.PutObjectError(input); err != nil {
+			return nil, err
+		}
+	}
+
 	bucket, found := stc.Buckets[*input.Bucket]
 	if !found {
 		bucket = &s3TestBucket{
@@ -159,18 +443,32 @@ func (stc *s3TestClient) PutObject(ctx context.Context, input *s3.PutObjectInput
 	}
 
 	hasher := md5.New()
+	var body bytes.Buffer
 	buffer := make([]byte, 65536)
 	var totalSize int64
 	for {
 		n, err := input.Body.Read(buffer)
+		hasher.Write(buffer[:n])
+		body.Write(buffer[:n])
+		totalSize += int64(n)
 		if err != nil {
 			break
 		}
-		hasher.Write(buffer[:n])
-		totalSize += int64(n)
+	}
+
+	// Like S3, a checksum sent with the body must match it, and one is computed if only the
+	// algorithm is given.
+	checksum := testChecksum(input.ChecksumAlgorithm, body.Bytes())
+	if checksum != nil {
+		expected := &s3Types.Checksum{ChecksumCRC32: input.ChecksumCRC32, ChecksumCRC32C: input.ChecksumCRC32C, ChecksumSHA1: input.ChecksumSHA1, ChecksumSHA256: input.ChecksumSHA256}
+		if sent := checksumFor(input.ChecksumAlgorithm).stored(expected); sent != nil && *sent != *checksumFor(input.ChecksumAlgorithm).stored(checksum) {
+			return nil, makeS3Error("PutObject", 400, "Bad Request", "BadDigest", "The "+string(input.ChecksumAlgorithm)+" you specified did not match the calculated checksum.")
+		}
 	}
 
 	object := &s3TestObject{
+		Body:               body.Bytes(),
+		Checksum:           checksum,
 		CacheControl:       copyAWSString(input.CacheControl),
 		ContentDisposition: copyAWSString(input.ContentDisposition),
 		ContentEncoding:    copyAWSString(input.ContentEncoding),
@@ -181,7 +479,10 @@ func (stc *s3TestClient) PutObject(ctx context.Context, input *s3.PutObjectInput
 		Expires:            copyAWSTime(input.Expires),
 		LastModified:       aws.Time(time.Now().UTC()),
 		Metadata:           copyAWSMapStringString(input.Metadata),
+		StorageClass:       input.StorageClass,
 		VersionId:          aws.String("000000000000"),
+
+		WebsiteRedirectLocation: copyAWSString(input.WebsiteRedirectLocation),
 	}
 
 	bucket.Mutex.Lock()
@@ -227,6 +528,29 @@ func (stre *S3TestResponseError) As(target interface{}) bool {
 	return errors.As(stre.ResponseError, target)
 }
 
+// testChecksum returns the checksum S3 keeps for an object with the given body uploaded with a
+// single request using the given checksum algorithm, or nil if no algorithm is given.
+func testChecksum(algorithm s3Types.ChecksumAlgorithm, body []byte) *s3Types.Checksum {
+	if algorithm == "" {
+		return nil
+	}
+
+	h := checksumFor(algorithm).newHash()
+	h.Write(body)
+	value := aws.String(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+
+	switch algorithm {
+	case s3Types.ChecksumAlgorithmSha256:
+		return &s3Types.Checksum{ChecksumSHA256: value}
+	case s3Types.ChecksumAlgorithmSha1:
+		return &s3Types.Checksum{ChecksumSHA1: value}
+	case s3Types.ChecksumAlgorithmCrc32c:
+		return &s3Types.Checksum{ChecksumCRC32C: value}
+	default:
+		return &s3Types.Checksum{ChecksumCRC32: value}
+	}
+}
+
 func makeS3Error(operation string, statusCode int, statusReason, errorCode, errorMessage string) *smithy.OperationError {
 	requestID := generateRequestID()
 	amzID2 := generateAmzID2()
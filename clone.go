@@ -0,0 +1,2067 @@
+package s3treeclone
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+// Cloner copies a filesystem tree to S3, recording each file's ownership, permissions, and
+// timestamps in the object metadata, or restores it from S3. Use NewCloner to create one.
+type Cloner struct {
+	// Counters updated atomically by the HandleFile goroutines. These are kept at the top of
+	// the struct to guarantee 64-bit alignment.
+	nObjects      int64
+	nFailed       int64
+	nUploaded     int64
+	nSkipped      int64
+	nDirsCreated  int64
+	nDeleted      int64
+	nConflicts    int64
+	bytesUploaded int64
+
+	// The number of S3 requests in a row that failed with a fatal error.
+	nConsecutiveFailures int64
+
+	ctx                  context.Context
+	cancel               context.CancelFunc
+	failureLimit         int
+	stopOnError          bool
+	abortOnce            sync.Once
+	abortErr             error
+	stdin                io.Reader
+	stdout               io.Writer
+	stderr               io.Writer
+	maxConcurrent        int
+	startTime            time.Time
+	sem                  *semaphore.Weighted
+	waitGroup            *sync.WaitGroup
+	walkWorkers          int
+	hashWorkers          int
+	jobs                 chan walkJob
+	hashJobs             chan *fileJob
+	uploadJobs           chan *fileJob
+	hashWaitGroup        sync.WaitGroup
+	uploadWaitGroup      sync.WaitGroup
+	queueMutex           sync.Mutex
+	queueCond            *sync.Cond
+	queuedDirs           []walkDir
+	queuedRetries        []walkJob
+	nPending             int
+	noRecurse            bool
+	maxDepth             int
+	links                string
+	oneFileSystem        bool
+	xattrs               bool
+	rootDev              uint64
+	s3Client             S3Interface
+	storageClass         s3Types.StorageClass
+	storageClassRules    []StorageClassRule
+	extStorageClasses    map[string]s3Types.StorageClass
+	acl                  s3Types.ObjectCannedACL
+	requestPayer         s3Types.RequestPayer
+	encAlg               s3Types.ServerSideEncryption
+	ignoreTimestamps     bool
+	ignoreCtime          bool
+	ignoreContentType    bool
+	timestampTolerance   time.Duration
+	modifiedSince        time.Time
+	verifyAfterUpload    bool
+	checksumAlgorithm    s3Types.ChecksumAlgorithm
+	kmsKey               string
+	bucketKeyEnabled     bool
+	multipartPartSize    int64
+	multipartThreshold   int64
+	multipartConcurrency int
+	limiter              *rate.Limiter
+	requestLimiter       *rate.Limiter
+	sseCustomerAlgorithm string
+	sseCustomerKey       string
+	sseCustomerKeyMD5    string
+	lockMode             s3Types.ObjectLockMode
+	lockRetainUntil      time.Time
+	legalHold            bool
+	bucket               string
+	prefix               string
+	nodeName             string
+	rootUID              uint32
+	rootGID              uint32
+	rootUnsquash         bool
+	squashedUID          uint32
+	squashedGID          uint32
+	uidMap               []IDMapping
+	contentTypes         map[string]string
+	defaultContentType   string
+	cacheControl         string
+	expires              time.Time
+	expiresIn            time.Duration
+	websiteRedirects     map[string]string
+	policy               []PolicyRule
+	contentDisposition   string
+	hashAlgorithms       []string
+	hashMinSize          int64
+	retryChanged         bool
+	verifyParts          bool
+	checksum             bool
+	sizeOnly             bool
+	changedMutex         sync.Mutex
+	changedFiles         map[string]bool
+	readBuffers          *sync.Pool
+	compress             []string
+	dedupe               bool
+	dedupeMutex          sync.Mutex
+	dedupeSources        map[string]dedupeSource
+	hardLinks            bool
+	hardLinkMutex        sync.Mutex
+	hardLinkKeys         map[inode]string
+	hardLinkJobs         []walkJob
+	hardLinksResolved    bool
+	restoreLinks         []restoreLink
+	compressMinSize      int64
+	gidMap               []IDMapping
+	baseDir              string
+	firstFilter          string
+	filesFrom            string
+	hashCachePath        string
+	prelist              bool
+	restore              bool
+	list                 bool
+	listLong             bool
+	verbosity            Verbosity
+	progress             bool
+	progressInterval     time.Duration
+	progressLine         *progressLine
+	logFormat            string
+	unicodeNormalize     string
+	dryRun               bool
+	deleteExtraneous     bool
+	deleteExcluded       bool
+	planOut              string
+	planMutex            sync.Mutex
+	planReasons          map[string]string
+	plan                 []planEntry
+	excludes             []string
+	respectIgnoreFiles   bool
+	tags                 map[string]string
+	tagFromMetadata      bool
+	sourceHost           string
+	runID                string
+	metadataScheme       metadataScheme
+	readMetadataSchemes  []metadataScheme
+	userAgent            string
+	errorsMutex          sync.Mutex
+	retryFailed          int
+	failedPaths          map[string]bool
+	failedJobs           []walkJob
+	errors               []PathError
+	visitedMutex         sync.Mutex
+	visitedKeys          map[string]bool
+	report               bool
+	reportMutex          sync.Mutex
+	reportEntries        []reportEntry
+	missingKeys          []string
+	hashCache            *hashCache
+	trustMtime           bool
+	trustETag            bool
+	etagMetadata         bool
+	listing              map[string]listedObject
+}
+
+// singlePassMaxSize is the largest file that UploadFile reads into memory so it only has to be read
+// from disk once.
+const singlePassMaxSize = 8 * 1024 * 1024
+
+// Values for the -links flag.
+const (
+	LinksSkip   = "skip"
+	LinksFollow = "follow"
+	LinksStore  = "store"
+)
+
+// fileStat holds the parts of a file's status that we record in S3. Each platform provides a
+// getFileStat function to extract this from an os.FileInfo.
+type fileStat struct {
+	Size  int64
+	Mode  uint32
+	Uid   uint32
+	Gid   uint32
+	Ctime int64 // Nanoseconds since the Unix epoch
+	Mtime int64 // Nanoseconds since the Unix epoch
+	Dev   uint64
+	Ino   uint64
+	Nlink uint64
+}
+
+// walkJob is a directory entry waiting to be examined by a walk worker.
+type walkJob struct {
+	relPath  string
+	dirName  string
+	filename string
+	ignores  *ignoreRules
+}
+
+// walkDir is a directory whose entries have not yet been read.
+type walkDir struct {
+	relPath string
+	dirName string
+	ignores *ignoreRules
+}
+
+// Hashes holds the hashes of a file's contents. Hashes that weren't computed are nil.
+type Hashes struct {
+	MD5    []byte
+	SHA1   []byte
+	SHA256 []byte
+	SHA512 []byte
+}
+
+// HashAlgorithms are the names of the hashes that can be computed, from weakest to strongest. Each
+// name is also the metadata key the hash is stored under.
+var HashAlgorithms = []string{"md5", "sha1", "sha256", "sha512"}
+
+// get returns the hash for the given algorithm, or nil if it wasn't computed.
+func (h *Hashes) get(algorithm string) []byte {
+	switch algorithm {
+	case "md5":
+		return h.MD5
+	case "sha1":
+		return h.SHA1
+	case "sha256":
+		return h.SHA256
+	case "sha512":
+		return h.SHA512
+	}
+
+	return nil
+}
+
+// hasAll determines whether all of the given hashes were computed.
+func (h *Hashes) hasAll(algorithms []string) bool {
+	for _, algorithm := range algorithms {
+		if len(h.get(algorithm)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseHashAlgorithms parses a comma-separated list of hash algorithm names, such as "sha256,md5".
+func ParseHashAlgorithms(s string) ([]string, error) {
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validHashAlgorithm(name) {
+			return nil, fmt.Errorf("Unknown hash algorithm: %#v", name)
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			algorithms = append(algorithms, name)
+		}
+	}
+
+	return algorithms, nil
+}
+
+func validHashAlgorithm(name string) bool {
+	for _, algorithm := range HashAlgorithms {
+		if name == algorithm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// S3Interface encapsulates the required APIs for our functionality. We use this for unit testing.
+type S3Interface interface {
+	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	CopyObject(context.Context, *s3.CopyObjectInput, ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	DeleteObjects(context.Context, *s3.DeleteObjectsInput, ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	GetBucketLocation(context.Context, *s3.GetBucketLocationInput, ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error)
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	GetObjectAttributes(context.Context, *s3.GetObjectAttributesInput, ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error)
+	GetObjectLockConfiguration(context.Context, *s3.GetObjectLockConfigurationInput, ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error)
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(context.Context, *s3.ListObjectsV2Input, ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+}
+
+// treePrefix returns the prefix of the objects corresponding to the source tree. If the source
+// doesn't end with a /, only the top-level directory is synchronized, so this is limited to it.
+// If it ends with a glob, the matching entries may be anywhere under the destination prefix.
+func (stc *Cloner) treePrefix() string {
+	if stc.firstFilter == "" || isGlob(stc.firstFilter) {
+		return stc.prefix
+	}
+
+	return stc.prefix + stc.firstFilter + "/"
+}
+
+// isGlob determines whether the final component of the source is a glob pattern rather than a
+// literal name.
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[\\")
+}
+
+// matchesFilter determines whether a top-level entry in the source directory matches the final
+// component of the source, which may be a glob. A literal name always matches itself, even if it
+// contains glob characters.
+func matchesFilter(filter, name string) bool {
+	if name == filter {
+		return true
+	}
+
+	matched, _ := path.Match(filter, name)
+	return matched
+}
+
+// inTree determines whether an object under treePrefix belongs to the source tree. This only
+// matters for glob sources, where objects whose top-level name doesn't match the glob are outside
+// of it.
+func (stc *Cloner) inTree(key string) bool {
+	if !isGlob(stc.firstFilter) {
+		return true
+	}
+
+	name := strings.TrimPrefix(key, stc.prefix)
+	if slash := strings.IndexByte(name, '/'); slash != -1 {
+		name = name[:slash]
+	}
+
+	return matchesFilter(stc.firstFilter, name)
+}
+
+// readFileList reads newline-separated paths from r. Blank lines are ignored, and paths are
+// cleaned so they are relative to the source directory. An absolute path inside the source
+// directory, such as one printed by find, is made relative to it; other absolute paths are taken
+// to be relative to the source directory already.
+func (stc *Cloner) readFileList(r io.Reader) ([]string, error) {
+	root, err := filepath.Abs(path.Join(stc.baseDir, stc.firstFilter))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name := strings.TrimSuffix(scanner.Text(), "\r")
+		if name == "" {
+			continue
+		}
+
+		if filepath.IsAbs(name) {
+			if relPath, err := filepath.Rel(root, name); err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+				name = relPath
+			}
+		}
+
+		name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+		if name == "" {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names, scanner.Err()
+}
+
+// lookupNFSNobody returns the UID and GID of the nfsnobody user.
+func lookupNFSNobody() (uint32, uint32, error) {
+	nobody, err := user.Lookup("nfsnobody")
+	if err != nil {
+		return 0, 0, fmt.Errorf("User nfsnobody does not exist: %w", err)
+	}
+
+	uid, err := strconv.ParseUint(nobody.Uid, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to convert nfsnobody UID to int: %s: %w", nobody.Uid, err)
+	}
+
+	gid, err := strconv.ParseUint(nobody.Gid, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to convert nfsnobody GID to int: %s: %w", nobody.Gid, err)
+	}
+
+	return uint32(uid), uint32(gid), nil
+}
+
+// SetRootFromNFSNobody sets the owner and group recorded for root-owned files to nfsnobody.
+func (stc *Cloner) SetRootFromNFSNobody() error {
+	uid, gid, err := lookupNFSNobody()
+	if err != nil {
+		return err
+	}
+
+	stc.rootUID = uid
+	stc.rootGID = gid
+	return nil
+}
+
+// SetUnsquashFromNFSNobody treats objects owned by nfsnobody as owned by root, reversing a root
+// squash done by an earlier upload: they match root-owned files and are restored as owned by root.
+func (stc *Cloner) SetUnsquashFromNFSNobody() error {
+	uid, gid, err := lookupNFSNobody()
+	if err != nil {
+		return err
+	}
+
+	stc.rootUnsquash = true
+	stc.squashedUID = uid
+	stc.squashedGID = gid
+	return nil
+}
+
+// unsquashedID returns the file-owner or file-group ID, mapping the nfsnobody ID back to root if
+// root unsquash is enabled.
+func (stc *Cloner) unsquashedID(id uint32, ownerType string) uint32 {
+	if !stc.rootUnsquash {
+		return id
+	}
+
+	if (ownerType == "file-owner" && id == stc.squashedUID) || (ownerType == "file-group" && id == stc.squashedGID) {
+		return 0
+	}
+
+	return id
+}
+
+func (stc *Cloner) SetBucketAndPrefix(dest string) error {
+	if !strings.HasPrefix(dest, "s3://") {
+		return fmt.Errorf("Destination must be an S3 URL\n")
+	}
+
+	bucketAndPrefix := strings.TrimPrefix(dest, "s3://")
+	bucketAndPrefixParts := strings.SplitN(bucketAndPrefix, "/", 2)
+
+	if len(bucketAndPrefixParts) != 2 {
+		stc.bucket = bucketAndPrefixParts[0]
+		stc.prefix = ""
+	} else {
+		stc.bucket = bucketAndPrefixParts[0]
+		stc.prefix = strings.TrimRight(bucketAndPrefixParts[1], "/")
+		if len(stc.prefix) > 0 {
+			stc.prefix += "/"
+		}
+
+		if prefix := normalizeKey(stc.prefix); prefix != stc.prefix {
+			stc.printf(stc.stderr, levelInfo, "Warning: normalized prefix %#v to %#v\n", stc.prefix, prefix)
+			stc.prefix = prefix
+		}
+	}
+
+	// With -prefix-hostname, each host writes below its own name under the given prefix.
+	if stc.nodeName != "" {
+		stc.prefix += stc.nodeName + "/"
+	}
+
+	return nil
+}
+
+// normalizeKey collapses repeated slashes and removes "." and ".." elements from a key. A trailing
+// slash, which marks a directory, is preserved.
+func normalizeKey(key string) string {
+	if key == "" {
+		return ""
+	}
+
+	cleaned := path.Clean(key)
+	switch {
+	case cleaned == ".":
+		return ""
+
+	case cleaned != "/" && strings.HasSuffix(key, "/"):
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+// objectKey returns the key of the object for a file, normalizing it (with a warning) if the parts
+// would produce repeated slashes or relative elements. With -unicode-normalize, the parts from the
+// source are first converted to the chosen form; the prefix is used as given.
+func (stc *Cloner) objectKey(pathname, relPath, filename string, isDir bool) string {
+	key := stc.prefix
+	for _, part := range []string{relPath, filename} {
+		if part == "" {
+			continue
+		}
+
+		part = stc.normalizeUnicode(part)
+
+		if key != "" && !strings.HasSuffix(key, "/") {
+			key += "/"
+		}
+		key += part
+	}
+
+	if isDir {
+		key += "/"
+	}
+
+	normalized := normalizeKey(key)
+	if normalized != key {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventWarning, Path: pathname, Key: normalized, Reason: "key normalized"}, "Warning: normalized key for %s from %#v to %#v\n", pathname, key, normalized)
+	}
+
+	return normalized
+}
+
+// ReconfigureS3ClientFromBucketLocation replaces the S3 client with one for the bucket's region,
+// creating it from the given config and S3 client options.
+func (stc *Cloner) ReconfigureS3ClientFromBucketLocation(ctx context.Context, configOptions []func(*config.LoadOptions) error, s3Options []func(*s3.Options)) error {
+	// Make sure the bucket exists and we have basic permissions for it.
+	gblo, err := stc.s3Client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: &stc.bucket})
+	if err != nil {
+		return fmt.Errorf("Unable to get location for S3 bucket %s: %w", stc.bucket, err)
+	}
+
+	var bucketRegion string
+	if gblo.LocationConstraint == s3Types.BucketLocationConstraintEu {
+		bucketRegion = "eu-west-1"
+	} else if gblo.LocationConstraint == "" {
+		bucketRegion = "us-east-1"
+	} else {
+		bucketRegion = string(gblo.LocationConstraint)
+	}
+
+	client, err := newRegionalClient(ctx, bucketRegion, configOptions, s3Options)
+	if err != nil {
+		return fmt.Errorf("Unable to create S3 client for %s: %w", bucketRegion, err)
+	}
+
+	stc.s3Client = client
+	return nil
+}
+
+// Walk clones the tree rooted at the base directory. Entries are examined by a fixed pool of
+// workers; directories found by the workers are queued back here to have their entries read so
+// the number of goroutines stays bounded regardless of the size of the tree.
+func (stc *Cloner) Walk(firstFilter string) error {
+	stc.startWorkers()
+	err := stc.WalkDirectory("", stc.baseDir, firstFilter)
+	stc.finishWalk()
+	return err
+}
+
+// WalkFiles clones only the given paths, which are relative to the source directory, instead of
+// walking the whole tree. Directory markers are created for their parent directories, but listed
+// directories are not descended into.
+func (stc *Cloner) WalkFiles(firstFilter string, names []string) {
+	stc.noRecurse = true
+	stc.startWorkers()
+
+	queued := make(map[string]bool)
+	for _, name := range names {
+		relName := path.Join(firstFilter, name)
+		if stc.isExcluded(relName) {
+			stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: path.Join(stc.baseDir, relName), Reason: "excluded"}, "Excluding %s\n", path.Join(stc.baseDir, relName))
+			continue
+		}
+
+		pathname := path.Join(stc.baseDir, relName)
+		if _, err := os.Lstat(pathname); errors.Is(err, fs.ErrNotExist) {
+			if stc.deleteExtraneous {
+				stc.missingKeys = append(stc.missingKeys, path.Join(stc.prefix, relName))
+			} else {
+				stc.fail(logEvent{Path: pathname, Reason: "does not exist"}, "Warning: %s does not exist\n", pathname)
+			}
+			continue
+		}
+
+		// Queue each parent directory (once) followed by the file itself.
+		components := strings.Split(relName, "/")
+		for i := range components {
+			relPath := strings.Join(components[:i], "/")
+			entry := path.Join(relPath, components[i])
+			if queued[entry] {
+				continue
+			}
+
+			queued[entry] = true
+			stc.queueFile(relPath, path.Join(stc.baseDir, relPath), components[i], nil)
+		}
+	}
+
+	stc.finishWalk()
+}
+
+// startWorkers starts the walk workers, and the hash and upload workers the walk workers pass
+// regular files on to.
+func (stc *Cloner) startWorkers() {
+	stc.jobs = make(chan walkJob, 2*stc.walkWorkers)
+	stc.queueCond = sync.NewCond(&stc.queueMutex)
+	stc.waitGroup = &sync.WaitGroup{}
+
+	for i := 0; i < stc.walkWorkers; i++ {
+		stc.waitGroup.Add(1)
+		go stc.walkWorker()
+	}
+
+	// Without hash workers, the walk workers hash and upload each file themselves.
+	if stc.hashWorkers == 0 {
+		return
+	}
+
+	stc.hashJobs = make(chan *fileJob, 2*stc.hashWorkers)
+	stc.uploadJobs = make(chan *fileJob, 2*stc.walkWorkers)
+
+	for i := 0; i < stc.hashWorkers; i++ {
+		stc.hashWaitGroup.Add(1)
+		go stc.hashWorker()
+	}
+
+	for i := 0; i < stc.walkWorkers; i++ {
+		stc.uploadWaitGroup.Add(1)
+		go stc.uploadWorker()
+	}
+}
+
+// finishWalk reads directories queued by the workers, and queues files to be retried and hard
+// links that were set aside, until no work remains, then stops the workers.
+func (stc *Cloner) finishWalk() {
+	for {
+		dir, retries, ok := stc.nextQueued()
+		if !ok {
+			// Hard links set aside during the walk are examined once all of them have been found.
+			if jobs := stc.resolveHardLinks(); len(jobs) > 0 {
+				for _, job := range jobs {
+					stc.queueFile(job.relPath, job.dirName, job.filename, job.ignores)
+				}
+				continue
+			}
+
+			break
+		}
+
+		for _, job := range retries {
+			stc.queueFile(job.relPath, job.dirName, job.filename, job.ignores)
+			stc.finishPending()
+		}
+
+		if dir == nil {
+			continue
+		}
+
+		if dirErr := stc.walkDirectory(dir.relPath, dir.dirName, "", dir.ignores); dirErr != nil {
+			stc.recordFailure(PathError{Path: dir.dirName, Err: dirErr})
+		}
+		stc.finishPending()
+	}
+
+	close(stc.jobs)
+	stc.waitGroup.Wait()
+
+	if stc.hashJobs != nil {
+		close(stc.hashJobs)
+		stc.hashWaitGroup.Wait()
+		close(stc.uploadJobs)
+		stc.uploadWaitGroup.Wait()
+		stc.hashJobs, stc.uploadJobs = nil, nil
+	}
+}
+
+// walkWorker handles files from the job queue until it is closed.
+func (stc *Cloner) walkWorker() {
+	defer stc.waitGroup.Done()
+
+	for job := range stc.jobs {
+		// Once the clone has been aborted, drain the queue without examining anything.
+		if stc.ctx.Err() != nil {
+			stc.finishPending()
+			continue
+		}
+
+		// Regular files passed on to the hash workers are finished by the upload workers.
+		if !stc.handleFile(job.relPath, job.dirName, job.filename, job.ignores) {
+			stc.finishJob(job)
+		}
+	}
+}
+
+// finishJob marks a walk job as complete, queueing its file to be retried if it changed while
+// being uploaded or failed.
+func (stc *Cloner) finishJob(job walkJob) {
+	if stc.retryChanged && stc.takeChanged(path.Join(job.dirName, job.filename)) {
+		stc.queueRetry(job)
+	}
+
+	if stc.retryFailed > 0 {
+		stc.noteFailedJob(job)
+	}
+	stc.finishPending()
+}
+
+// queueFile sends a directory entry to the walk workers. This blocks if the workers are busy.
+func (stc *Cloner) queueFile(relPath, dirName, filename string, ignores *ignoreRules) {
+	stc.queueMutex.Lock()
+	stc.nPending++
+	stc.queueMutex.Unlock()
+
+	stc.jobs <- walkJob{relPath: relPath, dirName: dirName, filename: filename, ignores: ignores}
+}
+
+// queueDir queues a directory to have its entries read. This never blocks, so workers can always
+// make progress while Walk is waiting to send them more files. ignores are the ignore file rules
+// in effect for the directory's entries.
+func (stc *Cloner) queueDir(relPath, dirName string, ignores *ignoreRules) {
+	stc.queueMutex.Lock()
+	stc.nPending++
+	stc.queuedDirs = append(stc.queuedDirs, walkDir{relPath: relPath, dirName: dirName, ignores: ignores})
+	stc.queueMutex.Unlock()
+	stc.queueCond.Broadcast()
+}
+
+// finishPending marks a queued file or directory as complete.
+func (stc *Cloner) finishPending() {
+	stc.queueMutex.Lock()
+	stc.nPending--
+	stc.queueMutex.Unlock()
+	stc.queueCond.Broadcast()
+}
+
+// nextQueued waits for a directory or files to retry to be queued. If all pending work has
+// finished and nothing is queued, the walk is complete and ok is false. dir is nil if only files
+// to retry were queued.
+func (stc *Cloner) nextQueued() (dir *walkDir, retries []walkJob, ok bool) {
+	stc.queueMutex.Lock()
+	defer stc.queueMutex.Unlock()
+
+	for len(stc.queuedDirs) == 0 && len(stc.queuedRetries) == 0 && stc.nPending > 0 {
+		stc.queueCond.Wait()
+	}
+
+	if len(stc.queuedDirs) == 0 && len(stc.queuedRetries) == 0 {
+		return nil, nil, false
+	}
+
+	retries = stc.queuedRetries
+	stc.queuedRetries = nil
+	if len(stc.queuedDirs) == 0 {
+		return nil, retries, true
+	}
+
+	// Take the most recently queued directory to walk the tree depth-first; this keeps the queue
+	// small on wide trees.
+	last := len(stc.queuedDirs) - 1
+	next := stc.queuedDirs[last]
+	stc.queuedDirs = stc.queuedDirs[:last]
+	return &next, retries, true
+}
+
+func (stc *Cloner) WalkDirectory(relPath string, dirName string, filter string) error {
+	return stc.walkDirectory(relPath, dirName, filter, nil)
+}
+
+// walkDirectory queues the entries of a directory for the walk workers, skipping those that are
+// excluded. With -respect-ignore-files, the directory's ignore file is added to the inherited
+// ignore rules first.
+func (stc *Cloner) walkDirectory(relPath string, dirName string, filter string, ignores *ignoreRules) error {
+	var dir *os.File
+	var err error
+
+	if stc.respectIgnoreFiles {
+		ignores, err = loadIgnoreFile(relPath, dirName, ignores)
+		if err != nil {
+			stc.logf(stc.stderr, levelError, logEvent{Event: eventError, Path: path.Join(dirName, ignoreFileName), Error: err.Error()}, "Unable to read ignore file %s: %v\n", path.Join(dirName, ignoreFileName), err)
+			return err
+		}
+	}
+
+	dir, err = os.OpenFile(dirName, os.O_RDONLY, 0)
+	if err != nil {
+		stc.logf(stc.stderr, levelError, logEvent{Event: eventError, Path: dirName, Error: err.Error()}, "Unable to open directory %s: %v\n", dirName, err)
+		return err
+	}
+	defer dir.Close()
+
+	for {
+		// Once the clone has been canceled, stop queuing work.
+		if stc.ctx.Err() != nil {
+			return nil
+		}
+
+		var names []string
+		names, err = dir.Readdirnames(16)
+		if len(names) == 0 {
+			if err == io.EOF {
+				break
+			} else {
+				stc.logf(stc.stderr, levelError, logEvent{Event: eventError, Path: dirName, Error: err.Error()}, "Unable to read directory %s: %v\n", dirName, err)
+				return err
+			}
+		}
+
+		for _, name := range names {
+			if filter != "" && !matchesFilter(filter, name) {
+				continue
+			}
+
+			if stc.isExcluded(path.Join(relPath, name)) {
+				stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: path.Join(dirName, name), Reason: "excluded"}, "Excluding %s\n", path.Join(dirName, name))
+				continue
+			}
+
+			if ignores.ignored(path.Join(relPath, name)) {
+				stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: path.Join(dirName, name), Reason: "ignored"}, "Ignoring %s\n", path.Join(dirName, name))
+				continue
+			}
+
+			stc.queueFile(relPath, dirName, name, ignores)
+		}
+	}
+
+	return nil
+}
+
+func (stc *Cloner) HandleFile(relPath, dirName, filename string) {
+	stc.handleFile(relPath, dirName, filename, nil)
+}
+
+// handleFile examines a directory entry, uploading it if needed. If it's a directory, it's queued
+// to be walked with the given ignore file rules. During a walk, regular files are passed on to the
+// hash workers, which hand them to the upload workers; queued is true if so, and the upload worker
+// finishes the entry.
+func (stc *Cloner) handleFile(relPath, dirName, filename string, ignores *ignoreRules) (queued bool) {
+	atomic.AddInt64(&stc.nObjects, 1)
+
+	pathname := path.Join(dirName, filename)
+	fileinfo, err := os.Lstat(pathname)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Error: err.Error()}, "Unable to get status of %s: %v\n", pathname, err)
+		return
+	}
+
+	var linkTarget string
+	isSymlink := false
+	if fileinfo.Mode()&fs.ModeSymlink != 0 {
+		links := stc.links
+		if relPath == "" && stc.firstFilter != "" && matchesFilter(stc.firstFilter, filename) {
+			// The source itself is always followed, as with a symlinked source directory.
+			links = LinksFollow
+		}
+
+		switch links {
+		case LinksFollow:
+			if isSymlinkLoop(pathname) {
+				stc.fail(logEvent{Path: pathname, Reason: "symbolic link loop"}, "Symbolic link %s points to a parent directory; not following\n", pathname)
+				return
+			}
+
+			fileinfo, err = os.Stat(pathname)
+			if err != nil {
+				stc.fail(logEvent{Path: pathname, Error: err.Error()}, "Unable to follow symbolic link %s: %v\n", pathname, err)
+				return
+			}
+
+		case LinksStore:
+			linkTarget, err = os.Readlink(pathname)
+			if err != nil {
+				stc.fail(logEvent{Path: pathname, Error: err.Error()}, "Unable to read symbolic link %s: %v\n", pathname, err)
+				return
+			}
+			isSymlink = true
+
+		default:
+			stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: pathname, Reason: "symbolic link"}, "Skipping symbolic link %s\n", pathname)
+			return
+		}
+	}
+
+	stat := getFileStat(fileinfo)
+	mode := fileinfo.Mode()
+	uploadRequired := false
+	metadataEqual := false
+	contentEqual := true
+
+	if isSymlink {
+		// The symlink is stored as an empty object.
+		stat.Size = 0
+	} else if !mode.IsDir() && !mode.IsRegular() {
+		// Skip devices, pipes, sockets, etc.
+		stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: pathname, Reason: "not a regular file"}, "Skipping non-regular file %s\n", pathname)
+		return
+	}
+
+	// Check what we have in S3
+	key := stc.objectKey(pathname, relPath, filename, mode.IsDir())
+
+	if stc.visitedKeys != nil {
+		stc.visitedMutex.Lock()
+		stc.visitedKeys[key] = true
+		stc.visitedMutex.Unlock()
+	}
+
+	// Files that haven't changed since -modified-since aren't compared at all. Directories are
+	// still walked, since they may contain newer files.
+	if !mode.IsDir() && !stc.modifiedSince.IsZero() && time.Unix(0, stat.Mtime).Before(stc.modifiedSince) {
+		stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: pathname, Key: key, Reason: "not modified since"}, "Skipping %s: not modified since %s\n", pathname, stc.modifiedSince.Format(time.RFC3339))
+		atomic.AddInt64(&stc.nSkipped, 1)
+		return
+	}
+
+	// With -hard-links, every link to a file but one is stored as an empty object that refers to
+	// that one. Files with other links are examined once the walk has found all of them.
+	var hardLinkTarget string
+	if stc.hardLinks && mode.IsRegular() && !isSymlink {
+		var deferred bool
+		hardLinkTarget, deferred = stc.hardLinkTarget(stat, key, walkJob{relPath: relPath, dirName: dirName, filename: filename, ignores: ignores})
+		if deferred {
+			return
+		}
+
+		if hardLinkTarget != "" {
+			stat.Size = 0
+		}
+	}
+
+	// With -trust-mtime, a file that hasn't changed since it was last synced isn't compared again.
+	if mode.IsRegular() && !isSymlink && hardLinkTarget == "" && !stc.report && stc.syncedUnchanged(pathname, key, stat) {
+		stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: pathname, Key: key, Reason: "unchanged since last sync"}, "Skipping %s; unchanged since it was synced to s3://%s/%s\n", pathname, stc.bucket, key)
+		atomic.AddInt64(&stc.nSkipped, 1)
+		return
+	}
+
+	stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventComparing, Path: pathname, Key: key}, "Comparing %s against s3://%s/%s\n", pathname, stc.bucket, key)
+
+	var hoo *s3.HeadObjectOutput
+	exists, sizeEqual := false, false
+	listed, isListed := stc.listing[key]
+
+	if mode.IsDir() && isListed && !stc.report && stc.syncedUnchanged(pathname, key, stat) {
+		// With -prelist and -trust-mtime, a directory marker known to exist that matched the
+		// unchanged directory on an earlier run isn't checked again; the directory is still walked.
+		stc.logf(stc.stdout, levelDebug, logEvent{Event: eventComparing, Path: pathname, Key: key, Reason: "unchanged since last sync"}, "Not checking s3://%s/%s; %s is unchanged since it was synced\n", stc.bucket, key, pathname)
+		exists, sizeEqual, metadataEqual = true, true, true
+	} else if stc.listing != nil && !isListed {
+		// The prelisting shows the object doesn't exist, so there's no need to ask S3.
+		stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "missing in S3"}, "s3://%s/%s does not exist; will resync object\n", stc.bucket, key)
+
+		uploadRequired = true
+	} else if stc.listing != nil && !mode.IsDir() && listed.Size != stat.Size && len(stc.compress) == 0 {
+		// Likewise, a size mismatch means the object must be resynced whatever its metadata is.
+		// Compressed objects are smaller than their files, so this doesn't apply with -compress.
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "size mismatch"}, "Content size mismatch: s3://%s/%s has size %d; %s has size %d; will resync\n", stc.bucket, key, listed.Size, pathname, stat.Size)
+		exists = true
+		uploadRequired = true
+	} else {
+		// Check out a semaphore to ensure we're not overloading S3 with too many concurrent requests
+		err = stc.sem.Acquire(stc.ctx, 1)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to acquire S3 semaphore: %v\n", err)
+			return
+		}
+
+		hoo, err = stc.s3Client.HeadObject(stc.ctx, stc.headObjectInput(key))
+		stc.sem.Release(1)
+
+		if err != nil {
+			// Assume the object must be resynced.
+			var smithyError smithy.APIError
+			showError := true
+			if errors.As(err, &smithyError) {
+				if smithyError.ErrorCode() == "NotFound" {
+					showError = false
+				}
+			}
+
+			if showError {
+				stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "HeadObject failed", Error: err.Error()}, "HeadObject on s3://%s/%s failed; will resync object: %v\n", stc.bucket, key, err)
+			} else {
+				stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "missing in S3"}, "s3://%s/%s does not exist; will resync object\n", stc.bucket, key)
+			}
+
+			hoo = nil
+			uploadRequired = true
+		} else {
+			exists = true
+			sizeEqual = mode.IsDir() || objectFileSize(hoo) == stat.Size
+			if stc.FileMetadataEqual(hoo, stat, pathname, key, mode.IsDir()) {
+				metadataEqual = true
+			} else {
+				uploadRequired = true
+			}
+		}
+	}
+
+	// A new key may collide with an existing object of the other type: a file where the directory
+	// marker is, or vice versa.
+	if !exists && !stc.report {
+		stc.checkKeyCollision(pathname, key, mode.IsDir())
+	}
+
+	if isSymlink {
+		if hoo != nil && hoo.Metadata["file-symlink-target"] != linkTarget {
+			stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "symbolic link target mismatch"}, "Symbolic link target mismatch: s3://%s/%s has %#v; %s has %#v; will resync\n", stc.bucket, key, hoo.Metadata["file-symlink-target"], pathname, linkTarget)
+			uploadRequired = true
+			contentEqual = false
+		}
+
+		if stc.report {
+			stc.reportObject(key, exists, sizeEqual, contentEqual, metadataEqual)
+		} else if uploadRequired {
+			stc.UploadSymlink(pathname, key, stat, linkTarget)
+		} else {
+			stc.skipUpToDate(pathname, key)
+		}
+	} else if !mode.IsDir() {
+		if hoo != nil && hoo.Metadata["file-hardlink-target"] != hardLinkTarget {
+			stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "hard link target mismatch"}, "Hard link target mismatch: s3://%s/%s has %#v; %s has %#v; will resync\n", stc.bucket, key, hoo.Metadata["file-hardlink-target"], pathname, hardLinkTarget)
+			uploadRequired = true
+			contentEqual = false
+		}
+
+		file := &fileJob{
+			walkJob:        walkJob{relPath: relPath, dirName: dirName, filename: filename, ignores: ignores},
+			pathname:       pathname,
+			key:            key,
+			stat:           stat,
+			hoo:            hoo,
+			hardLinkTarget: hardLinkTarget,
+			exists:         exists,
+			sizeEqual:      sizeEqual,
+			metadataEqual:  metadataEqual,
+			contentEqual:   contentEqual,
+			uploadRequired: uploadRequired,
+		}
+
+		// Outside of a walk, the file is hashed and uploaded here.
+		if stc.hashJobs == nil {
+			if stc.compareContent(file) {
+				stc.finishFile(file)
+			}
+			return
+		}
+
+		stc.hashJobs <- file
+		return true
+	} else {
+		if stc.report {
+			stc.reportObject(key, exists, sizeEqual, contentEqual, metadataEqual)
+		} else if uploadRequired {
+			stc.UploadDir(pathname, key, stat)
+		} else {
+			stc.skipUpToDate(pathname, key)
+			if hoo != nil {
+				stc.recordSynced(pathname, key, stat, aws.ToTime(hoo.LastModified))
+			}
+		}
+		if stc.noRecurse {
+			return
+		}
+
+		if stc.maxDepth > 0 && stc.depth(relPath, filename) >= stc.maxDepth {
+			stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: pathname, Reason: "maximum depth"}, "Not descending into %s: at maximum depth\n", pathname)
+			return
+		}
+
+		if stc.oneFileSystem && stat.Dev != stc.rootDev {
+			stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: pathname, Reason: "different file system"}, "Not descending into %s: on a different file system\n", pathname)
+			return
+		}
+
+		// Queue this directory to be walked
+		stc.logf(stc.stderr, levelVerbose, logEvent{Event: eventWalking, Path: pathname}, "Walking directory %s\n", pathname)
+		stc.queueDir(path.Join(relPath, filename), pathname, ignores)
+	}
+
+	return
+}
+
+// depth returns the number of levels an entry is below the source directory: entries directly in
+// it are at depth 1. The top-level directory created when the source doesn't end with a / is at
+// depth 0.
+func (stc *Cloner) depth(relPath, filename string) int {
+	depth := strings.Count(path.Join(relPath, filename), "/") + 1
+	if stc.firstFilter != "" {
+		depth--
+	}
+
+	return depth
+}
+
+// beyondMaxDepth determines whether an object is below the levels copied with -max-depth, and so
+// wasn't visited because it wasn't walked rather than because it doesn't exist.
+func (stc *Cloner) beyondMaxDepth(key string) bool {
+	if stc.maxDepth == 0 {
+		return false
+	}
+
+	return stc.depth("", strings.TrimSuffix(strings.TrimPrefix(key, stc.prefix), "/")) > stc.maxDepth
+}
+
+// skipUpToDate notes that an object already matches its source and doesn't need to be uploaded.
+func (stc *Cloner) skipUpToDate(pathname, key string) {
+	atomic.AddInt64(&stc.nSkipped, 1)
+	stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Path: pathname, Key: key, Reason: "up to date"}, "Skipping %s; s3://%s/%s is up to date\n", pathname, stc.bucket, key)
+}
+
+// recordFailure notes that an object could not be synchronized. The failures are returned in the
+// summary, and cause a non-zero exit code.
+func (stc *Cloner) recordFailure(pe PathError) {
+	atomic.AddInt64(&stc.nFailed, 1)
+
+	stc.errorsMutex.Lock()
+	stc.errors = append(stc.errors, pe)
+	if stc.failedPaths != nil && pe.Path != "" {
+		stc.failedPaths[pe.Path] = true
+	}
+	stc.errorsMutex.Unlock()
+
+	if stc.stopOnError && stc.ctx.Err() == nil {
+		stc.abort(fmt.Errorf("Stopped after the first failure: %w", pe), "Stopping at the first failure (-stop-on-error): %v\n", pe)
+	}
+}
+
+// fail logs an error event and records the failure, using the message as the error.
+func (stc *Cloner) fail(event logEvent, format string, args ...interface{}) {
+	event.Event = eventError
+	stc.logf(stc.stderr, levelError, event, format, args...)
+
+	message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	stc.recordFailure(PathError{Path: event.Path, Key: event.Key, Err: errors.New(message)})
+}
+
+func (stc *Cloner) FileMetadataEqual(hoo *s3.HeadObjectOutput, stat *fileStat, pathname, key string, isDir bool) bool {
+	// Check size
+	if size := objectFileSize(hoo); !isDir && size != stat.Size {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "size mismatch"}, "Content size mismatch: s3://%s/%s has size %d; %s has size %d; will resync\n", stc.bucket, key, size, pathname, stat.Size)
+		return false
+	}
+
+	// With -size-only, existence and size are all that's compared.
+	if stc.sizeOnly {
+		return true
+	}
+
+	uid, gid := stc.fileOwnerIDs(stat)
+
+	// Make sure uid/gid ownership match
+	if !stc.fileOwnershipEqual(hoo, uid, key, pathname, "file-owner") || !stc.fileOwnershipEqual(hoo, gid, key, pathname, "file-group") {
+		return false
+	}
+
+	// Check permissions, in whichever scheme the object was written with.
+	scheme := stc.objectMetadataScheme(hoo.Metadata)
+	permissionsKey := scheme.keys().permissions
+	s3PermsStr, isPresent := hoo.Metadata[permissionsKey]
+	if !isPresent {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "missing " + permissionsKey}, "No %s specified for s3://%s/%s; will resync\n", permissionsKey, stc.bucket, key)
+		return false
+	}
+
+	s3Perms, err := scheme.parsePermissions(s3PermsStr)
+	if err != nil {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "invalid " + permissionsKey}, "Non-integer value for %s for s3://%s/%s; will resync: %s\n", permissionsKey, stc.bucket, key, s3PermsStr)
+		return false
+	}
+
+	if uint16(s3Perms) != uint16(stat.Mode&07777) {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "permissions mismatch"}, "Permissions mismatch: s3://%s/%s has %04o; %s has %04o; will resync\n", stc.bucket, key, s3Perms, pathname, stat.Mode&07777)
+		return false
+	}
+
+	if stc.xattrs && !stc.xattrsEqual(hoo, pathname, key) {
+		return false
+	}
+
+	// With -etag-metadata, files uploaded without it are updated so it's stored. Symbolic links
+	// and hard links are stored as empty objects without it.
+	if stc.etagMetadata && !isDir && hoo.Metadata[etagMetadataKey] == "" && hoo.Metadata["file-symlink-target"] == "" && hoo.Metadata["file-hardlink-target"] == "" {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "missing " + etagMetadataKey}, "No %s specified for s3://%s/%s; will resync\n", etagMetadataKey, stc.bucket, key)
+		return false
+	}
+
+	// The user-agent metadata is deliberately not compared, so a different -metadata-user-agent or
+	// version doesn't cause a resync.
+
+	// Check the HTTP headers S3 serves the object with
+	if !stc.objectHeadersEqual(hoo, pathname, key) {
+		return false
+	}
+
+	if !isDir && !stc.objectContentTypeEqual(hoo, pathname, key) {
+		return false
+	}
+
+	// Check timestamps if requested. ctime changes with any metadata change and can't be restored,
+	// so it can be ignored on its own. It's also skipped if the object's scheme doesn't record it,
+	// or if an object written by another program (such as an older s3fs) doesn't have it.
+	if !stc.ignoreTimestamps {
+		_, hasCtime := hoo.Metadata[scheme.keys().ctime]
+		compareCtime := scheme.keys().ctime != "" && (hasCtime || scheme == stc.metadataScheme)
+		if !stc.ignoreCtime && compareCtime && !stc.fileTimestampEqual(hoo, stat.Ctime, key, pathname, "file-ctime") {
+			return false
+		}
+
+		if !stc.fileTimestampEqual(hoo, stat.Mtime, key, pathname, "file-mtime") {
+			return false
+		}
+	}
+
+	stc.logf(stc.stdout, levelDebug, logEvent{Event: eventComparing, Path: pathname, Key: key, Reason: "metadata matches"}, "Metadata for %s and s3://%s/%s matches\n", pathname, stc.bucket, key)
+
+	return true
+}
+
+// fileOwnershipEqual determines whether the owner (with an ownerType of file-owner) or group (with
+// file-group) recorded on an S3 object matches the given ID, under whichever key the object's
+// metadata scheme records it.
+func (stc *Cloner) fileOwnershipEqual(hoo *s3.HeadObjectOutput, id uint32, key, pathname, ownerType string) bool {
+	field := stc.objectMetadataScheme(hoo.Metadata).keys().key(ownerType)
+
+	s3OwnerStr, isPresent := hoo.Metadata[field]
+	if !isPresent {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "missing " + field}, "No %s specified for s3://%s/%s; will resync\n", field, stc.bucket, key)
+		return false
+	}
+
+	s3Owner, err := strconv.ParseUint(s3OwnerStr, 10, 32)
+	if err != nil {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "invalid " + field}, "Non-integer value for %s for s3://%s/%s; will resync: %s\n", field, stc.bucket, key, s3OwnerStr)
+		return false
+	}
+
+	// With root unsquash, nfsnobody and root are equivalent on either side, so a tree uploaded with
+	// root squash matches one uploaded without it.
+	if stc.unsquashedID(uint32(s3Owner), ownerType) != stc.unsquashedID(id, ownerType) {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: field + " mismatch"}, "Ownership mismatch: s3://%s/%s has %s %d; %s has %s %d; will resync\n", stc.bucket, key, field, s3Owner, pathname, field, id)
+		return false
+	}
+
+	return true
+}
+
+// fileTimestampEqual determines whether the timestamps on the local file and S3 object are
+// identical, or differ by no more than the timestamp tolerance. If the timestamp metadata is missing
+// from S3, it is assumed the timestamps are not identical. The field is file-ctime or file-mtime,
+// and is looked up under the object's metadata scheme; the local timestamp is first truncated to the
+// precision of that scheme.
+func (stc *Cloner) fileTimestampEqual(hoo *s3.HeadObjectOutput, timestamp int64, key, pathname, field string) bool {
+	scheme := stc.objectMetadataScheme(hoo.Metadata)
+	field = scheme.keys().key(field)
+
+	s3TimestampStr, isPresent := hoo.Metadata[field]
+	if !isPresent {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "missing " + field}, "No %s specified for s3://%s/%s; will resync\n", field, stc.bucket, key)
+		return false
+	}
+
+	s3Timestamp, err := scheme.parseTimestamp(s3TimestampStr)
+	if err != nil {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "invalid " + field, Error: err.Error()}, "Cannot parse %s for s3://%s/%s; will resync: %s: %v\n", field, stc.bucket, key, s3TimestampStr, err)
+		return false
+	}
+
+	timestamp = truncateTimestamp(timestamp, scheme.timestampPrecision())
+
+	if timestampDifference(s3Timestamp, timestamp) > uint64(stc.timestampTolerance) {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: field + " mismatch"}, "Timestamp mismatch: s3://%s/%s has %s %d ns; %s has %s %d ns; will resync\n", stc.bucket, key, field, s3Timestamp, pathname, field, timestamp)
+		return false
+	}
+
+	return true
+}
+
+// fileMetadata returns the metadata describing the permissions, ownership, and timestamps of a
+// file, in the keys and formats of the metadata scheme (File Gateway's by default).
+func (stc *Cloner) fileMetadata(stat *fileStat) map[string]string {
+	// Apply ID mappings and substitute root UID/GID if necessary.
+	uid, gid := stc.fileOwnerIDs(stat)
+	keys := stc.metadataScheme.keys()
+
+	metadata := make(map[string]string)
+	metadata[keys.owner] = fmt.Sprintf("%d", uid)
+	metadata[keys.group] = fmt.Sprintf("%d", gid)
+	metadata[keys.permissions] = stc.metadataScheme.formatPermissions(stat.Mode)
+
+	if keys.ctime != "" {
+		metadata[keys.ctime] = stc.metadataScheme.formatTimestamp(stat.Ctime)
+	}
+
+	metadata[keys.mtime] = stc.metadataScheme.formatTimestamp(stat.Mtime)
+	metadata[userAgentMetadataKey] = stc.userAgent
+	stc.addAnnotations(metadata)
+	return metadata
+}
+
+// UploadDir creates a directory entry in S3 with the given key, using the permissions, ownership,
+// and timestamp from the source directory.
+func (stc *Cloner) UploadDir(pathname, key string, stat *fileStat) {
+	if stc.dryRun {
+		stc.printf(stc.stdout, levelInfo, "Would upload %s to s3://%s/%s\n", pathname, stc.bucket, key)
+		stc.planUpload(planActionUpload, pathname, key, 0, stc.storageClass)
+		return
+	}
+
+	// File Gateway uses the generic "application/octet-stream" for the content-type
+	mtypeStr := "application/octet-stream"
+
+	// We don't need parallelism here.
+	err := stc.sem.Acquire(stc.ctx, 1)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to acquire S3 semaphore: %v\n", err)
+		return
+	}
+
+	// Entries may have been added or removed since the directory was examined, so record its
+	// timestamps as they are now rather than when it was compared.
+	refreshDirTimestamps(pathname, stat)
+
+	metadata := stc.fileMetadata(stat)
+	if stc.xattrs {
+		if err := stc.addXattrMetadata(pathname, metadata); err != nil {
+			stc.sem.Release(1)
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to read extended attributes of %s: %v\n", pathname, err)
+			return
+		}
+	}
+
+	poi := &s3.PutObjectInput{
+		Bucket:       &stc.bucket,
+		Key:          &key,
+		Body:         stc.throttle(&bytes.Reader{}),
+		ContentType:  &mtypeStr,
+		Metadata:     metadata,
+		StorageClass: stc.storageClass,
+		ACL:          stc.acl,
+		RequestPayer: stc.requestPayer,
+	}
+
+	poi.Tagging = stc.objectTagging(metadata)
+	stc.setPutObjectHeaders(poi)
+	stc.setPutObjectPolicy(poi)
+	stc.setPutObjectEncryption(poi)
+	stc.setPutObjectLock(poi)
+
+	_, err = stc.s3Client.PutObject(stc.ctx, poi)
+	stc.sem.Release(1)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to upload %s: %v\n", pathname, err)
+		return
+	}
+
+	stc.logf(stc.stderr, levelInfo, logEvent{Event: eventUploaded, Path: pathname, Key: key, Bytes: aws.Int64(stat.Size)}, "Uploaded %s to s3://%s/%s\n", pathname, stc.bucket, key)
+	atomic.AddInt64(&stc.nDirsCreated, 1)
+	stc.recordSynced(pathname, key, stat, time.Now())
+
+	if stc.verifyAfterUpload {
+		stc.VerifyUpload(pathname, key, stat, true)
+	}
+}
+
+// refreshDirTimestamps updates the ctime and mtime in stat from the directory at pathname. If the
+// directory can't be read, the timestamps are left as they were.
+func refreshDirTimestamps(pathname string, stat *fileStat) {
+	fileinfo, err := os.Stat(pathname)
+	if err != nil || !fileinfo.IsDir() {
+		return
+	}
+
+	current := getFileStat(fileinfo)
+	stat.Ctime = current.Ctime
+	stat.Mtime = current.Mtime
+}
+
+// UploadSymlink creates an empty object in S3 with the given key representing a symbolic link. The
+// link target is recorded in the file-symlink-target metadata.
+func (stc *Cloner) UploadSymlink(pathname, key string, stat *fileStat, target string) {
+	if stc.dryRun {
+		stc.printf(stc.stdout, levelInfo, "Would upload %s to s3://%s/%s\n", pathname, stc.bucket, key)
+		stc.planUpload(planActionUpload, pathname, key, 0, stc.storageClass)
+		return
+	}
+
+	mtypeStr := "application/octet-stream"
+	metadata := stc.fileMetadata(stat)
+	metadata["file-symlink-target"] = target
+
+	err := stc.sem.Acquire(stc.ctx, 1)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to acquire S3 semaphore: %v\n", err)
+		return
+	}
+
+	poi := &s3.PutObjectInput{
+		Bucket:       &stc.bucket,
+		Key:          &key,
+		Body:         stc.throttle(&bytes.Reader{}),
+		ContentType:  &mtypeStr,
+		Metadata:     metadata,
+		StorageClass: stc.storageClass,
+		ACL:          stc.acl,
+		RequestPayer: stc.requestPayer,
+	}
+
+	poi.Tagging = stc.objectTagging(metadata)
+	stc.setPutObjectHeaders(poi)
+	stc.setPutObjectPolicy(poi)
+	stc.setPutObjectEncryption(poi)
+	stc.setPutObjectLock(poi)
+
+	_, err = stc.s3Client.PutObject(stc.ctx, poi)
+	stc.sem.Release(1)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to upload %s: %v\n", pathname, err)
+		return
+	}
+
+	stc.logf(stc.stderr, levelInfo, logEvent{Event: eventUploaded, Path: pathname, Key: key, Bytes: aws.Int64(stat.Size)}, "Uploaded %s to s3://%s/%s\n", pathname, stc.bucket, key)
+	atomic.AddInt64(&stc.nUploaded, 1)
+
+	if stc.verifyAfterUpload {
+		stc.VerifyUpload(pathname, key, stat, true)
+	}
+}
+
+// isSymlinkLoop reports whether the symbolic link at pathname points to one of its own parent
+// directories, which would cause the walk to recurse forever if followed.
+func isSymlinkLoop(pathname string) bool {
+	target, err := filepath.EvalSymlinks(pathname)
+	if err != nil {
+		return false
+	}
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(pathname))
+	if err != nil {
+		return false
+	}
+
+	return parent == target || strings.HasPrefix(parent, target+string(filepath.Separator))
+}
+
+// UploadFile creates an object in S3 with the given key, using the permissions, ownership, and
+// timestamp from the source file to set the metadata. The file is uploaded as the S3 object
+// content. The Content-Type is set using MIME detection.
+func (stc *Cloner) UploadFile(pathname, key string, stat *fileStat, hashes *Hashes) {
+	if stc.dryRun {
+		stc.printf(stc.stdout, levelInfo, "Would upload %s to s3://%s/%s\n", pathname, stc.bucket, key)
+		stc.planUpload(planActionUpload, pathname, key, stat.Size, stc.fileStorageClass(key, stat))
+		return
+	}
+
+	metadata := stc.fileMetadata(stat)
+	if stc.xattrs {
+		if err := stc.addXattrMetadata(pathname, metadata); err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to read extended attributes of %s: %v\n", pathname, err)
+			return
+		}
+	}
+
+	// The file's size is recorded so it can be compared without relying on the object's length,
+	// which differs once the object is compressed.
+	metadata["file-size"] = strconv.FormatInt(stat.Size, 10)
+
+	// Files below -hash-min-size are uploaded without hash metadata.
+	hashed := stc.hashesFile(stat)
+	if hashes == nil && hashed {
+		hashes = stc.cachedFileHashes(pathname, stat)
+	}
+
+	fd, err := os.Open(pathname)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to open %s: %v\n", pathname, err)
+		return
+	}
+
+	defer fd.Close()
+
+	// S3 needs the hash metadata before the body is sent, so the hashes have to be known before
+	// the upload starts. Small files are read into memory once and both hashed and uploaded from
+	// the buffer. Larger files are read twice (once to hash, once to upload) rather than buffered,
+	// trading IO for bounded memory use. If the caller already compared hashes, the file has
+	// already been read once and is simply streamed.
+	var body io.ReadSeeker = fd
+	var content []byte
+	if hashes == nil && stat.Size <= singlePassMaxSize {
+		content, err = io.ReadAll(fd)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to read %s: %v\n", pathname, err)
+			return
+		}
+
+		if hashed {
+			hashes, err = stc.fileHashes(pathname, stat, bytes.NewReader(content))
+			if err != nil {
+				stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to get hashes of %s: %v\n", pathname, err)
+				return
+			}
+		}
+
+		body = bytes.NewReader(content)
+	} else if hashes == nil && hashed {
+		hashes, err = stc.fileHashes(pathname, stat, fd)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to get hashes of %s: %v\n", pathname, err)
+			return
+		}
+		_, err = fd.Seek(0, io.SeekStart)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to seek to start of %s: %v\n", pathname, err)
+			return
+		}
+	}
+
+	mtypeStr := stc.contentType(pathname, key, content)
+	metadata["file-content-type"] = mtypeStr
+
+	if hashes != nil {
+		for _, algorithm := range stc.hashAlgorithms {
+			metadata[algorithm] = hex.EncodeToString(hashes.get(algorithm))
+		}
+	}
+
+	// With -dedupe, a file whose content was already uploaded during this run is copied from that
+	// object on the server instead. CopyObject is limited to the same size as PutObject.
+	if stc.dedupe && hashes != nil && stat.Size <= maxPutObjectSize {
+		if source, found := stc.findDedupeSource(hashes); found {
+			if stc.etagMetadata {
+				etag, err := stc.copiedETag(pathname, hashes)
+				if err != nil {
+					stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to get the ETag of %s: %v\n", pathname, err)
+					return
+				}
+
+				metadata[etagMetadataKey] = etag
+			}
+
+			if stc.copyDuplicate(pathname, key, stat, source, metadata, mtypeStr) {
+				return
+			}
+		}
+	}
+
+	// With -etag-metadata, the ETag the object gets is stored so other tools can check it against
+	// the native ETag. It describes the file as is, even if it's compressed below.
+	if stc.etagMetadata {
+		if err = stc.addETagMetadata(metadata, body, stat.Size); err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to get the ETag of %s: %v\n", pathname, err)
+			return
+		}
+	}
+
+	// Compress the body if requested. The hashes and file-size metadata describe the original
+	// file so the object can still be compared and restored.
+	uploadSize := stat.Size
+	var contentEncoding *string
+	if len(stc.compress) > 0 && stat.Size >= stc.compressMinSize && stc.shouldCompress(pathname, mtypeStr) {
+		compressed, compressedSize, cleanup, err := compressBody(body, stat.Size)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to compress %s: %v\n", pathname, err)
+			return
+		}
+
+		if compressed == nil {
+			// Compression didn't help, so the file is uploaded as-is.
+			if _, err = body.Seek(0, io.SeekStart); err != nil {
+				stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to seek to start of %s: %v\n", pathname, err)
+				return
+			}
+		} else {
+			defer cleanup()
+			body = compressed
+			uploadSize = compressedSize
+			contentEncoding = aws.String(contentEncodingGzip)
+		}
+	}
+
+	// Files below the multipart threshold are uploaded with a single request. Larger files are
+	// uploaded in parts, so they count against -max-concurrent once per part uploaded at a time.
+	multipart := uploadSize >= stc.multipartThreshold
+	weight := int64(1)
+	var partSize int64
+	var concurrency int
+	if multipart {
+		partSize, concurrency = stc.multipartLayout(uploadSize)
+		weight = int64(concurrency)
+	}
+
+	// With -checksum-algorithm, the checksum of a body uploaded with a single request is sent
+	// along with it.
+	var checksum string
+	if stc.checksumAlgorithm != "" && !multipart {
+		if checksum, err = stc.bodyChecksum(body); err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to get the checksum of %s: %v\n", pathname, err)
+			return
+		}
+	}
+
+	err = stc.sem.Acquire(stc.ctx, weight)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to acquire S3 semaphore: %v\n", err)
+		return
+	}
+
+	poi := &s3.PutObjectInput{
+		Bucket:          &stc.bucket,
+		Key:             &key,
+		Body:            stc.throttle(body),
+		ContentEncoding: contentEncoding,
+		ContentType:     &mtypeStr,
+		Metadata:        metadata,
+		StorageClass:    stc.fileStorageClass(key, stat),
+		ACL:             stc.acl,
+		RequestPayer:    stc.requestPayer,
+	}
+
+	poi.Tagging = stc.objectTagging(metadata)
+	stc.setPutObjectHeaders(poi)
+	stc.setPutObjectPolicy(poi)
+	stc.setPutObjectEncryption(poi)
+	stc.setPutObjectLock(poi)
+
+	// With -verify-parts, S3 is asked to keep a checksum of each part so the object can be
+	// verified later.
+	if multipart && stc.verifyParts {
+		poi.ChecksumAlgorithm = s3Types.ChecksumAlgorithmSha256
+	}
+
+	stc.setPutObjectChecksum(poi, checksum)
+
+	if multipart {
+		_, err = stc.newUploader(partSize, concurrency).Upload(stc.ctx, poi)
+	} else {
+		_, err = stc.s3Client.PutObject(stc.ctx, poi)
+	}
+	stc.sem.Release(weight)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to upload %s: %v\n", pathname, err)
+		return
+	}
+
+	// If the file was written to while it was read, the object may not match the hashes in its
+	// metadata. Its metadata has the old size or mtime, so it will be uploaded again next time.
+	if fileChanged(fd, stat) {
+		if stc.retryChanged && stc.markChanged(pathname) {
+			stc.logf(stc.stderr, levelInfo, logEvent{Event: eventWarning, Path: pathname, Key: key, Reason: "changed during upload"}, "Warning: %s changed while it was being uploaded; will retry\n", pathname)
+		} else {
+			stc.fail(logEvent{Path: pathname, Key: key, Reason: "changed during upload"}, "Warning: %s changed while it was being uploaded to s3://%s/%s\n", pathname, stc.bucket, key)
+		}
+		return
+	}
+
+	stc.logf(stc.stderr, levelInfo, logEvent{Event: eventUploaded, Path: pathname, Key: key, Bytes: aws.Int64(uploadSize)}, "Uploaded %s to s3://%s/%s\n", pathname, stc.bucket, key)
+	atomic.AddInt64(&stc.nUploaded, 1)
+	atomic.AddInt64(&stc.bytesUploaded, uploadSize)
+	stc.recordSynced(pathname, key, stat, time.Now())
+
+	if stc.dedupe && hashes != nil {
+		stc.recordDedupeSource(hashes, key, contentEncoding)
+	}
+
+	if stc.verifyAfterUpload {
+		stc.VerifyUpload(pathname, key, stat, false)
+	}
+}
+
+// VerifyUpload reads back the metadata of a freshly uploaded object and makes sure it matches the
+// source file. A mismatch is counted as a failure.
+func (stc *Cloner) VerifyUpload(pathname, key string, stat *fileStat, isDir bool) {
+	err := stc.sem.Acquire(stc.ctx, 1)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to acquire S3 semaphore: %v\n", err)
+		return
+	}
+
+	hoo, err := stc.s3Client.HeadObject(stc.ctx, stc.headObjectInput(key))
+	stc.sem.Release(1)
+
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Reason: "verification failed", Error: err.Error()}, "Verification failed: HeadObject on s3://%s/%s failed: %v\n", stc.bucket, key, err)
+		return
+	}
+
+	if !stc.FileMetadataEqual(hoo, stat, pathname, key, isDir) {
+		stc.fail(logEvent{Path: pathname, Key: key, Reason: "verification failed"}, "Verification failed: metadata for s3://%s/%s does not match %s\n", stc.bucket, key, pathname)
+		return
+	}
+
+	if !isDir {
+		_, hashesEqual, err := stc.compareFileHashes(hoo, pathname, stat)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Reason: "verification failed", Error: err.Error()}, "Verification failed: unable to get hashes for %s: %v\n", pathname, err)
+			return
+		}
+
+		if !hashesEqual {
+			stc.fail(logEvent{Path: pathname, Key: key, Reason: "verification failed"}, "Verification failed: hashes for s3://%s/%s do not match %s\n", stc.bucket, key, pathname)
+			return
+		}
+	}
+
+	stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventVerified, Path: pathname, Key: key}, "Verified s3://%s/%s against %s\n", stc.bucket, key, pathname)
+}
+
+// DeleteExtraneous deletes objects under the given prefix that were not visited during the walk.
+// Objects for excluded paths are kept unless -delete-excluded was given.
+func (stc *Cloner) DeleteExtraneous(prefix string) {
+	keys, err := stc.unvisitedKeys(prefix)
+	if err != nil {
+		stc.recordFailure(PathError{Key: prefix, Err: err})
+		return
+	}
+
+	var toDelete []s3Types.ObjectIdentifier
+	for _, key := range keys {
+		// Like rsync, excluded paths are left alone unless -delete-excluded is given.
+		if !stc.deleteExcluded && stc.keyExcluded(key) {
+			stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventSkipped, Key: key, Reason: "excluded"}, "Not deleting s3://%s/%s: excluded\n", stc.bucket, key)
+			continue
+		}
+
+		toDelete = append(toDelete, s3Types.ObjectIdentifier{Key: aws.String(key)})
+	}
+
+	stc.deleteObjects(toDelete)
+}
+
+// unvisitedKeys lists the objects under the prefix that weren't visited during the walk.
+func (stc *Cloner) unvisitedKeys(prefix string) ([]string, error) {
+	var keys []string
+
+	paginator := s3.NewListObjectsV2Paginator(stc.s3Client, &s3.ListObjectsV2Input{Bucket: &stc.bucket, Prefix: &prefix, RequestPayer: stc.requestPayer})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(stc.ctx)
+		if err != nil {
+			stc.logf(stc.stderr, levelError, logEvent{Event: eventError, Key: prefix, Error: err.Error()}, "Unable to list objects in s3://%s/%s: %v\n", stc.bucket, prefix, err)
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if strings.HasPrefix(key, prefix) && !stc.visitedKeys[key] && stc.inTree(key) && !stc.beyondMaxDepth(key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// DeleteMissing deletes the object for a file that no longer exists locally. If it was a
+// directory, its marker and everything under it are deleted.
+func (stc *Cloner) DeleteMissing(key string) {
+	var toDelete []s3Types.ObjectIdentifier
+
+	paginator := s3.NewListObjectsV2Paginator(stc.s3Client, &s3.ListObjectsV2Input{Bucket: &stc.bucket, Prefix: &key, RequestPayer: stc.requestPayer})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(stc.ctx)
+		if err != nil {
+			stc.fail(logEvent{Key: key, Error: err.Error()}, "Unable to list objects in s3://%s/%s: %v\n", stc.bucket, key, err)
+			return
+		}
+
+		for _, object := range page.Contents {
+			objectKey := aws.ToString(object.Key)
+			if objectKey == key || strings.HasPrefix(objectKey, key+"/") {
+				toDelete = append(toDelete, s3Types.ObjectIdentifier{Key: object.Key})
+			}
+		}
+	}
+
+	stc.deleteObjects(toDelete)
+}
+
+// deleteObjects deletes the given objects from the bucket.
+func (stc *Cloner) deleteObjects(toDelete []s3Types.ObjectIdentifier) {
+	// DeleteObjects accepts at most 1000 keys per call.
+	for len(toDelete) > 0 {
+		batch := toDelete
+		if len(batch) > 1000 {
+			batch = batch[:1000]
+		}
+		toDelete = toDelete[len(batch):]
+
+		if stc.dryRun {
+			for _, object := range batch {
+				stc.printf(stc.stdout, levelInfo, "Would delete s3://%s/%s\n", stc.bucket, *object.Key)
+				stc.addPlanEntry(planEntry{Action: planActionDelete, Key: *object.Key})
+			}
+			continue
+		}
+
+		doo, err := stc.s3Client.DeleteObjects(stc.ctx, &s3.DeleteObjectsInput{
+			Bucket:       &stc.bucket,
+			Delete:       &s3Types.Delete{Objects: batch, Quiet: true},
+			RequestPayer: stc.requestPayer,
+		})
+		if err != nil {
+			stc.fail(logEvent{Bucket: stc.bucket, Error: err.Error()}, "Failed to delete %d objects from s3://%s: %v\n", len(batch), stc.bucket, err)
+			continue
+		}
+
+		failed := make(map[string]bool)
+		for _, deleteError := range doo.Errors {
+			failed[aws.ToString(deleteError.Key)] = true
+			stc.fail(logEvent{Key: aws.ToString(deleteError.Key), Error: aws.ToString(deleteError.Message)}, "Failed to delete s3://%s/%s: %s\n", stc.bucket, aws.ToString(deleteError.Key), aws.ToString(deleteError.Message))
+		}
+
+		for _, object := range batch {
+			if !failed[*object.Key] {
+				atomic.AddInt64(&stc.nDeleted, 1)
+				stc.logf(stc.stderr, levelInfo, logEvent{Event: eventDeleted, Key: *object.Key}, "Deleted s3://%s/%s\n", stc.bucket, *object.Key)
+			}
+		}
+	}
+}
+
+// checkKeyCollision looks for an object whose key differs from the given key only by a trailing
+// slash -- that is, a file in S3 where there is now a directory locally, or a directory marker
+// where there is now a file. With Delete, the stale object is deleted; otherwise the collision is
+// logged and counted as a conflict.
+func (stc *Cloner) checkKeyCollision(pathname, key string, isDir bool) {
+	otherKey := key + "/"
+	if isDir {
+		otherKey = strings.TrimSuffix(key, "/")
+	}
+
+	if otherKey == "" || otherKey == "/" {
+		return
+	}
+
+	if stc.listing != nil {
+		if _, found := stc.listing[otherKey]; !found {
+			return
+		}
+	} else {
+		if err := stc.sem.Acquire(stc.ctx, 1); err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to acquire S3 semaphore: %v\n", err)
+			return
+		}
+
+		_, err := stc.s3Client.HeadObject(stc.ctx, stc.headObjectInput(otherKey))
+		stc.sem.Release(1)
+		if err != nil {
+			return
+		}
+	}
+
+	localType, otherType := "file", "directory"
+	if isDir {
+		localType, otherType = "directory", "file"
+	}
+
+	if stc.deleteExtraneous {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventConflict, Path: pathname, Key: otherKey, Reason: "key collision"}, "Key collision: %s is a %s but s3://%s/%s is a %s; deleting it\n", pathname, localType, stc.bucket, otherKey, otherType)
+		stc.deleteObjects([]s3Types.ObjectIdentifier{{Key: aws.String(otherKey)}})
+		return
+	}
+
+	stc.logf(stc.stderr, levelInfo, logEvent{Event: eventConflict, Path: pathname, Key: otherKey, Reason: "key collision"}, "Key collision: %s is a %s but s3://%s/%s is a %s; use -delete to remove it\n", pathname, localType, stc.bucket, otherKey, otherType)
+	atomic.AddInt64(&stc.nConflicts, 1)
+}
+
+// namedHash is a hash function along with a human-readable name for error messages.
+type namedHash struct {
+	name string
+	hash hash.Hash
+}
+
+// getFileHashes simultaneously calculates the requested hashes (any of MD5, SHA1, SHA256, and
+// SHA512) of a given file. Only the requested hashers are created, since hashing is CPU-bound. The
+// file is read through the given buffer.
+func getFileHashes(fd io.Reader, algorithms []string, buffer []byte) (*Hashes, error) {
+	hashes := &Hashes{}
+	var hashers []namedHash
+	var dests []*[]byte
+
+	for _, algorithm := range algorithms {
+		switch algorithm {
+		case "md5":
+			hashers = append(hashers, namedHash{name: "MD5", hash: md5.New()})
+			dests = append(dests, &hashes.MD5)
+		case "sha1":
+			hashers = append(hashers, namedHash{name: "SHA1", hash: sha1.New()})
+			dests = append(dests, &hashes.SHA1)
+		case "sha256":
+			hashers = append(hashers, namedHash{name: "SHA256", hash: sha256.New()})
+			dests = append(dests, &hashes.SHA256)
+		case "sha512":
+			hashers = append(hashers, namedHash{name: "SHA512", hash: sha512.New()})
+			dests = append(dests, &hashes.SHA512)
+		}
+	}
+
+	if err := hashReader(fd, hashers, buffer); err != nil {
+		return nil, err
+	}
+
+	for i, h := range hashers {
+		*dests[i] = h.hash.Sum(nil)
+	}
+
+	return hashes, nil
+}
+
+// hashReader reads fd until EOF through the buffer, writing the contents to each of the given
+// hashes.
+func hashReader(fd io.Reader, hashes []namedHash, buffer []byte) error {
+	for {
+		nRead, err := fd.Read(buffer)
+		if nRead <= 0 {
+			if err == io.EOF {
+				break
+			} else {
+				return err
+			}
+		}
+
+		for _, h := range hashes {
+			nWritten, err := h.hash.Write(buffer[:nRead])
+			if nWritten != nRead {
+				return fmt.Errorf("Failed to write %d bytes to %s hash: %v", nRead, h.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// compareFileHashes attempts to compare the local file vs the file stored in S3 using (in order)
+// SHA-512, SHA-256, SHA-1, then MD5 (according to the first hash metadata marker found that is
+// also one of the hash algorithms being computed). If hash metadata is not present, this check is
+// skipped; we do this because AWS File Gateway does not store hashes in the metadata. With
+// -checksum, such objects are treated as differing instead. If the object only has hashes that
+// aren't being computed, it is treated as differing so it is rewritten with the requested hashes.
+//
+// Note that the S3 ETag header is useless for this purpose -- for encrypted buckets, this is *not*
+// the MD5 of the plaintext file. (Even for non-encrypted buckets, it's not guaranteed to be the
+// MD5 sum of the file, or the MD5 sum of the MD5 sums of multipart uploads.) With -trust-etag, it
+// is used anyway for the objects etagIsMD5 accepts, and only the MD5 sum of the file is computed.
+func (stc *Cloner) compareFileHashes(hoo *s3.HeadObjectOutput, pathname string, stat *fileStat) (*Hashes, bool, error) {
+	if !stc.contentComparable(hoo, stat) {
+		// Files below -hash-min-size, and objects without any of our hashes, are compared by size
+		// and timestamps alone.
+		return nil, true, nil
+	}
+
+	// With -checksum-algorithm, the checksum S3 keeps for the object is compared instead of the
+	// hashes in its metadata.
+	if stored := stc.objectChecksum(hoo); stored != "" {
+		same, err := stc.compareFileChecksum(stored, pathname, stat)
+		return nil, same, err
+	}
+
+	if stc.trustETag && etagIsMD5(hoo) {
+		same, err := stc.compareFileETag(hoo, pathname, stat)
+		return nil, same, err
+	}
+
+	metadata := hoo.Metadata
+	anyStored := false
+	algorithm := ""
+	for i := len(HashAlgorithms) - 1; i >= 0; i-- {
+		if metadata[HashAlgorithms[i]] == "" {
+			continue
+		}
+
+		anyStored = true
+		if algorithm == "" && stc.computesHash(HashAlgorithms[i]) {
+			algorithm = HashAlgorithms[i]
+		}
+	}
+
+	if !anyStored {
+		// With -checksum, content that can't be compared is treated as differing. The file is
+		// still hashed so the hashes are stored when it is uploaded.
+		hashes, err := stc.readFileHashes(pathname, stat)
+		return hashes, false, err
+	}
+
+	if algorithm == "" {
+		return nil, false, nil
+	}
+
+	hashes, err := stc.readFileHashes(pathname, stat)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return hashes, metadata[algorithm] == hex.EncodeToString(hashes.get(algorithm)), nil
+}
+
+// contentComparable determines whether compareFileHashes compares the content of a file against
+// its object. Otherwise, the content is assumed to be the same if the size and timestamps are, so
+// a file whose timestamps differ is uploaded again rather than having its metadata updated.
+func (stc *Cloner) contentComparable(hoo *s3.HeadObjectOutput, stat *fileStat) bool {
+	if !stc.hashesFile(stat) {
+		return false
+	}
+
+	if stc.checksum || stc.objectChecksum(hoo) != "" || (stc.trustETag && etagIsMD5(hoo)) {
+		return true
+	}
+
+	for _, algorithm := range HashAlgorithms {
+		if hoo.Metadata[algorithm] != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readFileHashes returns the hashes of a file, from the hash cache if possible.
+func (stc *Cloner) readFileHashes(pathname string, stat *fileStat) (*Hashes, error) {
+	if hashes := stc.cachedFileHashes(pathname, stat); hashes != nil {
+		return hashes, nil
+	}
+
+	fd, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return stc.fileHashes(pathname, stat, fd)
+}
+
+// hashesFile determines whether a file is large enough to be hashed under -hash-min-size. With
+// -checksum, every file is hashed; with -size-only, none are.
+func (stc *Cloner) hashesFile(stat *fileStat) bool {
+	return !stc.sizeOnly && (stc.checksum || stat.Size >= stc.hashMinSize)
+}
+
+// computesHash determines whether the given hash algorithm is one of those being computed.
+func (stc *Cloner) computesHash(algorithm string) bool {
+	for _, name := range stc.hashAlgorithms {
+		if name == algorithm {
+			return true
+		}
+	}
+
+	return false
+}
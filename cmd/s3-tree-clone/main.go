@@ -0,0 +1,13 @@
+// Command s3-tree-clone copies a filesystem tree to S3. See the s3treeclone package for details.
+package main
+
+import (
+	"os"
+
+	s3treeclone "github.jpl.nasa.gov/cloud/s3-tree-clone"
+)
+
+// main is the entrypoint for s3-tree-clone.
+func main() {
+	os.Exit(s3treeclone.Main(os.Args[1:]))
+}
@@ -0,0 +1,134 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// contentEncodingGzip is the Content-Encoding of objects compressed with -compress.
+const contentEncodingGzip = "gzip"
+
+// validCompressPattern determines whether a -compress pattern is a file extension (".log") or a
+// valid content type glob ("text/*").
+func validCompressPattern(pattern string) bool {
+	if strings.HasPrefix(pattern, ".") {
+		return len(pattern) > 1
+	}
+
+	if !strings.Contains(pattern, "/") {
+		return false
+	}
+
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// shouldCompress determines whether a file with the given content type matches any of the
+// compress patterns. Parameters such as "; charset=utf-8" are ignored when matching content types.
+func (stc *Cloner) shouldCompress(pathname, contentType string) bool {
+	mediaType := contentType
+	if semicolon := strings.IndexByte(mediaType, ';'); semicolon != -1 {
+		mediaType = mediaType[:semicolon]
+	}
+	mediaType = strings.TrimSpace(mediaType)
+
+	for _, pattern := range stc.compress {
+		if strings.HasPrefix(pattern, ".") {
+			if strings.EqualFold(filepath.Ext(pathname), pattern) {
+				return true
+			}
+		} else if matched, _ := path.Match(pattern, mediaType); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compressBody gzips the body of a file being uploaded. S3 needs the length of the body before it
+// is sent, so small files are compressed into memory and larger ones into a temporary file, which
+// the returned cleanup function removes. If compression doesn't make the file smaller, a nil body
+// is returned and the file should be uploaded as-is.
+func compressBody(body io.Reader, size int64) (io.ReadSeeker, int64, func(), error) {
+	if size <= singlePassMaxSize {
+		var buffer bytes.Buffer
+		if err := gzipTo(&buffer, body); err != nil || int64(buffer.Len()) >= size {
+			return nil, 0, nil, err
+		}
+
+		return bytes.NewReader(buffer.Bytes()), int64(buffer.Len()), func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "s3-tree-clone-gzip-")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	err = gzipTo(tmp, body)
+	var compressedSize int64
+	if err == nil {
+		compressedSize, err = tmp.Seek(0, io.SeekCurrent)
+	}
+
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+
+	if err != nil || compressedSize >= size {
+		cleanup()
+		return nil, 0, nil, err
+	}
+
+	return tmp, compressedSize, cleanup, nil
+}
+
+// gzipTo writes the gzip-compressed contents of r to w.
+func gzipTo(w io.Writer, r io.Reader) error {
+	gz := gzip.NewWriter(w)
+	if _, err := io.Copy(gz, r); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// objectFileSize returns the size of the file an object was uploaded from, as recorded in its
+// file-size metadata. For compressed objects, this differs from the object's length. Objects
+// without it, such as those written by other programs or earlier versions, are assumed to be the
+// size of their files.
+func objectFileSize(hoo *s3.HeadObjectOutput) int64 {
+	if size, err := strconv.ParseInt(hoo.Metadata["file-size"], 10, 64); err == nil {
+		return size
+	}
+
+	return hoo.ContentLength
+}
+
+// decompressedBody returns a reader for the original content of a downloaded object, undoing any
+// compression applied by -compress.
+func decompressedBody(body io.Reader, contentEncoding *string) (io.Reader, error) {
+	if contentEncoding == nil || *contentEncoding != contentEncodingGzip {
+		return body, nil
+	}
+
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decompress gzip content: %w", err)
+	}
+
+	return gz, nil
+}
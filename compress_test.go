@@ -0,0 +1,159 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestShouldCompress(t *testing.T) {
+	stc := &Cloner{compress: []string{".log", "text/*", "application/json"}}
+	for _, tc := range []struct {
+		pathname    string
+		contentType string
+		expected    bool
+	}{
+		{"/var/log/app.log", "application/octet-stream", true},
+		{"/var/log/APP.LOG", "application/octet-stream", true},
+		{"/data/readme", "text/plain; charset=utf-8", true},
+		{"/data/config", "application/json", true},
+		{"/data/image.png", "image/png", false},
+		{"/data/app.log.1", "application/octet-stream", false},
+	} {
+		if stc.shouldCompress(tc.pathname, tc.contentType) != tc.expected {
+			t.Errorf("Expected %s (%s) to be compressed=%v", tc.pathname, tc.contentType, tc.expected)
+		}
+	}
+
+	for _, pattern := range []string{"log", ".", "text", "text/["} {
+		if validCompressPattern(pattern) {
+			t.Errorf("Expected %#v to be rejected", pattern)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "test-compress-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	destDir, err := os.MkdirTemp("", "test-compress-dest-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	random := make([]byte, 8192)
+	if _, err = rand.Read(random); err != nil {
+		t.Fatalf("Failed to generate random data: %v", err)
+	}
+
+	files := map[string][]byte{
+		"compressible.log":   bytes.Repeat([]byte("GET /index.html 200\n"), 500),
+		"incompressible.log": random,
+		"small.log":          []byte("GET /index.html 200\n"),
+		// Large files are compressed into a temporary file instead of memory.
+		"large.log": bytes.Repeat([]byte("GET /index.html 200\n"), singlePassMaxSize/10),
+	}
+
+	for filename, content := range files {
+		if err = ioutil.WriteFile(srcDir+"/"+filename, content, 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", srcDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-compress", ".log", "-compress-min-size", "1KiB", srcDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, nil)
+
+	if obj := bucket.Objects["large.log"]; aws.ToString(obj.ContentEncoding) != "gzip" || obj.ContentLength >= singlePassMaxSize {
+		t.Errorf("Expected large.log to be compressed: %#v %d", aws.ToString(obj.ContentEncoding), obj.ContentLength)
+	}
+
+	obj := bucket.Objects["compressible.log"]
+	if aws.ToString(obj.ContentEncoding) != "gzip" || obj.Metadata["file-size"] != "10000" {
+		t.Fatalf("Expected compressible.log to be compressed: %#v %#v", aws.ToString(obj.ContentEncoding), obj.Metadata["file-size"])
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(obj.Body))
+	if err != nil {
+		t.Fatalf("Failed to read gzip body of compressible.log: %v", err)
+	}
+
+	decompressed, err := ioutil.ReadAll(gz)
+	if err != nil || !bytes.Equal(decompressed, files["compressible.log"]) {
+		t.Errorf("Expected compressible.log to decompress to the original content: %v", err)
+	}
+
+	for _, filename := range []string{"incompressible.log", "small.log"} {
+		obj = bucket.Objects[filename]
+		if obj.ContentEncoding != nil || !bytes.Equal(obj.Body, files[filename]) {
+			t.Errorf("Expected %s to be uploaded as-is: %#v", filename, aws.ToString(obj.ContentEncoding))
+		}
+	}
+
+	// The compressed object is compared against the original size and hashes, so nothing changes.
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	runExpect(t, []string{"-restore", "s3://hello", destDir}, client, 0, nil, nil)
+	for filename, content := range files {
+		restored, err := ioutil.ReadFile(destDir + "/" + filename)
+		if err != nil || !bytes.Equal(restored, content) {
+			t.Errorf("Expected %s to be restored with its original content: %v", filename, err)
+		}
+	}
+
+	runExpect(t, []string{"-compress", "text", srcDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -compress value: text"))
+}
+
+func TestFileSizeMetadata(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-file-size-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.txt"
+	if err = ioutil.WriteFile(pathname, []byte("Hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	if err = os.Chmod(pathname, 0644); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	// Every file's size is recorded, whether or not it's compressed.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	if size := bucket.Objects["hello.txt"].Metadata["file-size"]; size != "11" {
+		t.Errorf("Expected file-size 11: %#v", size)
+	}
+
+	// It's kept when only the metadata is updated.
+	if err = os.Chmod(pathname, 0600); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Updated the metadata of s3://hello/hello.txt"))
+	if size := bucket.Objects["hello.txt"].Metadata["file-size"]; size != "11" {
+		t.Errorf("Expected file-size 11 after the metadata update: %#v", size)
+	}
+
+	// The recorded size is compared rather than the object's length.
+	bucket.Objects["hello.txt"].Metadata["file-size"] = "12"
+	runExpect(t, args, client, 0, nil, []byte("Content size mismatch: s3://hello/hello.txt has size 12; "+pathname+" has size 11; will resync"))
+
+	// Objects without it are compared by their length.
+	delete(bucket.Objects["hello.txt"].Metadata, "file-size")
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+}
@@ -0,0 +1,97 @@
+package s3treeclone
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// defaultRoleSessionName is the session name used for -assume-role and -role-arn if
+// -role-session-name isn't given.
+const defaultRoleSessionName = "s3-tree-clone"
+
+// withStaticCredentials returns a config option that uses the given credentials instead of the
+// credential chain. The session token is only needed for temporary credentials. With -assume-role,
+// these are the credentials the role is assumed with.
+func withStaticCredentials(accessKeyID, secretAccessKey, sessionToken string) func(*config.LoadOptions) error {
+	return config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken))
+}
+
+// assumeRoleProvider returns a credentials provider that assumes the given role using the STS
+// client, caching the credentials until they expire. If the role requires MFA, mfaSerial is the
+// serial number or ARN of the device, and tokenProvider is called for a code each time the role is
+// assumed.
+func assumeRoleProvider(client stscreds.AssumeRoleAPIClient, roleARN, sessionName, externalID, mfaSerial string, tokenProvider func() (string, error)) aws.CredentialsProvider {
+	provider := stscreds.NewAssumeRoleProvider(client, roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+
+		if mfaSerial != "" {
+			o.SerialNumber = aws.String(mfaSerial)
+			o.TokenProvider = tokenProvider
+		}
+	})
+
+	return aws.NewCredentialsCache(provider)
+}
+
+// mfaTokenProvider returns an MFA token provider that returns the given code the first time it is
+// called, if there is one, and otherwise prompts for a code on stdin. A code can only be used
+// once, so when the credentials expire during a long run, the user is prompted for a new one.
+func mfaTokenProvider(code string) func() (string, error) {
+	return func() (string, error) {
+		if code != "" {
+			token := code
+			code = ""
+			return token, nil
+		}
+
+		return stscreds.StdinTokenProvider()
+	}
+}
+
+// withAssumeRole loads the config with the given options, and returns the options with a
+// credentials provider added that assumes the role using the loaded credentials. Since the
+// provider is part of the options, it also applies to any clients created later from them, such
+// as by ReconfigureS3ClientFromBucketLocation.
+func withAssumeRole(ctx context.Context, configOptions []func(*config.LoadOptions) error, roleARN, sessionName, externalID, mfaSerial string, tokenProvider func() (string, error)) ([]func(*config.LoadOptions) error, error) {
+	baseConfig, err := config.LoadDefaultConfig(ctx, configOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := assumeRoleProvider(sts.NewFromConfig(baseConfig), roleARN, sessionName, externalID, mfaSerial, tokenProvider)
+	return append(configOptions, config.WithCredentialsProvider(provider)), nil
+}
+
+// webIdentityProvider returns a credentials provider that assumes the given role with the OpenID
+// Connect token in tokenFile, such as the one EKS mounts into pods for IAM roles for service
+// accounts, caching the credentials until they expire. The file is read each time the role is
+// assumed, since the token is rotated.
+func webIdentityProvider(client stscreds.AssumeRoleWithWebIdentityAPIClient, roleARN, tokenFile, sessionName string) aws.CredentialsProvider {
+	provider := stscreds.NewWebIdentityRoleProvider(client, roleARN, stscreds.IdentityTokenFile(tokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+		o.RoleSessionName = sessionName
+	})
+
+	return aws.NewCredentialsCache(provider)
+}
+
+// withWebIdentity loads the config with the given options, and returns the options with a
+// credentials provider added that assumes the role with the token in tokenFile. This overrides the
+// AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE environment variables. Like withAssumeRole, the
+// provider also applies to any clients created later from the options.
+func withWebIdentity(ctx context.Context, configOptions []func(*config.LoadOptions) error, roleARN, tokenFile, sessionName string) ([]func(*config.LoadOptions) error, error) {
+	baseConfig, err := config.LoadDefaultConfig(ctx, configOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := webIdentityProvider(sts.NewFromConfig(baseConfig), roleARN, tokenFile, sessionName)
+	return append(configOptions, config.WithCredentialsProvider(provider)), nil
+}
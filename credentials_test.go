@@ -0,0 +1,275 @@
+package s3treeclone
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// stsTestClient records the AssumeRole requests made and returns fixed credentials.
+type stsTestClient struct {
+	inputs []*sts.AssumeRoleInput
+}
+
+func (c *stsTestClient) AssumeRole(ctx context.Context, input *sts.AssumeRoleInput, opts ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	c.inputs = append(c.inputs, input)
+	return &sts.AssumeRoleOutput{
+		Credentials: &stsTypes.Credentials{
+			AccessKeyId:     aws.String("AKIAASSUMED"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+func TestAssumeRoleProvider(t *testing.T) {
+	client := &stsTestClient{}
+	provider := assumeRoleProvider(client, "arn:aws:iam::123456789012:role/backup", "nightly", "secret-id", "", nil)
+
+	creds, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+
+	if creds.AccessKeyID != "AKIAASSUMED" || creds.SessionToken != "token" {
+		t.Errorf("Expected the assumed role credentials: %#v", creds)
+	}
+
+	// The credentials should be cached until they expire.
+	if _, err = provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+
+	if len(client.inputs) != 1 {
+		t.Fatalf("Expected 1 AssumeRole call: %d", len(client.inputs))
+	}
+
+	input := client.inputs[0]
+	if aws.ToString(input.RoleArn) != "arn:aws:iam::123456789012:role/backup" || aws.ToString(input.RoleSessionName) != "nightly" || aws.ToString(input.ExternalId) != "secret-id" {
+		t.Errorf("Unexpected AssumeRole input: %#v %#v %#v", aws.ToString(input.RoleArn), aws.ToString(input.RoleSessionName), aws.ToString(input.ExternalId))
+	}
+
+	// Without an external ID, none should be sent.
+	client = &stsTestClient{}
+	if _, err = assumeRoleProvider(client, "arn:aws:iam::123456789012:role/backup", defaultRoleSessionName, "", "", nil).Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+
+	if client.inputs[0].ExternalId != nil {
+		t.Errorf("Expected no external ID: %#v", aws.ToString(client.inputs[0].ExternalId))
+	}
+
+	if client.inputs[0].SerialNumber != nil || client.inputs[0].TokenCode != nil {
+		t.Errorf("Expected no MFA serial or token: %#v %#v", aws.ToString(client.inputs[0].SerialNumber), aws.ToString(client.inputs[0].TokenCode))
+	}
+}
+
+func TestAssumeRoleMFA(t *testing.T) {
+	client := &stsTestClient{}
+	nTokens := 0
+	tokenProvider := func() (string, error) {
+		nTokens++
+		return fmt.Sprintf("%06d", nTokens), nil
+	}
+
+	provider := assumeRoleProvider(client, "arn:aws:iam::123456789012:role/backup", defaultRoleSessionName, "", "arn:aws:iam::123456789012:mfa/operator", tokenProvider)
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+
+	input := client.inputs[0]
+	if aws.ToString(input.SerialNumber) != "arn:aws:iam::123456789012:mfa/operator" || aws.ToString(input.TokenCode) != "000001" {
+		t.Errorf("Expected the MFA serial and token: %#v %#v", aws.ToString(input.SerialNumber), aws.ToString(input.TokenCode))
+	}
+
+	// When the credentials expire, a new token is asked for.
+	provider.(*aws.CredentialsCache).Invalidate()
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+
+	if len(client.inputs) != 2 || aws.ToString(client.inputs[1].TokenCode) != "000002" {
+		t.Errorf("Expected the role to be assumed again with a new token: %d calls", len(client.inputs))
+	}
+
+	// A token given on the command line is used the first time the role is assumed.
+	token, err := mfaTokenProvider("123456")()
+	if err != nil || token != "123456" {
+		t.Errorf("Expected the given token: %#v %v", token, err)
+	}
+
+	runExpect(t, []string{"-mfa-serial", "arn:aws:iam::123456789012:mfa/operator", ".", "s3://hello"}, nil, 1, nil, []byte("-mfa-serial requires -assume-role, and -mfa-token requires -mfa-serial"))
+	runExpect(t, []string{"-assume-role", "arn:aws:iam::123456789012:role/backup", "-mfa-token", "123456", ".", "s3://hello"}, nil, 1, nil, []byte("-mfa-token requires -mfa-serial"))
+}
+
+func TestWithAssumeRole(t *testing.T) {
+	configOptions := []func(*config.LoadOptions) error{
+		config.WithRegion("us-west-2"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("AKIABASE", "secret", "")),
+	}
+
+	assumeOptions, err := withAssumeRole(context.Background(), configOptions, "arn:aws:iam::123456789012:role/backup", defaultRoleSessionName, "", "", nil)
+	if err != nil {
+		t.Fatalf("withAssumeRole failed: %v", err)
+	}
+
+	if len(assumeOptions) != len(configOptions)+1 {
+		t.Fatalf("Expected a credentials option to be added: %d options", len(assumeOptions))
+	}
+
+	// The added option replaces the base credentials with the assume role provider, so it also
+	// applies when the options are reloaded with the bucket's region.
+	var loadOptions config.LoadOptions
+	for _, option := range assumeOptions {
+		if err = option(&loadOptions); err != nil {
+			t.Fatalf("Failed to apply option: %v", err)
+		}
+	}
+
+	if _, isCache := loadOptions.Credentials.(*aws.CredentialsCache); !isCache {
+		t.Errorf("Expected a cached assume role provider: %T", loadOptions.Credentials)
+	}
+}
+
+func TestStaticCredentials(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-static-credentials-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	// The server records the credentials each request is signed with. Objects never exist, and
+	// every upload succeeds.
+	var mutex sync.Mutex
+	var authorizations, tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		tokens = append(tokens, r.Header.Get("X-Amz-Security-Token"))
+		mutex.Unlock()
+
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("ETag", "\"3e25960a79dbc69b674cd4ec67a72c62\"")
+	}))
+	defer server.Close()
+
+	args := []string{"-region", "us-east-1", "-endpoint-url", server.URL, "-force-path-style", "-access-key-id", "AKIASTATIC", "-secret-access-key", "secret", "-session-token", "token", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, nil, 0, nil, []byte("Objects uploaded:    1"))
+
+	if len(authorizations) == 0 {
+		t.Fatalf("Expected requests to be made")
+	}
+
+	for i, authorization := range authorizations {
+		if !strings.Contains(authorization, "Credential=AKIASTATIC/") || tokens[i] != "token" {
+			t.Errorf("Expected the request to be signed with the static credentials: %#v %#v", authorization, tokens[i])
+		}
+	}
+
+	// The access key and secret go together, and can't be combined with a profile.
+	runExpect(t, []string{"-access-key-id", "AKIASTATIC", ".", "s3://hello"}, nil, 1, nil, []byte("-access-key-id and -secret-access-key must be used together, and are required by -session-token"))
+	runExpect(t, []string{"-secret-access-key", "secret", ".", "s3://hello"}, nil, 1, nil, []byte("-access-key-id and -secret-access-key must be used together"))
+	runExpect(t, []string{"-session-token", "token", ".", "s3://hello"}, nil, 1, nil, []byte("-access-key-id and -secret-access-key must be used together"))
+	runExpect(t, []string{"-profile", "default", "-access-key-id", "AKIASTATIC", "-secret-access-key", "secret", ".", "s3://hello"}, nil, 1, nil, []byte("-access-key-id cannot be used with -profile"))
+}
+
+// stsWebIdentityTestClient records the AssumeRoleWithWebIdentity requests made and returns fixed
+// credentials.
+type stsWebIdentityTestClient struct {
+	inputs []*sts.AssumeRoleWithWebIdentityInput
+}
+
+func (c *stsWebIdentityTestClient) AssumeRoleWithWebIdentity(ctx context.Context, input *sts.AssumeRoleWithWebIdentityInput, opts ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	c.inputs = append(c.inputs, input)
+	return &sts.AssumeRoleWithWebIdentityOutput{
+		Credentials: &stsTypes.Credentials{
+			AccessKeyId:     aws.String("AKIAWEBIDENTITY"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+func TestWebIdentityProvider(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-web-identity-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tokenFile := tmpDir + "/token"
+	if err = ioutil.WriteFile(tokenFile, []byte("oidc-token"), 0600); err != nil {
+		t.Fatalf("Failed to write file %s: %v", tokenFile, err)
+	}
+
+	client := &stsWebIdentityTestClient{}
+	provider := webIdentityProvider(client, "arn:aws:iam::123456789012:role/pod", tokenFile, "nightly")
+	creds, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+
+	if creds.AccessKeyID != "AKIAWEBIDENTITY" || len(client.inputs) != 1 {
+		t.Fatalf("Expected the web identity role credentials: %#v, %d calls", creds, len(client.inputs))
+	}
+
+	input := client.inputs[0]
+	if aws.ToString(input.RoleArn) != "arn:aws:iam::123456789012:role/pod" || aws.ToString(input.RoleSessionName) != "nightly" || aws.ToString(input.WebIdentityToken) != "oidc-token" {
+		t.Errorf("Unexpected AssumeRoleWithWebIdentity input: %#v %#v %#v", aws.ToString(input.RoleArn), aws.ToString(input.RoleSessionName), aws.ToString(input.WebIdentityToken))
+	}
+
+	runExpect(t, []string{"-role-arn", "arn:aws:iam::123456789012:role/pod", ".", "s3://hello"}, nil, 1, nil, []byte("-role-arn and -web-identity-token-file must be used together"))
+	runExpect(t, []string{"-web-identity-token-file", tokenFile, ".", "s3://hello"}, nil, 1, nil, []byte("-role-arn and -web-identity-token-file must be used together"))
+	runExpect(t, []string{"-role-arn", "arn:aws:iam::123456789012:role/pod", "-web-identity-token-file", tokenFile, "-profile", "default", ".", "s3://hello"}, nil, 1, nil, []byte("-role-arn cannot be used with -access-key-id or -profile"))
+}
+
+func TestWithWebIdentity(t *testing.T) {
+	// The environment's web identity settings are overridden by the flags.
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/environment")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/nonexistent/environment-token")
+
+	configOptions := []func(*config.LoadOptions) error{config.WithRegion("us-west-2")}
+	webIdentityOptions, err := withWebIdentity(context.Background(), configOptions, "arn:aws:iam::123456789012:role/pod", "/nonexistent/flag-token", defaultRoleSessionName)
+	if err != nil {
+		t.Fatalf("withWebIdentity failed: %v", err)
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(context.Background(), webIdentityOptions...)
+	if err != nil {
+		t.Fatalf("LoadDefaultConfig failed: %v", err)
+	}
+
+	if _, isCache := awsConfig.Credentials.(*aws.CredentialsCache); !isCache {
+		t.Fatalf("Expected a cached web identity provider: %T", awsConfig.Credentials)
+	}
+
+	// The token is read from the file given, before STS is called.
+	_, err = awsConfig.Credentials.Retrieve(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/flag-token") {
+		t.Errorf("Expected the token to be read from the given file: %v", err)
+	}
+}
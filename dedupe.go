@@ -0,0 +1,129 @@
+package s3treeclone
+
+import (
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// dedupeSource is an object uploaded during this run. Files with the same SHA-256 hash are copied
+// from it on the server instead of being uploaded again.
+type dedupeSource struct {
+	key             string
+	contentEncoding *string
+}
+
+// findDedupeSource returns the object already uploaded with the given content, if any.
+func (stc *Cloner) findDedupeSource(hashes *Hashes) (dedupeSource, bool) {
+	stc.dedupeMutex.Lock()
+	defer stc.dedupeMutex.Unlock()
+
+	source, found := stc.dedupeSources[hex.EncodeToString(hashes.SHA256)]
+	return source, found
+}
+
+// recordDedupeSource notes that an object with the given content has been uploaded. The first
+// object uploaded with each content is kept.
+func (stc *Cloner) recordDedupeSource(hashes *Hashes, key string, contentEncoding *string) {
+	stc.dedupeMutex.Lock()
+	defer stc.dedupeMutex.Unlock()
+
+	sha256 := hex.EncodeToString(hashes.SHA256)
+	if _, found := stc.dedupeSources[sha256]; !found {
+		stc.dedupeSources[sha256] = dedupeSource{key: key, contentEncoding: contentEncoding}
+	}
+}
+
+// copyObjectInput returns the input to create the object for a file by copying sourceKey on the
+// server, replacing its metadata with the file's. contentEncoding is the source's content encoding,
+// since the copy keeps its body.
+func (stc *Cloner) copyObjectInput(key, sourceKey string, stat *fileStat, metadata map[string]string, contentType string, contentEncoding *string) *s3.CopyObjectInput {
+	metadata["file-size"] = strconv.FormatInt(stat.Size, 10)
+	metadata["file-content-type"] = contentType
+
+	// Build the headers, encryption, retention, tags, and policy the same way as for an upload.
+	poi := &s3.PutObjectInput{Key: &key, StorageClass: stc.fileStorageClass(key, stat), ACL: stc.acl}
+	poi.Tagging = stc.objectTagging(metadata)
+	stc.setPutObjectHeaders(poi)
+	stc.setPutObjectPolicy(poi)
+	stc.setPutObjectEncryption(poi)
+	stc.setPutObjectLock(poi)
+
+	coi := &s3.CopyObjectInput{
+		Bucket:               &stc.bucket,
+		Key:                  &key,
+		CopySource:           aws.String(stc.bucket + "/" + (&url.URL{Path: sourceKey}).EscapedPath()),
+		MetadataDirective:    s3Types.MetadataDirectiveReplace,
+		CacheControl:         poi.CacheControl,
+		ContentDisposition:   poi.ContentDisposition,
+		Expires:              poi.Expires,
+		ContentEncoding:      contentEncoding,
+		ContentType:          &contentType,
+		Metadata:             metadata,
+		StorageClass:         poi.StorageClass,
+		ACL:                  poi.ACL,
+		RequestPayer:         stc.requestPayer,
+		ServerSideEncryption: poi.ServerSideEncryption,
+		SSEKMSKeyId:          poi.SSEKMSKeyId,
+		BucketKeyEnabled:     poi.BucketKeyEnabled,
+		SSECustomerAlgorithm: poi.SSECustomerAlgorithm,
+		SSECustomerKey:       poi.SSECustomerKey,
+		SSECustomerKeyMD5:    poi.SSECustomerKeyMD5,
+
+		WebsiteRedirectLocation:   poi.WebsiteRedirectLocation,
+		ObjectLockMode:            poi.ObjectLockMode,
+		ObjectLockRetainUntilDate: poi.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: poi.ObjectLockLegalHoldStatus,
+	}
+
+	// With -checksum-algorithm, S3 computes the checksum of the copy.
+	if stc.checksumAlgorithm != "" {
+		coi.ChecksumAlgorithm = stc.checksumAlgorithm
+	}
+
+	if poi.Tagging != nil {
+		coi.Tagging = poi.Tagging
+		coi.TaggingDirective = s3Types.TaggingDirectiveReplace
+	}
+
+	// The source was encrypted with the same customer-provided key.
+	if poi.SSECustomerKey != nil {
+		coi.CopySourceSSECustomerAlgorithm = poi.SSECustomerAlgorithm
+		coi.CopySourceSSECustomerKey = poi.SSECustomerKey
+		coi.CopySourceSSECustomerKeyMD5 = poi.SSECustomerKeyMD5
+	}
+
+	return coi
+}
+
+// copyDuplicate creates the object for a file by copying an object with the same content on the
+// server, replacing its metadata with the file's. It returns false if the copy failed, in which
+// case the file should be uploaded instead.
+func (stc *Cloner) copyDuplicate(pathname, key string, stat *fileStat, source dedupeSource, metadata map[string]string, contentType string) bool {
+	coi := stc.copyObjectInput(key, source.key, stat, metadata, contentType, source.contentEncoding)
+	if err := stc.sem.Acquire(stc.ctx, 1); err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to acquire S3 semaphore: %v\n", err)
+		return true
+	}
+
+	_, err := stc.s3Client.CopyObject(stc.ctx, coi)
+	stc.sem.Release(1)
+	if err != nil {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventWarning, Path: pathname, Key: key, Error: err.Error()}, "Unable to copy s3://%s/%s to s3://%s/%s; uploading %s instead: %v\n", stc.bucket, source.key, stc.bucket, key, pathname, err)
+		return false
+	}
+
+	stc.logf(stc.stderr, levelInfo, logEvent{Event: eventUploaded, Path: pathname, Key: key, Reason: "duplicate", Bytes: aws.Int64(0)}, "Copied %s to s3://%s/%s from duplicate s3://%s/%s\n", pathname, stc.bucket, key, stc.bucket, source.key)
+	atomic.AddInt64(&stc.nUploaded, 1)
+
+	if stc.verifyAfterUpload {
+		stc.VerifyUpload(pathname, key, stat, false)
+	}
+
+	return true
+}
@@ -0,0 +1,105 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-dedupe-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.MkdirAll(srcDir+"/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/sub: %v", srcDir, err)
+	}
+
+	duplicate := bytes.Repeat([]byte("duplicate content\n"), 256)
+	files := map[string][]byte{
+		"a.txt":     duplicate,
+		"b.txt":     duplicate,
+		"sub/c.txt": duplicate,
+		"other.txt": []byte("something else"),
+	}
+
+	for name, content := range files {
+		err = ioutil.WriteFile(srcDir+"/"+name, content, 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", srcDir, name, err)
+		}
+	}
+
+	runExpect(t, []string{"-dedupe", "-hash-algorithms", "md5", srcDir + "/", "s3://hello"}, newS3TestClient(), 1, nil, []byte("Dedupe requires the sha256 hash algorithm"))
+
+	// Files are examined one at a time so the first copy of the content is always uploaded before
+	// the others are examined.
+	for _, compress := range []bool{false, true} {
+		args := []string{"-dedupe", "-walk-workers", "1", srcDir + "/", "s3://hello"}
+		if compress {
+			args = append([]string{"-compress", ".txt"}, args...)
+		}
+
+		client := &recordingClient{s3TestClient: newS3TestClient()}
+		bucket := client.createBucket("hello")
+		runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    4"))
+
+		duplicateBodies := 0
+		for _, input := range client.putInputs {
+			if key := *input.Key; key == "a.txt" || key == "b.txt" || key == "sub/c.txt" {
+				duplicateBodies++
+			}
+		}
+
+		if duplicateBodies != 1 || len(client.copyInputs) != 2 {
+			t.Errorf("compress=%v: expected 1 upload and 2 copies of the duplicate content, got %d uploads and %d copies", compress, duplicateBodies, len(client.copyInputs))
+		}
+
+		// Every object has the content and its own file's metadata.
+		for name := range files {
+			object, found := bucket.Objects[name]
+			if !found {
+				t.Errorf("compress=%v: expected %s to be present", compress, name)
+				continue
+			}
+
+			if object.Metadata["file-mtime"] == "" || object.Metadata["sha256"] == "" {
+				t.Errorf("compress=%v: expected %s to have file metadata, got %v", compress, name, object.Metadata)
+			}
+
+			if compress && name != "other.txt" && (object.ContentEncoding == nil || *object.ContentEncoding != contentEncodingGzip || object.Metadata["file-size"] == "") {
+				t.Errorf("compress=%v: expected %s to be gzipped with its file size recorded", compress, name)
+			}
+		}
+
+		// The copies are up to date.
+		runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+		if compress {
+			runExpect(t, []string{"-restore", "s3://hello", tmpDir + "/restored"}, client, 0, nil, nil)
+
+			restored, err := ioutil.ReadFile(tmpDir + "/restored/sub/c.txt")
+			if err != nil {
+				t.Fatalf("Failed to read %s/restored/sub/c.txt: %v", tmpDir, err)
+			}
+
+			if !bytes.Equal(restored, duplicate) {
+				t.Errorf("Expected restored sub/c.txt to match the original")
+			}
+		}
+	}
+
+	// Without -dedupe, each file is uploaded.
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	runExpect(t, []string{srcDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	if len(client.copyInputs) != 0 || len(client.putInputs) != 5 {
+		t.Errorf("Expected 5 uploads and no copies without -dedupe, got %d uploads and %d copies", len(client.putInputs), len(client.copyInputs))
+	}
+}
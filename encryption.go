@@ -0,0 +1,86 @@
+package s3treeclone
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// EncryptionSSEC is the EncryptionAlgorithm for server-side encryption with a customer-provided
+// key (SSE-C). The key is sent with every request for the object instead of being stored by S3.
+const EncryptionSSEC s3Types.ServerSideEncryption = "SSE-C"
+
+// sseCustomerAlgorithm is the only algorithm S3 supports for SSE-C.
+const sseCustomerAlgorithm = "AES256"
+
+// sseCustomerKeySize is the size, in bytes, of an AES256 SSE-C key.
+const sseCustomerKeySize = 32
+
+// parseSSECustomerKey checks that the base64-encoded key is the right size for SSE-C and returns
+// the base64-encoded MD5 digest of the key that S3 requires with it.
+func parseSSECustomerKey(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("A customer key is required with SSE-C")
+	}
+
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("SSE-C customer key is not valid base64: %w", err)
+	}
+
+	if len(rawKey) != sseCustomerKeySize {
+		return "", fmt.Errorf("SSE-C customer key must be %d bytes: got %d bytes", sseCustomerKeySize, len(rawKey))
+	}
+
+	keyMD5 := md5.Sum(rawKey)
+	return base64.StdEncoding.EncodeToString(keyMD5[:]), nil
+}
+
+// setPutObjectEncryption sets the server-side encryption parameters on an upload.
+func (stc *Cloner) setPutObjectEncryption(poi *s3.PutObjectInput) {
+	switch stc.encAlg {
+	case EncryptionSSEC:
+		poi.SSECustomerAlgorithm = &stc.sseCustomerAlgorithm
+		poi.SSECustomerKey = &stc.sseCustomerKey
+		poi.SSECustomerKeyMD5 = &stc.sseCustomerKeyMD5
+	case s3Types.ServerSideEncryptionAwsKms:
+		poi.ServerSideEncryption = stc.encAlg
+		poi.SSEKMSKeyId = &stc.kmsKey
+		poi.BucketKeyEnabled = stc.bucketKeyEnabled
+	default:
+		poi.ServerSideEncryption = stc.encAlg
+	}
+}
+
+// headObjectInput returns the input for a HeadObject call on the key. With SSE-C, the customer key
+// has to be sent to read the object's metadata.
+func (stc *Cloner) headObjectInput(key string) *s3.HeadObjectInput {
+	hoi := &s3.HeadObjectInput{Bucket: &stc.bucket, Key: &key, RequestPayer: stc.requestPayer}
+	if stc.checksumAlgorithm != "" {
+		hoi.ChecksumMode = s3Types.ChecksumModeEnabled
+	}
+
+	if stc.encAlg == EncryptionSSEC {
+		hoi.SSECustomerAlgorithm = &stc.sseCustomerAlgorithm
+		hoi.SSECustomerKey = &stc.sseCustomerKey
+		hoi.SSECustomerKeyMD5 = &stc.sseCustomerKeyMD5
+	}
+
+	return hoi
+}
+
+// getObjectInput returns the input for a GetObject call on the key, including the customer key
+// with SSE-C.
+func (stc *Cloner) getObjectInput(key string) *s3.GetObjectInput {
+	goi := &s3.GetObjectInput{Bucket: &stc.bucket, Key: &key, RequestPayer: stc.requestPayer}
+	if stc.encAlg == EncryptionSSEC {
+		goi.SSECustomerAlgorithm = &stc.sseCustomerAlgorithm
+		goi.SSECustomerKey = &stc.sseCustomerKey
+		goi.SSECustomerKeyMD5 = &stc.sseCustomerKeyMD5
+	}
+
+	return goi
+}
@@ -0,0 +1,201 @@
+package s3treeclone
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// recordingClient records the inputs of each PutObject, HeadObject, and CopyObject call, and the
+// RequestPayer of every call.
+type recordingClient struct {
+	*s3TestClient
+	mutex         sync.Mutex
+	putInputs     []*s3.PutObjectInput
+	headInputs    []*s3.HeadObjectInput
+	copyInputs    []*s3.CopyObjectInput
+	requestPayers map[string][]s3Types.RequestPayer
+}
+
+func (c *recordingClient) recordPayer(operation string, requestPayer s3Types.RequestPayer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.requestPayers == nil {
+		c.requestPayers = make(map[string][]s3Types.RequestPayer)
+	}
+
+	c.requestPayers[operation] = append(c.requestPayers[operation], requestPayer)
+}
+
+func (c *recordingClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	c.recordPayer("PutObject", input.RequestPayer)
+	c.mutex.Lock()
+	c.putInputs = append(c.putInputs, input)
+	c.mutex.Unlock()
+	return c.s3TestClient.PutObject(ctx, input, opts...)
+}
+
+func (c *recordingClient) CopyObject(ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	c.recordPayer("CopyObject", input.RequestPayer)
+	c.mutex.Lock()
+	c.copyInputs = append(c.copyInputs, input)
+	c.mutex.Unlock()
+	return c.s3TestClient.CopyObject(ctx, input, opts...)
+}
+
+func (c *recordingClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.recordPayer("HeadObject", input.RequestPayer)
+	c.mutex.Lock()
+	c.headInputs = append(c.headInputs, input)
+	c.mutex.Unlock()
+	return c.s3TestClient.HeadObject(ctx, input, opts...)
+}
+
+func (c *recordingClient) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	c.recordPayer("AbortMultipartUpload", input.RequestPayer)
+	return c.s3TestClient.AbortMultipartUpload(ctx, input, opts...)
+}
+
+func (c *recordingClient) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	c.recordPayer("CompleteMultipartUpload", input.RequestPayer)
+	return c.s3TestClient.CompleteMultipartUpload(ctx, input, opts...)
+}
+
+func (c *recordingClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	c.recordPayer("CreateMultipartUpload", input.RequestPayer)
+	return c.s3TestClient.CreateMultipartUpload(ctx, input, opts...)
+}
+
+func (c *recordingClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	c.recordPayer("UploadPart", input.RequestPayer)
+	return c.s3TestClient.UploadPart(ctx, input, opts...)
+}
+
+func (c *recordingClient) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	c.recordPayer("DeleteObjects", input.RequestPayer)
+	return c.s3TestClient.DeleteObjects(ctx, input, opts...)
+}
+
+func (c *recordingClient) GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.recordPayer("GetObject", input.RequestPayer)
+	return c.s3TestClient.GetObject(ctx, input, opts...)
+}
+
+func (c *recordingClient) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.recordPayer("ListObjectsV2", input.RequestPayer)
+	return c.s3TestClient.ListObjectsV2(ctx, input, opts...)
+}
+
+func TestSSECustomerKey(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-sse-c-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	rawKey := []byte(strings.Repeat("k", sseCustomerKeySize))
+	key := base64.StdEncoding.EncodeToString(rawKey)
+	keyMD5 := md5.Sum(rawKey)
+	expectedMD5 := base64.StdEncoding.EncodeToString(keyMD5[:])
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	args := []string{"-encryption-algorithm", "SSE-C", "-sse-customer-key", key, tmpDir, "s3://hello"}
+
+	// The first run uploads the directory marker and file; the second compares them.
+	runExpect(t, args, client, 0, nil, nil)
+	runExpect(t, args, client, 0, nil, nil)
+
+	if len(client.putInputs) != 2 {
+		t.Fatalf("Expected 2 PutObject calls: %d", len(client.putInputs))
+	}
+
+	for _, input := range client.putInputs {
+		if aws.ToString(input.SSECustomerAlgorithm) != "AES256" || aws.ToString(input.SSECustomerKey) != key || aws.ToString(input.SSECustomerKeyMD5) != expectedMD5 {
+			t.Errorf("Expected SSE-C parameters for PutObject of %s: %#v %#v %#v", aws.ToString(input.Key), aws.ToString(input.SSECustomerAlgorithm), aws.ToString(input.SSECustomerKey), aws.ToString(input.SSECustomerKeyMD5))
+		}
+
+		if input.ServerSideEncryption != "" {
+			t.Errorf("Expected no ServerSideEncryption with SSE-C: %s", input.ServerSideEncryption)
+		}
+	}
+
+	if len(client.headInputs) == 0 {
+		t.Fatalf("Expected HeadObject calls")
+	}
+
+	for _, input := range client.headInputs {
+		if aws.ToString(input.SSECustomerAlgorithm) != "AES256" || aws.ToString(input.SSECustomerKey) != key || aws.ToString(input.SSECustomerKeyMD5) != expectedMD5 {
+			t.Errorf("Expected SSE-C parameters for HeadObject of %s: %#v %#v %#v", aws.ToString(input.Key), aws.ToString(input.SSECustomerAlgorithm), aws.ToString(input.SSECustomerKey), aws.ToString(input.SSECustomerKeyMD5))
+		}
+	}
+}
+
+func TestSSECustomerKeyInvalid(t *testing.T) {
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	runExpect(t, []string{"-encryption-algorithm", "SSE-C", ".", "s3://hello"}, client, 1, nil, []byte("Invalid -sse-customer-key value: A customer key is required with SSE-C"))
+
+	shortKey := base64.StdEncoding.EncodeToString([]byte("too short"))
+	runExpect(t, []string{"-encryption-algorithm", "SSE-C", "-sse-customer-key", shortKey, ".", "s3://hello"}, client, 1, nil, []byte("SSE-C customer key must be 32 bytes: got 9 bytes"))
+
+	runExpect(t, []string{"-encryption-algorithm", "SSE-C", "-sse-customer-key", "not base64!", ".", "s3://hello"}, client, 1, nil, []byte("SSE-C customer key is not valid base64"))
+}
+
+func TestBucketKeyEnabled(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-bucket-key-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	runExpect(t, []string{"-encryption-algorithm", "aws:kms", "-bucket-key-enabled", tmpDir, "s3://hello/kms"}, client, 0, nil, nil)
+
+	// Both the directory marker and the file use the bucket key.
+	if len(client.putInputs) != 2 {
+		t.Fatalf("Expected 2 PutObject calls: %d", len(client.putInputs))
+	}
+
+	for _, input := range client.putInputs {
+		if !input.BucketKeyEnabled {
+			t.Errorf("Expected BucketKeyEnabled for PutObject of %s", aws.ToString(input.Key))
+		}
+	}
+
+	// Without KMS, the flag only produces a warning.
+	client.putInputs = nil
+	runExpect(t, []string{"-bucket-key-enabled", tmpDir, "s3://hello/aes"}, client, 0, nil, []byte("Warning: -bucket-key-enabled has no effect unless -encryption-algorithm is aws:kms"))
+
+	if len(client.putInputs) != 2 {
+		t.Fatalf("Expected 2 PutObject calls: %d", len(client.putInputs))
+	}
+
+	for _, input := range client.putInputs {
+		if input.BucketKeyEnabled {
+			t.Errorf("Expected no BucketKeyEnabled without KMS for PutObject of %s", aws.ToString(input.Key))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package s3treeclone
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// fipsRegions are the regions in which S3 has FIPS endpoints. The SDK will construct a FIPS
+// hostname for any region, so this is checked up front rather than failing on every request.
+var fipsRegions = map[string]bool{
+	"ca-central-1":  true,
+	"us-east-1":     true,
+	"us-east-2":     true,
+	"us-gov-east-1": true,
+	"us-gov-west-1": true,
+	"us-west-1":     true,
+	"us-west-2":     true,
+}
+
+// endpointConfigOptions returns the config options selecting FIPS and dual-stack (IPv4 and IPv6)
+// endpoints, if requested.
+func endpointConfigOptions(useFIPS, useDualStack bool) []func(*config.LoadOptions) error {
+	var options []func(*config.LoadOptions) error
+
+	if useFIPS {
+		options = append(options, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+
+	if useDualStack {
+		options = append(options, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+
+	return options
+}
+
+// usesFIPSEndpoint determines whether a loaded config selects FIPS endpoints, either through its
+// load options or through the environment or shared config file.
+func usesFIPSEndpoint(ctx context.Context, awsConfig aws.Config) bool {
+	for _, source := range awsConfig.ConfigSources {
+		provider, ok := source.(interface {
+			GetUseFIPSEndpoint(context.Context) (aws.FIPSEndpointState, bool, error)
+		})
+		if !ok {
+			continue
+		}
+
+		if state, found, err := provider.GetUseFIPSEndpoint(ctx); err == nil && found {
+			return state == aws.FIPSEndpointStateEnabled
+		}
+	}
+
+	return false
+}
+
+// checkFIPSRegion returns an error if a loaded config selects FIPS endpoints in a region where S3
+// doesn't have one.
+func checkFIPSRegion(ctx context.Context, awsConfig aws.Config) error {
+	if !usesFIPSEndpoint(ctx, awsConfig) || fipsRegions[awsConfig.Region] {
+		return nil
+	}
+
+	regions := make([]string, 0, len(fipsRegions))
+	for region := range fipsRegions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	return fmt.Errorf("S3 has no FIPS endpoint in %#v; FIPS endpoints are only available in %s", awsConfig.Region, strings.Join(regions, ", "))
+}
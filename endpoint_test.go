@@ -0,0 +1,99 @@
+package s3treeclone
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// hostRecorder is an HTTP client that records the host each request is sent to without sending it.
+type hostRecorder struct {
+	hosts []string
+}
+
+func (hr *hostRecorder) Do(req *http.Request) (*http.Response, error) {
+	hr.hosts = append(hr.hosts, req.URL.Host)
+	return nil, errors.New("not sent")
+}
+
+// requestHost returns the host the client sends a HeadObject request for s3://hello/hello.txt to.
+func requestHost(t *testing.T, newClient func(s3Options []func(*s3.Options)) (*s3.Client, error)) string {
+	recorder := &hostRecorder{}
+	client, err := newClient([]func(*s3.Options){func(o *s3.Options) { o.HTTPClient = recorder }})
+	if err != nil {
+		t.Fatalf("Failed to create S3 client: %v", err)
+	}
+
+	client.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: aws.String("hello"), Key: aws.String("hello.txt")})
+	if len(recorder.hosts) != 1 {
+		t.Fatalf("Expected one request, got %d", len(recorder.hosts))
+	}
+
+	return recorder.hosts[0]
+}
+
+func TestEndpointConfigOptions(t *testing.T) {
+	ctx := context.Background()
+	baseOptions := []func(*config.LoadOptions) error{
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")),
+		config.WithRetryer(func() aws.Retryer { return aws.NopRetryer{} }),
+	}
+
+	configOptions := append(baseOptions[:len(baseOptions):len(baseOptions)], endpointConfigOptions(true, true)...)
+	awsConfig, err := config.LoadDefaultConfig(ctx, append(configOptions, config.WithRegion("us-east-1"))...)
+	if err != nil {
+		t.Fatalf("Failed to load AWS config: %v", err)
+	}
+
+	if !usesFIPSEndpoint(ctx, awsConfig) {
+		t.Errorf("Expected the loaded config to use FIPS endpoints")
+	}
+
+	host := requestHost(t, func(s3Options []func(*s3.Options)) (*s3.Client, error) {
+		return s3.NewFromConfig(awsConfig, s3Options...), nil
+	})
+	if host != "hello.s3-fips.dualstack.us-east-1.amazonaws.com" {
+		t.Errorf("Expected the FIPS dual-stack endpoint, got %s", host)
+	}
+
+	// The endpoint options carry over to clients for the bucket's region.
+	host = requestHost(t, func(s3Options []func(*s3.Options)) (*s3.Client, error) {
+		return newRegionalClient(ctx, "us-west-2", configOptions, s3Options)
+	})
+	if host != "hello.s3-fips.dualstack.us-west-2.amazonaws.com" {
+		t.Errorf("Expected the FIPS dual-stack endpoint for us-west-2, got %s", host)
+	}
+
+	configOptions = append(baseOptions[:len(baseOptions):len(baseOptions)], endpointConfigOptions(false, true)...)
+	host = requestHost(t, func(s3Options []func(*s3.Options)) (*s3.Client, error) {
+		return newRegionalClient(ctx, "eu-west-1", configOptions, s3Options)
+	})
+	if host != "hello.s3.dualstack.eu-west-1.amazonaws.com" {
+		t.Errorf("Expected the dual-stack endpoint for eu-west-1, got %s", host)
+	}
+
+	if len(endpointConfigOptions(false, false)) != 0 {
+		t.Errorf("Expected no endpoint options by default")
+	}
+}
+
+func TestFIPSRegion(t *testing.T) {
+	configOptions := append(endpointConfigOptions(true, false), config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")))
+	_, err := newRegionalClient(context.Background(), "eu-west-1", configOptions, nil)
+	if err == nil || !strings.Contains(err.Error(), `S3 has no FIPS endpoint in "eu-west-1"`) {
+		t.Errorf("Expected FIPS to be rejected in eu-west-1: %v", err)
+	}
+
+	if _, err = newRegionalClient(context.Background(), "us-gov-west-1", configOptions, nil); err != nil {
+		t.Errorf("Expected FIPS to be allowed in us-gov-west-1: %v", err)
+	}
+
+	runExpect(t, []string{"-use-fips-endpoint", "-endpoint-url", "http://localhost:9000", ".", "s3://hello"}, newS3TestClient(), 1, nil, []byte("-use-fips-endpoint and -use-dualstack-endpoint cannot be used with -endpoint-url"))
+}
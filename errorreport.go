@@ -0,0 +1,77 @@
+package s3treeclone
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// errorReportEntry is a failure written to the -error-report file.
+type errorReportEntry struct {
+	Path  string `json:"path,omitempty"` // Relative to the source, as -files-from expects.
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// errorReportEntries converts the failures of a clone into error report entries, with each local
+// path made relative to the source directory.
+func (stc *Cloner) errorReportEntries(errs []PathError) []errorReportEntry {
+	root := stc.baseDir
+	if !isGlob(stc.firstFilter) {
+		root = path.Join(stc.baseDir, stc.firstFilter)
+	}
+
+	var entries []errorReportEntry
+	for _, pe := range errs {
+		entry := errorReportEntry{Path: pe.Path, Key: pe.Key, Error: pe.Err.Error()}
+		if pe.Path != "" {
+			if relPath, err := filepath.Rel(root, pe.Path); err == nil && !strings.HasPrefix(relPath, "..") {
+				entry.Path = filepath.ToSlash(relPath)
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// writeErrorReport writes the failures of a run to filename. In text format, the path of each
+// failed file is written on its own line, so the file can be given to -files-from to retry them;
+// failures that aren't of a local file, such as listing errors, are left out. In json format,
+// every failure is written as a JSON object per line with its path, key, and error.
+func writeErrorReport(filename string, entries []errorReportEntry, logFormat string) error {
+	fd, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	w := bufio.NewWriter(fd)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if logFormat == LogFormatJSON {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			w.Write(append(line, '\n'))
+			continue
+		}
+
+		if entry.Path != "" && !seen[entry.Path] {
+			seen[entry.Path] = true
+			w.WriteString(entry.Path + "\n")
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	return fd.Close()
+}
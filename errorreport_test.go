@@ -0,0 +1,88 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestErrorReport(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-error-report-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = os.MkdirAll(tmpDir+"/src/d1", 0755); err != nil {
+		t.Fatalf("Failed to create directory %s/src/d1: %v", tmpDir, err)
+	}
+
+	for _, filename := range []string{"c.txt", "d1/a.txt", "d1/b.txt"} {
+		if err = ioutil.WriteFile(tmpDir+"/src/"+filename, []byte("hello"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/src/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	client.PutObjectError = func(input *s3.PutObjectInput) error {
+		if *input.Key == "c.txt" || *input.Key == "d1/a.txt" {
+			return makeS3Error("PutObject", 403, "Forbidden", "AccessDenied", "Access Denied")
+		}
+		return nil
+	}
+
+	reportPath := tmpDir + "/failures.txt"
+	runExpect(t, []string{"-error-report", reportPath, tmpDir + "/src/", "s3://hello"}, client, 1, nil, []byte("2 of 4 objects failed"))
+
+	report, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", reportPath, err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(report), "\n"), "\n")
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != "c.txt,d1/a.txt" {
+		t.Errorf("Expected the failed files in the report: %#v", string(report))
+	}
+
+	// In json format, each failure has its path, key, and error.
+	runExpect(t, []string{"-error-report", reportPath, "-log-format", "json", tmpDir + "/src/", "s3://hello"}, client, 1, nil, nil)
+	report, err = ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", reportPath, err)
+	}
+
+	var keys []string
+	for _, line := range bytes.Split(bytes.TrimSuffix(report, []byte("\n")), []byte("\n")) {
+		var entry errorReportEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("Failed to parse report line %s: %v", line, err)
+		}
+
+		if entry.Path != entry.Key || !strings.Contains(entry.Error, "AccessDenied") {
+			t.Errorf("Expected the path, key, and error of the failure: %#v", entry)
+		}
+
+		keys = append(keys, entry.Key)
+	}
+
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "c.txt,d1/a.txt" {
+		t.Errorf("Expected the failed files in the report: %#v", string(report))
+	}
+
+	// The text report can be used to retry just the failures, after which it's empty.
+	runExpect(t, []string{"-error-report", reportPath, tmpDir + "/src/", "s3://hello"}, client, 1, nil, nil)
+	client.PutObjectError = nil
+	runExpect(t, []string{"-files-from", reportPath, "-error-report", reportPath, tmpDir + "/src/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    2"))
+	if report, err = ioutil.ReadFile(reportPath); err != nil || len(report) != 0 {
+		t.Errorf("Expected an empty report: %#v %v", string(report), err)
+	}
+}
@@ -0,0 +1,182 @@
+package s3treeclone
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// etagIsMD5 determines whether an object's ETag is the MD5 sum of its content. This only holds for
+// objects uploaded in a single part that are stored unencrypted or with SSE-S3 (AES256); with
+// SSE-KMS or SSE-C, the ETag is not derived from the plaintext, and a multipart ETag is a hash of
+// the MD5 sums of the parts. A compressed object's ETag is that of the compressed content.
+func etagIsMD5(hoo *s3.HeadObjectOutput) bool {
+	etag := aws.ToString(hoo.ETag)
+	switch {
+	case isMultipartETag(etag) || hoo.PartsCount > 1:
+		return false
+	case hoo.ServerSideEncryption != "" && hoo.ServerSideEncryption != s3Types.ServerSideEncryptionAes256:
+		return false
+	case hoo.SSECustomerAlgorithm != nil:
+		return false
+	case aws.ToString(hoo.ContentEncoding) == contentEncodingGzip:
+		return false
+	}
+
+	digest, err := hex.DecodeString(strings.Trim(etag, "\""))
+	return err == nil && len(digest) == 16
+}
+
+// compareFileETag compares the MD5 sum of a file against the ETag of its object, which must be
+// one etagIsMD5 trusts. Only the MD5 sum is computed, unless the hash cache already has it.
+func (stc *Cloner) compareFileETag(hoo *s3.HeadObjectOutput, pathname string, stat *fileStat) (bool, error) {
+	etag := strings.Trim(aws.ToString(hoo.ETag), "\"")
+	if stc.hashCache != nil {
+		if hashes := stc.hashCache.Get(pathname, stat); hashes != nil && hashes.MD5 != nil {
+			return hex.EncodeToString(hashes.MD5) == etag, nil
+		}
+	}
+
+	fd, err := os.Open(pathname)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+
+	hashes, err := stc.hashReader(fd, []string{"md5"})
+	if err != nil {
+		return false, err
+	}
+
+	return hex.EncodeToString(hashes.MD5) == etag, nil
+}
+
+// etagMetadataKey is the metadata key -etag-metadata stores the expected ETag of a file under.
+const etagMetadataKey = "file-etag"
+
+// etagHash computes the ETag S3 gives content uploaded in parts of partSize bytes: the MD5 sum of
+// the concatenated MD5 sums of the parts, followed by "-" and the number of parts. With a partSize
+// of zero, it computes the ETag of content uploaded with a single request, which is its MD5 sum.
+type etagHash struct {
+	partSize int64
+	partLen  int64
+	part     hash.Hash
+	partSums []byte
+	nParts   int
+}
+
+func newETagHash(partSize int64) *etagHash {
+	return &etagHash{partSize: partSize, part: md5.New()}
+}
+
+// Write adds p to the content, starting a new part each time partSize bytes have been written.
+func (h *etagHash) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p
+		if h.partSize > 0 && int64(len(chunk)) > h.partSize-h.partLen {
+			chunk = chunk[:h.partSize-h.partLen]
+		}
+
+		h.part.Write(chunk)
+		h.partLen += int64(len(chunk))
+		p = p[len(chunk):]
+
+		if h.partLen == h.partSize {
+			h.endPart()
+		}
+	}
+
+	return n, nil
+}
+
+func (h *etagHash) endPart() {
+	h.partSums = h.part.Sum(h.partSums)
+	h.nParts++
+	h.part.Reset()
+	h.partLen = 0
+}
+
+// ETag returns the ETag of the content, without the quotes S3 surrounds it with. It ends the last
+// part, so nothing more can be written.
+func (h *etagHash) ETag() string {
+	if h.partSize == 0 {
+		return hex.EncodeToString(h.part.Sum(nil))
+	}
+
+	if h.partLen > 0 || h.nParts == 0 {
+		h.endPart()
+	}
+
+	digest := md5.Sum(h.partSums)
+	return hex.EncodeToString(digest[:]) + "-" + strconv.Itoa(h.nParts)
+}
+
+// uploadETagPartSize returns the part size of the ETag S3 gives an object when this program
+// uploads a file of size bytes to it: zero if the file is uploaded with a single request, because
+// it's below the multipart threshold or fits in one part, and otherwise the part size
+// multipartLayout picks.
+func (stc *Cloner) uploadETagPartSize(size int64) int64 {
+	if size < stc.multipartThreshold {
+		return 0
+	}
+
+	if partSize, _ := stc.multipartLayout(size); size > partSize {
+		return partSize
+	}
+
+	return 0
+}
+
+// fileETag returns the ETag S3 gives the content of r when it's stored uncompressed, unencrypted or
+// with SSE-S3, after being uploaded in parts of partSize bytes, or with a single request if
+// partSize is zero.
+func (stc *Cloner) fileETag(r io.Reader, partSize int64) (string, error) {
+	buffer := stc.readBuffers.Get().(*[]byte)
+	defer stc.readBuffers.Put(buffer)
+
+	h := newETagHash(partSize)
+	if _, err := io.CopyBuffer(h, r, *buffer); err != nil {
+		return "", err
+	}
+
+	return h.ETag(), nil
+}
+
+// addETagMetadata stores the ETag of the file read from r, as it's about to be uploaded, in its
+// metadata for -etag-metadata, then seeks r back to the start so it can be uploaded.
+func (stc *Cloner) addETagMetadata(metadata map[string]string, r io.ReadSeeker, size int64) error {
+	etag, err := stc.fileETag(r, stc.uploadETagPartSize(size))
+	if err != nil {
+		return err
+	}
+
+	metadata[etagMetadataKey] = etag
+	_, err = r.Seek(0, io.SeekStart)
+	return err
+}
+
+// copiedETag returns the ETag of an object CopyObject writes from the object of a file for
+// -etag-metadata. CopyObject writes a single part whatever the parts of the source were, so this is
+// the MD5 sum of the file, taken from its hashes if they include it.
+func (stc *Cloner) copiedETag(pathname string, hashes *Hashes) (string, error) {
+	if hashes != nil && hashes.MD5 != nil {
+		return hex.EncodeToString(hashes.MD5), nil
+	}
+
+	fd, err := os.Open(pathname)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	return stc.fileETag(fd, 0)
+}
@@ -0,0 +1,281 @@
+package s3treeclone
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestETagIsMD5(t *testing.T) {
+	const etag = "\"5eb63bbbe01eeed093cb22bb8f5acdc3\""
+
+	for _, tc := range []struct {
+		name     string
+		hoo      s3.HeadObjectOutput
+		expected bool
+	}{
+		{"unencrypted", s3.HeadObjectOutput{ETag: aws.String(etag)}, true},
+		{"SSE-S3", s3.HeadObjectOutput{ETag: aws.String(etag), ServerSideEncryption: s3Types.ServerSideEncryptionAes256}, true},
+		{"SSE-KMS", s3.HeadObjectOutput{ETag: aws.String(etag), ServerSideEncryption: s3Types.ServerSideEncryptionAwsKms}, false},
+		{"SSE-C", s3.HeadObjectOutput{ETag: aws.String(etag), SSECustomerAlgorithm: aws.String("AES256")}, false},
+		{"multipart ETag", s3.HeadObjectOutput{ETag: aws.String("\"5eb63bbbe01eeed093cb22bb8f5acdc3-2\"")}, false},
+		{"multiple parts", s3.HeadObjectOutput{ETag: aws.String(etag), PartsCount: 2}, false},
+		{"compressed", s3.HeadObjectOutput{ETag: aws.String(etag), ContentEncoding: aws.String(contentEncodingGzip)}, false},
+		{"not an MD5 sum", s3.HeadObjectOutput{ETag: aws.String("\"hello\"")}, false},
+		{"missing", s3.HeadObjectOutput{}, false},
+	} {
+		if result := etagIsMD5(&tc.hoo); result != tc.expected {
+			t.Errorf("etagIsMD5 of %s object: expected %v, got %v", tc.name, tc.expected, result)
+		}
+	}
+}
+
+func TestTrustETag(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-trust-etag-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	var mutex sync.Mutex
+	var hashed []string
+	origHashFile := hashFile
+	hashFile = func(r io.Reader, algorithms []string, buffer []byte) (*Hashes, error) {
+		mutex.Lock()
+		hashed = append(hashed, strings.Join(algorithms, ","))
+		mutex.Unlock()
+		return origHashFile(r, algorithms, buffer)
+	}
+	defer func() { hashFile = origHashFile }()
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-trust-etag", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	// The ETag is trusted over the hash metadata, and only the MD5 sum is computed.
+	object := bucket.Objects["hello.txt"]
+	object.Metadata["sha512"] = strings.Repeat("0", 128)
+	hashed = nil
+	runExpect(t, args, client, 0, nil, []byte("Objects skipped:     1"))
+	if strings.Join(hashed, ";") != "md5" {
+		t.Errorf("Expected only the MD5 sum to be computed: %#v", hashed)
+	}
+
+	// A trusted ETag that doesn't match means the file changed.
+	object.ETag = aws.String("\"00000000000000000000000000000000\"")
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	// A multipart ETag isn't trusted, so the hashes are compared instead.
+	object = bucket.Objects["hello.txt"]
+	object.ETag = aws.String("\"00000000000000000000000000000000-2\"")
+	hashed = nil
+	runExpect(t, args, client, 0, nil, []byte("Objects skipped:     1"))
+	if strings.Join(hashed, ";") != strings.Join(HashAlgorithms, ",") {
+		t.Errorf("Expected every hash to be computed: %#v", hashed)
+	}
+}
+
+func TestETagHash(t *testing.T) {
+	content := make([]byte, 12<<20)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	// S3's multipart ETag: the MD5 sum of the MD5 sums of the parts, and the number of parts.
+	var partSums []byte
+	for offset := 0; offset < len(content); offset += 5 << 20 {
+		end := offset + 5<<20
+		if end > len(content) {
+			end = len(content)
+		}
+
+		sum := md5.Sum(content[offset:end])
+		partSums = append(partSums, sum[:]...)
+	}
+
+	digest := md5.Sum(partSums)
+	multipartETag := hex.EncodeToString(digest[:]) + "-3"
+	if multipartETag != "7df28755d1a6cc911533a3b50170cf7d-3" {
+		t.Errorf("Unexpected multipart ETag of the test content: %s", multipartETag)
+	}
+
+	digest = md5.Sum(partSums[:2*md5.Size])
+	exactPartsETag := hex.EncodeToString(digest[:]) + "-2"
+
+	for _, tc := range []struct {
+		name      string
+		content   []byte
+		partSize  int64
+		chunkSize int
+		expected  string
+	}{
+		{"single part", []byte("Hello world"), 0, 4, "3e25960a79dbc69b674cd4ec67a72c62"},
+		{"multipart", content, 5 << 20, 1 << 20, multipartETag},
+		{"chunks across parts", content, 5 << 20, 3<<20 + 1, multipartETag},
+		{"exact parts", content[:10<<20], 5 << 20, 1 << 20, exactPartsETag},
+	} {
+		h := newETagHash(tc.partSize)
+		for offset := 0; offset < len(tc.content); offset += tc.chunkSize {
+			end := offset + tc.chunkSize
+			if end > len(tc.content) {
+				end = len(tc.content)
+			}
+
+			h.Write(tc.content[offset:end])
+		}
+
+		if etag := h.ETag(); etag != tc.expected {
+			t.Errorf("%s: expected ETag %s, got %s", tc.name, tc.expected, etag)
+		}
+	}
+}
+
+// etagClient stores the objects of multipart uploads, which s3TestClient doesn't, with the ETag S3
+// computes from the parts uploaded.
+type etagClient struct {
+	*s3TestClient
+	mutex   sync.Mutex
+	uploads map[string]*s3.CreateMultipartUploadInput
+	parts   map[string]map[int32][]byte
+}
+
+func (c *etagClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	c.mutex.Lock()
+	key := aws.ToString(input.Key)
+	c.uploads[key] = input
+	c.parts[key] = make(map[int32][]byte)
+	c.mutex.Unlock()
+
+	return c.s3TestClient.CreateMultipartUpload(ctx, input, opts...)
+}
+
+func (c *etagClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.parts[aws.ToString(input.Key)][input.PartNumber] = body
+	c.mutex.Unlock()
+
+	return c.s3TestClient.UploadPart(ctx, input, opts...)
+}
+
+func (c *etagClient) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	key := aws.ToString(input.Key)
+	c.mutex.Lock()
+	upload, parts := c.uploads[key], c.parts[key]
+	c.mutex.Unlock()
+
+	var body, partSums []byte
+	for partNumber := int32(1); partNumber <= int32(len(parts)); partNumber++ {
+		body = append(body, parts[partNumber]...)
+		sum := md5.Sum(parts[partNumber])
+		partSums = append(partSums, sum[:]...)
+	}
+
+	digest := md5.Sum(partSums)
+	bucket := c.Buckets[aws.ToString(input.Bucket)]
+	bucket.Mutex.Lock()
+	bucket.Objects[key] = &s3TestObject{
+		Body:          body,
+		ContentLength: int64(len(body)),
+		ContentType:   upload.ContentType,
+		ETag:          aws.String(fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(digest[:]), len(parts))),
+		LastModified:  aws.Time(time.Now().UTC()),
+		Metadata:      upload.Metadata,
+		PartsCount:    int32(len(parts)),
+		StorageClass:  upload.StorageClass,
+	}
+	bucket.Mutex.Unlock()
+
+	return c.s3TestClient.CompleteMultipartUpload(ctx, input, opts...)
+}
+
+func TestETagMetadata(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-etag-metadata-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	content := make([]byte, 12<<20)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	for filename, data := range map[string][]byte{"large.bin": content, "part.bin": content[:5<<20], "hello.txt": []byte("Hello world")} {
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, data, 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	if err = os.Symlink("hello.txt", tmpDir+"/link"); err != nil {
+		t.Fatalf("Failed to create symlink %s/link: %v", tmpDir, err)
+	}
+
+	client := &etagClient{s3TestClient: newS3TestClient(), uploads: make(map[string]*s3.CreateMultipartUploadInput), parts: make(map[string]map[int32][]byte)}
+	bucket := client.createBucket("hello")
+	args := []string{"-etag-metadata", "-links", "store", "-multipart-threshold", "5MiB", "-multipart-part-size", "5MiB", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    4"))
+
+	// The large file is uploaded in three parts, and its file-etag matches the ETag S3 computes
+	// from them. A file that fits in a single part is uploaded with one request.
+	if bucket.Objects["large.bin"].PartsCount != 3 || bucket.Objects["part.bin"].PartsCount != 0 {
+		t.Fatalf("Expected large.bin to be uploaded in 3 parts and part.bin in one request: %d, %d", bucket.Objects["large.bin"].PartsCount, bucket.Objects["part.bin"].PartsCount)
+	}
+
+	if etag := bucket.Objects["large.bin"].Metadata[etagMetadataKey]; etag != "7df28755d1a6cc911533a3b50170cf7d-3" {
+		t.Errorf("Expected file-etag 7df28755d1a6cc911533a3b50170cf7d-3 for large.bin, got %s", etag)
+	}
+
+	for _, key := range []string{"large.bin", "part.bin", "hello.txt"} {
+		object := bucket.Objects[key]
+		if etag := object.Metadata[etagMetadataKey]; etag != strings.Trim(aws.ToString(object.ETag), "\"") {
+			t.Errorf("Expected file-etag %s for %s, got %s", aws.ToString(object.ETag), key, etag)
+		}
+	}
+
+	if etag, found := bucket.Objects["link"].Metadata[etagMetadataKey]; found {
+		t.Errorf("Expected no file-etag for the symbolic link: %s", etag)
+	}
+
+	// Objects uploaded without it have their metadata updated to add it.
+	delete(bucket.Objects["hello.txt"].Metadata, etagMetadataKey)
+	runExpect(t, args, client, 0, nil, []byte("No file-etag specified for s3://hello/hello.txt; will resync"))
+
+	if etag := bucket.Objects["hello.txt"].Metadata[etagMetadataKey]; etag != "3e25960a79dbc69b674cd4ec67a72c62" {
+		t.Errorf("Expected the file-etag of hello.txt to be added, got %q", etag)
+	}
+
+	// Updating only the metadata of the multipart object copies it onto itself as a single part,
+	// so the file-etag becomes the MD5 sum of the file, like the ETag of the copy.
+	if err = os.Chmod(tmpDir+"/large.bin", 0600); err != nil {
+		t.Fatalf("Failed to chmod %s/large.bin: %v", tmpDir, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Updated the metadata of s3://hello/large.bin"))
+
+	object := bucket.Objects["large.bin"]
+	if etag, expected := object.Metadata[etagMetadataKey], fmt.Sprintf("%x", md5.Sum(content)); etag != expected || etag != strings.Trim(aws.ToString(object.ETag), "\"") {
+		t.Errorf("Expected file-etag %s matching the ETag %s of the copy of large.bin, got %s", expected, aws.ToString(object.ETag), etag)
+	}
+}
@@ -0,0 +1,106 @@
+package s3treeclone
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// readExcludeFile reads newline-separated exclude patterns from the given file. Blank lines and
+// lines starting with "#" are ignored, as is whitespace surrounding each pattern.
+func readExcludeFile(filename string) ([]string, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, scanner.Err()
+}
+
+// matchExcludePattern reports whether the slash-separated relative path name matches the shell-style
+// glob pattern. A pattern without a slash is matched against the last component of name, so "*.tmp"
+// excludes matching files at any depth. Otherwise the pattern is matched against the whole path, and
+// a "**" component matches any number of path components.
+func matchExcludePattern(pattern, name string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(name))
+		return matched
+	}
+
+	return matchComponents(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+// matchComponents matches path components against pattern components, where a "**" pattern
+// component matches zero or more path components.
+func matchComponents(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			// Try consuming 0, 1, ... components of the name.
+			for i := 0; i <= len(names); i++ {
+				if matchComponents(patterns[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(names) == 0 {
+			return false
+		}
+
+		if matched, _ := path.Match(patterns[0], names[0]); !matched {
+			return false
+		}
+
+		patterns = patterns[1:]
+		names = names[1:]
+	}
+
+	return len(names) == 0
+}
+
+// keyExcluded reports whether the object with the given key is for an excluded path: one that
+// matches an -exclude pattern or is under a directory that does.
+func (stc *Cloner) keyExcluded(key string) bool {
+	relPath := strings.TrimSuffix(strings.TrimPrefix(key, stc.prefix), "/")
+	components := strings.Split(relPath, "/")
+	for i := range components {
+		if stc.isExcluded(strings.Join(components[:i+1], "/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isExcluded reports whether the given path, relative to the base directory, matches any of the
+// -exclude patterns. A directory matching "dir/**" is itself excluded so the walk never descends
+// into it.
+func (stc *Cloner) isExcluded(relPath string) bool {
+	for _, pattern := range stc.excludes {
+		if matchExcludePattern(pattern, relPath) {
+			return true
+		}
+
+		if strings.HasSuffix(pattern, "/**") && matchExcludePattern(strings.TrimSuffix(pattern, "/**"), relPath) {
+			return true
+		}
+	}
+
+	return false
+}
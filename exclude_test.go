@@ -0,0 +1,171 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMatchExcludePattern(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"*.tmp", "a.tmp", true},
+		{"*.tmp", "d1/d2/a.tmp", true},
+		{"*.tmp", "a.txt", false},
+		{"node_modules", "web/node_modules", true},
+		{"node_modules/**", "node_modules/a/b.js", true},
+		{"node_modules/**", "web/node_modules/a.js", false},
+		{"**/node_modules/**", "web/node_modules/a.js", true},
+		{"d1/*.log", "d1/a.log", true},
+		{"d1/*.log", "d1/d2/a.log", false},
+		{"d1/**/*.log", "d1/a.log", true},
+		{"d1/**/*.log", "d1/d2/d3/a.log", true},
+		{"/d1/cache/", "d1/cache", true},
+	}
+
+	for _, c := range cases {
+		if result := matchExcludePattern(c.pattern, c.name); result != c.expected {
+			t.Errorf("matchExcludePattern(%#v, %#v): expected %v, got %v", c.pattern, c.name, c.expected, result)
+		}
+	}
+}
+
+func TestExclude(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-exclude-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"node_modules/pkg", "src/cache"} {
+		err = os.MkdirAll(tmpDir+"/"+dir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create %s/%s: %v", tmpDir, dir, err)
+		}
+	}
+
+	for _, filename := range []string{"keep.txt", "scratch.tmp", "node_modules/pkg/index.js", "src/main.go", "src/main.tmp", "src/cache/blob"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-verbose", "-exclude", "*.tmp", "-exclude", "node_modules/**", "-exclude", "src/cache", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, []byte("Excluding "+tmpDir+"/node_modules\n"), nil)
+
+	for _, key := range []string{"keep.txt", "src/", "src/main.go"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be uploaded", key)
+		}
+	}
+
+	for _, key := range []string{"scratch.tmp", "src/main.tmp", "node_modules/", "node_modules/pkg/", "node_modules/pkg/index.js", "src/cache/", "src/cache/blob"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be excluded", key)
+		}
+	}
+}
+
+func TestExcludeFrom(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-exclude-from-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	for _, dir := range []string{"node_modules/pkg", "build"} {
+		err = os.MkdirAll(srcDir+"/"+dir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create %s/%s: %v", srcDir, dir, err)
+		}
+	}
+
+	for _, filename := range []string{"keep.txt", "scratch.tmp", "debug.log", "node_modules/pkg/index.js", "build/out.o"} {
+		err = ioutil.WriteFile(srcDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", srcDir, filename, err)
+		}
+	}
+
+	patternFile := tmpDir + "/excludes"
+	err = ioutil.WriteFile(patternFile, []byte("# Editor and build droppings\n*.tmp\n\n  node_modules/**  \n#build\n\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", patternFile, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-verbose", "-exclude", "*.log", "-exclude-from", patternFile, srcDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, []byte("Excluding "+srcDir+"/node_modules\n"), nil)
+
+	for _, key := range []string{"keep.txt", "build/", "build/out.o"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be uploaded", key)
+		}
+	}
+
+	for _, key := range []string{"scratch.tmp", "debug.log", "node_modules/", "node_modules/pkg/", "node_modules/pkg/index.js"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be excluded", key)
+		}
+	}
+
+	args = []string{"-exclude-from", tmpDir + "/missing", srcDir + "/", "s3://hello"}
+	runExpect(t, args, client, 1, nil, []byte("Unable to read exclude file "+tmpDir+"/missing"))
+}
+
+func TestDeleteExcluded(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-delete-excluded-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"keep.txt", "scratch.tmp"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    2"))
+	bucket.Objects["cache/"] = &s3TestObject{}
+	bucket.Objects["cache/blob"] = &s3TestObject{ContentLength: 5}
+	bucket.Objects["gone.txt"] = &s3TestObject{ContentLength: 5}
+
+	// By default, excluded objects are kept; only those for files that don't exist are deleted.
+	args := []string{"-delete", "-exclude", "*.tmp", "-exclude", "cache", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects deleted:     1"))
+	for _, key := range []string{"keep.txt", "scratch.tmp", "cache/", "cache/blob"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+
+	if _, found := bucket.Objects["gone.txt"]; found {
+		t.Errorf("Expected gone.txt to be deleted")
+	}
+
+	// With -delete-excluded, they're deleted too.
+	runExpect(t, append([]string{"-delete-excluded"}, args...), client, 0, nil, []byte("Objects deleted:     3"))
+	for _, key := range []string{"scratch.tmp", "cache/", "cache/blob"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+
+	if _, found := bucket.Objects["keep.txt"]; !found {
+		t.Errorf("Expected keep.txt to be kept")
+	}
+
+	runExpect(t, []string{"-delete-excluded", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("-delete-excluded requires -delete"))
+}
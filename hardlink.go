@@ -0,0 +1,180 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// inode identifies a file by its device and inode number, so hard links to it can be recognized.
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// restoreLink is a hard link that is created once all objects have been restored, since the file
+// it refers to may not have been restored yet.
+type restoreLink struct {
+	key      string
+	pathname string
+	target   string
+}
+
+// hardLinkTarget returns the key of the link stored with the content of this file's inode, or ""
+// if this is that link (or the file has no other links). That's the link with the lexically
+// smallest key, so it doesn't depend on the order the walk workers reach the links in and stays
+// the same from run to run. Until the walk has found every link, a file with other links is set
+// aside to be examined again afterwards, and deferred is true.
+func (stc *Cloner) hardLinkTarget(stat *fileStat, key string, job walkJob) (target string, deferred bool) {
+	if stat.Nlink < 2 {
+		return "", false
+	}
+
+	id := inode{dev: stat.Dev, ino: stat.Ino}
+
+	stc.hardLinkMutex.Lock()
+	defer stc.hardLinkMutex.Unlock()
+
+	if !stc.hardLinksResolved {
+		if first, found := stc.hardLinkKeys[id]; !found || key < first {
+			stc.hardLinkKeys[id] = key
+		}
+
+		stc.hardLinkJobs = append(stc.hardLinkJobs, job)
+		return "", true
+	}
+
+	// A file without an entry, such as one that gained links after it was examined, is stored
+	// with its content.
+	if first, found := stc.hardLinkKeys[id]; found && first != key {
+		return first, false
+	}
+
+	return "", false
+}
+
+// resolveHardLinks is called once the walk has found every link, and returns the files set aside
+// by hardLinkTarget to be examined again.
+func (stc *Cloner) resolveHardLinks() []walkJob {
+	stc.hardLinkMutex.Lock()
+	defer stc.hardLinkMutex.Unlock()
+
+	stc.hardLinksResolved = true
+	jobs := stc.hardLinkJobs
+	stc.hardLinkJobs = nil
+
+	// The files are examined again, but were already counted.
+	atomic.AddInt64(&stc.nObjects, -int64(len(jobs)))
+	return jobs
+}
+
+// UploadHardLink creates an empty object in S3 with the given key for a hard link to a file that
+// was uploaded under another key, which is recorded in the file-hardlink-target metadata. The
+// hashes of the file's content are still stored, unless it's below -hash-min-size, so the object
+// can be compared.
+func (stc *Cloner) UploadHardLink(pathname, key string, stat *fileStat, target string, hashes *Hashes) {
+	if stc.dryRun {
+		stc.printf(stc.stdout, levelInfo, "Would upload %s to s3://%s/%s\n", pathname, stc.bucket, key)
+		stc.planUpload(planActionUpload, pathname, key, 0, stc.storageClass)
+		return
+	}
+
+	hashed := stc.hashesFile(stat)
+	if hashes == nil && hashed {
+		hashes = stc.cachedFileHashes(pathname, stat)
+	}
+
+	if hashes == nil && hashed {
+		fd, err := os.Open(pathname)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to open %s: %v\n", pathname, err)
+			return
+		}
+
+		hashes, err = stc.fileHashes(pathname, stat, fd)
+		fd.Close()
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to get hashes of %s: %v\n", pathname, err)
+			return
+		}
+	}
+
+	mtypeStr := "application/octet-stream"
+	metadata := stc.fileMetadata(stat)
+	metadata["file-hardlink-target"] = target
+	if hashes != nil {
+		for _, algorithm := range stc.hashAlgorithms {
+			metadata[algorithm] = hex.EncodeToString(hashes.get(algorithm))
+		}
+	}
+
+	err := stc.sem.Acquire(stc.ctx, 1)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to acquire S3 semaphore: %v\n", err)
+		return
+	}
+
+	poi := &s3.PutObjectInput{
+		Bucket:       &stc.bucket,
+		Key:          &key,
+		Body:         stc.throttle(&bytes.Reader{}),
+		ContentType:  &mtypeStr,
+		Metadata:     metadata,
+		StorageClass: stc.storageClass,
+		ACL:          stc.acl,
+		RequestPayer: stc.requestPayer,
+	}
+
+	poi.Tagging = stc.objectTagging(metadata)
+	stc.setPutObjectHeaders(poi)
+	stc.setPutObjectPolicy(poi)
+	stc.setPutObjectEncryption(poi)
+	stc.setPutObjectLock(poi)
+
+	_, err = stc.s3Client.PutObject(stc.ctx, poi)
+	stc.sem.Release(1)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to upload %s: %v\n", pathname, err)
+		return
+	}
+
+	stc.logf(stc.stderr, levelInfo, logEvent{Event: eventUploaded, Path: pathname, Key: key, Reason: "hard link", Bytes: aws.Int64(0)}, "Uploaded %s to s3://%s/%s as a hard link to s3://%s/%s\n", pathname, stc.bucket, key, stc.bucket, target)
+	atomic.AddInt64(&stc.nUploaded, 1)
+
+	if stc.verifyAfterUpload {
+		stc.VerifyUpload(pathname, key, stat, false)
+	}
+}
+
+// queueRestoreLink notes a hard link to create once all objects have been restored.
+func (stc *Cloner) queueRestoreLink(key, pathname, target string) {
+	stc.hardLinkMutex.Lock()
+	stc.restoreLinks = append(stc.restoreLinks, restoreLink{key: key, pathname: pathname, target: target})
+	stc.hardLinkMutex.Unlock()
+}
+
+// restoreHardLinks creates the hard links recorded while restoring, linking each to the restored
+// file for its target key.
+func (stc *Cloner) restoreHardLinks(destDir string) {
+	for _, link := range stc.restoreLinks {
+		if !strings.HasPrefix(link.target, stc.prefix) || strings.Contains("/"+strings.TrimPrefix(link.target, stc.prefix)+"/", "/../") {
+			stc.fail(logEvent{Path: link.pathname, Key: link.key, Reason: "hard link target outside the destination"}, "Unable to restore hard link %s: target s3://%s/%s is outside the destination\n", link.pathname, stc.bucket, link.target)
+			continue
+		}
+
+		targetPath := filepath.Join(destDir, filepath.FromSlash(strings.TrimPrefix(link.target, stc.prefix)))
+		os.Remove(link.pathname)
+		if err := os.Link(targetPath, link.pathname); err != nil {
+			stc.fail(logEvent{Path: link.pathname, Key: link.key, Error: err.Error()}, "Unable to create hard link %s to %s: %v\n", link.pathname, targetPath, err)
+			continue
+		}
+
+		stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventRestored, Path: link.pathname, Key: link.key}, "Restored s3://%s/%s to %s as a hard link to %s\n", stc.bucket, link.key, link.pathname, targetPath)
+	}
+}
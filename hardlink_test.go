@@ -0,0 +1,173 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestHardLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Hard links are not detected on Windows")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "test-hard-links-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.MkdirAll(srcDir+"/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/sub: %v", srcDir, err)
+	}
+
+	content := []byte("hello, hard links")
+	err = ioutil.WriteFile(srcDir+"/a.txt", content, 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/a.txt: %v", srcDir, err)
+	}
+
+	for _, name := range []string{"b.txt", "sub/c.txt"} {
+		if err = os.Link(srcDir+"/a.txt", srcDir+"/"+name); err != nil {
+			t.Fatalf("Failed to link %s/%s: %v", srcDir, name, err)
+		}
+	}
+
+	// The link with the smallest key is uploaded; the others refer to it.
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	args := []string{"-hard-links", srcDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    3"))
+
+	first := "a.txt"
+	bodies := 0
+	for _, input := range client.putInputs {
+		if *input.Key != "sub/" && input.Metadata["file-hardlink-target"] == "" {
+			bodies++
+		}
+	}
+
+	if bodies != 1 {
+		t.Fatalf("Expected one body to be uploaded for the hard links, got %d", bodies)
+	}
+
+	for _, key := range []string{"a.txt", "b.txt", "sub/c.txt"} {
+		object := bucket.Objects[key]
+		if key == first {
+			if !bytes.Equal(object.Body, content) {
+				t.Errorf("Expected %s to have the file's content, got %#v", key, string(object.Body))
+			}
+			continue
+		}
+
+		if len(object.Body) != 0 || object.Metadata["file-hardlink-target"] != first {
+			t.Errorf("Expected %s to be an empty hard link to %s, got %d bytes and metadata %v", key, first, len(object.Body), object.Metadata)
+		}
+
+		if object.Metadata["sha256"] != bucket.Objects[first].Metadata["sha256"] {
+			t.Errorf("Expected %s to have the same hashes as %s", key, first)
+		}
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// Restoring recreates the hard links.
+	runExpect(t, []string{"-restore", "s3://hello", tmpDir + "/dest"}, client, 0, nil, nil)
+
+	firstInfo, err := os.Stat(tmpDir + "/dest/" + first)
+	if err != nil {
+		t.Fatalf("Failed to stat %s/dest/%s: %v", tmpDir, first, err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt", "sub/c.txt"} {
+		info, err := os.Stat(tmpDir + "/dest/" + name)
+		if err != nil {
+			t.Errorf("Failed to stat %s/dest/%s: %v", tmpDir, name, err)
+		} else if !os.SameFile(firstInfo, info) {
+			t.Errorf("Expected %s/dest/%s to be a hard link to %s", tmpDir, name, first)
+		}
+	}
+
+	restored, err := ioutil.ReadFile(tmpDir + "/dest/b.txt")
+	if err != nil || !bytes.Equal(restored, content) {
+		t.Errorf("Expected restored b.txt to have the file's content, got %#v: %v", string(restored), err)
+	}
+
+	// Without -hard-links, each link is uploaded in full; the earlier references are replaced.
+	runExpect(t, []string{srcDir + "/", "s3://hello"}, client, 0, nil, []byte("Hard link target mismatch"))
+
+	for _, key := range []string{"a.txt", "b.txt", "sub/c.txt"} {
+		if object := bucket.Objects[key]; !bytes.Equal(object.Body, content) || object.Metadata["file-hardlink-target"] != "" {
+			t.Errorf("Expected %s to be uploaded in full without -hard-links", key)
+		}
+	}
+}
+
+func TestHardLinksWalkOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Hard links are not detected on Windows")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "test-hard-links-walk-order-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"d1", "d1/d2", "d3", "d4"} {
+		if err = os.Mkdir(tmpDir+"/"+dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory %s/%s: %v", tmpDir, dir, err)
+		}
+	}
+
+	// Each file has links spread over several directories, so the walk workers reach them in an
+	// order that changes from run to run.
+	links := map[string][]string{
+		"d1/d2/x.txt": {"d4/x.txt", "d3/x.txt", "x.txt"},
+		"d4/y.txt":    {"d1/y.txt", "d3/d.txt", "d1/d2/y.txt"},
+		"d3/z.txt":    {"d4/a.txt", "d1/d2/z.txt"},
+	}
+
+	for name, others := range links {
+		if err = ioutil.WriteFile(tmpDir+"/"+name, []byte("Hello "+name), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, name, err)
+		}
+
+		for _, other := range others {
+			if err = os.Link(tmpDir+"/"+name, tmpDir+"/"+other); err != nil {
+				t.Fatalf("Failed to link %s/%s: %v", tmpDir, other, err)
+			}
+		}
+	}
+
+	expected := map[string]string{"d1/d2/x.txt": "d1/d2/x.txt", "d4/y.txt": "d1/d2/y.txt", "d3/z.txt": "d1/d2/z.txt"}
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-hard-links", "-walk-workers", "8", tmpDir + "/", "s3://hello"}
+	for run := 0; run < 5; run++ {
+		if run == 0 {
+			runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    11\n"))
+		} else {
+			// Nothing changed, so nothing is uploaded again.
+			runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0\n"))
+		}
+
+		for name, others := range links {
+			target := expected[name]
+			for _, key := range append([]string{name}, others...) {
+				object := bucket.Objects[key]
+				if key == target {
+					if object.Metadata["file-hardlink-target"] != "" || len(object.Body) == 0 {
+						t.Errorf("Run %d: expected %s to be uploaded with its content", run, key)
+					}
+				} else if object.Metadata["file-hardlink-target"] != target {
+					t.Errorf("Run %d: expected %s to be a hard link to %s, got %q", run, key, target, object.Metadata["file-hardlink-target"])
+				}
+			}
+		}
+	}
+}
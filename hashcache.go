@@ -0,0 +1,313 @@
+package s3treeclone
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hashFile computes the requested hashes of a file's contents. It is a variable so tests can count
+// calls.
+var hashFile = getFileHashes
+
+// hashCacheEntry holds the hashes of a file as of the given size and modification time. With
+// -trust-mtime, it also records the object the file was last found to match.
+type hashCacheEntry struct {
+	Size   int64         `json:"size"`
+	Mtime  int64         `json:"mtime"`
+	MD5    string        `json:"md5"`
+	SHA1   string        `json:"sha1"`
+	SHA256 string        `json:"sha256"`
+	SHA512 string        `json:"sha512"`
+	Synced *syncedObject `json:"synced,omitempty"`
+}
+
+// syncedObject records that a file matched an object, along with the parts of the file's status
+// that are stored in the object's metadata, as of the file's size and modification time in its
+// hash cache entry.
+type syncedObject struct {
+	URL          string `json:"url"`
+	Ctime        int64  `json:"ctime"`
+	Mode         uint32 `json:"mode"`
+	UID          uint32 `json:"uid"`
+	GID          uint32 `json:"gid"`
+	LastModified int64  `json:"last_modified"` // Nanoseconds since the Unix epoch
+}
+
+// hashCache is a flat-file store of file hashes keyed by absolute path. An entry is only used if
+// the file's size and modification time haven't changed since it was written.
+type hashCache struct {
+	filename string
+	mutex    sync.Mutex
+	entries  map[string]hashCacheEntry
+	dirty    bool
+}
+
+// loadHashCache reads the hash cache from the given file. A missing file is treated as an empty
+// cache.
+func loadHashCache(filename string) (*hashCache, error) {
+	hc := &hashCache{filename: filename, entries: make(map[string]hashCacheEntry)}
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return hc, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &hc.entries); err != nil {
+		return nil, err
+	}
+
+	return hc, nil
+}
+
+// Get returns the cached hashes for the file, or nil if there are none or the file has changed.
+func (hc *hashCache) Get(pathname string, stat *fileStat) *Hashes {
+	absPath, err := filepath.Abs(pathname)
+	if err != nil {
+		return nil
+	}
+
+	hc.mutex.Lock()
+	entry, found := hc.entries[absPath]
+	hc.mutex.Unlock()
+
+	if !found || entry.Size != stat.Size || entry.Mtime != stat.Mtime {
+		return nil
+	}
+
+	// Hashes that weren't computed are stored as empty strings and left nil.
+	hashes := &Hashes{}
+	for _, h := range []struct {
+		hex  string
+		dest *[]byte
+	}{{entry.MD5, &hashes.MD5}, {entry.SHA1, &hashes.SHA1}, {entry.SHA256, &hashes.SHA256}, {entry.SHA512, &hashes.SHA512}} {
+		if h.hex == "" {
+			continue
+		}
+
+		*h.dest, err = hex.DecodeString(h.hex)
+		if err != nil {
+			return nil
+		}
+	}
+
+	return hashes
+}
+
+// Put records the hashes for the file, replacing any previous entry.
+func (hc *hashCache) Put(pathname string, stat *fileStat, hashes *Hashes) {
+	absPath, err := filepath.Abs(pathname)
+	if err != nil {
+		return
+	}
+
+	hc.mutex.Lock()
+	hc.entries[absPath] = hashCacheEntry{
+		Size:   stat.Size,
+		Mtime:  stat.Mtime,
+		MD5:    hex.EncodeToString(hashes.MD5),
+		SHA1:   hex.EncodeToString(hashes.SHA1),
+		SHA256: hex.EncodeToString(hashes.SHA256),
+		SHA512: hex.EncodeToString(hashes.SHA512),
+	}
+	hc.dirty = true
+	hc.mutex.Unlock()
+}
+
+// Synced returns the time the object at url was last found to match the file, if that was recorded
+// and the file hasn't changed since.
+func (hc *hashCache) Synced(pathname string, stat *fileStat, url string) (int64, bool) {
+	absPath, err := filepath.Abs(pathname)
+	if err != nil {
+		return 0, false
+	}
+
+	hc.mutex.Lock()
+	entry, found := hc.entries[absPath]
+	hc.mutex.Unlock()
+
+	if !found || entry.Synced == nil || entry.Size != stat.Size || entry.Mtime != stat.Mtime {
+		return 0, false
+	}
+
+	synced := entry.Synced
+	if synced.URL != url || synced.Ctime != stat.Ctime || synced.Mode != stat.Mode || synced.UID != stat.Uid || synced.GID != stat.Gid {
+		return 0, false
+	}
+
+	return synced.LastModified, true
+}
+
+// PutSynced records that the object at url matched the file as of lastModified. Any hashes already
+// cached for the file are kept if it hasn't changed.
+func (hc *hashCache) PutSynced(pathname string, stat *fileStat, url string, lastModified time.Time) {
+	absPath, err := filepath.Abs(pathname)
+	if err != nil {
+		return
+	}
+
+	hc.mutex.Lock()
+	entry, found := hc.entries[absPath]
+	if !found || entry.Size != stat.Size || entry.Mtime != stat.Mtime {
+		entry = hashCacheEntry{Size: stat.Size, Mtime: stat.Mtime}
+	}
+
+	entry.Synced = &syncedObject{
+		URL:          url,
+		Ctime:        stat.Ctime,
+		Mode:         stat.Mode,
+		UID:          stat.Uid,
+		GID:          stat.Gid,
+		LastModified: lastModified.UnixNano(),
+	}
+	hc.entries[absPath] = entry
+	hc.dirty = true
+	hc.mutex.Unlock()
+}
+
+// Save writes the cache back to its file if it has changed. The file is replaced atomically so an
+// interrupted save doesn't corrupt the cache.
+func (hc *hashCache) Save() error {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	if !hc.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(hc.entries)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(hc.filename), filepath.Base(hc.filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(data)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), hc.filename)
+	}
+
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	hc.dirty = false
+	return nil
+}
+
+// newReadBufferPool returns a pool of buffers of the given size for reading files. The pool holds
+// pointers to slices so putting a buffer back doesn't allocate.
+func newReadBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			buffer := make([]byte, size)
+			return &buffer
+		},
+	}
+}
+
+// fileHashes returns the hashes of a file, using the hash cache if possible. Otherwise the hashes
+// are computed by reading r, which must contain the file's contents, and added to the cache.
+func (stc *Cloner) fileHashes(pathname string, stat *fileStat, r io.Reader) (*Hashes, error) {
+	if hashes := stc.cachedFileHashes(pathname, stat); hashes != nil {
+		return hashes, nil
+	}
+
+	hashes, err := stc.hashReader(r, stc.hashAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	if stc.hashCache != nil {
+		stc.hashCache.Put(pathname, stat, hashes)
+	}
+
+	return hashes, nil
+}
+
+// hashReader computes the requested hashes of r.
+func (stc *Cloner) hashReader(r io.Reader, algorithms []string) (*Hashes, error) {
+	// Buffers are pooled rather than allocated for each file to reduce GC pressure when many files
+	// are hashed concurrently.
+	buffer := stc.readBuffers.Get().(*[]byte)
+	defer stc.readBuffers.Put(buffer)
+	return hashFile(r, algorithms, *buffer)
+}
+
+// cachedFileHashes returns the cached hashes of a file without reading it, or nil if they aren't
+// available or don't include all of the hashes being computed.
+func (stc *Cloner) cachedFileHashes(pathname string, stat *fileStat) *Hashes {
+	if stc.hashCache == nil {
+		return nil
+	}
+
+	hashes := stc.hashCache.Get(pathname, stat)
+	if hashes == nil || !hashes.hasAll(stc.hashAlgorithms) {
+		return nil
+	}
+
+	return hashes
+}
+
+// saveHashCache writes the hash cache, if one is in use, logging any error.
+func (stc *Cloner) saveHashCache() {
+	if stc.hashCache == nil {
+		return
+	}
+
+	if err := stc.hashCache.Save(); err != nil {
+		stc.printf(stc.stderr, levelError, "Unable to save hash cache file %s: %v\n", stc.hashCachePath, err)
+	}
+}
+
+// syncedUnchanged determines whether, with -trust-mtime, the hash cache shows that the file matched
+// its object on an earlier run and hasn't changed since, so the object doesn't need to be checked.
+// If the object was prelisted and is missing, it isn't trusted.
+func (stc *Cloner) syncedUnchanged(pathname, key string, stat *fileStat) bool {
+	if !stc.trustMtime || stc.hashCache == nil {
+		return false
+	}
+
+	if stc.listing != nil {
+		if _, found := stc.listing[key]; !found {
+			return false
+		}
+	}
+
+	lastModified, found := stc.hashCache.Synced(pathname, stat, stc.objectURL(key))
+	return found && stat.Mtime <= lastModified
+}
+
+// recordSynced notes in the hash cache, with -trust-mtime, that the file matches its object as of
+// lastModified.
+func (stc *Cloner) recordSynced(pathname, key string, stat *fileStat, lastModified time.Time) {
+	if !stc.trustMtime || stc.hashCache == nil || stc.dryRun {
+		return
+	}
+
+	stc.hashCache.PutSynced(pathname, stat, stc.objectURL(key), lastModified)
+}
+
+// objectURL returns the S3 URL of the object with the given key.
+func (stc *Cloner) objectURL(key string) string {
+	return "s3://" + stc.bucket + "/" + key
+}
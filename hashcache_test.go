@@ -0,0 +1,111 @@
+package s3treeclone
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHashCacheInvalidation(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-hash-cache-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	hc, err := loadHashCache(tmpDir + "/cache.json")
+	if err != nil {
+		t.Fatalf("Failed to load missing hash cache: %v", err)
+	}
+
+	hashes := &Hashes{MD5: []byte{1}, SHA1: []byte{2}, SHA256: []byte{3}, SHA512: []byte{4}}
+	stat := &fileStat{Size: 5, Mtime: 1000}
+	hc.Put("hello.txt", stat, hashes)
+
+	if err = hc.Save(); err != nil {
+		t.Fatalf("Failed to save hash cache: %v", err)
+	}
+
+	hc, err = loadHashCache(tmpDir + "/cache.json")
+	if err != nil {
+		t.Fatalf("Failed to load hash cache: %v", err)
+	}
+
+	if cached := hc.Get("hello.txt", stat); cached == nil || cached.SHA512[0] != 4 {
+		t.Errorf("Expected cached hashes for hello.txt: %v", cached)
+	}
+
+	if cached := hc.Get("hello.txt", &fileStat{Size: 6, Mtime: 1000}); cached != nil {
+		t.Errorf("Expected a size change to invalidate the cache entry")
+	}
+
+	if cached := hc.Get("hello.txt", &fileStat{Size: 5, Mtime: 2000}); cached != nil {
+		t.Errorf("Expected an mtime change to invalidate the cache entry")
+	}
+
+	if cached := hc.Get("other.txt", stat); cached != nil {
+		t.Errorf("Expected no cache entry for other.txt")
+	}
+}
+
+func TestHashCacheAvoidsRehashing(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-hash-cache-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Mkdir(tmpDir+"/src", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/src: %v", tmpDir, err)
+	}
+
+	for _, filename := range []string{"a.txt", "b.txt"} {
+		err = ioutil.WriteFile(tmpDir+"/src/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/src/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	var nHashed int64
+	origHashFile := hashFile
+	hashFile = func(r io.Reader, algorithms []string, buffer []byte) (*Hashes, error) {
+		atomic.AddInt64(&nHashed, 1)
+		return origHashFile(r, algorithms, buffer)
+	}
+	defer func() { hashFile = origHashFile }()
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	args := []string{"-hash-cache", tmpDir + "/cache.json", tmpDir + "/src/", "s3://hello"}
+
+	runExpect(t, args, client, 0, nil, nil)
+	if n := atomic.LoadInt64(&nHashed); n != 2 {
+		t.Errorf("Expected 2 files to be hashed on the first run: %d", n)
+	}
+
+	// Nothing changed, so the hashes all come from the cache.
+	runExpect(t, args, client, 0, nil, nil)
+	if n := atomic.LoadInt64(&nHashed); n != 2 {
+		t.Errorf("Expected no files to be rehashed on the second run: %d", n-2)
+	}
+
+	// Only the changed file is rehashed.
+	err = ioutil.WriteFile(tmpDir+"/src/a.txt", []byte("hello, world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/src/a.txt: %v", tmpDir, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Uploaded "+tmpDir+"/src/a.txt"))
+	if n := atomic.LoadInt64(&nHashed); n != 3 {
+		t.Errorf("Expected 1 file to be rehashed on the third run: %d", n-2)
+	}
+
+	// Without the cache, everything is rehashed.
+	runExpect(t, args[2:], client, 0, nil, nil)
+	if n := atomic.LoadInt64(&nHashed); n != 5 {
+		t.Errorf("Expected 2 files to be rehashed without the cache: %d", n-3)
+	}
+}
@@ -0,0 +1,141 @@
+package s3treeclone
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fileJob is a regular file examined by a walk worker, on its way through the hash workers to the
+// upload workers.
+type fileJob struct {
+	walkJob
+	pathname       string
+	key            string
+	stat           *fileStat
+	hoo            *s3.HeadObjectOutput
+	hardLinkTarget string
+	exists         bool
+	sizeEqual      bool
+	metadataEqual  bool
+	contentEqual   bool
+	uploadRequired bool
+
+	// The hashes of the file, if they have been computed.
+	hashes *Hashes
+
+	// Whether the content was compared against the object rather than assumed to be the same.
+	compared bool
+}
+
+// hashWorker compares the content of files from the walk workers against their objects, and
+// hashes those about to be uploaded, then passes them on to the upload workers.
+func (stc *Cloner) hashWorker() {
+	defer stc.hashWaitGroup.Done()
+
+	for file := range stc.hashJobs {
+		if stc.ctx.Err() != nil {
+			stc.finishPending()
+		} else if stc.compareContent(file) {
+			stc.uploadJobs <- file
+		} else {
+			stc.finishJob(file.walkJob)
+		}
+	}
+}
+
+// uploadWorker uploads, updates, or skips files from the hash workers until the queue is closed.
+func (stc *Cloner) uploadWorker() {
+	defer stc.uploadWaitGroup.Done()
+
+	for file := range stc.uploadJobs {
+		if stc.ctx.Err() != nil {
+			stc.finishPending()
+			continue
+		}
+
+		stc.finishFile(file)
+		stc.finishJob(file.walkJob)
+	}
+}
+
+// compareContent compares the content of a file against its object by its hashes, S3 checksum, or
+// ETag, and with -verify-parts, its part checksums. If the file will be uploaded or have its
+// metadata updated, its hashes are computed here so the upload workers don't have to. This
+// returns false if the file failed.
+func (stc *Cloner) compareContent(file *fileJob) bool {
+	pathname, key, hoo := file.pathname, file.key, file.hoo
+
+	if hoo != nil {
+		hashes, hashesEqual, err := stc.compareFileHashes(hoo, pathname, file.stat)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to get hashes for %s: %v\n", pathname, err)
+			return false
+		}
+
+		file.hashes = hashes
+		file.compared = stc.contentComparable(hoo, file.stat)
+		if !hashesEqual {
+			stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "hash mismatch"}, "File hashes differ for s3://%s/%s and %s; will resync object\n", stc.bucket, key, pathname)
+			file.uploadRequired = true
+			file.contentEqual = false
+		} else {
+			stc.logf(stc.stdout, levelDebug, logEvent{Event: eventComparing, Path: pathname, Key: key, Reason: "hashes match"}, "Hash values for %s and s3://%s/%s match\n", pathname, stc.bucket, key)
+		}
+	}
+
+	// With -verify-parts, multipart objects that otherwise match are also checked against the
+	// checksums S3 keeps for their parts.
+	if hoo != nil && !file.uploadRequired && stc.verifyParts && file.hardLinkTarget == "" && isArchived(hoo) {
+		stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventComparing, Path: pathname, Key: key, Reason: "archived"}, "Skipping part checksum verification of s3://%s/%s: archived in %s storage\n", stc.bucket, key, hoo.StorageClass)
+	} else if hoo != nil && !file.uploadRequired && stc.verifyParts && file.hardLinkTarget == "" {
+		partsEqual, err := stc.comparePartChecksums(hoo, pathname, key, file.stat)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to get part checksums for s3://%s/%s: %v\n", stc.bucket, key, err)
+			return false
+		}
+
+		if !partsEqual {
+			stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "part checksum mismatch"}, "Part checksums differ for s3://%s/%s and %s; will resync object\n", stc.bucket, key, pathname)
+			file.uploadRequired = true
+			file.contentEqual = false
+		}
+	}
+
+	// Content compared by S3 checksum or ETag leaves the hashes for the new metadata to be
+	// computed here. A dry run only needs them to tell a metadata update from an upload.
+	metadataOnly := file.sizeEqual && file.contentEqual && file.compared
+	if file.hashes == nil && file.uploadRequired && file.hardLinkTarget == "" && !stc.report && (!stc.dryRun || metadataOnly) && stc.hashesFile(file.stat) {
+		hashes, err := stc.readFileHashes(pathname, file.stat)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to get hashes for %s: %v\n", pathname, err)
+			return false
+		}
+
+		file.hashes = hashes
+	}
+
+	return true
+}
+
+// finishFile reports, uploads, updates the metadata of, or skips a file once its content has been
+// compared.
+func (stc *Cloner) finishFile(file *fileJob) {
+	pathname, key, stat, hoo := file.pathname, file.key, file.stat, file.hoo
+
+	if stc.report {
+		stc.reportObject(key, file.exists, file.sizeEqual, file.contentEqual, file.metadataEqual)
+	} else if file.uploadRequired && file.hardLinkTarget != "" {
+		stc.UploadHardLink(pathname, key, stat, file.hardLinkTarget, file.hashes)
+	} else if file.uploadRequired {
+		// The content alone decides whether the file is uploaded again; when it matches and only
+		// the metadata differs, the object is updated in place.
+		if !file.sizeEqual || !file.contentEqual || !file.compared || file.hashes == nil || !stc.UpdateMetadata(pathname, key, stat, file.hashes, hoo) {
+			stc.UploadFile(pathname, key, stat, file.hashes)
+		}
+	} else {
+		stc.skipUpToDate(pathname, key)
+		if hoo != nil && file.hardLinkTarget == "" {
+			stc.recordSynced(pathname, key, stat, aws.ToTime(hoo.LastModified))
+		}
+	}
+}
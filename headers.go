@@ -0,0 +1,155 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// DefaultContentType is the content type of files whose type can't be detected.
+const DefaultContentType = "application/octet-stream"
+
+// ParseContentTypeMapping parses a content type override given as .ext=type/subtype. The extension
+// is returned in lowercase, since extensions are matched without regard to case.
+func ParseContentTypeMapping(mapping string) (string, string, error) {
+	equals := strings.IndexByte(mapping, '=')
+	if equals < 2 || mapping[0] != '.' || !strings.Contains(mapping[equals+1:], "/") {
+		return "", "", fmt.Errorf("Content type mapping must be in the form .ext=type/subtype: %s", mapping)
+	}
+
+	return strings.ToLower(mapping[:equals]), mapping[equals+1:], nil
+}
+
+// ParseWebsiteRedirect parses a website redirect given as key=target. S3 only accepts targets that
+// are absolute paths or http or https URLs.
+func ParseWebsiteRedirect(mapping string) (string, string, error) {
+	equals := strings.IndexByte(mapping, '=')
+	if equals > 0 {
+		target := mapping[equals+1:]
+		if strings.HasPrefix(target, "/") || strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+			return mapping[:equals], target, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("Website redirect must be in the form key=/path or key=https://host/path: %s", mapping)
+}
+
+// contentType returns the content type for a file: the override for its extension if there is one,
+// or else the detected type. If the file's content is already in memory, it is used for detection
+// instead of reading the file again. Files that can't be identified get the default content type.
+func (stc *Cloner) contentType(pathname, key string, content []byte) string {
+	if rule := stc.policyRule(key); rule != nil && rule.ContentType != "" {
+		return rule.ContentType
+	}
+
+	if contentType, found := stc.contentTypes[strings.ToLower(filepath.Ext(pathname))]; found {
+		return contentType
+	}
+
+	var mtype *mimetype.MIME
+	var err error
+	if content != nil {
+		mtype = mimetype.Detect(content)
+	} else {
+		mtype, err = mimetype.DetectFile(pathname)
+	}
+
+	if err != nil {
+		stc.logf(stc.stderr, levelError, logEvent{Event: eventError, Path: pathname, Key: key, Error: err.Error()}, "Cannot detect mime-type for %s: %v\n", pathname, err)
+		return stc.defaultContentType
+	}
+
+	if mtype.Is(DefaultContentType) {
+		return stc.defaultContentType
+	}
+
+	return mtype.String()
+}
+
+// setPutObjectHeaders sets the HTTP headers S3 serves the object with. The key must already be
+// set, since redirects are configured per key.
+func (stc *Cloner) setPutObjectHeaders(poi *s3.PutObjectInput) {
+	if cacheControl := stc.objectCacheControl(aws.ToString(poi.Key)); cacheControl != "" {
+		poi.CacheControl = &cacheControl
+	}
+
+	if stc.contentDisposition != "" {
+		poi.ContentDisposition = &stc.contentDisposition
+	}
+
+	if !stc.expires.IsZero() {
+		poi.Expires = &stc.expires
+	}
+
+	if target, found := stc.websiteRedirects[aws.ToString(poi.Key)]; found {
+		poi.WebsiteRedirectLocation = &target
+	}
+}
+
+// objectHeadersEqual determines whether the HTTP headers stored with an object are the ones it
+// would be uploaded with now. An object with a header that is no longer wanted also needs to be
+// resynced.
+func (stc *Cloner) objectHeadersEqual(hoo *s3.HeadObjectOutput, pathname, key string) bool {
+	for _, header := range []struct {
+		name     string
+		stored   *string
+		expected string
+	}{
+		{"Cache-Control", hoo.CacheControl, stc.objectCacheControl(key)},
+		{"Content-Disposition", hoo.ContentDisposition, stc.contentDisposition},
+		{"Website redirect", hoo.WebsiteRedirectLocation, stc.websiteRedirects[key]},
+	} {
+		if aws.ToString(header.stored) != header.expected {
+			stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: header.name + " mismatch"}, "%s mismatch: s3://%s/%s has %#v; expected %#v; will resync\n", header.name, stc.bucket, key, aws.ToString(header.stored), header.expected)
+			return false
+		}
+	}
+
+	// An Expires set from a duration is different on every run, so it's only replaced once it
+	// has passed.
+	expires := aws.ToTime(hoo.Expires)
+	if stc.expiresIn != 0 {
+		if expires.After(stc.startTime) {
+			return true
+		}
+	} else if expires.Equal(stc.expires) {
+		return true
+	}
+
+	stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "Expires mismatch"}, "Expires mismatch: s3://%s/%s has %#v; expected %#v; will resync\n", stc.bucket, key, formatExpires(expires), formatExpires(stc.expires))
+	return false
+}
+
+// objectContentTypeEqual determines whether the content type recorded in an object's
+// file-content-type metadata is the one the file would be uploaded with now, such as after a
+// -content-type override changes. Objects without it, including directories, links, and objects
+// uploaded before it was recorded, aren't compared.
+func (stc *Cloner) objectContentTypeEqual(hoo *s3.HeadObjectOutput, pathname, key string) bool {
+	stored, found := hoo.Metadata["file-content-type"]
+	if !found || stc.ignoreContentType {
+		return true
+	}
+
+	if expected := stc.contentType(pathname, key, nil); stored != expected {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "content type mismatch"}, "Content type mismatch: s3://%s/%s has %#v; %s has %#v; will resync\n", stc.bucket, key, stored, pathname, expected)
+		return false
+	}
+
+	return true
+}
+
+// formatExpires formats an Expires time as an HTTP date, or returns an empty string if it isn't
+// set.
+func formatExpires(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(http.TimeFormat)
+}
@@ -0,0 +1,246 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestObjectHeaders(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-object-headers-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Mkdir(tmpDir+"/d1", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/d1: %v", tmpDir, err)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/d1/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/d1/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-cache-control", "max-age=3600", "-content-disposition", "attachment", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, nil)
+
+	for _, key := range []string{"d1/", "d1/hello.txt"} {
+		obj := bucket.Objects[key]
+		if aws.ToString(obj.CacheControl) != "max-age=3600" || aws.ToString(obj.ContentDisposition) != "attachment" {
+			t.Errorf("Expected headers on %s: %#v %#v", key, aws.ToString(obj.CacheControl), aws.ToString(obj.ContentDisposition))
+		}
+	}
+
+	// The same headers are up to date.
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// Changing or removing a header forces a resync.
+	args[1] = "no-cache"
+	runExpect(t, args, client, 0, nil, []byte("Cache-Control mismatch: s3://hello/d1/hello.txt has \"max-age=3600\"; expected \"no-cache\"; will resync"))
+
+	if obj := bucket.Objects["d1/hello.txt"]; aws.ToString(obj.CacheControl) != "no-cache" {
+		t.Errorf("Expected the new Cache-Control on d1/hello.txt: %#v", aws.ToString(obj.CacheControl))
+	}
+
+	runExpect(t, []string{"-cache-control", "no-cache", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Content-Disposition mismatch: s3://hello/d1/hello.txt has \"attachment\"; expected \"\"; will resync"))
+
+	if obj := bucket.Objects["d1/hello.txt"]; obj.ContentDisposition != nil {
+		t.Errorf("Expected no Content-Disposition on d1/hello.txt: %#v", aws.ToString(obj.ContentDisposition))
+	}
+}
+
+func TestParseContentTypeMapping(t *testing.T) {
+	ext, contentType, err := ParseContentTypeMapping(".GLB=model/gltf-binary")
+	if err != nil || ext != ".glb" || contentType != "model/gltf-binary" {
+		t.Errorf("Expected .glb and model/gltf-binary: %#v %#v %v", ext, contentType, err)
+	}
+
+	for _, mapping := range []string{"", "glb=model/gltf-binary", ".=model/gltf-binary", ".glb", ".glb=binary", "=model/gltf-binary"} {
+		if _, _, err = ParseContentTypeMapping(mapping); err == nil {
+			t.Errorf("Expected %#v to be rejected", mapping)
+		}
+	}
+}
+
+func TestContentTypeOverrides(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-content-type-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string][]byte{
+		"scene.glb":  []byte("detected as text without the override"),
+		"notes.txt":  []byte("hello"),
+		"opaque.bin": {0x00, 0x9f, 0x13, 0xfe, 0x42, 0x07},
+	}
+
+	for filename, content := range files {
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, content, 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-content-type", ".GLB=model/gltf-binary", "-default-content-type", "application/x-opaque", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	for key, expected := range map[string]string{
+		// Overridden by extension.
+		"scene.glb": "model/gltf-binary",
+		// Not overridden, so the detected type is used.
+		"notes.txt": "text/plain; charset=utf-8",
+		// Not overridden and not detectable.
+		"opaque.bin": "application/x-opaque",
+	} {
+		if contentType := aws.ToString(bucket.Objects[key].ContentType); contentType != expected {
+			t.Errorf("Expected %s to have content type %s: %s", key, expected, contentType)
+		}
+	}
+
+	runExpect(t, []string{"-content-type", "glb=model/gltf-binary", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Content type mapping must be in the form .ext=type/subtype: glb=model/gltf-binary"))
+}
+
+func TestContentTypeResync(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-content-type-resync-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/scene.glb"
+	if err = ioutil.WriteFile(pathname, []byte("detected as text without the override"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1"))
+	if contentType := bucket.Objects["scene.glb"].Metadata["file-content-type"]; contentType != "text/plain; charset=utf-8" {
+		t.Errorf("Expected the detected content type in the metadata: %#v", contentType)
+	}
+
+	// Adding an override updates the object.
+	args := []string{"-content-type", ".glb=model/gltf-binary", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Content type mismatch: s3://hello/scene.glb has \"text/plain; charset=utf-8\"; "+pathname+" has \"model/gltf-binary\"; will resync"))
+	obj := bucket.Objects["scene.glb"]
+	if aws.ToString(obj.ContentType) != "model/gltf-binary" || obj.Metadata["file-content-type"] != "model/gltf-binary" {
+		t.Errorf("Expected the new content type: %#v %#v", aws.ToString(obj.ContentType), obj.Metadata["file-content-type"])
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// With -ignore-content-type, removing the override doesn't.
+	runExpect(t, []string{"-ignore-content-type", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+	if contentType := aws.ToString(bucket.Objects["scene.glb"].ContentType); contentType != "model/gltf-binary" {
+		t.Errorf("Expected the content type to be kept: %#v", contentType)
+	}
+
+	// Objects without the metadata aren't compared.
+	delete(bucket.Objects["scene.glb"].Metadata, "file-content-type")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+}
+
+func TestWebsiteRedirect(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-website-redirect-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"old.html", "new.html"} {
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("<html></html>"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-website-redirect", "site/old.html=/site/new.html", tmpDir + "/", "s3://hello/site/"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    2"))
+
+	if obj := bucket.Objects["site/old.html"]; aws.ToString(obj.WebsiteRedirectLocation) != "/site/new.html" {
+		t.Errorf("Expected a redirect on site/old.html: %#v", aws.ToString(obj.WebsiteRedirectLocation))
+	}
+
+	if obj := bucket.Objects["site/new.html"]; obj.WebsiteRedirectLocation != nil {
+		t.Errorf("Expected no redirect on site/new.html: %#v", aws.ToString(obj.WebsiteRedirectLocation))
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// Changing or removing the redirect forces a resync.
+	args[1] = "site/old.html=https://example.com/"
+	runExpect(t, args, client, 0, nil, []byte("Website redirect mismatch: s3://hello/site/old.html has \"/site/new.html\"; expected \"https://example.com/\"; will resync"))
+	if obj := bucket.Objects["site/old.html"]; aws.ToString(obj.WebsiteRedirectLocation) != "https://example.com/" {
+		t.Errorf("Expected the new redirect on site/old.html: %#v", aws.ToString(obj.WebsiteRedirectLocation))
+	}
+
+	runExpect(t, []string{tmpDir + "/", "s3://hello/site/"}, client, 0, nil, []byte("Objects uploaded:    1"))
+	if obj := bucket.Objects["site/old.html"]; obj.WebsiteRedirectLocation != nil {
+		t.Errorf("Expected no redirect on site/old.html: %#v", aws.ToString(obj.WebsiteRedirectLocation))
+	}
+
+	runExpect(t, []string{"-website-redirect", "old.html=new.html", tmpDir + "/", "s3://hello/site/"}, client, 1, nil, []byte("Website redirect must be in the form key=/path or key=https://host/path: old.html=new.html"))
+}
+
+func TestExpires(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-expires-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	// Off by default.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1"))
+	if obj := bucket.Objects["hello.txt"]; obj.Expires != nil {
+		t.Errorf("Expected no Expires on hello.txt: %v", obj.Expires)
+	}
+
+	expires := "Fri, 03 Jan 2031 04:05:06 GMT"
+	args := []string{"-expires", expires, tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Expires mismatch: s3://hello/hello.txt has \"\"; expected \""+expires+"\"; will resync"))
+	if obj := bucket.Objects["hello.txt"]; !aws.ToTime(obj.Expires).Equal(time.Date(2031, 1, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Errorf("Expected Expires on hello.txt: %v", obj.Expires)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// A different time forces a resync.
+	args[1] = "Sat, 04 Jan 2031 04:05:06 GMT"
+	runExpect(t, args, client, 0, nil, []byte("Expires mismatch: s3://hello/hello.txt has \""+expires+"\"; expected \"Sat, 04 Jan 2031 04:05:06 GMT\"; will resync"))
+
+	// A duration is resolved from the start of the run, and isn't replaced on later runs until it
+	// has passed.
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	args[1] = "24h"
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	first := aws.ToTime(bucket.Objects["hello.txt"].Expires)
+	if until := time.Until(first); until < 23*time.Hour || until > 24*time.Hour {
+		t.Errorf("Expected Expires on hello.txt about a day from now: %v", first)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	bucket.Objects["hello.txt"].Expires = aws.Time(time.Now().Add(-time.Minute))
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	if !aws.ToTime(bucket.Objects["hello.txt"].Expires).After(time.Now()) {
+		t.Errorf("Expected a new Expires on hello.txt: %v", bucket.Objects["hello.txt"].Expires)
+	}
+
+	runExpect(t, []string{"-expires", "tomorrow", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -expires value: Invalid time: tomorrow: expected an RFC 1123 time or a duration"))
+}
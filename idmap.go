@@ -0,0 +1,67 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDMapping remaps a source UID or GID to the ID recorded in S3. If Any is set, every ID is
+// remapped and From is ignored.
+type IDMapping struct {
+	From uint32
+	To   uint32
+	Any  bool
+}
+
+// ParseIDMapping parses a mapping given as from:to, where from may be "*" to match any ID.
+func ParseIDMapping(mapping string) (IDMapping, error) {
+	colon := strings.IndexByte(mapping, ':')
+	if colon == -1 {
+		return IDMapping{}, fmt.Errorf("ID mapping must be in the form from:to: %s", mapping)
+	}
+
+	to, err := strconv.ParseUint(mapping[colon+1:], 10, 32)
+	if err != nil {
+		return IDMapping{}, fmt.Errorf("Invalid ID in mapping %s: %s", mapping, mapping[colon+1:])
+	}
+
+	if mapping[:colon] == "*" {
+		return IDMapping{To: uint32(to), Any: true}, nil
+	}
+
+	from, err := strconv.ParseUint(mapping[:colon], 10, 32)
+	if err != nil {
+		return IDMapping{}, fmt.Errorf("Invalid ID in mapping %s: %s", mapping, mapping[:colon])
+	}
+
+	return IDMapping{From: uint32(from), To: uint32(to)}, nil
+}
+
+// mapID applies each mapping in order, so a later mapping applies to the result of an earlier one.
+func mapID(mappings []IDMapping, id uint32) uint32 {
+	for _, mapping := range mappings {
+		if mapping.Any || mapping.From == id {
+			id = mapping.To
+		}
+	}
+
+	return id
+}
+
+// fileOwnerIDs returns the owner and group to record for a file. The ID mappings are applied
+// first; root squash then applies to any ID that is still root.
+func (stc *Cloner) fileOwnerIDs(stat *fileStat) (uint32, uint32) {
+	uid := mapID(stc.uidMap, stat.Uid)
+	gid := mapID(stc.gidMap, stat.Gid)
+
+	if uid == 0 {
+		uid = stc.rootUID
+	}
+
+	if gid == 0 {
+		gid = stc.rootGID
+	}
+
+	return uid, gid
+}
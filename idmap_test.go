@@ -0,0 +1,185 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestParseIDMapping(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected IDMapping
+	}{
+		{"1000:2000", IDMapping{From: 1000, To: 2000}},
+		{"0:65534", IDMapping{From: 0, To: 65534}},
+		{"*:65534", IDMapping{To: 65534, Any: true}},
+	} {
+		mapping, err := ParseIDMapping(tc.s)
+		if err != nil || mapping != tc.expected {
+			t.Errorf("Expected %#v to parse as %#v: %#v %v", tc.s, tc.expected, mapping, err)
+		}
+	}
+
+	for _, s := range []string{"", "1000", "1000:", ":1000", "a:1000", "1000:*", "-1:0", "4294967296:0"} {
+		if _, err := ParseIDMapping(s); err == nil {
+			t.Errorf("Expected %#v to be rejected", s)
+		}
+	}
+}
+
+func TestMapID(t *testing.T) {
+	single := []IDMapping{{From: 1000, To: 2000}}
+	chained := []IDMapping{{From: 1000, To: 2000}, {From: 2000, To: 3000}}
+	wildcard := []IDMapping{{From: 1000, To: 2000}, {To: 65534, Any: true}}
+
+	for _, tc := range []struct {
+		mappings []IDMapping
+		id       uint32
+		expected uint32
+	}{
+		{nil, 1000, 1000},
+		{single, 1000, 2000},
+		{single, 1001, 1001},
+		{chained, 1000, 3000},
+		{chained, 2000, 3000},
+		{chained, 3000, 3000},
+		{wildcard, 1000, 65534},
+		{wildcard, 0, 65534},
+	} {
+		if id := mapID(tc.mappings, tc.id); id != tc.expected {
+			t.Errorf("Expected %d to map to %d with %#v: %d", tc.id, tc.expected, tc.mappings, id)
+		}
+	}
+}
+
+func TestFileOwnerIDsRootSquash(t *testing.T) {
+	stc := &Cloner{
+		rootUID: 65534,
+		rootGID: 65534,
+		uidMap:  []IDMapping{{From: 0, To: 500}, {From: 1000, To: 0}},
+		gidMap:  []IDMapping{{From: 100, To: 0}},
+	}
+
+	// An explicit mapping of root takes precedence over root squash, but root squash still applies
+	// to IDs mapped to root.
+	for _, tc := range []struct {
+		uid, gid                 uint32
+		expectedUID, expectedGID uint32
+	}{
+		{0, 0, 500, 65534},
+		{1000, 100, 65534, 65534},
+		{1001, 101, 1001, 101},
+	} {
+		uid, gid := stc.fileOwnerIDs(&fileStat{Uid: tc.uid, Gid: tc.gid})
+		if uid != tc.expectedUID || gid != tc.expectedGID {
+			t.Errorf("Expected %d:%d to be recorded as %d:%d: %d:%d", tc.uid, tc.gid, tc.expectedUID, tc.expectedGID, uid, gid)
+		}
+	}
+}
+
+func TestMapUIDFlags(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-map-uid-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	args := []string{"-map-uid", fmt.Sprintf("%d:1234", uid), "-map-gid", "*:4321", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, nil)
+
+	if len(client.putInputs) != 1 {
+		t.Fatalf("Expected 1 PutObject call: %d", len(client.putInputs))
+	}
+
+	metadata := client.putInputs[0].Metadata
+	if metadata["file-owner"] != "1234" || metadata["file-group"] != "4321" {
+		t.Errorf("Expected %d:%d to be recorded as 1234:4321: %s:%s (%s)", uid, gid, metadata["file-owner"], metadata["file-group"], aws.ToString(client.putInputs[0].Key))
+	}
+
+	// The mapped IDs are also used for comparison, so a second run finds nothing to do.
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	runExpect(t, []string{"-map-uid", "nobody:0", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid ID in mapping nobody:0: nobody"))
+}
+
+func TestRootUnsquash(t *testing.T) {
+	for _, tc := range []struct {
+		unsquash  bool
+		stored    string
+		local     uint32
+		ownerType string
+		expected  bool
+	}{
+		// Uploaded with root squash, compared from a host without it.
+		{true, "65534", 0, "file-owner", true},
+		{true, "65533", 0, "file-group", true},
+		// Uploaded without root squash, compared from a host with it.
+		{true, "0", 65534, "file-owner", true},
+		{true, "0", 65533, "file-group", true},
+		// The owner and group IDs aren't interchangeable.
+		{true, "65533", 0, "file-owner", false},
+		{true, "1000", 0, "file-owner", false},
+		{false, "65534", 0, "file-owner", false},
+		{false, "0", 65534, "file-owner", false},
+	} {
+		stc := &Cloner{stderr: io.Discard, squashedUID: 65534, squashedGID: 65533, rootUnsquash: tc.unsquash, metadataScheme: fileGatewayScheme{}}
+		hoo := &s3.HeadObjectOutput{Metadata: map[string]string{tc.ownerType: tc.stored}}
+		if stc.fileOwnershipEqual(hoo, tc.local, "key", "path", tc.ownerType) != tc.expected {
+			t.Errorf("Expected %s %s in S3 and %d locally with unsquash=%v to be equal=%v", tc.ownerType, tc.stored, tc.local, tc.unsquash, tc.expected)
+		}
+	}
+}
+
+func TestRootUnsquashRestore(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Restoring ownership requires root")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "test-root-unsquash-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.txt"
+	err = ioutil.WriteFile(pathname, []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	metadata := map[string]string{"file-owner": "65534", "file-group": "65533"}
+	for _, tc := range []struct {
+		unsquash                 bool
+		expectedUID, expectedGID uint32
+	}{
+		{false, 65534, 65533},
+		{true, 0, 0},
+	} {
+		stc := &Cloner{stderr: io.Discard, squashedUID: 65534, squashedGID: 65533, rootUnsquash: tc.unsquash, metadataScheme: fileGatewayScheme{}}
+		stc.applyFileOwnership(pathname, "hello.txt", metadata)
+
+		fileinfo, err := os.Stat(pathname)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", pathname, err)
+		}
+
+		stat := getFileStat(fileinfo)
+		if stat.Uid != tc.expectedUID || stat.Gid != tc.expectedGID {
+			t.Errorf("Expected %s to be restored as %d:%d with unsquash=%v: %d:%d", pathname, tc.expectedUID, tc.expectedGID, tc.unsquash, stat.Uid, stat.Gid)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package s3treeclone
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ignoreFileName is the name of the per-directory ignore file read with -respect-ignore-files.
+const ignoreFileName = ".s3ignore"
+
+// ignorePattern is a single pattern from an ignore file. A negated pattern re-includes entries
+// ignored by an earlier pattern or by an ignore file in a parent directory.
+type ignorePattern struct {
+	pattern string
+	negate  bool
+}
+
+// ignoreRules holds the patterns from the ignore file in one directory, linked to the rules of the
+// nearest parent directory that has one. Patterns are relative to the directory they were read
+// from.
+type ignoreRules struct {
+	parent   *ignoreRules
+	relPath  string
+	patterns []ignorePattern
+}
+
+// loadIgnoreFile reads the ignore file in a directory, if there is one, and returns its rules
+// stacked on top of the parent's. If the directory has no ignore file, parent is returned as is.
+func loadIgnoreFile(relPath, dirName string, parent *ignoreRules) (*ignoreRules, error) {
+	lines, err := readExcludeFile(path.Join(dirName, ignoreFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return parent, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rules := &ignoreRules{parent: parent, relPath: relPath}
+	for _, line := range lines {
+		negate := strings.HasPrefix(line, "!")
+		if negate {
+			line = line[1:]
+		}
+
+		if line != "" {
+			rules.patterns = append(rules.patterns, ignorePattern{pattern: line, negate: negate})
+		}
+	}
+
+	return rules, nil
+}
+
+// ignored reports whether the given path, relative to the base directory, is ignored. Rules from
+// deeper directories override their parents, and later patterns in a file override earlier ones.
+func (rules *ignoreRules) ignored(relPath string) bool {
+	for ; rules != nil; rules = rules.parent {
+		name := relPath
+		if rules.relPath != "" {
+			name = strings.TrimPrefix(relPath, rules.relPath+"/")
+		}
+
+		for i := len(rules.patterns) - 1; i >= 0; i-- {
+			// As with -exclude, "dir/**" also matches the directory itself, but only where the
+			// pattern is anchored.
+			pattern := rules.patterns[i].pattern
+			anchored := strings.Split(strings.TrimPrefix(strings.TrimSuffix(pattern, "/**"), "/"), "/")
+			if matchExcludePattern(pattern, name) || (strings.HasSuffix(pattern, "/**") && matchComponents(anchored, strings.Split(name, "/"))) {
+				return !rules.patterns[i].negate
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRespectIgnoreFiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-ignore-files-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"build", "sub/build", "sub/data"} {
+		err = os.MkdirAll(tmpDir+"/"+dir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create %s/%s: %v", tmpDir, dir, err)
+		}
+	}
+
+	files := map[string]string{
+		".s3ignore":       "# Logs are ignored everywhere except where re-included.\n*.log\n!keep.log\n\nbuild/**\n",
+		"sub/.s3ignore":   "!*.log\ndata/*.csv\n",
+		"a.log":           "hello",
+		"keep.log":        "hello",
+		"build/out.o":     "hello",
+		"sub/b.log":       "hello",
+		"sub/build/out.o": "hello",
+		"sub/data/x.csv":  "hello",
+		"sub/data/y.txt":  "hello",
+	}
+
+	for filename, content := range files {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-verbose", "-respect-ignore-files", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, []byte("Ignoring "+tmpDir+"/build\n"), nil)
+
+	for _, key := range []string{".s3ignore", "keep.log", "sub/b.log", "sub/build/out.o", "sub/data/y.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be uploaded", key)
+		}
+	}
+
+	for _, key := range []string{"a.log", "build/", "build/out.o", "sub/data/x.csv"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be ignored", key)
+		}
+	}
+
+	// Without -respect-ignore-files, .s3ignore files are just files.
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    3"))
+}
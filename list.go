@@ -0,0 +1,169 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// List writes the objects under the prefix to stdout, one per line, as the size, storage class, and
+// S3 URL separated by tabs. With -l, the metadata of each object is fetched with HeadObject, and the
+// recorded permissions, owner, group, and mtime are written first, and a symbolic link's target
+// last. Nothing is changed.
+func (stc *Cloner) List() error {
+	paginator := s3.NewListObjectsV2Paginator(stc.s3Client, &s3.ListObjectsV2Input{Bucket: &stc.bucket, Prefix: &stc.prefix, RequestPayer: stc.requestPayer})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(stc.ctx)
+		if err != nil {
+			return err
+		}
+
+		var heads []*s3.HeadObjectOutput
+		if stc.listLong {
+			heads = stc.headListedObjects(page.Contents)
+		}
+
+		for i, object := range page.Contents {
+			atomic.AddInt64(&stc.nObjects, 1)
+			key := aws.ToString(object.Key)
+
+			storageClass := string(object.StorageClass)
+			if storageClass == "" {
+				storageClass = "-"
+			}
+
+			line := fmt.Sprintf("%d\t%s\ts3://%s/%s", object.Size, storageClass, stc.bucket, key)
+			if stc.listLong {
+				// The failure was already reported.
+				if heads[i] == nil {
+					continue
+				}
+
+				line = stc.listedMetadata(key, heads[i].Metadata) + "\t" + line
+				if target, found := heads[i].Metadata["file-symlink-target"]; found {
+					line += " -> " + target
+				}
+			}
+
+			fmt.Fprintln(stc.stdout, line)
+		}
+	}
+
+	return nil
+}
+
+// headListedObjects fetches the metadata of a page of listed objects concurrently. The result for
+// an object whose HeadObject failed is nil; the failure is recorded.
+func (stc *Cloner) headListedObjects(objects []s3Types.Object) []*s3.HeadObjectOutput {
+	heads := make([]*s3.HeadObjectOutput, len(objects))
+	var waitGroup sync.WaitGroup
+	for i := range objects {
+		key := aws.ToString(objects[i].Key)
+		if err := stc.sem.Acquire(stc.ctx, 1); err != nil {
+			stc.fail(logEvent{Key: key, Error: err.Error()}, "Failed to acquire S3 semaphore: %v\n", err)
+			continue
+		}
+
+		waitGroup.Add(1)
+		go func(i int, key string) {
+			defer waitGroup.Done()
+			defer stc.sem.Release(1)
+
+			hoo, err := stc.s3Client.HeadObject(stc.ctx, stc.headObjectInput(key))
+			if err != nil {
+				stc.fail(logEvent{Key: key, Error: err.Error()}, "HeadObject on s3://%s/%s failed: %v\n", stc.bucket, key, err)
+				return
+			}
+
+			heads[i] = hoo
+		}(i, key)
+	}
+
+	waitGroup.Wait()
+	return heads
+}
+
+// listedMetadata formats the permissions, owner, group, and mtime recorded in an object's metadata
+// for -list -l, separated by tabs. Values that are missing or can't be parsed are shown as "-".
+func (stc *Cloner) listedMetadata(key string, metadata map[string]string) string {
+	scheme := stc.objectMetadataScheme(metadata)
+	keys := scheme.keys()
+
+	perms := "-"
+	if permsStr, isPresent := metadata[keys.permissions]; isPresent {
+		if mode, err := scheme.parsePermissions(permsStr); err == nil {
+			_, isLink := metadata["file-symlink-target"]
+			perms = permissionString(strings.HasSuffix(key, "/"), isLink, mode)
+		}
+	}
+
+	owner, group := "-", "-"
+	if ownerStr, isPresent := metadata[keys.owner]; isPresent {
+		if _, err := strconv.ParseUint(ownerStr, 10, 32); err == nil {
+			owner = ownerStr
+		}
+	}
+
+	if groupStr, isPresent := metadata[keys.group]; isPresent {
+		if _, err := strconv.ParseUint(groupStr, 10, 32); err == nil {
+			group = groupStr
+		}
+	}
+
+	mtime := "-"
+	if mtimeStr, isPresent := metadata[keys.mtime]; isPresent {
+		if ns, err := scheme.parseTimestamp(mtimeStr); err == nil {
+			mtime = time.Unix(0, ns).UTC().Format(time.RFC3339)
+		}
+	}
+
+	return strings.Join([]string{perms, owner, group, mtime}, "\t")
+}
+
+// permissionString formats Unix permission bits like ls -l, such as "drwxr-xr-x" or "-rwsr-xr-x".
+func permissionString(isDir, isLink bool, perms uint32) string {
+	var b strings.Builder
+	switch {
+	case isDir:
+		b.WriteByte('d')
+	case isLink:
+		b.WriteByte('l')
+	default:
+		b.WriteByte('-')
+	}
+
+	// The setuid, setgid, and sticky bits are shown in the execute position of each class.
+	special := [3]uint32{04000, 02000, 01000}
+	specialChar := [3]byte{'s', 's', 't'}
+	for class := 0; class < 3; class++ {
+		bits := perms >> (3 * (2 - class))
+		for i, c := range []byte("rw") {
+			if bits&(4>>i) != 0 {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('-')
+			}
+		}
+
+		execute, set := bits&1 != 0, perms&special[class] != 0
+		switch {
+		case set && execute:
+			b.WriteByte(specialChar[class])
+		case set:
+			b.WriteByte(specialChar[class] - 'a' + 'A')
+		case execute:
+			b.WriteByte('x')
+		default:
+			b.WriteByte('-')
+		}
+	}
+
+	return b.String()
+}
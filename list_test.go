@@ -0,0 +1,109 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-list-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = os.Mkdir(tmpDir+"/d1", 0755); err != nil {
+		t.Fatalf("Failed to create directory %s/d1: %v", tmpDir, err)
+	}
+
+	if err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	if err = ioutil.WriteFile(tmpDir+"/d1/run.sh", []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write file %s/d1/run.sh: %v", tmpDir, err)
+	}
+
+	if err = os.Symlink("hello.txt", tmpDir+"/link"); err != nil {
+		t.Fatalf("Failed to create symlink %s/link: %v", tmpDir, err)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err = os.Chtimes(tmpDir+"/hello.txt", mtime, mtime); err != nil {
+		t.Fatalf("Failed to set the times of %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-links", "store", tmpDir + "/", "s3://hello/backup"}, client, 0, nil, nil)
+	owner, group := bucket.Objects["backup/hello.txt"].Metadata["file-owner"], bucket.Objects["backup/hello.txt"].Metadata["file-group"]
+
+	// Only the objects under the prefix are listed, and nothing is changed.
+	nObjects := len(bucket.Objects)
+	exitCode, stdout, stderr := runCapture([]string{"-list", "s3://hello/backup/d1"}, client)
+	if exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, stderr)
+	}
+
+	expected := "0\tSTANDARD\ts3://hello/backup/d1/\n10\tSTANDARD\ts3://hello/backup/d1/run.sh\n"
+	if string(stdout) != expected {
+		t.Errorf("Unexpected listing: %q", stdout)
+	}
+
+	if bytes.Contains(stderr, []byte("Summary")) || len(bucket.Objects) != nObjects {
+		t.Errorf("Expected nothing to be changed or summarized: %s", stderr)
+	}
+
+	exitCode, stdout, stderr = runCapture([]string{"-list", "-l", "s3://hello/backup"}, client)
+	if exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, stderr)
+	}
+
+	for _, line := range []string{
+		fmt.Sprintf("-rw-r--r--\t%s\t%s\t2020-01-02T03:04:05Z\t11\tSTANDARD\ts3://hello/backup/hello.txt\n", owner, group),
+		fmt.Sprintf("drwxr-xr-x\t%s\t%s\t", owner, group),
+		"\t0\tSTANDARD\ts3://hello/backup/d1/\n",
+		fmt.Sprintf("-rwxr-xr-x\t%s\t%s\t", owner, group),
+		fmt.Sprintf("lrwxrwxrwx\t%s\t%s\t", owner, group),
+		"\t0\tSTANDARD\ts3://hello/backup/link -> hello.txt\n",
+	} {
+		if !bytes.Contains(stdout, []byte(line)) {
+			t.Errorf("Expected %q in the long listing: %q", line, stdout)
+		}
+	}
+
+	// Objects without the metadata show "-" for it.
+	bucket.Objects["backup/hello.txt"].Metadata = map[string]string{}
+	if exitCode, stdout, _ = runCapture([]string{"-list", "-l", "s3://hello/backup"}, client); exitCode != 0 || !bytes.Contains(stdout, []byte("\n-\t-\t-\t-\t11\tSTANDARD\ts3://hello/backup/hello.txt\n")) {
+		t.Errorf("Unexpected listing of an object without metadata: %d %q", exitCode, stdout)
+	}
+
+	runExpect(t, []string{"-l", tmpDir + "/", "s3://hello/backup"}, client, 1, nil, []byte("-l requires -list"))
+	runExpect(t, []string{"-list", "-delete", "s3://hello/backup"}, client, 1, nil, []byte("-list cannot be used with"))
+	runExpect(t, []string{"-list", tmpDir + "/", "s3://hello/backup"}, client, 2, nil, []byte("Unexpected argument: s3://hello/backup"))
+	runExpect(t, []string{"-list", "s3://missing/backup"}, client, 1, nil, []byte("Unable to list objects in s3://missing/backup/"))
+}
+
+func TestPermissionString(t *testing.T) {
+	for _, tc := range []struct {
+		isDir, isLink bool
+		perms         uint32
+		expected      string
+	}{
+		{false, false, 0644, "-rw-r--r--"},
+		{true, false, 0755, "drwxr-xr-x"},
+		{false, true, 0777, "lrwxrwxrwx"},
+		{false, false, 04755, "-rwsr-xr-x"},
+		{false, false, 02644, "-rw-r-Sr--"},
+		{true, false, 01777, "drwxrwxrwt"},
+		{true, false, 01770, "drwxrwx--T"},
+	} {
+		if result := permissionString(tc.isDir, tc.isLink, tc.perms); result != tc.expected {
+			t.Errorf("permissionString(%v, %v, %04o): expected %s, got %s", tc.isDir, tc.isLink, tc.perms, tc.expected, result)
+		}
+	}
+}
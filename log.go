@@ -0,0 +1,122 @@
+package s3treeclone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Values for the -log-format flag.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Verbosity controls which messages are written. A message is written if its level is at most the
+// cloner's verbosity.
+type Verbosity int
+
+// Values for Options.Verbosity.
+const (
+	VerbosityQuiet   Verbosity = iota - 1 // Only errors and the final summary.
+	VerbosityNormal                       // Uploads, deletions, resyncs, warnings, and errors.
+	VerbosityVerbose                      // Comparisons, skipped entries, and directories walked.
+	VerbosityDebug                        // Hash and metadata matches.
+)
+
+// Message levels, named for what is logged at each.
+const (
+	levelError   = VerbosityQuiet
+	levelInfo    = VerbosityNormal
+	levelVerbose = VerbosityVerbose
+	levelDebug   = VerbosityDebug
+)
+
+// Per-file events.
+const (
+	eventComparing = "comparing"
+	eventUploaded  = "uploaded"
+	eventSkipped   = "skipped"
+	eventResync    = "resync"
+	eventError     = "error"
+	eventVerified  = "verified"
+	eventDeleted   = "deleted"
+	eventConflict  = "conflict"
+	eventWarning   = "warning"
+	eventRestored  = "restored"
+	eventWalking   = "walking"
+	eventProgress  = "progress"
+)
+
+// syncWriter serializes writes to w, such as a log file shared by stdout and stderr, so messages
+// written concurrently aren't interleaved.
+type syncWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	return sw.w.Write(p)
+}
+
+// logEvent describes a per-file event. In json format, each event is written as a single JSON
+// object on its own line.
+type logEvent struct {
+	Event   string `json:"event"`
+	Path    string `json:"path,omitempty"`
+	Bucket  string `json:"bucket,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Bytes   *int64 `json:"bytes,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message"`
+}
+
+// printf writes a message that isn't a per-file event to w if the verbosity allows it.
+func (stc *Cloner) printf(w io.Writer, level Verbosity, format string, args ...interface{}) {
+	if level <= stc.verbosity {
+		fmt.Fprintf(w, format, args...)
+	}
+}
+
+// logf logs a per-file event at the given level. In text format, the formatted message is written
+// to w as before. In json format, the event is written to stderr with the message (without its
+// trailing newline) in the message field; the bucket is filled in if a key is given.
+func (stc *Cloner) logf(w io.Writer, level Verbosity, event logEvent, format string, args ...interface{}) {
+	// The reason an object is resynced goes in its -plan-out entry whether or not it's logged.
+	if event.Event == eventResync {
+		stc.notePlanReason(event.Key, event.Reason)
+	}
+
+	if level > stc.verbosity {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+
+	if stc.logFormat != LogFormatJSON {
+		io.WriteString(w, message)
+		return
+	}
+
+	if event.Key != "" && event.Bucket == "" {
+		event.Bucket = stc.bucket
+	}
+
+	if len(message) > 0 && message[len(message)-1] == '\n' {
+		message = message[:len(message)-1]
+	}
+	event.Message = message
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		// This can't happen with a struct of strings and integers.
+		panic(err)
+	}
+
+	// Write the line in a single call so concurrent events aren't interleaved.
+	stc.stderr.Write(append(line, '\n'))
+}
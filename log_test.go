@@ -0,0 +1,259 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// jsonEvents parses the JSON log lines in the given output, ignoring anything else (such as the
+// test client's messages).
+func jsonEvents(t *testing.T, output []byte) []map[string]interface{} {
+	var events []map[string]interface{}
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("{")) {
+			continue
+		}
+
+		var event map[string]interface{}
+		if err := json.Unmarshal(line, &event); err != nil {
+			t.Errorf("Invalid JSON log line %q: %v", line, err)
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// findEvent returns the first event with the given name and key.
+func findEvent(events []map[string]interface{}, name, key string) map[string]interface{} {
+	for _, event := range events {
+		if event["event"] == name && event["key"] == key {
+			return event
+		}
+	}
+
+	return nil
+}
+
+func TestJSONLogFormat(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-log-format-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	result, out, errOut := runCapture([]string{"-log-format", "json", tmpDir + "/", "s3://hello/prefix"}, client)
+	if result != 0 {
+		t.Fatalf("Expected returncode 0, got %d\nStderr: %s", result, errOut)
+	}
+
+	if len(out) != 0 {
+		t.Errorf("Expected nothing on stdout: %q", out)
+	}
+
+	uploaded := findEvent(jsonEvents(t, errOut), eventUploaded, "prefix/hello.txt")
+	if uploaded == nil {
+		t.Fatalf("Expected an uploaded event for prefix/hello.txt: %s", errOut)
+	}
+
+	expected := map[string]interface{}{
+		"event":   "uploaded",
+		"path":    tmpDir + "/hello.txt",
+		"bucket":  "hello",
+		"key":     "prefix/hello.txt",
+		"bytes":   float64(5),
+		"message": "Uploaded " + tmpDir + "/hello.txt to s3://hello/prefix/hello.txt",
+	}
+
+	for field, value := range expected {
+		if uploaded[field] != value {
+			t.Errorf("Expected uploaded event to have %s=%#v: %#v", field, value, uploaded[field])
+		}
+	}
+
+	if len(uploaded) != len(expected) {
+		t.Errorf("Unexpected fields in uploaded event: %#v", uploaded)
+	}
+
+	// Nothing has changed, so the file is skipped.
+	result, out, errOut = runCapture([]string{"-log-format", "json", "-verbose", tmpDir + "/", "s3://hello/prefix"}, client)
+	if result != 0 {
+		t.Fatalf("Expected returncode 0, got %d\nStderr: %s", result, errOut)
+	}
+
+	if len(out) != 0 {
+		t.Errorf("Expected nothing on stdout: %q", out)
+	}
+
+	events := jsonEvents(t, errOut)
+	if findEvent(events, eventComparing, "prefix/hello.txt") == nil {
+		t.Errorf("Expected a comparing event for prefix/hello.txt: %s", errOut)
+	}
+
+	if findEvent(events, eventUploaded, "prefix/hello.txt") != nil {
+		t.Errorf("Expected no uploaded event for prefix/hello.txt: %s", errOut)
+	}
+
+	skipped := findEvent(events, eventSkipped, "prefix/hello.txt")
+	if skipped == nil {
+		t.Fatalf("Expected a skipped event for prefix/hello.txt: %s", errOut)
+	}
+
+	if skipped["reason"] != "up to date" || skipped["path"] != tmpDir+"/hello.txt" || skipped["bucket"] != "hello" {
+		t.Errorf("Unexpected skipped event: %#v", skipped)
+	}
+
+	runExpect(t, []string{"-log-format", "xml", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -log-format value: xml"))
+}
+
+func TestVerbosity(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-verbosity-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Mkdir(tmpDir+"/d1", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create directory %s/d1: %v", tmpDir, err)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/d1/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/d1/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	uploaded := "Uploaded " + tmpDir + "/d1/hello.txt to s3://hello/d1/hello.txt"
+	walking := "Walking directory " + tmpDir + "/d1"
+	comparing := "Comparing " + tmpDir + "/d1/hello.txt against s3://hello/d1/hello.txt"
+	hashesMatch := "Hash values for " + tmpDir + "/d1/hello.txt and s3://hello/d1/hello.txt match"
+
+	cases := []struct {
+		flags    []string
+		expected []string
+		excluded []string
+	}{
+		// The first run uploads everything; the rest find it up to date.
+		{nil, []string{uploaded, "Objects uploaded:    1"}, []string{walking, comparing}},
+		{[]string{"-q"}, []string{"Objects uploaded:    0"}, []string{walking, comparing, "Uploaded "}},
+		{[]string{"-v"}, []string{walking, comparing}, []string{hashesMatch}},
+		{[]string{"-verbose"}, []string{walking, comparing}, []string{hashesMatch}},
+		{[]string{"-vv"}, []string{walking, comparing, hashesMatch}, nil},
+	}
+
+	for _, c := range cases {
+		args := append(append([]string{}, c.flags...), tmpDir+"/", "s3://hello")
+		result, out, errOut := runCapture(args, client)
+		if result != 0 {
+			t.Fatalf("%v: expected returncode 0, got %d\nStderr: %s", c.flags, result, errOut)
+		}
+
+		output := string(out) + string(errOut)
+		for _, expected := range c.expected {
+			if !strings.Contains(output, expected) {
+				t.Errorf("%v: expected %#v in output: %#v", c.flags, expected, output)
+			}
+		}
+
+		for _, excluded := range c.excluded {
+			if strings.Contains(output, excluded) {
+				t.Errorf("%v: expected no %#v in output: %#v", c.flags, excluded, output)
+			}
+		}
+	}
+
+	// Failures are still shown with -quiet.
+	err = ioutil.WriteFile(tmpDir+"/files", []byte("d1/missing.txt\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/files: %v", tmpDir, err)
+	}
+
+	runExpect(t, []string{"-q", "-files-from", tmpDir + "/files", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Warning: "+tmpDir+"/d1/missing.txt does not exist"))
+	runExpect(t, []string{"-q", "-v", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("-quiet cannot be used with -verbose or -vv"))
+}
+
+func TestLogFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-log-file-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.Mkdir(srcDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed to create directory %s: %v", srcDir, err)
+	}
+
+	for _, filename := range []string{"hello.txt", "world.txt"} {
+		err = ioutil.WriteFile(srcDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", srcDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	// Output goes to both the console and the log file.
+	logFile := tmpDir + "/clone.log"
+	runExpect(t, []string{"-log-file", logFile, srcDir + "/", "s3://hello"}, client, 0, nil, []byte("Uploaded "+srcDir+"/hello.txt"))
+
+	contents, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file %s: %v", logFile, err)
+	}
+
+	for _, expected := range []string{"Uploaded " + srcDir + "/hello.txt", "Uploaded " + srcDir + "/world.txt", "Objects uploaded:    2"} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Expected %#v in log file: %#v", expected, string(contents))
+		}
+	}
+
+	// With -log-file-only, output goes only to the log file, which is appended to.
+	err = ioutil.WriteFile(srcDir+"/hello.txt", []byte("hello world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", srcDir, err)
+	}
+
+	result, out, errOut := runCapture([]string{"-log-file", logFile, "-log-file-only", "-log-format", "json", srcDir + "/", "s3://hello"}, client)
+	if result != 0 {
+		t.Fatalf("Expected returncode 0, got %d\nStderr: %s", result, errOut)
+	}
+
+	if len(out) != 0 || bytes.Contains(errOut, []byte("{")) || bytes.Contains(errOut, []byte("Summary:")) {
+		t.Errorf("Expected no output on the console: %q %q", out, errOut)
+	}
+
+	contents, err = ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file %s: %v", logFile, err)
+	}
+
+	if !bytes.Contains(contents, []byte("Uploaded "+srcDir+"/world.txt to s3://hello/world.txt")) {
+		t.Errorf("Expected the log file to be appended to: %s", contents)
+	}
+
+	if findEvent(jsonEvents(t, contents), eventUploaded, "hello.txt") == nil {
+		t.Errorf("Expected an uploaded event for hello.txt in the log file: %s", contents)
+	}
+
+	runExpect(t, []string{"-log-file-only", srcDir + "/", "s3://hello"}, client, 1, nil, []byte("-log-file-only requires -log-file"))
+}
@@ -1,14 +1,27 @@
-package main
+package s3treeclone
 
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/semaphore"
 )
 
 func runCapture(args []string, s3i S3Interface) (int, []byte, []byte) {
@@ -88,7 +101,47 @@ func TestOneArg(t *testing.T) {
 }
 
 func TestThreeArgs(t *testing.T) {
-	runExpect(t, []string{".", "s3://test/foo", "what"}, nil, 2, nil, []byte("Unexpected argument"))
+	// All but the last argument are sources, so the destination must still be last.
+	runExpect(t, []string{".", "s3://test/foo", "what"}, nil, 2, nil, []byte("Destination is not a valid S3 URL: what"))
+	runExpect(t, []string{"-restore", "s3://test/foo", ".", "what"}, nil, 2, nil, []byte("Unexpected argument: what"))
+}
+
+func TestMultipleSources(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-multiple-sources-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"web/index.html", "web/css/site.css", "docs/guide.txt", "extra/notes.txt"} {
+		pathname := tmpDir + "/" + filename
+		if err = os.MkdirAll(path.Dir(pathname), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", pathname, err)
+		}
+
+		if err = ioutil.WriteFile(pathname, []byte("hello"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s: %v", pathname, err)
+		}
+	}
+
+	// Each source keeps its own directory semantics: web/ is copied into the prefix, while docs
+	// and extra are copied as directories under it.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{tmpDir + "/web/", tmpDir + "/docs", tmpDir + "/extra", "s3://hello/site"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    4"))
+
+	for _, key := range []string{"site/index.html", "site/css/", "site/css/site.css", "site/docs/", "site/docs/guide.txt", "site/extra/", "site/extra/notes.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be uploaded", key)
+		}
+	}
+
+	// The summary covers every source.
+	runExpect(t, args, client, 0, nil, []byte("Objects skipped:     7"))
+
+	runExpect(t, []string{"-delete", tmpDir + "/web/", tmpDir + "/docs", "s3://hello/site"}, client, 1, nil, []byte("-delete, -report, and -files-from cannot be used with multiple sources"))
+	runExpect(t, []string{tmpDir + "/web/", tmpDir + "/missing/", "s3://hello/site"}, client, 1, nil, []byte("Unable to open source directory"))
 }
 
 func TestInvalidDestURL(t *testing.T) {
@@ -255,3 +308,1718 @@ func TestNestedDirs(t *testing.T) {
 		}
 	}
 }
+
+func TestUploadFailureExitCode(t *testing.T) {
+	oldWD, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	defer func() {
+		err := os.Chdir(oldWD)
+		if err != nil {
+			t.Fatalf("Failed to chdir back to %s: %v", oldWD, err)
+		}
+	}()
+
+	tmpDir, err := os.MkdirTemp("", "test-upload-failure-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Chdir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to chdir to temporary directory %s: %v", tmpDir, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		filename := fmt.Sprintf("file-%d.txt", i)
+		err = ioutil.WriteFile(filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s: %v", filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	client.PutObjectError = func(input *s3.PutObjectInput) error {
+		if *input.Key == "file-1.txt" {
+			return makeS3Error("PutObject", 403, "Forbidden", "AccessDenied", "Access Denied")
+		}
+		return nil
+	}
+
+	runExpect(t, []string{".", "s3://hello"}, client, 1, nil, []byte("1 of 3 objects failed"))
+}
+
+func TestManyFilesAllUploaded(t *testing.T) {
+	oldWD, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	defer func() {
+		err := os.Chdir(oldWD)
+		if err != nil {
+			t.Fatalf("Failed to chdir back to %s: %v", oldWD, err)
+		}
+	}()
+
+	tmpDir, err := os.MkdirTemp("", "test-many-files-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Chdir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to chdir to temporary directory %s: %v", tmpDir, err)
+	}
+
+	const nFiles = 300
+	for i := 0; i < nFiles; i++ {
+		filename := fmt.Sprintf("file-%d.txt", i)
+		err = ioutil.WriteFile(filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s: %v", filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{".", "s3://hello"}, client, 0, nil, nil)
+
+	bucket.Mutex.Lock()
+	defer bucket.Mutex.Unlock()
+
+	if len(bucket.Objects) != nFiles {
+		t.Errorf("Expected %d objects in bucket %s, found %d", nFiles, bucket.Name, len(bucket.Objects))
+	}
+
+	for i := 0; i < nFiles; i++ {
+		key := fmt.Sprintf("file-%d.txt", i)
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected to find object %s in bucket %s", key, bucket.Name)
+		}
+	}
+}
+
+// goroutineCountingClient records the largest number of live goroutines seen during HeadObject.
+type goroutineCountingClient struct {
+	*s3TestClient
+	maxGoroutines int64
+}
+
+func (c *goroutineCountingClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	n := int64(runtime.NumGoroutine())
+	for {
+		cur := atomic.LoadInt64(&c.maxGoroutines)
+		if n <= cur || atomic.CompareAndSwapInt64(&c.maxGoroutines, cur, n) {
+			break
+		}
+	}
+
+	return c.s3TestClient.HeadObject(ctx, input, opts...)
+}
+
+func TestWalkGoroutinesBounded(t *testing.T) {
+	oldWD, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	defer func() {
+		err := os.Chdir(oldWD)
+		if err != nil {
+			t.Fatalf("Failed to chdir back to %s: %v", oldWD, err)
+		}
+	}()
+
+	tmpDir, err := os.MkdirTemp("", "test-walk-bounded-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Chdir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to chdir to temporary directory %s: %v", tmpDir, err)
+	}
+
+	// 10 directories of 10 subdirectories of 10 files each, plus a deep chain of directories.
+	nFiles := 0
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			dir := fmt.Sprintf("d%d/d%d", i, j)
+			err = os.MkdirAll(dir, fs.FileMode(0755))
+			if err != nil {
+				t.Fatalf("Failed to create %s: %v", dir, err)
+			}
+
+			for k := 0; k < 10; k++ {
+				filename := fmt.Sprintf("%s/file-%d.txt", dir, k)
+				err = ioutil.WriteFile(filename, []byte("hello"), 0644)
+				if err != nil {
+					t.Fatalf("Failed to write file %s: %v", filename, err)
+				}
+				nFiles++
+			}
+		}
+	}
+
+	deep := "deep"
+	for i := 0; i < 50; i++ {
+		deep = fmt.Sprintf("%s/%d", deep, i)
+	}
+	err = os.MkdirAll(deep, fs.FileMode(0755))
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", deep, err)
+	}
+	err = ioutil.WriteFile(deep+"/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", deep, err)
+	}
+	nFiles++
+
+	const walkWorkers = 4
+	baseline := int64(runtime.NumGoroutine())
+	client := &goroutineCountingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-walk-workers", fmt.Sprintf("%d", walkWorkers), ".", "s3://hello"}, client, 0, nil, nil)
+
+	if client.maxGoroutines > baseline+walkWorkers+10 {
+		t.Errorf("Expected at most %d goroutines, saw %d", baseline+walkWorkers+10, client.maxGoroutines)
+	}
+
+	bucket.Mutex.Lock()
+	defer bucket.Mutex.Unlock()
+
+	var nUploadedFiles int
+	for key := range bucket.Objects {
+		if !strings.HasSuffix(key, "/") {
+			nUploadedFiles++
+		}
+	}
+
+	if nUploadedFiles != nFiles {
+		t.Errorf("Expected %d files in bucket %s, found %d", nFiles, bucket.Name, nUploadedFiles)
+	}
+}
+
+// shortWriteHash is a hash.Hash that never accepts more than half of the bytes written to it.
+type shortWriteHash struct {
+	hash.Hash
+}
+
+func (h *shortWriteHash) Write(p []byte) (int, error) {
+	return h.Hash.Write(p[:len(p)/2])
+}
+
+func TestHashReaderShortWrite(t *testing.T) {
+	err := hashReader(bytes.NewReader([]byte("hello")), []namedHash{
+		{name: "MD5", hash: md5.New()},
+		{name: "SHA256", hash: &shortWriteHash{Hash: sha256.New()}},
+	}, make([]byte, 1024))
+	if err == nil {
+		t.Fatalf("Expected hashReader to fail on a short write")
+	}
+
+	if !strings.Contains(err.Error(), "SHA256") {
+		t.Errorf("Expected error to name the SHA256 hash: %v", err)
+	}
+}
+
+func TestGetFileHashes(t *testing.T) {
+	hashes, err := getFileHashes(bytes.NewReader([]byte("hello")), HashAlgorithms, make([]byte, 1024))
+	if err != nil {
+		t.Fatalf("getFileHashes failed: %v", err)
+	}
+
+	if hex.EncodeToString(hashes.MD5) != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("Unexpected MD5: %s", hex.EncodeToString(hashes.MD5))
+	}
+
+	if hex.EncodeToString(hashes.SHA256) != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Unexpected SHA256: %s", hex.EncodeToString(hashes.SHA256))
+	}
+
+	// Only the requested hashes are computed.
+	hashes, err = getFileHashes(bytes.NewReader([]byte("hello")), []string{"sha256"}, make([]byte, 1024))
+	if err != nil {
+		t.Fatalf("getFileHashes failed: %v", err)
+	}
+
+	if hashes.MD5 != nil || hashes.SHA1 != nil || hashes.SHA512 != nil {
+		t.Errorf("Expected only SHA256 to be computed: %#v", hashes)
+	}
+
+	if hex.EncodeToString(hashes.SHA256) != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Unexpected SHA256: %s", hex.EncodeToString(hashes.SHA256))
+	}
+}
+
+func TestParseHashAlgorithms(t *testing.T) {
+	algorithms, err := ParseHashAlgorithms("SHA256, md5,sha256")
+	if err != nil || strings.Join(algorithms, ",") != "sha256,md5" {
+		t.Errorf("Expected sha256,md5: %#v %v", algorithms, err)
+	}
+
+	for _, s := range []string{"", "sha3", "md5,,sha1"} {
+		if _, err = ParseHashAlgorithms(s); err == nil {
+			t.Errorf("Expected %#v to be rejected", s)
+		}
+	}
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-hash-algorithms-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-hash-algorithms", "sha256", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, nil)
+
+	metadata := bucket.Objects["hello.txt"].Metadata
+	if metadata["sha256"] != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Expected the SHA256 metadata to be written: %#v", metadata["sha256"])
+	}
+
+	for _, key := range []string{"md5", "sha1", "sha512"} {
+		if value, found := metadata[key]; found {
+			t.Errorf("Expected no %s metadata: %#v", key, value)
+		}
+	}
+
+	// The SHA256 hash is enough to compare against, with or without the other hashes.
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// With no hash in common, the object is rewritten with the requested hashes.
+	runExpect(t, []string{"-hash-algorithms", "md5", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("File hashes differ for s3://hello/hello.txt"))
+	if metadata = bucket.Objects["hello.txt"].Metadata; metadata["md5"] != "5d41402abc4b2a76b9719d911017c592" || metadata["sha256"] != "" {
+		t.Errorf("Expected only the MD5 metadata to be written: %#v", metadata)
+	}
+
+	runExpect(t, []string{"-hash-algorithms", "crc32", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -hash-algorithms value: crc32"))
+}
+
+func TestHashMinSize(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-hash-min-size-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/small.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/small.txt: %v", tmpDir, err)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/large.txt", bytes.Repeat([]byte("hello"), 1024), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/large.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-hash-min-size", "1KiB", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    2"))
+
+	for _, algorithm := range HashAlgorithms {
+		if value, found := bucket.Objects["small.txt"].Metadata[algorithm]; found {
+			t.Errorf("Expected no %s metadata on small.txt: %#v", algorithm, value)
+		}
+
+		if value := bucket.Objects["large.txt"].Metadata[algorithm]; value == "" {
+			t.Errorf("Expected %s metadata on large.txt", algorithm)
+		}
+	}
+
+	// Without hashes, the small file is compared by size and timestamps alone.
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	runExpect(t, []string{"-hash-min-size", "-1", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -hash-min-size value: -1"))
+}
+
+func TestReadBufferSize(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-read-buffer-size-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Files several times larger than the buffer, hashed concurrently through the shared pool.
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	expected := sha256.Sum256(content)
+	for i := 0; i < 20; i++ {
+		err = ioutil.WriteFile(fmt.Sprintf("%s/file%02d.bin", tmpDir, i), content, 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/file%02d.bin: %v", tmpDir, i, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-read-buffer-size", "1KiB", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	for key, obj := range bucket.Objects {
+		if obj.Metadata["sha256"] != hex.EncodeToString(expected[:]) {
+			t.Errorf("Unexpected SHA256 for %s: %s", key, obj.Metadata["sha256"])
+		}
+	}
+
+	runExpect(t, []string{"-read-buffer-size", "0", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -read-buffer-size value: 0"))
+}
+
+// BenchmarkFileHashes compares hashing many small files through the pooled read buffers against
+// allocating a buffer for each file.
+func BenchmarkFileHashes(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 256)
+	stc := &Cloner{hashAlgorithms: []string{"md5"}, readBuffers: newReadBufferPool(DefaultReadBufferSize)}
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := stc.fileHashes("file.bin", &fileStat{}, bytes.NewReader(content)); err != nil {
+				b.Fatalf("fileHashes failed: %v", err)
+			}
+		}
+	})
+
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := getFileHashes(bytes.NewReader(content), stc.hashAlgorithms, make([]byte, DefaultReadBufferSize)); err != nil {
+				b.Fatalf("getFileHashes failed: %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetFileHashes(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024*1024/16)
+	for _, tc := range []struct {
+		name       string
+		algorithms []string
+	}{
+		{"all", HashAlgorithms},
+		{"sha256", []string{"sha256"}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			buffer := make([]byte, DefaultReadBufferSize)
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				if _, err := getFileHashes(bytes.NewReader(content), tc.algorithms, buffer); err != nil {
+					b.Fatalf("getFileHashes failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// slowUploadClient discards uploaded content after a delay, standing in for the network.
+type slowUploadClient struct {
+	*s3TestClient
+}
+
+func (c *slowUploadClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	_, err := io.Copy(ioutil.Discard, input.Body)
+	time.Sleep(20 * time.Millisecond)
+	return &s3.PutObjectOutput{}, err
+}
+
+// BenchmarkHashWorkers clones a directory with the walk workers hashing each file before uploading
+// it, as they did before the hash workers, and with the hash workers hashing files while others
+// upload.
+func BenchmarkHashWorkers(b *testing.B) {
+	tmpDir, err := os.MkdirTemp("", "bench-hash-workers-")
+	if err != nil {
+		b.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	const nFiles = 16
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	for i := 0; i < nFiles; i++ {
+		if err = ioutil.WriteFile(fmt.Sprintf("%s/file%02d.bin", tmpDir, i), content, 0644); err != nil {
+			b.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		pipelined bool
+	}{
+		{"serial", false},
+		{"pipelined", true},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			b.SetBytes(nFiles * int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				client := &slowUploadClient{s3TestClient: newS3TestClient()}
+				client.createBucket("hello")
+
+				stc, err := NewCloner(Options{Source: tmpDir + "/", Destination: "s3://hello", WalkWorkers: 2}, client)
+				if err != nil {
+					b.Fatalf("NewCloner failed: %v", err)
+				}
+
+				if !tc.pipelined {
+					stc.hashWorkers = 0
+				}
+
+				summary, err := stc.Clone(context.Background())
+				if err != nil {
+					b.Fatalf("Clone failed: %v", err)
+				}
+
+				if summary.Uploaded != nFiles {
+					b.Fatalf("Expected %d files to be uploaded, got %d", nFiles, summary.Uploaded)
+				}
+			}
+		})
+	}
+}
+
+func TestGetFileStat(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-get-file-stat-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := tmpDir + "/hello.txt"
+	err = ioutil.WriteFile(filename, []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", filename, err)
+	}
+
+	fileinfo, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", filename, err)
+	}
+
+	stat := getFileStat(fileinfo)
+	if stat.Size != 5 {
+		t.Errorf("Expected size 5, got %d", stat.Size)
+	}
+
+	if stat.Mtime != fileinfo.ModTime().UnixNano() {
+		t.Errorf("Expected mtime %d, got %d", fileinfo.ModTime().UnixNano(), stat.Mtime)
+	}
+
+	if fs.FileMode(stat.Mode).Perm() != fileinfo.Mode().Perm() {
+		t.Errorf("Expected permissions %04o, got %04o", fileinfo.Mode().Perm(), stat.Mode&0777)
+	}
+}
+
+func TestACL(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-acl-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/index.html", []byte("<html></html>"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/index.html: %v", tmpDir, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	runExpect(t, []string{"-acl", "public-read", tmpDir, "s3://hello"}, client, 0, nil, nil)
+
+	if len(client.putInputs) != 2 {
+		t.Fatalf("Expected 2 PutObject calls: %d", len(client.putInputs))
+	}
+
+	for _, input := range client.putInputs {
+		if input.ACL != s3Types.ObjectCannedACLPublicRead {
+			t.Errorf("Expected public-read ACL for %s: %#v", *input.Key, input.ACL)
+		}
+	}
+
+	runExpect(t, []string{"-acl", "public", tmpDir, "s3://hello"}, client, 1, nil, []byte("Invalid -acl value: public"))
+}
+
+func TestMultipartOptions(t *testing.T) {
+	stc, err := NewCloner(Options{Source: ".", Destination: "s3://hello"}, newS3TestClient())
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	uploader := stc.newUploader(stc.multipartLayout(100 << 20))
+	if uploader.PartSize != DefaultMultipartPartSize || uploader.Concurrency != DefaultMultipartConcurrency || stc.multipartThreshold != DefaultMultipartPartSize {
+		t.Errorf("Unexpected default uploader configuration: %d %d %d", uploader.PartSize, uploader.Concurrency, stc.multipartThreshold)
+	}
+
+	stc, err = NewCloner(Options{Source: ".", Destination: "s3://hello", MultipartPartSize: 16 << 20, MultipartConcurrency: 12}, newS3TestClient())
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	uploader = stc.newUploader(stc.multipartLayout(500 << 20))
+	if uploader.PartSize != 16<<20 || uploader.Concurrency != 12 || stc.multipartThreshold != 16<<20 {
+		t.Errorf("Unexpected uploader configuration: %d %d %d", uploader.PartSize, uploader.Concurrency, stc.multipartThreshold)
+	}
+
+	if _, err = NewCloner(Options{Source: ".", Destination: "s3://hello", MultipartPartSize: 1 << 20}, newS3TestClient()); err == nil {
+		t.Errorf("Expected a 1 MiB part size to be rejected")
+	}
+}
+
+func TestMultipartFlags(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-multipart-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/large.bin", bytes.Repeat([]byte("x"), 6<<20), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/large.bin: %v", tmpDir, err)
+	}
+
+	putKeys := func(client *recordingClient) []string {
+		var keys []string
+		for _, input := range client.putInputs {
+			keys = append(keys, *input.Key)
+		}
+		return keys
+	}
+
+	// By default, a 6 MiB file is larger than the part size and is uploaded in parts.
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+	if len(client.putInputs) != 0 {
+		t.Errorf("Expected a multipart upload: %v", putKeys(client))
+	}
+
+	// Below the threshold, it is uploaded with a single PutObject.
+	client = &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	runExpect(t, []string{"-multipart-threshold", "8MiB", "-multipart-part-size", "8MiB", "-multipart-concurrency", "2", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+	if len(client.putInputs) != 1 || *client.putInputs[0].Key != "large.bin" {
+		t.Errorf("Expected a single PutObject for large.bin: %v", putKeys(client))
+	}
+
+	runExpect(t, []string{"-multipart-part-size", "4MiB", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -multipart-part-size value: 4MiB"))
+	runExpect(t, []string{"-multipart-threshold", "lots", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -multipart-threshold value: lots"))
+	runExpect(t, []string{"-multipart-concurrency", "0", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -multipart-concurrency value: 0"))
+}
+
+func TestS3ClientOptions(t *testing.T) {
+	var opts s3.Options
+	for _, fn := range s3ClientOptions("http://localhost:9000", true) {
+		fn(&opts)
+	}
+
+	if !opts.UsePathStyle {
+		t.Errorf("Expected UsePathStyle to be set")
+	}
+
+	if opts.EndpointResolver == nil {
+		t.Fatalf("Expected EndpointResolver to be set")
+	}
+
+	endpoint, err := opts.EndpointResolver.ResolveEndpoint("us-east-1", s3.EndpointResolverOptions{})
+	if err != nil {
+		t.Fatalf("Failed to resolve endpoint: %v", err)
+	}
+
+	if endpoint.URL != "http://localhost:9000" {
+		t.Errorf("Expected endpoint URL http://localhost:9000, got %s", endpoint.URL)
+	}
+
+	opts = s3.Options{}
+	for _, fn := range s3ClientOptions("", false) {
+		fn(&opts)
+	}
+
+	if opts.UsePathStyle || opts.EndpointResolver != nil {
+		t.Errorf("Expected default S3 options to be unchanged")
+	}
+}
+
+// TestCustomEndpoint runs against a real S3-compatible server such as MinIO. It is skipped unless
+// S3_TREE_CLONE_TEST_ENDPOINT and S3_TREE_CLONE_TEST_BUCKET are set; credentials and region are taken
+// from the usual AWS environment variables. For example:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	AWS_ACCESS_KEY_ID=minioadmin AWS_SECRET_ACCESS_KEY=minioadmin AWS_REGION=us-east-1 \
+//	S3_TREE_CLONE_TEST_ENDPOINT=http://localhost:9000 S3_TREE_CLONE_TEST_BUCKET=test go test
+func TestCustomEndpoint(t *testing.T) {
+	endpoint := os.Getenv("S3_TREE_CLONE_TEST_ENDPOINT")
+	bucket := os.Getenv("S3_TREE_CLONE_TEST_BUCKET")
+	if endpoint == "" || bucket == "" {
+		t.Skip("S3_TREE_CLONE_TEST_ENDPOINT and S3_TREE_CLONE_TEST_BUCKET are not set")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "test-custom-endpoint-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	args := []string{"-endpoint-url", endpoint, "-force-path-style", tmpDir + "/", "s3://" + bucket + "/s3-tree-clone-test"}
+	runExpect(t, args, nil, 0, nil, []byte("Uploaded"))
+
+	// A second run should find everything already in sync.
+	result, _, errOut := runCapture(args, nil)
+	if result != 0 {
+		t.Errorf("Expected returncode 0, got %d\nStderr: %s", result, string(errOut))
+	}
+
+	if bytes.Contains(errOut, []byte("Uploaded")) {
+		t.Errorf("Expected no uploads on second run: %s", string(errOut))
+	}
+}
+
+// tamperingClient alters the permissions metadata of every object written through it.
+type tamperingClient struct {
+	*s3TestClient
+}
+
+func (c *tamperingClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	tampered := *input
+	tampered.Metadata = copyAWSMapStringString(input.Metadata)
+	tampered.Metadata["file-permissions"] = "0000"
+	return c.s3TestClient.PutObject(ctx, &tampered, opts...)
+}
+
+func TestVerifyAfterUpload(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-verify-after-upload-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Mkdir(tmpDir+"/d1", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/d1: %v", tmpDir, err)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/d1/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/d1/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	runExpect(t, []string{"-verify-after-upload", "-verbose", tmpDir + "/", "s3://hello"}, client, 0, []byte("Verified s3://hello/d1/hello.txt"), nil)
+
+	tampering := &tamperingClient{s3TestClient: newS3TestClient()}
+	tampering.createBucket("hello")
+	runExpect(t, []string{"-verify-after-upload", tmpDir + "/", "s3://hello"}, tampering, 1, nil, []byte("Verification failed: metadata for s3://hello/d1/hello.txt"))
+}
+
+func TestDelete(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-delete-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.MkdirAll(srcDir+"/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/sub: %v", srcDir, err)
+	}
+
+	err = ioutil.WriteFile(srcDir+"/sub/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/sub/hello.txt: %v", srcDir, err)
+	}
+
+	seed := func(bucket *s3TestBucket, keys ...string) {
+		for _, key := range keys {
+			bucket.Objects[key] = &s3TestObject{ContentLength: 5}
+		}
+	}
+
+	// Dry run: nothing should change.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	seed(bucket, "backup/stale.txt", "backup/sub/stale.txt", "other/keep.txt")
+	runExpect(t, []string{"-delete", "-dry-run", srcDir + "/", "s3://hello/backup"}, client, 0, []byte("Would delete s3://hello/backup/sub/stale.txt"), nil)
+
+	if len(bucket.Objects) != 3 {
+		t.Errorf("Expected dry run to leave bucket unchanged, found %d objects", len(bucket.Objects))
+	}
+
+	// Contents of the source directory are synchronized to the prefix.
+	runExpect(t, []string{"-delete", srcDir + "/", "s3://hello/backup"}, client, 0, nil, []byte("Deleted s3://hello/backup/stale.txt"))
+
+	for _, key := range []string{"backup/stale.txt", "backup/sub/stale.txt"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+
+	for _, key := range []string{"backup/sub/", "backup/sub/hello.txt", "other/keep.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+
+	// The source directory itself is synchronized; siblings under the prefix are left alone.
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	seed(bucket, "backup/sibling.txt", "backup/src/stale.txt")
+	runExpect(t, []string{"-delete", srcDir, "s3://hello/backup"}, client, 0, nil, nil)
+
+	if _, found := bucket.Objects["backup/src/stale.txt"]; found {
+		t.Errorf("Expected backup/src/stale.txt to be deleted")
+	}
+
+	for _, key := range []string{"backup/sibling.txt", "backup/src/", "backup/src/sub/hello.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+}
+
+func TestKeyCollision(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-key-collision-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.MkdirAll(srcDir+"/dir", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/dir: %v", srcDir, err)
+	}
+
+	err = ioutil.WriteFile(srcDir+"/file", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/file: %v", srcDir, err)
+	}
+
+	// A local directory where S3 has a file, and a local file where S3 has a directory marker.
+	seed := func() (*s3TestClient, *s3TestBucket) {
+		client := newS3TestClient()
+		bucket := client.createBucket("hello")
+		bucket.Objects["backup/dir"] = &s3TestObject{Body: []byte("stale"), ContentLength: 5}
+		bucket.Objects["backup/file/"] = &s3TestObject{}
+		return client, bucket
+	}
+
+	client, bucket := seed()
+	result, _, stderr := runCapture([]string{srcDir + "/", "s3://hello/backup"}, client)
+	if result != 0 {
+		t.Errorf("Expected returncode 0, got %d\nStderr: %#v\n", result, string(stderr))
+	}
+
+	for _, expected := range []string{
+		"Key collision: " + srcDir + "/dir is a directory but s3://hello/backup/dir is a file",
+		"Key collision: " + srcDir + "/file is a file but s3://hello/backup/file/ is a directory",
+		"  Key conflicts:       2\n",
+	} {
+		if !bytes.Contains(stderr, []byte(expected)) {
+			t.Errorf("Expected stderr to contain %#v\nStderr: %#v\n", expected, string(stderr))
+		}
+	}
+
+	for _, key := range []string{"backup/dir", "backup/dir/", "backup/file", "backup/file/"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+
+	// With -delete, the stale objects are removed.
+	client, bucket = seed()
+	runExpect(t, []string{"-delete", srcDir + "/", "s3://hello/backup"}, client, 0, nil, []byte("  Key conflicts:       0\n"))
+
+	for _, key := range []string{"backup/dir", "backup/file/"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+
+	for _, key := range []string{"backup/dir/", "backup/file"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	for _, tc := range []struct {
+		key      string
+		expected string
+	}{
+		{"", ""},
+		{"foo", "foo"},
+		{"foo/", "foo/"},
+		{"foo//bar", "foo/bar"},
+		{"foo//bar//", "foo/bar/"},
+		{"foo/./bar/../baz", "foo/baz"},
+		{"./", ""},
+		{"/", "/"},
+		{"//foo", "/foo"},
+	} {
+		if result := normalizeKey(tc.key); result != tc.expected {
+			t.Errorf("normalizeKey(%#v): expected %#v, got %#v", tc.key, tc.expected, result)
+		}
+	}
+}
+
+func TestPrefixHostname(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("Unable to get the hostname: %v", err)
+	}
+
+	for _, tc := range []struct {
+		dest     string
+		nodeName string
+		expected string
+	}{
+		{"s3://hello", "", hostname + "/"},
+		{"s3://hello/", "", hostname + "/"},
+		{"s3://hello/base", "", "base/" + hostname + "/"},
+		{"s3://hello/base//", "node-a", "base/node-a/"},
+	} {
+		stc, err := NewCloner(Options{Source: ".", Destination: tc.dest, PrefixHostname: true, NodeName: tc.nodeName}, newS3TestClient())
+		if err != nil {
+			t.Errorf("NewCloner(%s, %#v) failed: %v", tc.dest, tc.nodeName, err)
+			continue
+		}
+
+		if stc.prefix != tc.expected {
+			t.Errorf("Prefix of %s with node name %#v: expected %#v, got %#v", tc.dest, tc.nodeName, tc.expected, stc.prefix)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "test-prefix-hostname-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-prefix-hostname", "-node-name", "node-a", tmpDir + "/", "s3://hello/base"}, client, 0, nil, nil)
+	if _, found := bucket.Objects["base/node-a/hello.txt"]; !found {
+		t.Errorf("Expected base/node-a/hello.txt to be present")
+	}
+
+	runExpect(t, []string{"-node-name", "node-a", tmpDir + "/", "s3://hello/base"}, client, 1, nil, []byte("NodeName requires PrefixHostname"))
+	runExpect(t, []string{"-prefix-hostname", "-node-name", "a/b", tmpDir + "/", "s3://hello/base"}, client, 1, nil, []byte("Invalid node name: a/b"))
+}
+
+func TestObjectKey(t *testing.T) {
+	var stderr bytes.Buffer
+	stc := &Cloner{stderr: &stderr, bucket: "hello", prefix: "backup/"}
+
+	for _, tc := range []struct {
+		relPath  string
+		filename string
+		isDir    bool
+		expected string
+		warning  bool
+	}{
+		{"", "file", false, "backup/file", false},
+		{"sub", "dir", true, "backup/sub/dir/", false},
+		{"sub//", "file", false, "backup/sub/file", true},
+		{"/sub", "dir", true, "backup/sub/dir/", true},
+		{"sub/./x", "file", false, "backup/sub/x/file", true},
+	} {
+		stderr.Reset()
+		key := stc.objectKey("src/"+tc.filename, tc.relPath, tc.filename, tc.isDir)
+		if key != tc.expected {
+			t.Errorf("objectKey(%#v, %#v, %v): expected %#v, got %#v", tc.relPath, tc.filename, tc.isDir, tc.expected, key)
+		}
+
+		if warned := strings.Contains(stderr.String(), "Warning: normalized key"); warned != tc.warning {
+			t.Errorf("objectKey(%#v, %#v, %v): expected warning=%v, stderr %#v", tc.relPath, tc.filename, tc.isDir, tc.warning, stderr.String())
+		}
+	}
+}
+
+func TestDoubleSlashPrefix(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-double-slash-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.MkdirAll(tmpDir+"/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/sub: %v", tmpDir, err)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/sub/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/sub/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	bucket.Objects["a/b/stale.txt"] = &s3TestObject{ContentLength: 5}
+	runExpect(t, []string{"-delete", tmpDir + "//", "s3://hello/a//b//"}, client, 0, nil, []byte("Warning: normalized prefix \"a//b/\" to \"a/b/\""))
+
+	for key := range bucket.Objects {
+		if strings.Contains(key, "//") {
+			t.Errorf("Unexpected key with '//': %#v", key)
+		}
+	}
+
+	for _, key := range []string{"a/b/sub/", "a/b/sub/hello.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+
+	if _, found := bucket.Objects["a/b/stale.txt"]; found {
+		t.Errorf("Expected a/b/stale.txt to be deleted")
+	}
+}
+
+func TestFilesFrom(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-files-from-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.MkdirAll(srcDir+"/d1/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/d1/sub: %v", srcDir, err)
+	}
+
+	for _, filename := range []string{"b.txt", "c.txt", "d1/a.txt", "d1/sub/x.txt"} {
+		err = ioutil.WriteFile(srcDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", srcDir, filename, err)
+		}
+	}
+
+	manifest := tmpDir + "/manifest"
+	err = ioutil.WriteFile(manifest, []byte("d1/a.txt\n\n./b.txt\nmissing.txt\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", manifest, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-files-from", manifest, srcDir + "/", "s3://hello"}, client, 1, nil, []byte("Warning: "+srcDir+"/missing.txt does not exist"))
+
+	for _, key := range []string{"d1/", "d1/a.txt", "b.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be uploaded", key)
+		}
+	}
+
+	for _, key := range []string{"c.txt", "d1/sub/", "d1/sub/x.txt"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s not to be uploaded", key)
+		}
+	}
+
+	// With -delete, listed paths that don't exist are removed from S3 instead.
+	err = ioutil.WriteFile(manifest, []byte("b.txt\nmissing.txt\ngone\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", manifest, err)
+	}
+
+	for _, key := range []string{"missing.txt", "missing.txt.bak", "gone/", "gone/x.txt"} {
+		bucket.Objects[key] = &s3TestObject{ContentLength: 5}
+	}
+
+	runExpect(t, []string{"-files-from", manifest, "-delete", srcDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	for _, key := range []string{"missing.txt", "gone/", "gone/x.txt"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+
+	for _, key := range []string{"missing.txt.bak", "b.txt", "d1/a.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+}
+
+func TestFilesFromStdin(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-files-from-stdin-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.MkdirAll(srcDir+"/d1", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/d1: %v", srcDir, err)
+	}
+
+	for _, filename := range []string{"a b.txt", "c.txt", "d1/x.txt"} {
+		err = ioutil.WriteFile(srcDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", srcDir, filename, err)
+		}
+	}
+
+	// Paths may be relative to the source or absolute, as find prints them, and may have spaces.
+	stdin := bytes.NewBufferString("a b.txt\n" + srcDir + "/d1/x.txt\n")
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	stc, err := NewCloner(Options{Source: srcDir + "/", Destination: "s3://hello/base", FilesFrom: "-", Stdin: stdin}, client)
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	summary, err := stc.Clone(context.Background())
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if summary.Failed != 0 {
+		t.Errorf("Expected no failures: %#v", summary.Errors)
+	}
+
+	for _, key := range []string{"base/a b.txt", "base/d1/", "base/d1/x.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be uploaded", key)
+		}
+	}
+
+	if _, found := bucket.Objects["base/c.txt"]; found {
+		t.Errorf("Expected base/c.txt not to be uploaded")
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-symlinks-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Mkdir(tmpDir+"/realdir", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/realdir: %v", tmpDir, err)
+	}
+
+	for _, filename := range []string{"target.txt", "realdir/f.txt"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	links := map[string]string{"link.txt": "target.txt", "dirlink": "realdir", "loop": ".."}
+	for link, target := range links {
+		err = os.Symlink(target, tmpDir+"/"+link)
+		if err != nil {
+			t.Fatalf("Failed to create symlink %s: %v", link, err)
+		}
+	}
+
+	// Skip (the default)
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-verbose", tmpDir + "/", "s3://hello"}, client, 0, []byte("Skipping symbolic link "+tmpDir+"/link.txt"), nil)
+
+	for _, key := range []string{"link.txt", "dirlink", "dirlink/", "loop", "loop/"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be skipped", key)
+		}
+	}
+
+	// Store
+	err = os.Symlink("nonexistent", tmpDir+"/broken")
+	if err != nil {
+		t.Fatalf("Failed to create symlink broken: %v", err)
+	}
+	links["broken"] = "nonexistent"
+
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	runExpect(t, []string{"-links", "store", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	for link, target := range links {
+		obj, found := bucket.Objects[link]
+		if !found {
+			t.Errorf("Expected %s to be stored", link)
+			continue
+		}
+
+		if obj.ContentLength != 0 {
+			t.Errorf("Expected Content-Length of %s to be 0: %d", link, obj.ContentLength)
+		}
+
+		if obj.Metadata["file-symlink-target"] != target {
+			t.Errorf("Expected file-symlink-target of %s to be %#v: %#v", link, target, obj.Metadata["file-symlink-target"])
+		}
+	}
+
+	_, _, errOut := runCapture([]string{"-links", "store", tmpDir + "/", "s3://hello"}, client)
+	if bytes.Contains(errOut, []byte("Uploaded")) {
+		t.Errorf("Expected no uploads on second run: %s", string(errOut))
+	}
+
+	// Follow; the broken link and the loop are errors.
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	result, _, errOut := runCapture([]string{"-links", "follow", tmpDir + "/", "s3://hello"}, client)
+	if result != 1 {
+		t.Errorf("Expected returncode 1, got %d", result)
+	}
+
+	if !bytes.Contains(errOut, []byte("Unable to follow symbolic link "+tmpDir+"/broken")) {
+		t.Errorf("Expected broken link error in stderr: %s", string(errOut))
+	}
+
+	if !bytes.Contains(errOut, []byte("Symbolic link "+tmpDir+"/loop points to a parent directory")) {
+		t.Errorf("Expected loop error in stderr: %s", string(errOut))
+	}
+
+	for _, key := range []string{"link.txt", "dirlink/", "dirlink/f.txt"} {
+		obj, found := bucket.Objects[key]
+		if !found {
+			t.Errorf("Expected %s to be uploaded", key)
+		} else if obj.Metadata["file-symlink-target"] != "" {
+			t.Errorf("Expected %s to be a copy of the link target", key)
+		}
+	}
+
+	if obj := bucket.Objects["link.txt"]; obj != nil && obj.ContentLength != 5 {
+		t.Errorf("Expected Content-Length of link.txt to be 5: %d", obj.ContentLength)
+	}
+
+	runExpect(t, []string{"-links", "bogus", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -links value: bogus"))
+}
+
+func TestSymlinkedSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Symbolic links require privileges on Windows")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "test-symlinked-source-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.MkdirAll(tmpDir+"/real/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/real/sub: %v", tmpDir, err)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/real/sub/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/real/sub/hello.txt: %v", tmpDir, err)
+	}
+
+	err = os.Symlink("real", tmpDir+"/link")
+	if err != nil {
+		t.Fatalf("Failed to create symlink %s/link: %v", tmpDir, err)
+	}
+
+	// The contents of the linked directory are copied.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/link/", "s3://hello/backup"}, client, 0, nil, nil)
+
+	for _, key := range []string{"backup/sub/", "backup/sub/hello.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+
+	// Without the trailing slash, the top-level directory is created under the link's name.
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/link", "s3://hello/backup"}, client, 0, nil, nil)
+
+	for _, key := range []string{"backup/link/", "backup/link/sub/", "backup/link/sub/hello.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+}
+
+func TestOneFileSystem(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-one-file-system-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.MkdirAll(tmpDir+"/mnt/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/mnt/sub: %v", tmpDir, err)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/mnt/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/mnt/hello.txt: %v", tmpDir, err)
+	}
+
+	// Everything is on one file system, so nothing is skipped.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-one-file-system", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	for _, key := range []string{"mnt/", "mnt/sub/", "mnt/hello.txt"} {
+		if _, found := bucket.Objects[key]; !found {
+			t.Errorf("Expected %s to be present", key)
+		}
+	}
+
+	// Simulate the source being on a different device than its subdirectories: the directory
+	// marker for mnt is created, but it isn't descended into.
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	var stdout bytes.Buffer
+	stc, err := NewCloner(Options{Source: tmpDir + "/", Destination: "s3://hello", OneFileSystem: true, Verbose: true, Stdout: &stdout}, client)
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+	stc.rootDev++
+
+	if _, err = stc.Clone(context.Background()); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if _, found := bucket.Objects["mnt/"]; !found {
+		t.Errorf("Expected mnt/ to be present")
+	}
+
+	for _, key := range []string{"mnt/sub/", "mnt/hello.txt"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s not to be copied", key)
+		}
+	}
+
+	if expected := "Not descending into " + tmpDir + "/mnt: on a different file system"; !strings.Contains(stdout.String(), expected) {
+		t.Errorf("Expected %#v in stdout: %#v", expected, stdout.String())
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-max-depth-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.MkdirAll(srcDir+"/a/b/c", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/a/b/c: %v", srcDir, err)
+	}
+
+	for _, name := range []string{"top.txt", "a/one.txt", "a/b/two.txt", "a/b/c/three.txt"} {
+		err = ioutil.WriteFile(srcDir+"/"+name, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", srcDir, name, err)
+		}
+	}
+
+	runExpect(t, []string{"-max-depth", "-1", srcDir, "s3://hello"}, nil, 1, nil, []byte("Invalid -max-depth value: -1"))
+
+	// Depth is counted from the source's contents, whether or not the top-level directory is
+	// created.
+	for _, tc := range []struct {
+		source string
+		prefix string
+	}{
+		{srcDir + "/", ""},
+		{srcDir, "src/"},
+	} {
+		client := newS3TestClient()
+		bucket := client.createBucket("hello")
+		bucket.Objects[tc.prefix+"a/b/stale.txt"] = &s3TestObject{ContentLength: 5}
+		runExpect(t, []string{"-max-depth", "2", "-delete", tc.source, "s3://hello"}, client, 0, nil, nil)
+
+		for _, key := range []string{"top.txt", "a/", "a/one.txt", "a/b/"} {
+			if _, found := bucket.Objects[tc.prefix+key]; !found {
+				t.Errorf("Expected %s%s to be present", tc.prefix, key)
+			}
+		}
+
+		for _, key := range []string{"a/b/two.txt", "a/b/c/", "a/b/c/three.txt"} {
+			if _, found := bucket.Objects[tc.prefix+key]; found {
+				t.Errorf("Expected %s%s not to be copied", tc.prefix, key)
+			}
+		}
+
+		// Objects below the maximum depth weren't walked, so they aren't deleted.
+		if _, found := bucket.Objects[tc.prefix+"a/b/stale.txt"]; !found {
+			t.Errorf("Expected %sa/b/stale.txt to be present", tc.prefix)
+		}
+	}
+}
+
+func TestGlobSource(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-glob-source-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A directory matching the glob is copied in full.
+	err = os.Mkdir(tmpDir+"/dir.txt", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/dir.txt: %v", tmpDir, err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt", "file-1.log", "file-22.log", "other.dat", "dir.txt/inner.dat"} {
+		err = ioutil.WriteFile(tmpDir+"/"+name, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, name, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		source   string
+		present  []string
+		excluded []string
+	}{
+		{"*.txt", []string{"logs/a.txt", "logs/b.txt", "logs/dir.txt/", "logs/dir.txt/inner.dat"}, []string{"logs/file-1.log", "logs/other.dat"}},
+		{"file-?.log", []string{"logs/file-1.log"}, []string{"logs/file-22.log", "logs/a.txt", "logs/other.dat"}},
+		{"other.dat", []string{"logs/other.dat"}, []string{"logs/a.txt", "logs/file-1.log"}},
+	} {
+		client := newS3TestClient()
+		bucket := client.createBucket("hello")
+		runExpect(t, []string{tmpDir + "/" + tc.source, "s3://hello/logs"}, client, 0, nil, nil)
+
+		for _, key := range tc.present {
+			if _, found := bucket.Objects[key]; !found {
+				t.Errorf("%s: expected %s to be present", tc.source, key)
+			}
+		}
+
+		for _, key := range tc.excluded {
+			if _, found := bucket.Objects[key]; found {
+				t.Errorf("%s: expected %s not to be copied", tc.source, key)
+			}
+		}
+	}
+
+	// With -delete, only objects matching the glob are candidates for deletion.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	for _, key := range []string{"logs/stale.txt", "logs/dir.txt/stale.dat", "logs/keep.dat"} {
+		bucket.Objects[key] = &s3TestObject{ContentLength: 5}
+	}
+	runExpect(t, []string{"-delete", tmpDir + "/*.txt", "s3://hello/logs"}, client, 0, nil, nil)
+
+	for _, key := range []string{"logs/stale.txt", "logs/dir.txt/stale.dat"} {
+		if _, found := bucket.Objects[key]; found {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+
+	if _, found := bucket.Objects["logs/keep.dat"]; !found {
+		t.Errorf("Expected logs/keep.dat to be present")
+	}
+
+	runExpect(t, []string{tmpDir + "/[", "s3://hello/logs"}, newS3TestClient(), 1, nil, []byte("Invalid source pattern: ["))
+}
+
+func TestRestore(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "test-restore-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	destDir, err := os.MkdirTemp("", "test-restore-dest-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	err = os.MkdirAll(srcDir+"/a/b", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/a/b: %v", srcDir, err)
+	}
+
+	files := map[string]os.FileMode{"top.txt": 0644, "a/script.sh": 0755, "a/b/secret.txt": 0600}
+	mtime := time.Unix(1600000000, 123456789)
+	for filename, mode := range files {
+		pathname := srcDir + "/" + filename
+		err = ioutil.WriteFile(pathname, []byte("contents of "+filename), mode)
+		if err != nil {
+			t.Fatalf("Failed to write file %s: %v", pathname, err)
+		}
+
+		// Ignore the umask
+		if err = os.Chmod(pathname, mode); err != nil {
+			t.Fatalf("Failed to chmod %s: %v", pathname, err)
+		}
+
+		if err = os.Chtimes(pathname, mtime, mtime); err != nil {
+			t.Fatalf("Failed to set timestamps on %s: %v", pathname, err)
+		}
+	}
+
+	err = os.Symlink("../top.txt", srcDir+"/a/link.txt")
+	if err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	if err = os.Chmod(srcDir+"/a/b", 0750); err != nil {
+		t.Fatalf("Failed to chmod %s/a/b: %v", srcDir, err)
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	runExpect(t, []string{"-links", "store", srcDir + "/", "s3://hello/backup"}, client, 0, nil, nil)
+	runExpect(t, []string{"-restore", "s3://hello/backup", destDir + "/restored"}, client, 0, nil, nil)
+
+	for filename, mode := range files {
+		pathname := destDir + "/restored/" + filename
+		contents, err := ioutil.ReadFile(pathname)
+		if err != nil {
+			t.Errorf("Failed to read restored file %s: %v", pathname, err)
+			continue
+		}
+
+		if string(contents) != "contents of "+filename {
+			t.Errorf("Unexpected contents for %s: %q", pathname, contents)
+		}
+
+		fi, err := os.Stat(pathname)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", pathname, err)
+		}
+
+		if fi.Mode().Perm() != mode {
+			t.Errorf("Expected %s to have mode %04o: %04o", pathname, mode, fi.Mode().Perm())
+		}
+
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("Expected %s to have mtime %v: %v", pathname, mtime, fi.ModTime())
+		}
+	}
+
+	target, err := os.Readlink(destDir + "/restored/a/link.txt")
+	if err != nil {
+		t.Errorf("Expected a/link.txt to be restored as a symbolic link: %v", err)
+	} else if target != "../top.txt" {
+		t.Errorf("Unexpected symbolic link target for a/link.txt: %s", target)
+	}
+
+	fi, err := os.Stat(destDir + "/restored/a/b")
+	if err != nil {
+		t.Fatalf("Failed to stat restored directory a/b: %v", err)
+	}
+
+	if !fi.IsDir() || fi.Mode().Perm() != 0750 {
+		t.Errorf("Expected a/b to be restored as a directory with mode 0750: %v", fi.Mode())
+	}
+
+	// Keys that would escape the destination are refused.
+	client.Buckets["hello"].Objects["backup/../escape.txt"] = &s3TestObject{}
+	runExpect(t, []string{"-restore", "s3://hello/backup", destDir + "/restored"}, client, 1, nil, []byte("key is outside the destination"))
+
+	if _, err = os.Lstat(destDir + "/escape.txt"); err == nil {
+		t.Errorf("Expected escape.txt not to be restored")
+	}
+}
+
+func TestRestoreDirectoryTimestamps(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "test-restore-dir-times-src-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	destDir, err := os.MkdirTemp("", "test-restore-dir-times-dest-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	err = os.MkdirAll(srcDir+"/a/b", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/a/b: %v", srcDir, err)
+	}
+
+	for _, filename := range []string{"a/one.txt", "a/b/two.txt"} {
+		err = ioutil.WriteFile(srcDir+"/"+filename, []byte("contents of "+filename), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", srcDir, filename, err)
+		}
+	}
+
+	// Set the directory times after their contents were written.
+	dirTimes := map[string]time.Time{"a": time.Unix(1500000000, 100), "a/b": time.Unix(1550000000, 200)}
+	for dirname, mtime := range dirTimes {
+		if err = os.Chtimes(srcDir+"/"+dirname, mtime, mtime); err != nil {
+			t.Fatalf("Failed to set timestamps on %s/%s: %v", srcDir, dirname, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{srcDir + "/", "s3://hello/backup"}, client, 0, nil, nil)
+	runExpect(t, []string{"-restore", "s3://hello/backup", destDir + "/restored"}, client, 0, nil, nil)
+
+	for dirname, mtime := range dirTimes {
+		object, found := bucket.Objects["backup/"+dirname+"/"]
+		if !found {
+			t.Errorf("Expected a directory marker for %s", dirname)
+			continue
+		}
+
+		if stored := object.Metadata["file-mtime"]; stored != formatTimestamp(mtime.UnixNano()) {
+			t.Errorf("Expected %s to be stored with file-mtime %d: %s", dirname, mtime.UnixNano(), stored)
+		}
+
+		// The restored directory has the stored mtime even though files were written into it.
+		fi, err := os.Stat(destDir + "/restored/" + dirname)
+		if err != nil {
+			t.Errorf("Failed to stat restored directory %s: %v", dirname, err)
+			continue
+		}
+
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("Expected restored directory %s to have mtime %v: %v", dirname, mtime, fi.ModTime())
+		}
+	}
+}
+
+func TestUploadFileSinglePass(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-single-pass-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	contents := []byte("hello, world\n")
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", contents, 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	obj, found := bucket.Objects["hello.txt"]
+	if !found {
+		t.Fatalf("Expected hello.txt to be uploaded")
+	}
+
+	if !bytes.Equal(obj.Body, contents) {
+		t.Errorf("Unexpected body for hello.txt: %q", obj.Body)
+	}
+
+	md5Sum := md5.Sum(contents)
+	if obj.Metadata["md5"] != hex.EncodeToString(md5Sum[:]) {
+		t.Errorf("Unexpected md5 metadata for hello.txt: %s", obj.Metadata["md5"])
+	}
+
+	if obj.ContentType == nil || !strings.HasPrefix(*obj.ContentType, "text/plain") {
+		t.Errorf("Expected a text/plain Content-Type for hello.txt: %v", obj.ContentType)
+	}
+}
+
+// discardingClient discards uploaded content so benchmarks measure only the local IO and hashing.
+type discardingClient struct {
+	*s3TestClient
+}
+
+func (c *discardingClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	_, err := io.Copy(ioutil.Discard, input.Body)
+	return &s3.PutObjectOutput{}, err
+}
+
+func (c *discardingClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	_, err := io.Copy(ioutil.Discard, input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.s3TestClient.UploadPart(ctx, input, opts...)
+}
+
+// BenchmarkUploadFile measures UploadFile for a file small enough to be hashed and uploaded in a
+// single read, and for one large enough that it is read twice.
+func BenchmarkUploadFile(b *testing.B) {
+	for _, size := range []int64{singlePassMaxSize / 8, singlePassMaxSize * 4} {
+		b.Run(fmt.Sprintf("%dKiB", size/1024), func(b *testing.B) {
+			benchmarkUploadFile(b, size)
+		})
+	}
+}
+
+func benchmarkUploadFile(b *testing.B, size int64) {
+	tmpDir, err := os.MkdirTemp("", "bench-upload-")
+	if err != nil {
+		b.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/data.bin"
+	err = ioutil.WriteFile(pathname, bytes.Repeat([]byte("0123456789abcdef"), int(size/16)), 0644)
+	if err != nil {
+		b.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	fileinfo, err := os.Stat(pathname)
+	if err != nil {
+		b.Fatalf("Failed to stat %s: %v", pathname, err)
+	}
+
+	// Discard the per-file log messages.
+	stc := &Cloner{
+		ctx:                  context.Background(),
+		stdout:               io.Discard,
+		stderr:               io.Discard,
+		sem:                  semaphore.NewWeighted(DefaultMaxConcurrent),
+		maxConcurrent:        DefaultMaxConcurrent,
+		s3Client:             &discardingClient{newS3TestClient()},
+		bucket:               "hello",
+		encAlg:               s3Types.ServerSideEncryptionAes256,
+		storageClass:         s3Types.StorageClassStandard,
+		hashAlgorithms:       HashAlgorithms,
+		readBuffers:          newReadBufferPool(DefaultReadBufferSize),
+		metadataScheme:       fileGatewayScheme{},
+		multipartPartSize:    DefaultMultipartPartSize,
+		multipartThreshold:   DefaultMultipartPartSize,
+		multipartConcurrency: DefaultMultipartConcurrency,
+	}
+	stat := getFileStat(fileinfo)
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stc.UploadFile(pathname, "data.bin", stat, nil)
+	}
+	b.StopTimer()
+
+	if stc.nFailed != 0 {
+		b.Fatalf("UploadFile failed")
+	}
+}
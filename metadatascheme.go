@@ -0,0 +1,213 @@
+package s3treeclone
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Values for the -metadata-scheme flag.
+const (
+	MetadataSchemeFileGateway = "filegateway"
+	MetadataSchemeS3FS        = "s3fs"
+	MetadataSchemeGoofys      = "goofys"
+)
+
+// metadataKeys are the metadata keys a scheme records a file's ownership, permissions, and
+// timestamps under. ctime is empty if the scheme doesn't record it.
+type metadataKeys struct {
+	owner       string
+	group       string
+	permissions string
+	ctime       string
+	mtime       string
+}
+
+// key returns the scheme's key for the metadata File Gateway records under field, such as
+// file-mtime, or an empty string if the scheme doesn't record it.
+func (keys metadataKeys) key(field string) string {
+	switch field {
+	case "file-owner":
+		return keys.owner
+	case "file-group":
+		return keys.group
+	case "file-permissions":
+		return keys.permissions
+	case "file-ctime":
+		return keys.ctime
+	case "file-mtime":
+		return keys.mtime
+	default:
+		return ""
+	}
+}
+
+// metadataScheme determines the metadata keys and value formats used to record the ownership,
+// permissions, and timestamps of a file, so the objects can be read by other S3 file systems.
+// Hashes, link targets, and extended attributes are recorded the same way in every scheme.
+type metadataScheme interface {
+	keys() metadataKeys
+	formatPermissions(mode uint32) string
+	parsePermissions(s string) (uint32, error)
+	formatTimestamp(ns int64) string
+	parseTimestamp(s string) (int64, error)
+
+	// timestampPrecision is the granularity of the recorded timestamps. Local timestamps are
+	// truncated to it before being compared.
+	timestampPrecision() time.Duration
+}
+
+// metadataSchemes maps each -metadata-scheme value to its scheme.
+var metadataSchemes = map[string]metadataScheme{
+	MetadataSchemeFileGateway: fileGatewayScheme{},
+	MetadataSchemeS3FS:        s3fsScheme{},
+	MetadataSchemeGoofys:      goofysScheme{},
+}
+
+// fallbackMetadataSchemes are the other schemes whose metadata is understood when comparing and
+// restoring with each scheme, so objects written by those programs aren't all seen as changed.
+var fallbackMetadataSchemes = map[string][]string{
+	MetadataSchemeFileGateway: {MetadataSchemeS3FS},
+}
+
+// readMetadataSchemes returns the schemes whose metadata is understood with the named scheme, in
+// order of preference.
+func readMetadataSchemes(name string) []metadataScheme {
+	schemes := []metadataScheme{metadataSchemes[name]}
+	for _, fallback := range fallbackMetadataSchemes[name] {
+		schemes = append(schemes, metadataSchemes[fallback])
+	}
+
+	return schemes
+}
+
+// objectMetadataScheme returns the scheme an object's metadata was written with: the first scheme
+// understood whose mtime key is present, since every scheme records it. If none is, the configured
+// scheme is returned.
+func (stc *Cloner) objectMetadataScheme(metadata map[string]string) metadataScheme {
+	for _, scheme := range stc.readMetadataSchemes {
+		if _, found := metadata[scheme.keys().mtime]; found {
+			return scheme
+		}
+	}
+
+	return stc.metadataScheme
+}
+
+// validMetadataScheme reports whether name is a valid -metadata-scheme value.
+func validMetadataScheme(name string) bool {
+	_, found := metadataSchemes[name]
+	return found
+}
+
+// fileGatewayScheme records metadata the way AWS Storage Gateway File Gateway does: 4-digit octal
+// permissions and nanosecond timestamps with an "ns" suffix.
+type fileGatewayScheme struct{}
+
+func (fileGatewayScheme) keys() metadataKeys {
+	return metadataKeys{owner: "file-owner", group: "file-group", permissions: "file-permissions", ctime: "file-ctime", mtime: "file-mtime"}
+}
+
+func (fileGatewayScheme) formatPermissions(mode uint32) string {
+	return fmt.Sprintf("%04o", mode&07777)
+}
+
+func (fileGatewayScheme) parsePermissions(s string) (uint32, error) {
+	perms, err := strconv.ParseUint(s, 8, 16)
+	return uint32(perms), err
+}
+
+func (fileGatewayScheme) formatTimestamp(ns int64) string {
+	return formatTimestamp(ns)
+}
+
+func (fileGatewayScheme) parseTimestamp(s string) (int64, error) {
+	return parseTimestamp(s)
+}
+
+func (fileGatewayScheme) timestampPrecision() time.Duration {
+	return time.Nanosecond
+}
+
+// s3fsScheme records metadata the way s3fs-fuse does: the whole st_mode, including the file type,
+// in decimal, and timestamps in integer seconds since the Unix epoch.
+type s3fsScheme struct{}
+
+func (s3fsScheme) keys() metadataKeys {
+	return metadataKeys{owner: "uid", group: "gid", permissions: "mode", ctime: "ctime", mtime: "mtime"}
+}
+
+func (s3fsScheme) formatPermissions(mode uint32) string {
+	return strconv.FormatUint(uint64(mode), 10)
+}
+
+func (s3fsScheme) parsePermissions(s string) (uint32, error) {
+	mode, err := strconv.ParseUint(s, 10, 32)
+	return uint32(mode) & 07777, err
+}
+
+func (s3fsScheme) formatTimestamp(ns int64) string {
+	return strconv.FormatInt(time.Unix(0, ns).Unix(), 10)
+}
+
+func (s3fsScheme) parseTimestamp(s string) (int64, error) {
+	return parseSecondsTimestamp(s)
+}
+
+func (s3fsScheme) timestampPrecision() time.Duration {
+	return time.Second
+}
+
+// goofysScheme records the same keys as s3fs, but with octal permissions and RFC 3339 timestamps
+// with nanoseconds. No ctime is recorded.
+type goofysScheme struct{}
+
+func (goofysScheme) keys() metadataKeys {
+	return metadataKeys{owner: "uid", group: "gid", permissions: "mode", mtime: "mtime"}
+}
+
+func (goofysScheme) formatPermissions(mode uint32) string {
+	return fmt.Sprintf("%04o", mode&07777)
+}
+
+func (goofysScheme) parsePermissions(s string) (uint32, error) {
+	perms, err := strconv.ParseUint(s, 8, 16)
+	return uint32(perms), err
+}
+
+func (goofysScheme) formatTimestamp(ns int64) string {
+	return time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+}
+
+func (goofysScheme) parseTimestamp(s string) (int64, error) {
+	return parseTimestamp(s)
+}
+
+func (goofysScheme) timestampPrecision() time.Duration {
+	return time.Nanosecond
+}
+
+// parseSecondsTimestamp parses a timestamp in integer seconds since the Unix epoch into
+// nanoseconds.
+func parseSecondsTimestamp(s string) (int64, error) {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if errors.Is(err, strconv.ErrRange) || seconds < minTimestamp/int64(time.Second) || seconds > maxTimestamp/int64(time.Second) {
+		return 0, fmt.Errorf("Timestamp is out of range: %s", s)
+	} else if err != nil {
+		return 0, fmt.Errorf("Invalid timestamp: %s: expected seconds since the Unix epoch", s)
+	}
+
+	return seconds * int64(time.Second), nil
+}
+
+// truncateTimestamp truncates a timestamp in nanoseconds to the given precision, rounding toward
+// the past like a file system storing whole seconds does.
+func truncateTimestamp(ns int64, precision time.Duration) int64 {
+	remainder := ns % int64(precision)
+	if remainder < 0 {
+		remainder += int64(precision)
+	}
+
+	return ns - remainder
+}
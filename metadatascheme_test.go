@@ -0,0 +1,167 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMetadataSchemeFormats(t *testing.T) {
+	const ns = 1600000000123456789
+	for name, scheme := range metadataSchemes {
+		perms, err := scheme.parsePermissions(scheme.formatPermissions(0100755))
+		if err != nil || perms != 0755 {
+			t.Errorf("Expected %s permissions to round-trip to 0755: %04o %v", name, perms, err)
+		}
+
+		expected := truncateTimestamp(ns, scheme.timestampPrecision())
+		timestamp, err := scheme.parseTimestamp(scheme.formatTimestamp(ns))
+		if err != nil || timestamp != expected {
+			t.Errorf("Expected %s timestamp to round-trip to %d: %d %v", name, int64(expected), timestamp, err)
+		}
+	}
+
+	s3fs := metadataSchemes[MetadataSchemeS3FS]
+	if mode := s3fs.formatPermissions(0100644); mode != "33188" {
+		t.Errorf("Expected s3fs mode 33188: %s", mode)
+	}
+
+	if mtime := s3fs.formatTimestamp(ns); mtime != "1600000000" {
+		t.Errorf("Expected s3fs mtime 1600000000: %s", mtime)
+	}
+
+	if _, err := s3fs.parseTimestamp("1600000000ns"); err == nil {
+		t.Errorf("Expected s3fs to reject a nanosecond timestamp")
+	}
+
+	if truncated := truncateTimestamp(-1, time.Second); truncated != -int64(time.Second) {
+		t.Errorf("Expected -1ns to truncate to -1s: %d", truncated)
+	}
+}
+
+func TestMetadataScheme(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-metadata-scheme-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/src/hello.txt"
+	if err = os.Mkdir(tmpDir+"/src", 0755); err != nil {
+		t.Fatalf("Failed to create %s/src: %v", tmpDir, err)
+	}
+
+	if err = ioutil.WriteFile(pathname, []byte("hello"), 0640); err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	if err = os.Chmod(pathname, 0640); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	mtime := time.Unix(1600000000, 123456789)
+	if err = os.Chtimes(pathname, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set timestamps on %s: %v", pathname, err)
+	}
+
+	for name, scheme := range metadataSchemes {
+		client := newS3TestClient()
+		bucket := client.createBucket("hello")
+		runExpect(t, []string{"-metadata-scheme", name, tmpDir + "/src/", "s3://hello"}, client, 0, nil, nil)
+
+		object, found := bucket.Objects["hello.txt"]
+		if !found {
+			t.Errorf("Expected hello.txt to be uploaded with %s", name)
+			continue
+		}
+
+		keys := scheme.keys()
+		for _, key := range []string{keys.owner, keys.group, keys.permissions, keys.mtime} {
+			if _, found := object.Metadata[key]; !found {
+				t.Errorf("Expected %s metadata with %s: %#v", key, name, object.Metadata)
+			}
+		}
+
+		if name != MetadataSchemeFileGateway {
+			if _, found := object.Metadata["file-mtime"]; found {
+				t.Errorf("Expected no file-mtime metadata with %s: %#v", name, object.Metadata)
+			}
+		}
+
+		// The metadata is read back the same way, so nothing changed.
+		runExpect(t, []string{"-metadata-scheme", name, tmpDir + "/src/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0\n"))
+
+		restored := tmpDir + "/restored-" + name
+		runExpect(t, []string{"-metadata-scheme", name, "-restore", "s3://hello", restored}, client, 0, nil, nil)
+
+		fileinfo, err := os.Stat(restored + "/hello.txt")
+		if err != nil {
+			t.Errorf("Failed to stat restored file with %s: %v", name, err)
+			continue
+		}
+
+		if fileinfo.Mode().Perm() != 0640 {
+			t.Errorf("Expected permissions 0640 restored with %s: %04o", name, fileinfo.Mode().Perm())
+		}
+
+		expected := time.Unix(0, truncateTimestamp(mtime.UnixNano(), scheme.timestampPrecision()))
+		if !fileinfo.ModTime().Equal(expected) {
+			t.Errorf("Expected mtime %s restored with %s: %s", expected, name, fileinfo.ModTime())
+		}
+	}
+
+	runExpect(t, []string{"-metadata-scheme", "rclone", tmpDir + "/src/", "s3://hello"}, newS3TestClient(), 1, nil, []byte("Invalid -metadata-scheme value: rclone"))
+}
+
+func TestS3FSMetadataFallback(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-s3fs-fallback-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.txt"
+	if err = ioutil.WriteFile(pathname, []byte("hello"), 0640); err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	if err = os.Chmod(pathname, 0640); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	mtime := time.Unix(1600000000, 123456789)
+	if err = os.Chtimes(pathname, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set timestamps on %s: %v", pathname, err)
+	}
+
+	fileinfo, err := os.Stat(pathname)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", pathname, err)
+	}
+	stat := getFileStat(fileinfo)
+
+	// An object written by s3fs, without ctime or any File Gateway metadata.
+	s3fsMetadata := func(mtime string) map[string]string {
+		return map[string]string{
+			"uid":   fmt.Sprintf("%d", stat.Uid),
+			"gid":   fmt.Sprintf("%d", stat.Gid),
+			"mode":  "33184",
+			"mtime": mtime,
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	bucket.Objects["hello.txt"] = &s3TestObject{Body: []byte("hello"), ContentLength: 5, Metadata: s3fsMetadata("1600000000")}
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0\n"))
+
+	// A different mtime is still noticed, and the object is rewritten with File Gateway metadata.
+	bucket.Objects["hello.txt"] = &s3TestObject{Body: []byte("hello"), ContentLength: 5, Metadata: s3fsMetadata("1600000001")}
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1\n"))
+
+	if _, found := bucket.Objects["hello.txt"].Metadata["file-mtime"]; !found {
+		t.Errorf("Expected hello.txt to be rewritten with file-mtime: %#v", bucket.Objects["hello.txt"].Metadata)
+	}
+}
@@ -0,0 +1,75 @@
+package s3treeclone
+
+import (
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// UpdateMetadata replaces the metadata of an object whose content already matches the file, such
+// as after only its permissions or timestamps changed, by copying the object onto itself instead of
+// uploading the body again. hashes must be the file's hashes, already compared against the object.
+// It returns false if the object can't be updated this way, in which case the file should be
+// uploaded instead.
+func (stc *Cloner) UpdateMetadata(pathname, key string, stat *fileStat, hashes *Hashes, hoo *s3.HeadObjectOutput) bool {
+	// CopyObject is limited to the same size as PutObject, and can't read archived objects.
+	if stat.Size > maxPutObjectSize || isArchived(hoo) {
+		return false
+	}
+
+	if stc.dryRun {
+		stc.printf(stc.stdout, levelInfo, "Would update the metadata of s3://%s/%s from %s\n", stc.bucket, key, pathname)
+		stc.planUpload(planActionUpdateMetadata, pathname, key, 0, stc.fileStorageClass(key, stat))
+		return true
+	}
+
+	metadata := stc.fileMetadata(stat)
+	if stc.xattrs {
+		if err := stc.addXattrMetadata(pathname, metadata); err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to read extended attributes of %s: %v\n", pathname, err)
+			return true
+		}
+	}
+
+	for _, algorithm := range stc.hashAlgorithms {
+		metadata[algorithm] = hex.EncodeToString(hashes.get(algorithm))
+	}
+
+	// The copy is a new single-part object, so its ETag differs from the one stored if the object
+	// was uploaded in parts.
+	if stc.etagMetadata {
+		etag, err := stc.copiedETag(pathname, hashes)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to get the ETag of %s: %v\n", pathname, err)
+			return true
+		}
+
+		metadata[etagMetadataKey] = etag
+	}
+
+	coi := stc.copyObjectInput(key, key, stat, metadata, stc.contentType(pathname, key, nil), hoo.ContentEncoding)
+	if err := stc.sem.Acquire(stc.ctx, 1); err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to acquire S3 semaphore: %v\n", err)
+		return true
+	}
+
+	_, err := stc.s3Client.CopyObject(stc.ctx, coi)
+	stc.sem.Release(1)
+	if err != nil {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventWarning, Path: pathname, Key: key, Error: err.Error()}, "Unable to update the metadata of s3://%s/%s; uploading %s instead: %v\n", stc.bucket, key, pathname, err)
+		return false
+	}
+
+	stc.logf(stc.stderr, levelInfo, logEvent{Event: eventUploaded, Path: pathname, Key: key, Reason: "metadata only", Bytes: aws.Int64(0)}, "Updated the metadata of s3://%s/%s from %s\n", stc.bucket, key, pathname)
+	atomic.AddInt64(&stc.nUploaded, 1)
+	stc.recordSynced(pathname, key, stat, time.Now())
+
+	if stc.verifyAfterUpload {
+		stc.VerifyUpload(pathname, key, stat, false)
+	}
+
+	return true
+}
@@ -0,0 +1,70 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUpdateMetadata(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-update-metadata-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.txt"
+	err = ioutil.WriteFile(pathname, []byte("Hello world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	if err = os.Chmod(pathname, 0644); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	args := []string{tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	if len(client.putInputs) != 1 {
+		t.Fatalf("Expected 1 PutObject call: %d", len(client.putInputs))
+	}
+
+	// Only the permissions differ, so the object is copied onto itself.
+	if err = os.Chmod(pathname, 0600); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Updated the metadata of s3://hello/hello.txt from "+pathname))
+	if len(client.putInputs) != 1 {
+		t.Errorf("Expected no more PutObject calls: %d", len(client.putInputs))
+	}
+
+	if len(client.copyInputs) != 1 || *client.copyInputs[0].CopySource != "hello/hello.txt" || client.copyInputs[0].MetadataDirective != "REPLACE" {
+		t.Fatalf("Expected the object to be copied onto itself: %#v", client.copyInputs)
+	}
+
+	object := bucket.Objects["hello.txt"]
+	if string(object.Body) != "Hello world" || object.Metadata["file-permissions"] != "0600" {
+		t.Errorf("Unexpected object after updating its metadata: %#v %#v", string(object.Body), object.Metadata)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// If the content changed too, the file is uploaded.
+	err = ioutil.WriteFile(pathname, []byte("Hello there"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	if err = os.Chmod(pathname, 0640); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("File hashes differ for s3://hello/hello.txt"))
+	if len(client.putInputs) != 2 || len(client.copyInputs) != 1 {
+		t.Errorf("Expected the file to be uploaded: %d PutObject calls, %d CopyObject calls", len(client.putInputs), len(client.copyInputs))
+	}
+}
@@ -0,0 +1,54 @@
+package s3treeclone
+
+import (
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+)
+
+// Files at least this large upload twice, and at least four times, the -multipart-concurrency
+// number of parts at once, since they spend long enough in flight to benefit from it.
+const (
+	largeUploadSize = 1 << 30
+	hugeUploadSize  = 16 << 30
+)
+
+// multipartLayout returns the part size and the number of parts uploaded at once for a multipart
+// upload of size bytes. The part size grows if needed to stay within the S3 limit on the number of
+// parts. A file with few parts doesn't hold more -max-concurrent slots than it has parts, and
+// the concurrency never exceeds -max-concurrent, so it can be acquired from the semaphore as is.
+func (stc *Cloner) multipartLayout(size int64) (int64, int) {
+	partSize := stc.multipartPartSize
+	if minPartSize := (size + int64(manager.MaxUploadParts) - 1) / int64(manager.MaxUploadParts); partSize < minPartSize {
+		partSize = minPartSize
+	}
+
+	concurrency := int64(stc.multipartConcurrency)
+	switch {
+	case size >= hugeUploadSize:
+		concurrency *= 4
+	case size >= largeUploadSize:
+		concurrency *= 2
+	}
+
+	if nParts := (size + partSize - 1) / partSize; concurrency > nParts {
+		concurrency = nParts
+	}
+
+	if concurrency > int64(stc.maxConcurrent) {
+		concurrency = int64(stc.maxConcurrent)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return partSize, int(concurrency)
+}
+
+// newUploader returns an uploader for files at or above the multipart threshold, with the layout
+// from multipartLayout.
+func (stc *Cloner) newUploader(partSize int64, concurrency int) *manager.Uploader {
+	return manager.NewUploader(stc.multipartClient(), func(u *manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = concurrency
+	})
+}
@@ -0,0 +1,132 @@
+package s3treeclone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestMultipartLayout(t *testing.T) {
+	for _, tc := range []struct {
+		size          int64
+		maxConcurrent int
+		partSize      int64
+		concurrency   int
+	}{
+		{6 << 20, 0, DefaultMultipartPartSize, 2},
+		{100 << 20, 0, DefaultMultipartPartSize, DefaultMultipartConcurrency},
+		{2 << 30, 0, DefaultMultipartPartSize, 2 * DefaultMultipartConcurrency},
+		{32 << 30, 0, DefaultMultipartPartSize, 4 * DefaultMultipartConcurrency},
+		{100 << 30, 0, (100<<30 + 9999) / 10000, 4 * DefaultMultipartConcurrency},
+		{32 << 30, 8, DefaultMultipartPartSize, 8},
+	} {
+		stc, err := NewCloner(Options{Source: ".", Destination: "s3://hello", MaxConcurrent: tc.maxConcurrent}, newS3TestClient())
+		if err != nil {
+			t.Fatalf("NewCloner failed: %v", err)
+		}
+
+		partSize, concurrency := stc.multipartLayout(tc.size)
+		if partSize != tc.partSize || concurrency != tc.concurrency {
+			t.Errorf("Layout for %d bytes with %d max concurrent: expected %d byte parts %d at a time, got %d byte parts %d at a time", tc.size, tc.maxConcurrent, tc.partSize, tc.concurrency, partSize, concurrency)
+		}
+	}
+}
+
+// inFlightClient records the largest number of parts being uploaded at once.
+type inFlightClient struct {
+	*s3TestClient
+	mutex       sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *inFlightClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	c.mutex.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mutex.Unlock()
+
+	_, err := io.Copy(ioutil.Discard, input.Body)
+	time.Sleep(20 * time.Millisecond)
+
+	c.mutex.Lock()
+	c.inFlight--
+	c.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.s3TestClient.UploadPart(ctx, input, opts...)
+}
+
+func TestMultipartConcurrency(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-multipart-concurrency-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"d1", "d1/d2", "d3"} {
+		if err = os.Mkdir(tmpDir+"/"+dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory %s/%s: %v", tmpDir, dir, err)
+		}
+	}
+
+	for _, filename := range []string{"a.txt", "d1/b.txt", "d1/d2/c.txt", "d3/d.txt"} {
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("Hello world"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	for _, filename := range []string{"data.bin", "d1/data.bin"} {
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, make([]byte, 26<<20), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	// Directories, small files, and multipart uploads share two slots without deadlocking, and the
+	// uploader doesn't run more parts at once than the slots acquired for them.
+	client := &inFlightClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runExpect(t, []string{"-max-concurrent", "2", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    6"))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatalf("Clone with -max-concurrent 2 did not finish")
+	}
+
+	if client.maxInFlight != 2 {
+		t.Errorf("Expected 2 parts to be uploaded at once: %d", client.maxInFlight)
+	}
+
+	runExpect(t, []string{"-max-concurrent", "2", "-multipart-concurrency", "5", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("-multipart-concurrency (5) cannot be greater than -max-concurrent (2)"))
+
+	if _, err = NewCloner(Options{Source: ".", Destination: "s3://hello", MaxConcurrent: 2, MultipartConcurrency: 5}, newS3TestClient()); err == nil {
+		t.Errorf("Expected a multipart concurrency greater than the maximum concurrency to be rejected")
+	}
+}
+
+// BenchmarkMultipartUpload measures UploadFile for files below the multipart threshold and with
+// fewer and more parts than -multipart-concurrency.
+func BenchmarkMultipartUpload(b *testing.B) {
+	for _, size := range []int64{DefaultMultipartPartSize / 2, 2 * DefaultMultipartPartSize, 16 * DefaultMultipartPartSize} {
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			benchmarkUploadFile(b, size)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package s3treeclone
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// validObjectLockMode reports whether mode can be used with -object-lock-mode. The empty mode
+// means no retention.
+func validObjectLockMode(mode s3Types.ObjectLockMode) bool {
+	return mode == "" || mode == s3Types.ObjectLockModeGovernance || mode == s3Types.ObjectLockModeCompliance
+}
+
+// objectLockRequested reports whether uploaded objects are placed under retention or a legal
+// hold.
+func (stc *Cloner) objectLockRequested() bool {
+	return stc.lockMode != "" || stc.legalHold
+}
+
+// checkObjectLock makes sure Object Lock is enabled on the bucket before anything is uploaded;
+// otherwise every upload would fail.
+func (stc *Cloner) checkObjectLock() error {
+	golco, err := stc.s3Client.GetObjectLockConfiguration(stc.ctx, &s3.GetObjectLockConfigurationInput{Bucket: &stc.bucket})
+	if err != nil {
+		var apiError smithy.APIError
+		if errors.As(err, &apiError) && apiError.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+			return fmt.Errorf("S3 bucket %s does not have Object Lock enabled", stc.bucket)
+		}
+
+		return fmt.Errorf("Unable to get the Object Lock configuration of S3 bucket %s: %w", stc.bucket, err)
+	}
+
+	if golco.ObjectLockConfiguration == nil || golco.ObjectLockConfiguration.ObjectLockEnabled != s3Types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("S3 bucket %s does not have Object Lock enabled", stc.bucket)
+	}
+
+	return nil
+}
+
+// setPutObjectLock sets the retention and legal hold parameters on an upload.
+func (stc *Cloner) setPutObjectLock(poi *s3.PutObjectInput) {
+	if stc.lockMode != "" {
+		poi.ObjectLockMode = stc.lockMode
+		poi.ObjectLockRetainUntilDate = &stc.lockRetainUntil
+	}
+
+	if stc.legalHold {
+		poi.ObjectLockLegalHoldStatus = s3Types.ObjectLockLegalHoldStatusOn
+	}
+
+	// S3 requires a checksum of the body when Object Lock parameters are sent.
+	if stc.objectLockRequested() {
+		poi.ChecksumAlgorithm = s3Types.ChecksumAlgorithmCrc32
+	}
+}
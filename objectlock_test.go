@@ -0,0 +1,79 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestObjectLock(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-object-lock-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"hello.txt", "copy.txt"} {
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("Hello world"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	retainUntil := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	args := []string{"-object-lock-mode", "COMPLIANCE", "-object-lock-retain-until", retainUntil.Format(time.RFC3339), "-legal-hold", "-dedupe", tmpDir + "/", "s3://hello"}
+
+	// Nothing is uploaded unless the bucket has Object Lock enabled.
+	runExpect(t, args, client, 1, nil, []byte("S3 bucket hello does not have Object Lock enabled"))
+	if len(client.putInputs) != 0 || len(client.copyInputs) != 0 {
+		t.Fatalf("Expected nothing to be written: %d PutObject calls, %d CopyObject calls", len(client.putInputs), len(client.copyInputs))
+	}
+
+	bucket.ObjectLockEnabled = true
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    2"))
+	if len(client.putInputs) != 1 || len(client.copyInputs) != 1 {
+		t.Fatalf("Expected 1 PutObject call and 1 CopyObject call: %d, %d", len(client.putInputs), len(client.copyInputs))
+	}
+
+	for _, input := range client.putInputs {
+		if input.ObjectLockMode != s3Types.ObjectLockModeCompliance || !aws.ToTime(input.ObjectLockRetainUntilDate).Equal(retainUntil) || input.ObjectLockLegalHoldStatus != s3Types.ObjectLockLegalHoldStatusOn {
+			t.Errorf("Expected Object Lock parameters for PutObject of %s: %#v %v %#v", aws.ToString(input.Key), input.ObjectLockMode, aws.ToTime(input.ObjectLockRetainUntilDate), input.ObjectLockLegalHoldStatus)
+		}
+
+		if input.ChecksumAlgorithm == "" {
+			t.Errorf("Expected a checksum for PutObject of %s", aws.ToString(input.Key))
+		}
+	}
+
+	// The duplicate is copied under the same retention.
+	input := client.copyInputs[0]
+	if input.ObjectLockMode != s3Types.ObjectLockModeCompliance || !aws.ToTime(input.ObjectLockRetainUntilDate).Equal(retainUntil) || input.ObjectLockLegalHoldStatus != s3Types.ObjectLockLegalHoldStatusOn {
+		t.Errorf("Expected Object Lock parameters for CopyObject of %s: %#v %v %#v", aws.ToString(input.Key), input.ObjectLockMode, aws.ToTime(input.ObjectLockRetainUntilDate), input.ObjectLockLegalHoldStatus)
+	}
+
+	// A legal hold alone doesn't set a retention mode.
+	client = &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello").ObjectLockEnabled = true
+	runExpect(t, []string{"-legal-hold", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    2"))
+	for _, input := range client.putInputs {
+		if input.ObjectLockMode != "" || input.ObjectLockRetainUntilDate != nil || input.ObjectLockLegalHoldStatus != s3Types.ObjectLockLegalHoldStatusOn {
+			t.Errorf("Expected only a legal hold for PutObject of %s: %#v %v %#v", aws.ToString(input.Key), input.ObjectLockMode, input.ObjectLockRetainUntilDate, input.ObjectLockLegalHoldStatus)
+		}
+	}
+}
+
+func TestObjectLockInvalid(t *testing.T) {
+	client := newS3TestClient()
+	client.createBucket("hello").ObjectLockEnabled = true
+
+	runExpect(t, []string{"-object-lock-mode", "FOREVER", "-object-lock-retain-until", "24h", ".", "s3://hello"}, client, 1, nil, []byte("Invalid -object-lock-mode value: FOREVER"))
+	runExpect(t, []string{"-object-lock-mode", "GOVERNANCE", ".", "s3://hello"}, client, 1, nil, []byte("-object-lock-mode and -object-lock-retain-until must be used together"))
+	runExpect(t, []string{"-object-lock-retain-until", "24h", ".", "s3://hello"}, client, 1, nil, []byte("-object-lock-mode and -object-lock-retain-until must be used together"))
+	runExpect(t, []string{"-object-lock-mode", "GOVERNANCE", "-object-lock-retain-until", "2001-02-03T04:05:06Z", ".", "s3://hello"}, client, 1, nil, []byte("Invalid -object-lock-retain-until value: Time is not in the future: 2001-02-03T04:05:06Z"))
+	runExpect(t, []string{"-object-lock-mode", "GOVERNANCE", "-object-lock-retain-until", "-24h", ".", "s3://hello"}, client, 1, nil, []byte("Invalid -object-lock-retain-until value: Invalid duration: -24h"))
+}
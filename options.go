@@ -0,0 +1,873 @@
+package s3treeclone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/semaphore"
+)
+
+// Default values for Options fields left unset.
+const (
+	DefaultKMSKey               = "aws/s3"
+	DefaultMaxConcurrent        = 30
+	DefaultMultipartPartSize    = manager.DefaultUploadPartSize
+	DefaultMultipartConcurrency = 5
+	DefaultReadBufferSize       = 1024 * 1024
+)
+
+// maxPutObjectSize is the largest object that can be uploaded with a single PutObject request.
+const maxPutObjectSize = 5 * 1024 * 1024 * 1024
+
+// ErrInvalidS3URL is returned by NewCloner when the S3 side of a clone isn't an s3:// URL.
+var ErrInvalidS3URL = errors.New("not a valid S3 URL")
+
+// Options configures a Cloner. The fields mirror the command-line flags; fields left at their zero
+// value take the same defaults as the flags.
+type Options struct {
+	// Source is the local directory to copy, interpreted like rsync: if it ends with a /, its
+	// contents are copied; otherwise the directory itself is created under the destination. With
+	// Restore, this is the S3 URL to restore from, and with List, the S3 URL to list.
+	Source string
+
+	// Destination is the S3 URL to copy to. With Restore, this is the local directory to restore
+	// into.
+	Destination string
+
+	// PrefixHostname adds the name of this host, or NodeName if set, to the end of the S3 prefix, so
+	// many hosts can be cloned into one bucket each under its own prefix.
+	PrefixHostname bool
+	NodeName       string
+
+	// ExtensionStorageClasses overrides StorageClass for files with the given extensions, such as
+	// ".mp4", compared without regard to case. StorageClassRules take precedence over it.
+	ExtensionStorageClasses map[string]s3Types.StorageClass
+
+	StorageClass        s3Types.StorageClass         // Defaults to STANDARD.
+	StorageClassRules   []StorageClassRule           // Override StorageClass for the files they match.
+	ACL                 s3Types.ObjectCannedACL      // The canned ACL for uploaded objects, if any.
+	EncryptionAlgorithm s3Types.ServerSideEncryption // AES256, aws:kms, or EncryptionSSEC. Defaults to AES256.
+	KMSKey              string                       // Defaults to aws/s3.
+	BucketKeyEnabled    bool                         // Use an S3 Bucket Key with aws:kms; ignored otherwise.
+	SSECustomerKey      string                       // The base64-encoded 256-bit key for EncryptionSSEC.
+	IgnoreTimestamps    bool                         // Ignore both ctime and mtime.
+	IgnoreCtime         bool                         // Ignore ctime, which cannot be restored, but check mtime.
+	IgnoreContentType   bool                         // Don't compare the file-content-type metadata.
+	TimestampTolerance  time.Duration                // The largest difference at which timestamps are still equal.
+	ModifiedSince       time.Time                    // If set, skip files last modified before this time.
+	VerifyAfterUpload   bool
+	RetryChanged        bool // Upload files that change while being uploaded once more.
+	RetryFailed         int  // Handle files that failed again up to this many times after the walk.
+	VerifyParts         bool // Compare multipart objects against the checksums S3 keeps for their parts.
+	Checksum            bool // Decide whether to upload by content hash alone; see the -checksum flag.
+	SizeOnly            bool // Compare only existence and size, and never hash files.
+	MaxConcurrent       int  // The maximum number of concurrent S3 requests. Defaults to 30.
+
+	// MultipartPartSize is the size of each part of a multipart upload; it must be at least 5 MiB.
+	// MultipartThreshold is the file size at which multipart uploads are used, and defaults to the
+	// part size. MultipartConcurrency is the number of parts of a file uploaded at once.
+	MultipartPartSize    int64 // Defaults to 5 MiB.
+	MultipartThreshold   int64
+	MultipartConcurrency int // Defaults to 5, or MaxConcurrent if lower. Can't exceed MaxConcurrent.
+
+	// ChecksumAlgorithm, if set, is the algorithm of the checksum S3 verifies each upload with and
+	// keeps: SHA256, CRC32C, CRC32, or SHA1. Files are compared against it instead of the hashes in
+	// their objects' metadata when their objects have one.
+	ChecksumAlgorithm s3Types.ChecksumAlgorithm
+
+	// ObjectLockMode, if set, places each uploaded object under Object Lock retention in that mode
+	// until ObjectLockRetainUntil; the two must be set together. LegalHold places a legal hold on
+	// each uploaded object. Either requires Object Lock to be enabled on the bucket.
+	ObjectLockMode        s3Types.ObjectLockMode
+	ObjectLockRetainUntil time.Time
+	LegalHold             bool
+
+	// ContentTypes maps lowercase file extensions, such as ".wasm", to the content type to upload
+	// them with instead of the detected type. DefaultContentType is used for files whose type
+	// can't be detected, and defaults to application/octet-stream.
+	ContentTypes       map[string]string
+	DefaultContentType string
+
+	CacheControl       string // The Cache-Control header for uploaded objects, if any.
+	ContentDisposition string // The Content-Disposition header for uploaded objects, if any.
+
+	// Expires is the Expires header for uploaded objects, if any. ExpiresIn instead sets it to that
+	// long after the run starts; objects are then only re-uploaded for it once their Expires has
+	// passed, rather than on every run.
+	Expires   time.Time
+	ExpiresIn time.Duration
+
+	// WebsiteRedirects maps object keys to the location the bucket's static website redirects
+	// requests for them to. The location must be an absolute path or an http or https URL.
+	WebsiteRedirects map[string]string
+
+	// Policy overrides the storage class, tags, ACL, Cache-Control header, and content type of the
+	// objects for matching paths. The first rule matching a path applies.
+	Policy []PolicyRule
+
+	// HashAlgorithms are the hashes (from HashAlgorithms) computed for each file and stored in its
+	// metadata. Defaults to all of them.
+	HashAlgorithms []string
+
+	// HashMinSize is the size below which files aren't hashed. They're uploaded without hash
+	// metadata and compared by size, timestamps, and permissions alone, and can't be deduplicated.
+	HashMinSize int64
+
+	ReadBufferSize int // The size of the buffer files are read through to hash them. Defaults to 1 MiB.
+
+	// Compress lists the file extensions (".log") and content type patterns ("text/*") of files to
+	// gzip before uploading. Files smaller than CompressMinSize, or that don't get smaller, are
+	// uploaded as-is.
+	Compress        []string
+	CompressMinSize int64
+
+	// Dedupe copies files whose content was already uploaded during the run from the earlier
+	// object with CopyObject instead of uploading them again. It requires the sha256 hash.
+	Dedupe bool
+
+	// BandwidthLimit is the maximum aggregate rate, in bytes per second, at which upload bodies are
+	// read. Zero means no limit.
+	BandwidthLimit int64
+
+	// MaxRPS is the maximum rate, in requests per second, at which S3 requests are made, regardless
+	// of how many are in flight. Zero means no limit.
+	MaxRPS float64
+
+	WalkWorkers     int         // Defaults to MaxConcurrent.
+	HashWorkers     int         // The number of files hashed at once, ahead of uploading. Defaults to WalkWorkers.
+	MaxDepth        int         // The number of levels below the source to copy. Zero means no limit.
+	FailureLimit    int         // Abort after this many fatal S3 errors in a row. Zero means never.
+	StopOnError     bool        // Abort at the first file or object that fails.
+	RequestPayer    bool        // Accept the charges for requests to a requester-pays bucket.
+	RootSquash      bool        // Record files owned by root as owned by nfsnobody.
+	RootUnsquash    bool        // Treat objects owned by nfsnobody as owned by root.
+	UIDMap          []IDMapping // Applied in order to each file's UID before root squash.
+	GIDMap          []IDMapping // Applied in order to each file's GID before root squash.
+	Links           string      // One of LinksSkip, LinksFollow, or LinksStore. Defaults to LinksSkip.
+	OneFileSystem   bool        // Don't descend into directories on other file systems.
+	Xattrs          bool        // Store and compare extended attributes. Only supported on Linux.
+	HardLinks       bool        // Store links to a file after the first as references to it.
+	HashCache       string      // The hash cache file, if any.
+	TrustMtime      bool        // Skip files the hash cache shows are unchanged since they were synced.
+	TrustETag       bool        // Compare files by MD5 against ETags that are known to be MD5 sums.
+	ETagMetadata    bool        // Store the ETag each file's object gets when uploaded as file-etag.
+	Prelist         bool
+	FilesFrom       string // A file listing the paths to copy instead of walking the source, or "-" for Stdin.
+	Excludes        []string
+	ExcludeFrom     string            // A file of additional exclude patterns, one per line.
+	IgnoreFiles     bool              // Skip entries matching the .s3ignore files found during the walk.
+	Tags            map[string]string // Tags to apply to every uploaded object.
+	TagFromMetadata bool              // Also tag objects with their owner, group, and permissions.
+	Annotate        bool              // Record the source host and a run ID in each object's metadata.
+	MetadataScheme  string            // One of the MetadataScheme values. Defaults to MetadataSchemeFileGateway.
+	Verbose         bool              // Equivalent to a Verbosity of at least VerbosityVerbose.
+	Verbosity       Verbosity         // Which messages are written. Defaults to VerbosityNormal.
+	LogFormat       string            // Either LogFormatText or LogFormatJSON. Defaults to LogFormatText.
+	DryRun          bool
+	Delete          bool
+	DeleteExcluded  bool // With Delete, also delete objects whose paths match Excludes.
+	Report          bool
+	Restore         bool
+	List            bool // Write the objects under Source, an S3 URL, to Stdout instead of copying.
+	ListLong        bool // With List, also show the permissions, owner, group, and mtime recorded.
+
+	// PlanOut, with DryRun, is a file the operations that would have been performed are written to
+	// as a JSON array, for review before the real run.
+	PlanOut string
+
+	// UnicodeNormalize converts the names from the source in object keys to a normalization form.
+	// It is one of the UnicodeNormalize values, and defaults to UnicodeNormalizeNone.
+	UnicodeNormalize string
+
+	// MetadataUserAgent is the name recorded, with the version, in the user-agent metadata of each
+	// uploaded object and sent in the User-Agent header of S3 requests. It defaults to
+	// DefaultMetadataUserAgent. The metadata isn't compared, so changing it uploads nothing again.
+	MetadataUserAgent string
+
+	// Progress periodically writes the transfer counts to Stderr every ProgressInterval, which
+	// defaults to DefaultProgressInterval.
+	Progress         bool
+	ProgressInterval time.Duration
+
+	// Stdout and Stderr receive the per-file messages and the report. Messages are discarded if
+	// these are nil. Stdin is read for the paths to copy when FilesFrom is "-", and defaults to
+	// os.Stdin.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// validStorageClass reports whether storageClass can be used with -storage-class.
+func validStorageClass(storageClass s3Types.StorageClass) bool {
+	switch storageClass {
+	case s3Types.StorageClassStandard, s3Types.StorageClassStandardIa, s3Types.StorageClassOnezoneIa, s3Types.StorageClassIntelligentTiering, s3Types.StorageClassGlacier, s3Types.StorageClassDeepArchive, s3Types.StorageClassOutposts:
+		return true
+	default:
+		return false
+	}
+}
+
+// validACL reports whether acl can be used with -acl.
+func validACL(acl s3Types.ObjectCannedACL) bool {
+	for _, value := range acl.Values() {
+		if acl == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validEncryptionAlgorithm reports whether encAlg can be used with -encryption-algorithm.
+func validEncryptionAlgorithm(encAlg s3Types.ServerSideEncryption) bool {
+	return encAlg == s3Types.ServerSideEncryptionAes256 || encAlg == s3Types.ServerSideEncryptionAwsKms || encAlg == EncryptionSSEC
+}
+
+// validLinks reports whether links is a valid -links value.
+func validLinks(links string) bool {
+	return links == LinksSkip || links == LinksFollow || links == LinksStore
+}
+
+// validLogFormat reports whether logFormat is a valid -log-format value.
+func validLogFormat(logFormat string) bool {
+	return logFormat == LogFormatText || logFormat == LogFormatJSON
+}
+
+// NewCloner validates the options and returns a Cloner that uses the given S3 client.
+func NewCloner(options Options, s3Client S3Interface) (*Cloner, error) {
+	if options.StorageClass == "" {
+		options.StorageClass = s3Types.StorageClassStandard
+	}
+
+	if options.EncryptionAlgorithm == "" {
+		options.EncryptionAlgorithm = s3Types.ServerSideEncryptionAes256
+	}
+
+	if options.KMSKey == "" {
+		options.KMSKey = DefaultKMSKey
+	}
+
+	if options.MaxConcurrent == 0 {
+		options.MaxConcurrent = DefaultMaxConcurrent
+	}
+
+	if options.MultipartPartSize == 0 {
+		options.MultipartPartSize = DefaultMultipartPartSize
+	}
+
+	if options.MultipartThreshold == 0 {
+		options.MultipartThreshold = options.MultipartPartSize
+	}
+
+	if options.MultipartConcurrency == 0 {
+		options.MultipartConcurrency = DefaultMultipartConcurrency
+		if options.MaxConcurrent > 0 && options.MaxConcurrent < options.MultipartConcurrency {
+			options.MultipartConcurrency = options.MaxConcurrent
+		}
+	}
+
+	if options.WalkWorkers == 0 {
+		options.WalkWorkers = options.MaxConcurrent
+	}
+
+	if options.HashWorkers == 0 {
+		options.HashWorkers = options.WalkWorkers
+	}
+
+	if options.Links == "" {
+		options.Links = LinksSkip
+	}
+
+	if len(options.HashAlgorithms) == 0 {
+		options.HashAlgorithms = HashAlgorithms
+	}
+
+	if options.ReadBufferSize == 0 {
+		options.ReadBufferSize = DefaultReadBufferSize
+	}
+
+	if options.DefaultContentType == "" {
+		options.DefaultContentType = DefaultContentType
+	}
+
+	if options.MetadataScheme == "" {
+		options.MetadataScheme = MetadataSchemeFileGateway
+	}
+
+	if options.MetadataUserAgent == "" {
+		options.MetadataUserAgent = DefaultMetadataUserAgent
+	}
+
+	if options.LogFormat == "" {
+		options.LogFormat = LogFormatText
+	}
+
+	if options.UnicodeNormalize == "" {
+		options.UnicodeNormalize = UnicodeNormalizeNone
+	}
+
+	if options.Verbose && options.Verbosity < VerbosityVerbose {
+		options.Verbosity = VerbosityVerbose
+	}
+
+	if options.ProgressInterval == 0 {
+		options.ProgressInterval = DefaultProgressInterval
+	}
+
+	if options.Stdout == nil {
+		options.Stdout = io.Discard
+	}
+
+	if options.Stderr == nil {
+		options.Stderr = io.Discard
+	}
+
+	if options.Stdin == nil {
+		options.Stdin = os.Stdin
+	}
+
+	for _, algorithm := range options.HashAlgorithms {
+		if !validHashAlgorithm(algorithm) {
+			return nil, fmt.Errorf("Unknown hash algorithm: %#v", algorithm)
+		}
+	}
+
+	for _, pattern := range options.Compress {
+		if !validCompressPattern(pattern) {
+			return nil, fmt.Errorf("Invalid compress pattern: %s", pattern)
+		}
+	}
+
+	for _, rule := range options.Policy {
+		if err := validatePolicyRule(rule); err != nil {
+			return nil, err
+		}
+
+		nTags := len(options.Tags) + len(rule.Tags)
+		if options.TagFromMetadata {
+			nTags += len(metadataTags)
+		}
+
+		if nTags > maxObjectTags {
+			return nil, fmt.Errorf("Too many tags with policy rule %s: S3 allows at most %d tags per object", rule.Match, maxObjectTags)
+		}
+	}
+
+	for key, target := range options.WebsiteRedirects {
+		if _, _, err := ParseWebsiteRedirect(key + "=" + target); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rule := range options.StorageClassRules {
+		if err := validateStorageClassRule(rule); err != nil {
+			return nil, err
+		}
+	}
+
+	extStorageClasses := make(map[string]s3Types.StorageClass, len(options.ExtensionStorageClasses))
+	for ext, storageClass := range options.ExtensionStorageClasses {
+		if err := validateExtStorageClass(ext, storageClass); err != nil {
+			return nil, err
+		}
+
+		extStorageClasses[strings.ToLower(ext)] = storageClass
+	}
+
+	switch {
+	case !validStorageClass(options.StorageClass):
+		return nil, fmt.Errorf("Invalid storage class: %s", options.StorageClass)
+	case options.ACL != "" && !validACL(options.ACL):
+		return nil, fmt.Errorf("Invalid ACL: %s", options.ACL)
+	case !validEncryptionAlgorithm(options.EncryptionAlgorithm):
+		return nil, fmt.Errorf("Invalid encryption algorithm: %s", options.EncryptionAlgorithm)
+	case !validChecksumAlgorithm(options.ChecksumAlgorithm):
+		return nil, fmt.Errorf("Invalid checksum algorithm: %s", options.ChecksumAlgorithm)
+	case !validObjectLockMode(options.ObjectLockMode):
+		return nil, fmt.Errorf("Invalid Object Lock mode: %s", options.ObjectLockMode)
+	case (options.ObjectLockMode == "") != options.ObjectLockRetainUntil.IsZero():
+		return nil, fmt.Errorf("ObjectLockMode and ObjectLockRetainUntil must be used together")
+	case !options.ObjectLockRetainUntil.IsZero() && !options.ObjectLockRetainUntil.After(time.Now()):
+		return nil, fmt.Errorf("ObjectLockRetainUntil must be in the future: %s", options.ObjectLockRetainUntil.Format(time.RFC3339))
+	case !validLinks(options.Links):
+		return nil, fmt.Errorf("Invalid links value: %s", options.Links)
+	case !validLogFormat(options.LogFormat):
+		return nil, fmt.Errorf("Invalid log format: %s", options.LogFormat)
+	case !validUnicodeNormalize(options.UnicodeNormalize):
+		return nil, fmt.Errorf("Invalid Unicode normalization: %s", options.UnicodeNormalize)
+	case !validMetadataScheme(options.MetadataScheme):
+		return nil, fmt.Errorf("Invalid metadata scheme: %s", options.MetadataScheme)
+	case !validMetadataUserAgent(options.MetadataUserAgent):
+		return nil, fmt.Errorf("Invalid metadata user agent: %s", options.MetadataUserAgent)
+	case options.MaxConcurrent < 0:
+		return nil, fmt.Errorf("Invalid maximum concurrency: %d", options.MaxConcurrent)
+	case options.MultipartPartSize < manager.MinUploadPartSize:
+		return nil, fmt.Errorf("Multipart part size must be at least %d bytes: %d", manager.MinUploadPartSize, options.MultipartPartSize)
+	case options.MultipartThreshold < 0 || options.MultipartThreshold > maxPutObjectSize:
+		return nil, fmt.Errorf("Multipart threshold must be at most %d bytes: %d", int64(maxPutObjectSize), options.MultipartThreshold)
+	case options.TimestampTolerance < 0:
+		return nil, fmt.Errorf("Invalid timestamp tolerance: %s", options.TimestampTolerance)
+	case !options.Expires.IsZero() && options.ExpiresIn != 0:
+		return nil, fmt.Errorf("Expires and ExpiresIn cannot be used together")
+	case options.ExpiresIn < 0:
+		return nil, fmt.Errorf("Invalid expiration duration: %s", options.ExpiresIn)
+	case options.ReadBufferSize < 0:
+		return nil, fmt.Errorf("Invalid read buffer size: %d", options.ReadBufferSize)
+	case options.CompressMinSize < 0:
+		return nil, fmt.Errorf("Invalid compress minimum size: %d", options.CompressMinSize)
+	case options.HashMinSize < 0:
+		return nil, fmt.Errorf("Invalid hash minimum size: %d", options.HashMinSize)
+	case options.BandwidthLimit < 0:
+		return nil, fmt.Errorf("Invalid bandwidth limit: %d", options.BandwidthLimit)
+	case options.MaxRPS < 0:
+		return nil, fmt.Errorf("Invalid maximum request rate: %g", options.MaxRPS)
+	case options.MultipartConcurrency < 0:
+		return nil, fmt.Errorf("Invalid multipart concurrency: %d", options.MultipartConcurrency)
+	case options.MultipartConcurrency > options.MaxConcurrent:
+		return nil, fmt.Errorf("Multipart concurrency (%d) cannot be greater than the maximum concurrency (%d)", options.MultipartConcurrency, options.MaxConcurrent)
+	case options.HashWorkers < 0:
+		return nil, fmt.Errorf("Invalid number of hash workers: %d", options.HashWorkers)
+	case options.WalkWorkers < 0:
+		return nil, fmt.Errorf("Invalid number of walk workers: %d", options.WalkWorkers)
+	case options.Xattrs && !xattrsSupported:
+		return nil, fmt.Errorf("Xattrs is only supported on Linux")
+	case options.MaxDepth < 0:
+		return nil, fmt.Errorf("Invalid maximum depth: %d", options.MaxDepth)
+	case options.Verbosity < VerbosityQuiet || options.Verbosity > VerbosityDebug:
+		return nil, fmt.Errorf("Invalid verbosity: %d", options.Verbosity)
+	case options.ProgressInterval < 0:
+		return nil, fmt.Errorf("Invalid progress interval: %s", options.ProgressInterval)
+	case options.FailureLimit < 0:
+		return nil, fmt.Errorf("Invalid failure limit: %d", options.FailureLimit)
+	case len(options.Tags) > maxObjectTags || (options.TagFromMetadata && len(options.Tags)+len(metadataTags) > maxObjectTags):
+		return nil, fmt.Errorf("Too many tags: S3 allows at most %d tags per object", maxObjectTags)
+	case options.Restore && (options.Delete || options.FilesFrom != ""):
+		return nil, fmt.Errorf("Delete and FilesFrom cannot be used with Restore")
+	case options.Report && (options.Delete || options.Restore):
+		return nil, fmt.Errorf("Report cannot be used with Delete or Restore")
+	case options.SizeOnly && (options.Checksum || options.Dedupe || options.VerifyParts):
+		return nil, fmt.Errorf("SizeOnly cannot be used with Checksum, Dedupe, or VerifyParts")
+	case options.NodeName != "" && !options.PrefixHostname:
+		return nil, fmt.Errorf("NodeName requires PrefixHostname")
+	case options.DeleteExcluded && !options.Delete:
+		return nil, fmt.Errorf("DeleteExcluded requires Delete")
+	case options.RetryFailed < 0:
+		return nil, fmt.Errorf("Invalid number of failure retries: %d", options.RetryFailed)
+	case options.RetryFailed > 0 && options.StopOnError:
+		return nil, fmt.Errorf("RetryFailed cannot be used with StopOnError")
+	case options.TrustMtime && options.HashCache == "":
+		return nil, fmt.Errorf("TrustMtime requires HashCache")
+	case options.PlanOut != "" && (!options.DryRun || options.Restore):
+		return nil, fmt.Errorf("PlanOut requires DryRun and cannot be used with Restore")
+	case options.List && (options.Restore || options.Report || options.Delete || options.FilesFrom != "" || options.DryRun):
+		return nil, fmt.Errorf("List cannot be used with Restore, Report, Delete, FilesFrom, or DryRun")
+	case options.ListLong && !options.List:
+		return nil, fmt.Errorf("ListLong requires List")
+	}
+
+	stc := &Cloner{
+		s3Client:             s3Client,
+		stdin:                options.Stdin,
+		stdout:               options.Stdout,
+		stderr:               options.Stderr,
+		maxConcurrent:        options.MaxConcurrent,
+		multipartPartSize:    options.MultipartPartSize,
+		multipartThreshold:   options.MultipartThreshold,
+		multipartConcurrency: options.MultipartConcurrency,
+		walkWorkers:          options.WalkWorkers,
+		hashWorkers:          options.HashWorkers,
+		maxDepth:             options.MaxDepth,
+		failureLimit:         options.FailureLimit,
+		stopOnError:          options.StopOnError,
+		storageClass:         options.StorageClass,
+		storageClassRules:    options.StorageClassRules,
+		extStorageClasses:    extStorageClasses,
+		acl:                  options.ACL,
+		encAlg:               options.EncryptionAlgorithm,
+		kmsKey:               options.KMSKey,
+		bucketKeyEnabled:     options.BucketKeyEnabled,
+		lockMode:             options.ObjectLockMode,
+		lockRetainUntil:      options.ObjectLockRetainUntil,
+		legalHold:            options.LegalHold,
+		ignoreTimestamps:     options.IgnoreTimestamps,
+		ignoreCtime:          options.IgnoreCtime,
+		ignoreContentType:    options.IgnoreContentType,
+		timestampTolerance:   options.TimestampTolerance,
+		modifiedSince:        options.ModifiedSince,
+		verifyAfterUpload:    options.VerifyAfterUpload,
+		retryChanged:         options.RetryChanged,
+		retryFailed:          options.RetryFailed,
+		verifyParts:          options.VerifyParts,
+		checksumAlgorithm:    options.ChecksumAlgorithm,
+		checksum:             options.Checksum,
+		sizeOnly:             options.SizeOnly,
+		links:                options.Links,
+		oneFileSystem:        options.OneFileSystem,
+		xattrs:               options.Xattrs,
+		hardLinks:            options.HardLinks,
+		hardLinkKeys:         make(map[inode]string),
+		hashCachePath:        options.HashCache,
+		trustMtime:           options.TrustMtime,
+		trustETag:            options.TrustETag,
+		etagMetadata:         options.ETagMetadata,
+		prelist:              options.Prelist,
+		filesFrom:            options.FilesFrom,
+		excludes:             options.Excludes,
+		respectIgnoreFiles:   options.IgnoreFiles,
+		tags:                 options.Tags,
+		uidMap:               options.UIDMap,
+		contentTypes:         options.ContentTypes,
+		defaultContentType:   options.DefaultContentType,
+		cacheControl:         options.CacheControl,
+		contentDisposition:   options.ContentDisposition,
+		expires:              options.Expires,
+		expiresIn:            options.ExpiresIn,
+		websiteRedirects:     options.WebsiteRedirects,
+		policy:               options.Policy,
+		hashAlgorithms:       options.HashAlgorithms,
+		hashMinSize:          options.HashMinSize,
+		readBuffers:          newReadBufferPool(options.ReadBufferSize),
+		compress:             options.Compress,
+		dedupe:               options.Dedupe,
+		compressMinSize:      options.CompressMinSize,
+		gidMap:               options.GIDMap,
+		tagFromMetadata:      options.TagFromMetadata,
+		metadataScheme:       metadataSchemes[options.MetadataScheme],
+		readMetadataSchemes:  readMetadataSchemes(options.MetadataScheme),
+		userAgent:            metadataUserAgentValue(options.MetadataUserAgent),
+		verbosity:            options.Verbosity,
+		logFormat:            options.LogFormat,
+		unicodeNormalize:     options.UnicodeNormalize,
+		dryRun:               options.DryRun,
+		deleteExtraneous:     options.Delete,
+		deleteExcluded:       options.DeleteExcluded,
+		planOut:              options.PlanOut,
+		report:               options.Report,
+		restore:              options.Restore,
+		list:                 options.List,
+		listLong:             options.ListLong,
+		progress:             options.Progress,
+		progressInterval:     options.ProgressInterval,
+	}
+
+	// Other output erases the progress line so it isn't left in the middle of a message.
+	if stc.progress && stc.logFormat != LogFormatJSON {
+		stc.progressLine = &progressLine{w: options.Stderr}
+		stc.stdout = &progressWriter{line: stc.progressLine, w: options.Stdout}
+		stc.stderr = &progressWriter{line: stc.progressLine, w: options.Stderr}
+	}
+
+	if stc.dedupe {
+		if !stc.computesHash("sha256") {
+			return nil, fmt.Errorf("Dedupe requires the sha256 hash algorithm")
+		}
+
+		stc.dedupeSources = make(map[string]dedupeSource)
+	}
+
+	if options.RetryFailed > 0 {
+		stc.failedPaths = make(map[string]bool)
+	}
+
+	if stc.planOut != "" {
+		stc.planReasons = make(map[string]string)
+	}
+
+	if options.RetryChanged {
+		stc.changedFiles = make(map[string]bool)
+	}
+
+	if options.ExcludeFrom != "" {
+		patterns, err := readExcludeFile(options.ExcludeFrom)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read exclude file %s: %w", options.ExcludeFrom, err)
+		}
+
+		stc.excludes = append(append([]string(nil), options.Excludes...), patterns...)
+	}
+
+	if options.Annotate && !options.Restore && !options.List {
+		if err := stc.setAnnotations(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.RequestPayer {
+		stc.requestPayer = s3Types.RequestPayerRequester
+	}
+
+	if options.BandwidthLimit > 0 {
+		stc.limiter = newBandwidthLimiter(options.BandwidthLimit)
+	}
+
+	if options.MaxRPS > 0 {
+		stc.requestLimiter = newRequestLimiter(options.MaxRPS)
+	}
+
+	if stc.encAlg == EncryptionSSEC {
+		keyMD5, err := parseSSECustomerKey(options.SSECustomerKey)
+		if err != nil {
+			return nil, err
+		}
+
+		stc.sseCustomerAlgorithm = sseCustomerAlgorithm
+		stc.sseCustomerKey = options.SSECustomerKey
+		stc.sseCustomerKeyMD5 = keyMD5
+	}
+
+	if options.PrefixHostname {
+		stc.nodeName = options.NodeName
+		if stc.nodeName == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("Unable to get the hostname: %w", err)
+			}
+
+			stc.nodeName = hostname
+		}
+
+		if stc.nodeName == "." || stc.nodeName == ".." || strings.Contains(stc.nodeName, "/") {
+			return nil, fmt.Errorf("Invalid node name: %s", stc.nodeName)
+		}
+	}
+
+	if options.Restore || options.List {
+		stc.baseDir = options.Destination
+		if err := stc.SetBucketAndPrefix(options.Source); err != nil {
+			return nil, fmt.Errorf("Source is %w: %s", ErrInvalidS3URL, options.Source)
+		}
+	} else {
+		stc.baseDir, stc.firstFilter = path.Split(filepath.ToSlash(options.Source))
+		if stc.firstFilter == "." {
+			stc.firstFilter = ""
+		}
+
+		if stc.baseDir == "" {
+			stc.baseDir = "."
+		}
+
+		// Reading a symlinked source directory through the link is unreliable, so use its target.
+		if fileinfo, err := os.Lstat(strings.TrimSuffix(stc.baseDir, "/")); err == nil && fileinfo.Mode()&fs.ModeSymlink != 0 {
+			if resolved, err := filepath.EvalSymlinks(stc.baseDir); err == nil {
+				stc.baseDir = filepath.ToSlash(resolved) + "/"
+			}
+		}
+
+		if isGlob(stc.firstFilter) {
+			if _, err := path.Match(stc.firstFilter, ""); err != nil {
+				return nil, fmt.Errorf("Invalid source pattern: %s", stc.firstFilter)
+			}
+
+			if options.FilesFrom != "" {
+				return nil, fmt.Errorf("FilesFrom cannot be used with a source pattern: %s", options.Source)
+			}
+		}
+
+		if stc.oneFileSystem {
+			root := stc.baseDir
+			if !isGlob(stc.firstFilter) {
+				root = path.Join(stc.baseDir, stc.firstFilter)
+			}
+
+			fileinfo, err := os.Stat(root)
+			if err != nil {
+				return nil, err
+			}
+
+			stc.rootDev = getFileStat(fileinfo).Dev
+		}
+
+		if err := stc.SetBucketAndPrefix(options.Destination); err != nil {
+			return nil, fmt.Errorf("Destination is %w: %s", ErrInvalidS3URL, options.Destination)
+		}
+	}
+
+	if options.RootSquash {
+		if err := stc.SetRootFromNFSNobody(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.RootUnsquash {
+		if err := stc.SetUnsquashFromNFSNobody(); err != nil {
+			return nil, err
+		}
+	}
+
+	if stc.deleteExtraneous || stc.report {
+		stc.visitedKeys = make(map[string]bool)
+	}
+
+	return stc, nil
+}
+
+// Clone copies the source tree to S3 or, with Restore, restores it from S3, and returns a summary
+// of the work done. With List, it only lists the objects. Failures of individual files and objects
+// are logged and counted in the summary; an error is only returned if the clone couldn't be carried
+// out at all. A Cloner can only be used for a single clone.
+func (stc *Cloner) Clone(ctx context.Context) (Summary, error) {
+	stc.ctx, stc.cancel = context.WithCancel(ctx)
+	defer stc.cancel()
+
+	if stc.requestLimiter != nil {
+		stc.s3Client = &rateLimitedClient{S3Interface: stc.s3Client, limiter: stc.requestLimiter}
+	}
+
+	if stc.failureLimit > 0 {
+		stc.s3Client = &circuitBreaker{S3Interface: stc.s3Client, stc: stc}
+	}
+
+	stc.sem = semaphore.NewWeighted(int64(stc.maxConcurrent))
+	stc.startTime = time.Now()
+	if stc.expiresIn != 0 {
+		stc.expires = stc.startTime.Add(stc.expiresIn).Truncate(time.Second)
+	}
+
+	if stc.progress {
+		stopProgress := stc.startProgress()
+		defer stopProgress()
+	}
+
+	if stc.restore {
+		err := stc.Restore(stc.baseDir)
+		if stc.abortErr != nil {
+			return stc.summary(), stc.abortErr
+		}
+
+		if err != nil {
+			return stc.summary(), fmt.Errorf("Restore failed: %w", err)
+		}
+
+		return stc.summary(), nil
+	}
+
+	if stc.list {
+		err := stc.List()
+		if stc.abortErr != nil {
+			return stc.summary(), stc.abortErr
+		}
+
+		if err != nil {
+			return stc.summary(), fmt.Errorf("Unable to list objects in s3://%s/%s: %w", stc.bucket, stc.prefix, err)
+		}
+
+		return stc.summary(), nil
+	}
+
+	sourceDir, err := os.OpenFile(stc.baseDir, os.O_RDONLY, 0)
+	if err != nil {
+		return stc.summary(), fmt.Errorf("Unable to open source directory %s: %w", stc.baseDir, err)
+	}
+	sourceDir.Close()
+
+	if stc.hashCachePath != "" {
+		stc.hashCache, err = loadHashCache(stc.hashCachePath)
+		if err != nil {
+			return stc.summary(), fmt.Errorf("Unable to read hash cache file %s: %w", stc.hashCachePath, err)
+		}
+	}
+
+	if stc.objectLockRequested() {
+		if err = stc.checkObjectLock(); err != nil {
+			return stc.summary(), err
+		}
+	}
+
+	if stc.prelist {
+		err = stc.Prelist(stc.treePrefix())
+		if stc.abortErr != nil {
+			return stc.summary(), stc.abortErr
+		}
+
+		if err != nil {
+			return stc.summary(), fmt.Errorf("Unable to list objects in s3://%s/%s: %w", stc.bucket, stc.treePrefix(), err)
+		}
+	}
+
+	if stc.filesFrom == "-" {
+		names, err := stc.readFileList(stc.stdin)
+		if err != nil {
+			return stc.summary(), fmt.Errorf("Unable to read the paths to copy from stdin: %w", err)
+		}
+
+		stc.WalkFiles(stc.firstFilter, names)
+	} else if stc.filesFrom != "" {
+		fd, err := os.Open(stc.filesFrom)
+		if err != nil {
+			return stc.summary(), fmt.Errorf("Unable to read files-from file %s: %w", stc.filesFrom, err)
+		}
+
+		names, err := stc.readFileList(fd)
+		fd.Close()
+		if err != nil {
+			return stc.summary(), fmt.Errorf("Unable to read files-from file %s: %w", stc.filesFrom, err)
+		}
+
+		stc.WalkFiles(stc.firstFilter, names)
+	} else {
+		err = stc.Walk(stc.firstFilter)
+		if stc.abortErr == nil && err != nil {
+			return stc.summary(), fmt.Errorf("walkDirectory failed: %w", err)
+		}
+	}
+
+	if stc.retryFailed > 0 && stc.abortErr == nil {
+		stc.retryFailedJobs()
+	}
+
+	if stc.abortErr != nil {
+		return stc.summary(), stc.abortErr
+	}
+
+	// If the caller's context ended, such as at a deadline, the walk is incomplete and nothing can
+	// be deleted or reported as only in S3. What was hashed is still worth keeping.
+	if err = ctx.Err(); err != nil {
+		stc.saveHashCache()
+		return stc.summary(), err
+	}
+
+	if stc.deleteExtraneous {
+		// Like rsync, don't delete anything if we couldn't read everything; a file we failed to
+		// examine would otherwise be removed from S3.
+		if atomic.LoadInt64(&stc.nFailed) > 0 {
+			stc.printf(stc.stderr, levelError, "Errors occurred during the walk; skipping deletion\n")
+		} else if stc.filesFrom != "" {
+			// Only listed paths that no longer exist are deleted.
+			for _, key := range stc.missingKeys {
+				stc.DeleteMissing(key)
+			}
+		} else {
+			stc.DeleteExtraneous(stc.treePrefix())
+		}
+	}
+
+	if stc.report && stc.filesFrom == "" {
+		stc.ReportOnlyInS3(stc.treePrefix())
+	}
+
+	stc.saveHashCache()
+
+	if stc.planOut != "" {
+		if err = stc.writePlan(); err != nil {
+			return stc.summary(), fmt.Errorf("Unable to write plan file %s: %w", stc.planOut, err)
+		}
+	}
+
+	summary := stc.summary()
+	if stc.report {
+		summary.Differences = stc.WriteReport()
+	}
+
+	return summary, nil
+}
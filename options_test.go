@@ -0,0 +1,96 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestNewClonerDefaults(t *testing.T) {
+	stc, err := NewCloner(Options{Source: "src/", Destination: "s3://hello/backup"}, newS3TestClient())
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	if stc.storageClass != s3Types.StorageClassStandard || stc.encAlg != s3Types.ServerSideEncryptionAes256 || stc.kmsKey != DefaultKMSKey {
+		t.Errorf("Unexpected defaults: %s %s %s", stc.storageClass, stc.encAlg, stc.kmsKey)
+	}
+
+	if stc.maxConcurrent != DefaultMaxConcurrent || stc.walkWorkers != DefaultMaxConcurrent {
+		t.Errorf("Unexpected concurrency defaults: %d %d", stc.maxConcurrent, stc.walkWorkers)
+	}
+
+	if stc.links != LinksSkip || stc.logFormat != LogFormatText {
+		t.Errorf("Unexpected defaults: %s %s", stc.links, stc.logFormat)
+	}
+
+	if stc.baseDir != "src/" || stc.firstFilter != "" || stc.bucket != "hello" || stc.prefix != "backup/" {
+		t.Errorf("Unexpected source and destination: %#v %#v %#v %#v", stc.baseDir, stc.firstFilter, stc.bucket, stc.prefix)
+	}
+}
+
+func TestNewClonerInvalidOptions(t *testing.T) {
+	for _, options := range []Options{
+		{Source: ".", Destination: "s3://hello", StorageClass: "REDUCED_REDUNDANCY"},
+		{Source: ".", Destination: "s3://hello", Links: "bogus"},
+		{Source: ".", Destination: "s3://hello", Report: true, Delete: true},
+		{Source: "s3://hello", Destination: ".", Restore: true, FilesFrom: "list.txt"},
+	} {
+		if _, err := NewCloner(options, newS3TestClient()); err == nil {
+			t.Errorf("Expected NewCloner to fail with %#v", options)
+		}
+	}
+
+	_, err := NewCloner(Options{Source: ".", Destination: "hello"}, newS3TestClient())
+	if !errors.Is(err, ErrInvalidS3URL) {
+		t.Errorf("Expected ErrInvalidS3URL: %v", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-clone-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+
+	var stdout, stderr bytes.Buffer
+	stc, err := NewCloner(Options{Source: tmpDir + "/", Destination: "s3://hello/backup", Verbose: true, Stdout: &stdout, Stderr: &stderr}, client)
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	summary, err := stc.Clone(context.Background())
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if summary.Uploaded != 1 || summary.BytesUploaded != 11 || summary.Failed != 0 {
+		t.Errorf("Unexpected summary: %#v", summary)
+	}
+
+	if _, found := bucket.Objects["backup/hello.txt"]; !found {
+		t.Errorf("Expected backup/hello.txt to be uploaded")
+	}
+
+	if !bytes.Contains(stderr.Bytes(), []byte("Uploaded "+tmpDir+"/hello.txt to s3://hello/backup/hello.txt")) {
+		t.Errorf("Expected upload message in stderr: %#v", stderr.String())
+	}
+
+	if !bytes.Contains(stdout.Bytes(), []byte("Comparing "+tmpDir+"/hello.txt")) {
+		t.Errorf("Expected comparison message in stdout: %#v", stdout.String())
+	}
+}
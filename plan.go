@@ -0,0 +1,84 @@
+package s3treeclone
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// The actions written to a -plan-out file.
+const (
+	planActionUpload         = "upload"
+	planActionUpdateMetadata = "update-metadata"
+	planActionDelete         = "delete"
+)
+
+// planEntry is an operation a dry run would have performed, written to the -plan-out file.
+type planEntry struct {
+	Action       string               `json:"action"`
+	Path         string               `json:"path,omitempty"`
+	Key          string               `json:"key"`
+	Reason       string               `json:"reason,omitempty"`
+	Bytes        int64                `json:"bytes"`
+	StorageClass s3Types.StorageClass `json:"storage_class,omitempty"`
+}
+
+// notePlanReason remembers why an object needs to be resynced, for its plan entry. The first
+// reason found for a key is kept.
+func (stc *Cloner) notePlanReason(key, reason string) {
+	if stc.planOut == "" || key == "" {
+		return
+	}
+
+	stc.planMutex.Lock()
+	defer stc.planMutex.Unlock()
+
+	if _, found := stc.planReasons[key]; !found {
+		stc.planReasons[key] = reason
+	}
+}
+
+// planUpload records, with -plan-out, that a dry run would have uploaded pathname to key.
+// storageClass is the default for the kind of object; a policy rule may override it.
+func (stc *Cloner) planUpload(action, pathname, key string, size int64, storageClass s3Types.StorageClass) {
+	if rule := stc.policyRule(key); rule != nil && rule.StorageClass != "" {
+		storageClass = rule.StorageClass
+	}
+
+	stc.addPlanEntry(planEntry{Action: action, Path: pathname, Key: key, Bytes: size, StorageClass: storageClass})
+}
+
+// addPlanEntry records a planned operation, with the reason noted for its key, if any.
+func (stc *Cloner) addPlanEntry(entry planEntry) {
+	if stc.planOut == "" {
+		return
+	}
+
+	stc.planMutex.Lock()
+	defer stc.planMutex.Unlock()
+
+	if entry.Reason == "" {
+		entry.Reason = stc.planReasons[entry.Key]
+	}
+
+	stc.plan = append(stc.plan, entry)
+}
+
+// writePlan writes the planned operations to the -plan-out file as a JSON array, sorted by key so
+// plans of the same tree can be compared.
+func (stc *Cloner) writePlan() error {
+	stc.planMutex.Lock()
+	defer stc.planMutex.Unlock()
+
+	entries := append([]planEntry{}, stc.plan...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(stc.planOut, append(data, '\n'), 0644)
+}
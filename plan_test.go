@@ -0,0 +1,83 @@
+package s3treeclone
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPlanOut(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-plan-out-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = os.Mkdir(tmpDir+"/src", 0755); err != nil {
+		t.Fatalf("Failed to create directory %s/src: %v", tmpDir, err)
+	}
+
+	for _, filename := range []string{"changed.txt", "gone.txt", "perms.txt", "same.txt"} {
+		if err = ioutil.WriteFile(tmpDir+"/src/"+filename, []byte("Hello world"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/src/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/src/", "s3://hello/backup"}, client, 0, nil, []byte("Objects uploaded:    4"))
+
+	if err = ioutil.WriteFile(tmpDir+"/src/new.txt", []byte("Hello"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/src/new.txt: %v", tmpDir, err)
+	}
+
+	if err = ioutil.WriteFile(tmpDir+"/src/changed.txt", []byte("Hello again, world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/src/changed.txt: %v", tmpDir, err)
+	}
+
+	if err = os.Chmod(tmpDir+"/src/perms.txt", 0600); err != nil {
+		t.Fatalf("Failed to chmod %s/src/perms.txt: %v", tmpDir, err)
+	}
+
+	if err = os.Remove(tmpDir + "/src/gone.txt"); err != nil {
+		t.Fatalf("Failed to remove %s/src/gone.txt: %v", tmpDir, err)
+	}
+
+	planPath := tmpDir + "/plan.json"
+	runExpect(t, []string{"-dry-run", "-delete", "-plan-out", planPath, tmpDir + "/src/", "s3://hello/backup"}, client, 0, nil, nil)
+
+	data, err := os.ReadFile(planPath)
+	if err != nil {
+		t.Fatalf("Failed to read plan file %s: %v", planPath, err)
+	}
+
+	var plan []planEntry
+	if err = json.Unmarshal(data, &plan); err != nil {
+		t.Fatalf("Invalid plan file %s: %v\n%s", planPath, err, data)
+	}
+
+	expected := []planEntry{
+		{Action: planActionUpload, Path: tmpDir + "/src/changed.txt", Key: "backup/changed.txt", Reason: "size mismatch", Bytes: 18, StorageClass: "STANDARD"},
+		{Action: planActionDelete, Key: "backup/gone.txt"},
+		{Action: planActionUpload, Path: tmpDir + "/src/new.txt", Key: "backup/new.txt", Reason: "missing in S3", Bytes: 5, StorageClass: "STANDARD"},
+		{Action: planActionUpdateMetadata, Path: tmpDir + "/src/perms.txt", Key: "backup/perms.txt", Reason: "permissions mismatch", StorageClass: "STANDARD"},
+	}
+
+	if len(plan) != len(expected) {
+		t.Fatalf("Expected %d plan entries, got %d:\n%s", len(expected), len(plan), data)
+	}
+
+	for i := range expected {
+		if plan[i] != expected[i] {
+			t.Errorf("Plan entry %d: expected %#v, got %#v", i, expected[i], plan[i])
+		}
+	}
+
+	// Nothing was changed by the dry run.
+	if _, found := client.Buckets["hello"].Objects["backup/new.txt"]; found {
+		t.Errorf("Dry run uploaded backup/new.txt")
+	}
+
+	runExpect(t, []string{"-plan-out", planPath, tmpDir + "/src/", "s3://hello/backup"}, client, 1, nil, []byte("-plan-out requires -dry-run"))
+}
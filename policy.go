@@ -0,0 +1,132 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// PolicyRule overrides how the objects for the paths matching Match are uploaded. Match is a
+// pattern like those of -exclude, matched against the path relative to the source directory.
+// Fields left empty keep the values given on the command line; Tags are added to those of -tag.
+type PolicyRule struct {
+	Match        string                  `json:"match"`
+	StorageClass s3Types.StorageClass    `json:"storage_class,omitempty"`
+	Tags         map[string]string       `json:"tags,omitempty"`
+	ACL          s3Types.ObjectCannedACL `json:"acl,omitempty"`
+	CacheControl string                  `json:"cache_control,omitempty"`
+	ContentType  string                  `json:"content_type,omitempty"`
+}
+
+// policyFile is the format of a -policy file.
+type policyFile struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// LoadPolicy reads the rules from a JSON policy file such as
+// {"rules": [{"match": "*.log", "storage_class": "GLACIER", "tags": {"kind": "log"}}]}.
+func LoadPolicy(filename string) ([]PolicyRule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var policy policyFile
+	if err = decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("Invalid policy file %s: %w", filename, err)
+	}
+
+	for _, rule := range policy.Rules {
+		if err = validatePolicyRule(rule); err != nil {
+			return nil, fmt.Errorf("Invalid policy file %s: %w", filename, err)
+		}
+	}
+
+	return policy.Rules, nil
+}
+
+// validatePolicyRule makes sure a rule has a usable pattern and values.
+func validatePolicyRule(rule PolicyRule) error {
+	if rule.Match == "" {
+		return fmt.Errorf("Policy rule must have a match pattern")
+	}
+
+	if _, err := path.Match(rule.Match, ""); err != nil {
+		return fmt.Errorf("Invalid policy rule pattern: %s", rule.Match)
+	}
+
+	switch {
+	case rule.StorageClass != "" && !validStorageClass(rule.StorageClass):
+		return fmt.Errorf("Invalid storage class in policy rule %s: %s", rule.Match, rule.StorageClass)
+	case rule.ACL != "" && !validACL(rule.ACL):
+		return fmt.Errorf("Invalid ACL in policy rule %s: %s", rule.Match, rule.ACL)
+	}
+
+	for key := range rule.Tags {
+		if key == "" {
+			return fmt.Errorf("Policy rule %s has a tag with an empty key", rule.Match)
+		}
+	}
+
+	return nil
+}
+
+// policyRule returns the first policy rule matching the path of the object with the given key, or
+// nil if none does.
+func (stc *Cloner) policyRule(key string) *PolicyRule {
+	relPath := strings.TrimSuffix(strings.TrimPrefix(key, stc.prefix), "/")
+	for i := range stc.policy {
+		if matchExcludePattern(stc.policy[i].Match, relPath) {
+			return &stc.policy[i]
+		}
+	}
+
+	return nil
+}
+
+// objectCacheControl returns the Cache-Control header for the object with the given key.
+func (stc *Cloner) objectCacheControl(key string) string {
+	if rule := stc.policyRule(key); rule != nil && rule.CacheControl != "" {
+		return rule.CacheControl
+	}
+
+	return stc.cacheControl
+}
+
+// setPutObjectPolicy applies the policy rule for the object, if any, to an upload. The key, storage
+// class, ACL, and tags must already be set.
+func (stc *Cloner) setPutObjectPolicy(poi *s3.PutObjectInput) {
+	rule := stc.policyRule(aws.ToString(poi.Key))
+	if rule == nil {
+		return
+	}
+
+	if rule.StorageClass != "" {
+		poi.StorageClass = rule.StorageClass
+	}
+
+	if rule.ACL != "" {
+		poi.ACL = rule.ACL
+	}
+
+	if len(rule.Tags) > 0 {
+		tags, _ := url.ParseQuery(aws.ToString(poi.Tagging))
+		for key, value := range rule.Tags {
+			tags.Set(key, value)
+		}
+
+		tagging := tags.Encode()
+		poi.Tagging = &tagging
+	}
+}
@@ -0,0 +1,88 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestPolicy(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-policy-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = os.MkdirAll(tmpDir+"/src/logs", 0755); err != nil {
+		t.Fatalf("Failed to create directory %s/src/logs: %v", tmpDir, err)
+	}
+
+	for _, filename := range []string{"index.html", "logs/app.log", "logs/app.txt"} {
+		if err = ioutil.WriteFile(tmpDir+"/src/"+filename, []byte("Hello world"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/src/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	policyPath := tmpDir + "/policy.json"
+	policy := `{"rules": [
+		{"match": "*.log", "storage_class": "GLACIER", "tags": {"kind": "log"}, "acl": "private"},
+		{"match": "logs/**", "storage_class": "STANDARD_IA", "cache_control": "no-cache"},
+		{"match": "*.html", "content_type": "text/html; charset=utf-8", "cache_control": "max-age=60"}
+	]}`
+	if err = ioutil.WriteFile(policyPath, []byte(policy), 0644); err != nil {
+		t.Fatalf("Failed to write file %s: %v", policyPath, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	args := []string{"-policy", policyPath, "-tag", "team=web", tmpDir + "/src/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    3"))
+
+	inputs := make(map[string]int)
+	for i, input := range client.putInputs {
+		inputs[aws.ToString(input.Key)] = i
+	}
+
+	for _, tc := range []struct {
+		key          string
+		storageClass s3Types.StorageClass
+		tags         string
+		acl          s3Types.ObjectCannedACL
+		cacheControl string
+		contentType  string
+	}{
+		{"index.html", s3Types.StorageClassStandard, "team=web", "", "max-age=60", "text/html; charset=utf-8"},
+		{"logs/", s3Types.StorageClassStandardIa, "team=web", "", "no-cache", ""},
+		{"logs/app.log", s3Types.StorageClassGlacier, "kind=log&team=web", s3Types.ObjectCannedACLPrivate, "", "text/plain; charset=utf-8"},
+		{"logs/app.txt", s3Types.StorageClassStandardIa, "team=web", "", "no-cache", "text/plain; charset=utf-8"},
+	} {
+		i, found := inputs[tc.key]
+		if !found {
+			t.Errorf("Expected %s to be uploaded", tc.key)
+			continue
+		}
+
+		input := client.putInputs[i]
+		tags, _ := url.ParseQuery(aws.ToString(input.Tagging))
+		if input.StorageClass != tc.storageClass || tags.Encode() != tc.tags || input.ACL != tc.acl || aws.ToString(input.CacheControl) != tc.cacheControl {
+			t.Errorf("Unexpected upload of %s: storage class %s, tags %s, ACL %#v, Cache-Control %#v", tc.key, input.StorageClass, tags.Encode(), input.ACL, aws.ToString(input.CacheControl))
+		}
+
+		if tc.contentType != "" && aws.ToString(input.ContentType) != tc.contentType {
+			t.Errorf("Expected %s to be uploaded as %s: %s", tc.key, tc.contentType, aws.ToString(input.ContentType))
+		}
+	}
+
+	// The objects match the policy, so nothing is uploaded again.
+	runExpect(t, args, client, 0, nil, []byte("Objects skipped:     4"))
+
+	if err = ioutil.WriteFile(policyPath, []byte(`{"rules": [{"match": "*.log", "storage_class": "FAST"}]}`), 0644); err != nil {
+		t.Fatalf("Failed to write file %s: %v", policyPath, err)
+	}
+
+	runExpect(t, args, client, 1, nil, []byte("Invalid storage class in policy rule *.log: FAST"))
+}
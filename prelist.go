@@ -0,0 +1,47 @@
+package s3treeclone
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// listedObject is what ListObjectsV2 tells us about an object. It doesn't include the user
+// metadata, so HeadObject is still needed to compare ownership, permissions, and hashes.
+type listedObject struct {
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// Prelist pages through ListObjectsV2 for the prefix and records every object. HandleFile then
+// skips HeadObject for objects that don't exist or whose size differs.
+func (stc *Cloner) Prelist(prefix string) error {
+	listing := make(map[string]listedObject)
+
+	paginator := s3.NewListObjectsV2Paginator(stc.s3Client, &s3.ListObjectsV2Input{Bucket: &stc.bucket, Prefix: &prefix, RequestPayer: stc.requestPayer})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(stc.ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			listing[key] = listedObject{
+				Size:         object.Size,
+				ETag:         aws.ToString(object.ETag),
+				LastModified: aws.ToTime(object.LastModified),
+			}
+		}
+	}
+
+	stc.listing = listing
+	return nil
+}
@@ -0,0 +1,98 @@
+package s3treeclone
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// headCountingClient counts the HeadObject calls made.
+type headCountingClient struct {
+	*s3TestClient
+	nHeadObject int64
+}
+
+func (c *headCountingClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	atomic.AddInt64(&c.nHeadObject, 1)
+	return c.s3TestClient.HeadObject(ctx, input, opts...)
+}
+
+func TestPrelistMap(t *testing.T) {
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+
+	// Enough objects to require more than one page.
+	for i := 0; i < 1500; i++ {
+		bucket.Objects[fmt.Sprintf("prefix/%04d", i)] = &s3TestObject{ContentLength: int64(i)}
+	}
+	bucket.Objects["other/0000"] = &s3TestObject{ContentLength: 5}
+
+	stc := &Cloner{ctx: context.Background(), s3Client: client, bucket: "hello"}
+	if err := stc.Prelist("prefix/"); err != nil {
+		t.Fatalf("Prelist failed: %v", err)
+	}
+
+	if len(stc.listing) != 1500 {
+		t.Errorf("Expected 1500 listed objects: %d", len(stc.listing))
+	}
+
+	if listed, found := stc.listing["prefix/1234"]; !found || listed.Size != 1234 {
+		t.Errorf("Expected prefix/1234 to be listed with size 1234: %v", listed)
+	}
+
+	if _, found := stc.listing["other/0000"]; found {
+		t.Errorf("Expected other/0000 not to be listed")
+	}
+}
+
+func TestPrelist(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-prelist-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"same.txt", "resized.txt", "new.txt"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := &headCountingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	delete(bucket.Objects, "new.txt")
+	err = ioutil.WriteFile(tmpDir+"/resized.txt", []byte("hello, world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/resized.txt: %v", tmpDir, err)
+	}
+
+	// Only same.txt exists with a matching size, so it's the only one that needs HeadObject.
+	atomic.StoreInt64(&client.nHeadObject, 0)
+	runExpect(t, []string{"-prelist", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Content size mismatch: s3://hello/resized.txt has size 5"))
+
+	if n := atomic.LoadInt64(&client.nHeadObject); n != 1 {
+		t.Errorf("Expected 1 HeadObject call with -prelist: %d", n)
+	}
+
+	for key, size := range map[string]int64{"same.txt": 5, "resized.txt": 12, "new.txt": 5} {
+		if obj, found := bucket.Objects[key]; !found || obj.ContentLength != size {
+			t.Errorf("Expected %s to be uploaded with size %d", key, size)
+		}
+	}
+
+	// Without -prelist, every file needs HeadObject.
+	atomic.StoreInt64(&client.nHeadObject, 0)
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	if n := atomic.LoadInt64(&client.nHeadObject); n != 3 {
+		t.Errorf("Expected 3 HeadObject calls without -prelist: %d", n)
+	}
+}
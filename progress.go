@@ -0,0 +1,122 @@
+package s3treeclone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProgressInterval is how often the -progress line is refreshed.
+const DefaultProgressInterval = time.Second
+
+// progressLine keeps a single status line at the bottom of stderr. Other output is written through
+// a progressWriter, which erases the line first; the line is redrawn on the next tick.
+type progressLine struct {
+	mutex sync.Mutex
+	w     io.Writer
+	width int // The length of the line currently shown, or zero if none is
+}
+
+// progressWriter writes to w, erasing the progress line first so the two aren't interleaved.
+type progressWriter struct {
+	line *progressLine
+	w    io.Writer
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.line.mutex.Lock()
+	defer pw.line.mutex.Unlock()
+
+	pw.line.clear()
+	return pw.w.Write(p)
+}
+
+// clear erases the line if it is shown. The mutex must be held.
+func (pl *progressLine) clear() {
+	if pl.width > 0 {
+		io.WriteString(pl.w, "\r"+strings.Repeat(" ", pl.width)+"\r")
+		pl.width = 0
+	}
+}
+
+// show replaces the line with the given text.
+func (pl *progressLine) show(text string) {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	pl.clear()
+	io.WriteString(pl.w, text)
+	pl.width = len(text)
+}
+
+// startProgress starts a goroutine that writes the transfer counts to stderr every progress
+// interval, and returns a function that stops it. In text format, the counts are shown on a single
+// line that is overwritten on each tick and erased when stopped; in json format, each tick writes
+// a progress event.
+func (stc *Cloner) startProgress() func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(stc.progressInterval)
+		defer ticker.Stop()
+
+		lastBytes := int64(0)
+		lastTick := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				bytesUploaded := atomic.LoadInt64(&stc.bytesUploaded)
+				rate := float64(bytesUploaded-lastBytes) / now.Sub(lastTick).Seconds()
+				lastBytes, lastTick = bytesUploaded, now
+				stc.writeProgress(rate)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+
+		if stc.progressLine != nil {
+			stc.progressLine.mutex.Lock()
+			stc.progressLine.clear()
+			stc.progressLine.mutex.Unlock()
+		}
+	}
+}
+
+// writeProgress writes the current counts and the given upload rate, in bytes per second.
+func (stc *Cloner) writeProgress(rate float64) {
+	processed := atomic.LoadInt64(&stc.nObjects)
+	uploaded := atomic.LoadInt64(&stc.nUploaded)
+	skipped := atomic.LoadInt64(&stc.nSkipped)
+	bytesUploaded := atomic.LoadInt64(&stc.bytesUploaded)
+
+	if stc.logFormat == LogFormatJSON {
+		line, err := json.Marshal(map[string]interface{}{
+			"event":             eventProgress,
+			"objects_processed": processed,
+			"objects_uploaded":  uploaded,
+			"objects_skipped":   skipped,
+			"bytes_uploaded":    bytesUploaded,
+			"bytes_per_second":  rate,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		stc.stderr.Write(append(line, '\n'))
+		return
+	}
+
+	stc.progressLine.show(fmt.Sprintf("Processed %d, uploaded %d, skipped %d, %d bytes (%.0f bytes/sec)", processed, uploaded, skipped, bytesUploaded, rate))
+}
@@ -0,0 +1,86 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressStartStop(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-progress-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"a.txt", "b.txt", "c.txt"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	stderr := &bytes.Buffer{}
+	stc, err := NewCloner(Options{Source: tmpDir + "/", Destination: "s3://hello", Verbose: true, Progress: true, ProgressInterval: time.Millisecond, Stderr: stderr}, client)
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	done := make(chan Summary)
+	go func() {
+		summary, err := stc.Clone(context.Background())
+		if err != nil {
+			t.Errorf("Clone failed: %v", err)
+		}
+
+		done <- summary
+	}()
+
+	select {
+	case summary := <-done:
+		if summary.Uploaded != 3 {
+			t.Errorf("Expected 3 objects uploaded: %#v", summary)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Clone with progress did not finish")
+	}
+
+	// Once stopped, the progress line should have been erased.
+	if output := stderr.String(); strings.Contains(output, "Processed") && !strings.HasSuffix(output, "\r") {
+		t.Errorf("Expected the progress line to be erased: %#v", output)
+	}
+
+	// Run the ticker long enough to draw the line at least once.
+	stop := stc.startProgress()
+	time.Sleep(20 * time.Millisecond)
+	stop()
+
+	output := stderr.String()
+	if !strings.Contains(output, "uploaded 3, skipped 0, 15 bytes") {
+		t.Errorf("Expected the progress line in stderr: %#v", output)
+	}
+
+	if !strings.HasSuffix(output, "\r") {
+		t.Errorf("Expected the progress line to be erased: %#v", output)
+	}
+}
+
+func TestProgressJSON(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	stc, err := NewCloner(Options{Source: ".", Destination: "s3://hello", LogFormat: LogFormatJSON, Progress: true, Stderr: stderr}, nil)
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	stc.writeProgress(100)
+	if output := stderr.String(); !strings.HasPrefix(output, `{"bytes_per_second":100,`) || !strings.Contains(output, `"event":"progress"`) {
+		t.Errorf("Unexpected progress event: %#v", output)
+	}
+}
@@ -0,0 +1,250 @@
+package s3treeclone
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// bucketRegionHeader is the response header in which S3 reports the region a bucket is in.
+const bucketRegionHeader = "X-Amz-Bucket-Region"
+
+// redirectRegion returns the region S3 redirected a request to because the bucket is in a
+// different region than the client's, or "" if err isn't such a redirect.
+func redirectRegion(err error) string {
+	var responseError *awshttp.ResponseError
+	if !errors.As(err, &responseError) || responseError.Response == nil || responseError.Response.Response == nil {
+		return ""
+	}
+
+	// HEAD requests get a bare 301; others say why in the error code.
+	var apiError smithy.APIError
+	if responseError.HTTPStatusCode() != http.StatusMovedPermanently && !(errors.As(err, &apiError) && (apiError.ErrorCode() == "PermanentRedirect" || apiError.ErrorCode() == "AuthorizationHeaderMalformed")) {
+		return ""
+	}
+
+	return responseError.Response.Header.Get(bucketRegionHeader)
+}
+
+// newRegionalClient creates an S3 client for the given region from the given config and S3 client
+// options.
+func newRegionalClient(ctx context.Context, region string, configOptions []func(*config.LoadOptions) error, s3Options []func(*s3.Options)) (*s3.Client, error) {
+	configOptions = append(configOptions[:len(configOptions):len(configOptions)], config.WithRegion(region))
+	awsConfig, err := config.LoadDefaultConfig(ctx, configOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkFIPSRegion(ctx, awsConfig); err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(awsConfig, s3Options...), nil
+}
+
+// FollowRegionRedirects makes the Cloner switch to a client for the bucket's region, created from
+// the given config and S3 client options, the first time S3 redirects a request there because the
+// client is for another region. The redirected request is retried with the new client.
+func (stc *Cloner) FollowRegionRedirects(configOptions []func(*config.LoadOptions) error, s3Options []func(*s3.Options)) {
+	stc.followRegionRedirects(func(ctx context.Context, region string) (S3Interface, error) {
+		return newRegionalClient(ctx, region, configOptions, s3Options)
+	})
+}
+
+// followRegionRedirects wraps the S3 client in a regionRedirector that creates clients with
+// newClient.
+func (stc *Cloner) followRegionRedirects(newClient func(ctx context.Context, region string) (S3Interface, error)) {
+	stc.s3Client = &regionRedirector{stc: stc, client: stc.s3Client, newClient: newClient}
+}
+
+// regionRedirector wraps an S3 client, replacing it with one for the bucket's region the first time
+// S3 redirects a request there. Only one switch is made so clients can't flap between regions.
+type regionRedirector struct {
+	stc       *Cloner
+	newClient func(ctx context.Context, region string) (S3Interface, error)
+	mutex     sync.Mutex
+	client    S3Interface
+	switched  bool
+	region    string // The region switched to, if the switch succeeded.
+}
+
+// current returns the client to send requests with.
+func (rr *regionRedirector) current() S3Interface {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	return rr.client
+}
+
+// redirect returns the client to retry a request with if it failed because the bucket is in
+// another region, switching to a client for that region if this is the first redirect. It returns
+// nil if the request shouldn't be retried.
+func (rr *regionRedirector) redirect(ctx context.Context, err error) S3Interface {
+	region := redirectRegion(err)
+	if region == "" {
+		return nil
+	}
+
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if !rr.switched {
+		rr.switched = true
+
+		client, err := rr.newClient(ctx, region)
+		if err != nil {
+			rr.stc.printf(rr.stc.stderr, levelError, "Unable to create an S3 client for %s: %v\n", region, err)
+			return nil
+		}
+
+		rr.stc.printf(rr.stc.stderr, levelInfo, "Bucket %s is in %s; switching to it\n", rr.stc.bucket, region)
+		rr.client = client
+		rr.region = region
+	}
+
+	// Requests sent before the switch are redirected too, and can just be retried.
+	if region != rr.region {
+		return nil
+	}
+
+	return rr.client
+}
+
+// rewind seeks a request body back to its start so the request can be retried. It returns false
+// if the body can't be rewound.
+func rewind(body io.Reader) bool {
+	if body == nil {
+		return true
+	}
+
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return false
+	}
+
+	_, err := seeker.Seek(0, io.SeekStart)
+	return err == nil
+}
+
+func (rr *regionRedirector) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	output, err := rr.current().AbortMultipartUpload(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.AbortMultipartUpload(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	output, err := rr.current().CompleteMultipartUpload(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.CompleteMultipartUpload(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) CopyObject(ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	output, err := rr.current().CopyObject(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.CopyObject(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	output, err := rr.current().CreateMultipartUpload(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.CreateMultipartUpload(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	output, err := rr.current().DeleteObjects(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.DeleteObjects(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, opts ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	output, err := rr.current().GetBucketLocation(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.GetBucketLocation(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	output, err := rr.current().GetObject(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.GetObject(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) GetObjectAttributes(ctx context.Context, input *s3.GetObjectAttributesInput, opts ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	output, err := rr.current().GetObjectAttributes(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.GetObjectAttributes(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) GetObjectLockConfiguration(ctx context.Context, input *s3.GetObjectLockConfigurationInput, opts ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	output, err := rr.current().GetObjectLockConfiguration(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.GetObjectLockConfiguration(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	output, err := rr.current().HeadObject(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.HeadObject(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	output, err := rr.current().ListObjectsV2(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil {
+		output, err = client.ListObjectsV2(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	output, err := rr.current().PutObject(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil && rewind(input.Body) {
+		output, err = client.PutObject(ctx, input, opts...)
+	}
+
+	return output, err
+}
+
+func (rr *regionRedirector) UploadPart(ctx context.Context, input *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	output, err := rr.current().UploadPart(ctx, input, opts...)
+	if client := rr.redirect(ctx, err); client != nil && rewind(input.Body) {
+		output, err = client.UploadPart(ctx, input, opts...)
+	}
+
+	return output, err
+}
@@ -0,0 +1,120 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// makeRedirectError returns the error S3 gives when a request is sent to the wrong region for the
+// bucket.
+func makeRedirectError(operation, errorCode, region string) error {
+	err := makeS3Error(operation, 301, "Moved Permanently", errorCode, "The bucket you are attempting to access must be addressed using the specified endpoint.")
+
+	var responseError *awshttp.ResponseError
+	if errors.As(err, &responseError) {
+		responseError.Response.Header.Set(bucketRegionHeader, region)
+	}
+
+	return err
+}
+
+// wrongRegionClient redirects every HeadObject and PutObject request to another region.
+type wrongRegionClient struct {
+	*s3TestClient
+	region string
+}
+
+func (c *wrongRegionClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return nil, makeRedirectError("HeadObject", "MovedPermanently", c.region)
+}
+
+func (c *wrongRegionClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if input.Body != nil {
+		ioutil.ReadAll(input.Body)
+	}
+
+	return nil, makeRedirectError("PutObject", "PermanentRedirect", c.region)
+}
+
+func TestRedirectRegion(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		region string
+	}{
+		{makeRedirectError("HeadObject", "MovedPermanently", "eu-west-1"), "eu-west-1"},
+		{makeRedirectError("PutObject", "PermanentRedirect", "us-west-2"), "us-west-2"},
+		{makeS3Error("PutObject", 403, "Forbidden", "AccessDenied", "Access Denied"), ""},
+		{errors.New("connection reset"), ""},
+		{nil, ""},
+	} {
+		if region := redirectRegion(tc.err); region != tc.region {
+			t.Errorf("redirectRegion(%v): expected %#v, got %#v", tc.err, tc.region, region)
+		}
+	}
+}
+
+func TestFollowRegionRedirects(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-region-redirect-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err = ioutil.WriteFile(tmpDir+"/"+name, []byte("hello, "+name), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, name, err)
+		}
+	}
+
+	// The bucket is only reachable through a client for its region.
+	client := &wrongRegionClient{s3TestClient: newS3TestClient(), region: "eu-west-1"}
+	regionalClient := newS3TestClient()
+	bucket := regionalClient.createBucket("hello")
+
+	var stderr bytes.Buffer
+	stc, err := NewCloner(Options{Source: tmpDir + "/", Destination: "s3://hello", WalkWorkers: 1, Stderr: &stderr}, client)
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	var nClients int64
+	stc.followRegionRedirects(func(ctx context.Context, region string) (S3Interface, error) {
+		atomic.AddInt64(&nClients, 1)
+		if region != "eu-west-1" {
+			t.Errorf("Expected a client for eu-west-1, got %s", region)
+		}
+
+		return regionalClient, nil
+	})
+
+	summary, err := stc.Clone(context.Background())
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if summary.Failed != 0 || summary.Uploaded != 2 {
+		t.Errorf("Expected 2 uploads and no failures, got %d and %d: %s", summary.Uploaded, summary.Failed, stderr.String())
+	}
+
+	if nClients != 1 || strings.Count(stderr.String(), "Bucket hello is in eu-west-1; switching to it") != 1 {
+		t.Errorf("Expected one switch to eu-west-1, got %d clients: %s", nClients, stderr.String())
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		object := bucket.Objects[name]
+		if object == nil {
+			t.Errorf("Expected %s to be uploaded to the bucket's region", name)
+		} else if string(object.Body) != "hello, "+name {
+			t.Errorf("Unexpected body for %s after the retry: %#v", name, string(object.Body))
+		}
+	}
+}
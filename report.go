@@ -0,0 +1,90 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Categories of differences written by -report.
+const (
+	diffMissing  = "missing in S3"
+	diffContent  = "content differs"
+	diffMetadata = "metadata differs"
+	diffOnlyInS3 = "only in S3"
+)
+
+// reportExitCode is returned by -report when differences were found.
+const reportExitCode = 3
+
+// diffCategoryOrder is the order categories are written in.
+var diffCategoryOrder = map[string]int{diffMissing: 0, diffContent: 1, diffMetadata: 2, diffOnlyInS3: 3}
+
+// reportEntry is a single difference between the local tree and S3.
+type reportEntry struct {
+	category string
+	key      string
+}
+
+// classifyDifference categorizes how a local file differs from its S3 object, given the results of
+// the comparisons made by HandleFile. Differences in size or content take precedence over
+// differences in metadata. An empty string is returned if the file and object match.
+func classifyDifference(exists, sizeEqual, contentEqual, metadataEqual bool) string {
+	switch {
+	case !exists:
+		return diffMissing
+	case !sizeEqual || !contentEqual:
+		return diffContent
+	case !metadataEqual:
+		return diffMetadata
+	default:
+		return ""
+	}
+}
+
+// recordDifference adds a difference to the report. Empty categories are ignored.
+func (stc *Cloner) recordDifference(category, key string) {
+	if category == "" {
+		return
+	}
+
+	stc.reportMutex.Lock()
+	stc.reportEntries = append(stc.reportEntries, reportEntry{category: category, key: key})
+	stc.reportMutex.Unlock()
+}
+
+// reportObject records the difference, if any, between a local file and its S3 object.
+func (stc *Cloner) reportObject(key string, exists, sizeEqual, contentEqual, metadataEqual bool) {
+	stc.recordDifference(classifyDifference(exists, sizeEqual, contentEqual, metadataEqual), key)
+}
+
+// ReportOnlyInS3 records every object under the prefix that wasn't visited during the walk.
+func (stc *Cloner) ReportOnlyInS3(prefix string) {
+	keys, err := stc.unvisitedKeys(prefix)
+	if err != nil {
+		stc.recordFailure(PathError{Key: prefix, Err: err})
+		return
+	}
+
+	for _, key := range keys {
+		stc.recordDifference(diffOnlyInS3, key)
+	}
+}
+
+// WriteReport writes the recorded differences, one per line, as the category and S3 URL separated
+// by a tab. It returns the number of differences.
+func (stc *Cloner) WriteReport() int {
+	entries := stc.reportEntries
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].category != entries[j].category {
+			return diffCategoryOrder[entries[i].category] < diffCategoryOrder[entries[j].category]
+		}
+
+		return entries[i].key < entries[j].key
+	})
+
+	for _, entry := range entries {
+		fmt.Fprintf(stc.stdout, "%s\ts3://%s/%s\n", entry.category, stc.bucket, entry.key)
+	}
+
+	return len(entries)
+}
@@ -0,0 +1,78 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestClassifyDifference(t *testing.T) {
+	cases := []struct {
+		exists        bool
+		sizeEqual     bool
+		contentEqual  bool
+		metadataEqual bool
+		expected      string
+	}{
+		{false, false, true, false, diffMissing},
+		{true, false, true, false, diffContent},
+		{true, true, false, true, diffContent},
+		{true, false, false, false, diffContent},
+		{true, true, true, false, diffMetadata},
+		{true, true, true, true, ""},
+	}
+
+	for _, c := range cases {
+		if result := classifyDifference(c.exists, c.sizeEqual, c.contentEqual, c.metadataEqual); result != c.expected {
+			t.Errorf("classifyDifference(%v, %v, %v, %v): expected %#v, got %#v", c.exists, c.sizeEqual, c.contentEqual, c.metadataEqual, c.expected, result)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-report-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"same.txt", "content.txt", "metadata.txt"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+	runExpect(t, []string{"-report", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	err = ioutil.WriteFile(tmpDir+"/content.txt", []byte("hello, world"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/content.txt: %v", tmpDir, err)
+	}
+
+	err = os.Chmod(tmpDir+"/metadata.txt", 0600)
+	if err != nil {
+		t.Fatalf("Failed to chmod %s/metadata.txt: %v", tmpDir, err)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/new.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/new.txt: %v", tmpDir, err)
+	}
+
+	bucket.Objects["gone.txt"] = &s3TestObject{ContentLength: 5}
+
+	nObjects := len(bucket.Objects)
+	expected := "missing in S3\ts3://hello/new.txt\n" +
+		"content differs\ts3://hello/content.txt\n" +
+		"metadata differs\ts3://hello/metadata.txt\n" +
+		"only in S3\ts3://hello/gone.txt\n"
+	runExpect(t, []string{"-report", tmpDir + "/", "s3://hello"}, client, reportExitCode, []byte(expected), nil)
+
+	if len(bucket.Objects) != nObjects {
+		t.Errorf("Expected -report not to modify the bucket")
+	}
+}
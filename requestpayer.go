@@ -0,0 +1,44 @@
+package s3treeclone
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// multipartClient returns the client for the multipart uploader. The uploader only copies
+// RequestPayer from the PutObjectInput to the CreateMultipartUpload request, so the requests
+// uploading parts and completing or aborting the upload have it added here.
+func (stc *Cloner) multipartClient() S3Interface {
+	if stc.requestPayer == "" {
+		return stc.s3Client
+	}
+
+	return &requestPayerClient{S3Interface: stc.s3Client, requestPayer: stc.requestPayer}
+}
+
+// requestPayerClient wraps an S3 client, setting RequestPayer on the multipart upload requests the
+// uploader doesn't set it on.
+type requestPayerClient struct {
+	S3Interface
+	requestPayer s3Types.RequestPayer
+}
+
+func (c *requestPayerClient) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	withPayer := *input
+	withPayer.RequestPayer = c.requestPayer
+	return c.S3Interface.AbortMultipartUpload(ctx, &withPayer, opts...)
+}
+
+func (c *requestPayerClient) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	withPayer := *input
+	withPayer.RequestPayer = c.requestPayer
+	return c.S3Interface.CompleteMultipartUpload(ctx, &withPayer, opts...)
+}
+
+func (c *requestPayerClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	withPayer := *input
+	withPayer.RequestPayer = c.requestPayer
+	return c.S3Interface.UploadPart(ctx, &withPayer, opts...)
+}
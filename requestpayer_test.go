@@ -0,0 +1,79 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestRequestPayer(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-request-payer-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	if err = os.Mkdir(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", srcDir, err)
+	}
+
+	if err = ioutil.WriteFile(srcDir+"/small.txt", []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/small.txt: %v", srcDir, err)
+	}
+
+	// Large enough to be uploaded in parts.
+	if err = ioutil.WriteFile(srcDir+"/large.bin", bytes.Repeat([]byte("x"), 6<<20), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/large.bin: %v", srcDir, err)
+	}
+
+	for _, requestPayer := range []bool{true, false} {
+		var flags []string
+		var expected s3Types.RequestPayer
+		if requestPayer {
+			flags = []string{"-request-payer"}
+			expected = s3Types.RequestPayerRequester
+		}
+
+		client := &recordingClient{s3TestClient: newS3TestClient()}
+		bucket := client.createBucket("hello")
+		bucket.Objects["stale.txt"] = &s3TestObject{Body: []byte("stale"), ContentLength: 5}
+
+		runExpect(t, append(flags, "-delete", srcDir+"/", "s3://hello"), client, 0, nil, nil)
+		runExpect(t, append(flags, "-restore", "s3://hello", tmpDir+"/restored"), client, 0, nil, nil)
+
+		for _, operation := range []string{"HeadObject", "PutObject", "CreateMultipartUpload", "UploadPart", "CompleteMultipartUpload", "ListObjectsV2", "DeleteObjects", "GetObject"} {
+			payers := client.requestPayers[operation]
+			if len(payers) == 0 {
+				t.Errorf("Expected %s to be called", operation)
+			}
+
+			for _, payer := range payers {
+				if payer != expected {
+					t.Errorf("Expected RequestPayer %#v on %s with -request-payer=%v, got %#v", expected, operation, requestPayer, payer)
+					break
+				}
+			}
+		}
+
+		os.RemoveAll(tmpDir + "/restored")
+	}
+
+	// The uploader doesn't set RequestPayer when aborting an upload, so it is added.
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	stc := &Cloner{s3Client: client, requestPayer: s3Types.RequestPayerRequester}
+	_, err = stc.multipartClient().AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{Bucket: aws.String("hello"), Key: aws.String("large.bin"), UploadId: aws.String("00000000")})
+	if err != nil {
+		t.Fatalf("AbortMultipartUpload failed: %v", err)
+	}
+
+	if payers := client.requestPayers["AbortMultipartUpload"]; len(payers) != 1 || payers[0] != s3Types.RequestPayerRequester {
+		t.Errorf("Expected RequestPayer to be set on AbortMultipartUpload: %v", payers)
+	}
+}
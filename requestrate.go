@@ -0,0 +1,126 @@
+package s3treeclone
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/time/rate"
+)
+
+// newRequestLimiter returns a limiter allowing the given number of S3 requests per second. The
+// burst is a single request so requests are spread evenly instead of arriving in bunches.
+func newRequestLimiter(requestsPerSecond float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+}
+
+// rateLimitedClient wraps an S3 client, waiting on a limiter shared by every request before each
+// one is made. This is independent of -max-concurrent, which limits how many requests are in
+// flight but not how often they're made.
+type rateLimitedClient struct {
+	S3Interface
+	limiter *rate.Limiter
+}
+
+func (rlc *rateLimitedClient) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput, opts ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.AbortMultipartUpload(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.CompleteMultipartUpload(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) CopyObject(ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.CopyObject(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, opts ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.CreateMultipartUpload(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.DeleteObjects(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, opts ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.GetBucketLocation(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.GetObject(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) GetObjectAttributes(ctx context.Context, input *s3.GetObjectAttributesInput, opts ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.GetObjectAttributes(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) GetObjectLockConfiguration(ctx context.Context, input *s3.GetObjectLockConfigurationInput, opts ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.GetObjectLockConfiguration(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.HeadObject(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.ListObjectsV2(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.PutObject(ctx, input, opts...)
+}
+
+func (rlc *rateLimitedClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, opts ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if err := rlc.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return rlc.S3Interface.UploadPart(ctx, input, opts...)
+}
@@ -0,0 +1,65 @@
+package s3treeclone
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	client := newS3TestClient()
+	client.createBucket("hello")
+	rlc := &rateLimitedClient{S3Interface: client, limiter: newRequestLimiter(20)}
+
+	// The first request is allowed immediately; each of the other four waits 50ms.
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		rlc.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: aws.String("hello"), Key: aws.String("missing")})
+	}
+	elapsed := time.Since(start)
+
+	if elapsed < 190*time.Millisecond {
+		t.Errorf("Expected 5 requests at 20 per second to take at least 200ms: %s", elapsed)
+	}
+
+	// A canceled request doesn't wait for the limiter.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rlc.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("hello"), Key: aws.String("a.txt")}); err == nil {
+		t.Errorf("Expected a canceled request to fail")
+	}
+}
+
+func TestMaxRPS(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-max-rps-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"a.txt", "b.txt", "c.txt"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	// Each file takes a HeadObject and a PutObject, so at least 5 requests wait 100ms each.
+	start := time.Now()
+	runExpect(t, []string{"-max-rps", "10", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+	elapsed := time.Since(start)
+
+	if elapsed < 450*time.Millisecond {
+		t.Errorf("Expected at least 6 requests at 10 per second to take at least 500ms: %s", elapsed)
+	}
+
+	runExpect(t, []string{"-max-rps", "-1", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -max-rps value: -1"))
+}
@@ -0,0 +1,255 @@
+package s3treeclone
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// restoreDir is a directory marker whose metadata is applied once all objects have been restored.
+type restoreDir struct {
+	key      string
+	pathname string
+}
+
+// Restore downloads every object under the prefix into destDir, recreating directories from their
+// markers and re-applying the ownership, permissions, and timestamps recorded in the metadata.
+func (stc *Cloner) Restore(destDir string) error {
+	if !stc.dryRun {
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	var dirs []restoreDir
+	stc.waitGroup = &sync.WaitGroup{}
+
+	paginator := s3.NewListObjectsV2Paginator(stc.s3Client, &s3.ListObjectsV2Input{Bucket: &stc.bucket, Prefix: &stc.prefix, RequestPayer: stc.requestPayer})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(stc.ctx)
+		if err != nil {
+			stc.waitGroup.Wait()
+			return err
+		}
+
+		for _, object := range page.Contents {
+			// The clone was aborted; the next page request will fail.
+			if stc.ctx.Err() != nil {
+				break
+			}
+
+			key := aws.ToString(object.Key)
+			relPath := strings.TrimPrefix(key, stc.prefix)
+			isDir := relPath == "" || strings.HasSuffix(relPath, "/")
+
+			// Refuse keys that would escape the destination directory.
+			cleanPath := path.Clean("/" + relPath)[1:]
+			if strings.Contains("/"+relPath+"/", "/../") {
+				stc.fail(logEvent{Key: key, Reason: "outside the destination"}, "Skipping s3://%s/%s: key is outside the destination\n", stc.bucket, key)
+				continue
+			}
+
+			atomic.AddInt64(&stc.nObjects, 1)
+			pathname := filepath.Join(destDir, filepath.FromSlash(cleanPath))
+
+			if stc.dryRun {
+				stc.printf(stc.stdout, levelInfo, "Would restore s3://%s/%s to %s\n", stc.bucket, key, pathname)
+				continue
+			}
+
+			if isDir {
+				if err := os.MkdirAll(pathname, 0755); err != nil {
+					stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to create directory %s: %v\n", pathname, err)
+					continue
+				}
+
+				dirs = append(dirs, restoreDir{key: key, pathname: pathname})
+				continue
+			}
+
+			err = stc.sem.Acquire(stc.ctx, 1)
+			if err != nil {
+				stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Failed to acquire S3 semaphore: %v\n", err)
+				continue
+			}
+
+			stc.waitGroup.Add(1)
+			go func(key, pathname string) {
+				defer stc.waitGroup.Done()
+				defer stc.sem.Release(1)
+				stc.RestoreObject(key, pathname)
+			}(key, pathname)
+		}
+	}
+
+	stc.waitGroup.Wait()
+	stc.restoreHardLinks(destDir)
+
+	// Directory metadata needs to be fetched with HeadObject since ListObjectsV2 doesn't return it.
+	// It is applied once every file, link, and subdirectory has been created, since creating them
+	// updates the directory's mtime, and deepest-first so that read-only permissions don't prevent
+	// restoring children.
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].pathname > dirs[j].pathname })
+	for _, dir := range dirs {
+		hoo, err := stc.s3Client.HeadObject(stc.ctx, stc.headObjectInput(dir.key))
+		if err != nil {
+			stc.fail(logEvent{Path: dir.pathname, Key: dir.key, Error: err.Error()}, "Unable to get metadata for s3://%s/%s: %v\n", stc.bucket, dir.key, err)
+			continue
+		}
+
+		stc.applyFileMetadata(dir.pathname, dir.key, hoo.Metadata)
+	}
+
+	return nil
+}
+
+// RestoreObject downloads a single object to the given pathname and applies its metadata. Objects
+// with a file-symlink-target are restored as symbolic links.
+func (stc *Cloner) RestoreObject(key, pathname string) {
+	goo, err := stc.s3Client.GetObject(stc.ctx, stc.getObjectInput(key))
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to download s3://%s/%s: %v\n", stc.bucket, key, err)
+		return
+	}
+	defer goo.Body.Close()
+
+	if err = os.MkdirAll(filepath.Dir(pathname), 0755); err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to create directory %s: %v\n", filepath.Dir(pathname), err)
+		return
+	}
+
+	if target, isSymlink := goo.Metadata["file-symlink-target"]; isSymlink {
+		os.Remove(pathname)
+		if err = os.Symlink(target, pathname); err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to create symbolic link %s: %v\n", pathname, err)
+			return
+		}
+
+		stc.applyFileOwnership(pathname, key, goo.Metadata)
+		stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventRestored, Path: pathname, Key: key}, "Restored s3://%s/%s to %s\n", stc.bucket, key, pathname)
+		return
+	}
+
+	fd, err := os.OpenFile(pathname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to create %s: %v\n", pathname, err)
+		return
+	}
+
+	body, err := decompressedBody(goo.Body, goo.ContentEncoding)
+	if err == nil {
+		_, err = io.Copy(fd, body)
+	}
+	closeErr := fd.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to write %s: %v\n", pathname, err)
+		return
+	}
+
+	// Hard links are created once the files they refer to have been restored.
+	if target, isHardLink := goo.Metadata["file-hardlink-target"]; isHardLink {
+		stc.queueRestoreLink(key, pathname, target)
+		return
+	}
+
+	stc.applyFileMetadata(pathname, key, goo.Metadata)
+	stc.logf(stc.stdout, levelVerbose, logEvent{Event: eventRestored, Path: pathname, Key: key, Bytes: aws.Int64(goo.ContentLength)}, "Restored s3://%s/%s to %s\n", stc.bucket, key, pathname)
+}
+
+// applyFileMetadata re-applies the ownership, extended attributes (with Xattrs), permissions, and
+// modification time recorded in an object's metadata to a restored file or directory. Missing metadata is left as-is.
+func (stc *Cloner) applyFileMetadata(pathname, key string, metadata map[string]string) {
+	stc.applyFileOwnership(pathname, key, metadata)
+
+	// Changing ownership clears security.capability, so extended attributes are set afterwards.
+	if stc.xattrs {
+		stc.applyXattrs(pathname, key, metadata)
+	}
+
+	scheme := stc.objectMetadataScheme(metadata)
+	keys := scheme.keys()
+	if permsStr, isPresent := metadata[keys.permissions]; isPresent {
+		perms, err := scheme.parsePermissions(permsStr)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Reason: "invalid " + keys.permissions}, "Non-integer value for %s for s3://%s/%s: %s\n", keys.permissions, stc.bucket, key, permsStr)
+		} else if err = os.Chmod(pathname, fileModeFromPermissions(perms)); err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to set permissions on %s: %v\n", pathname, err)
+		}
+	}
+
+	if mtimeStr, isPresent := metadata[keys.mtime]; isPresent {
+		mtime, err := scheme.parseTimestamp(mtimeStr)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Reason: "invalid " + keys.mtime, Error: err.Error()}, "Cannot parse %s for s3://%s/%s: %s: %v\n", keys.mtime, stc.bucket, key, mtimeStr, err)
+		} else {
+			modTime := time.Unix(0, mtime)
+			if err = os.Chtimes(pathname, modTime, modTime); err != nil {
+				stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to set timestamps on %s: %v\n", pathname, err)
+			}
+		}
+	}
+}
+
+// applyFileOwnership re-applies the owner and group recorded in an object's metadata, reversing
+// root squash if requested. Only root can give files away, so this is skipped when running as any
+// other user.
+func (stc *Cloner) applyFileOwnership(pathname, key string, metadata map[string]string) {
+	if os.Geteuid() != 0 {
+		return
+	}
+
+	keys := stc.objectMetadataScheme(metadata).keys()
+	uid, gid := -1, -1
+	if ownerStr, isPresent := metadata[keys.owner]; isPresent {
+		if owner, err := strconv.ParseUint(ownerStr, 10, 32); err == nil {
+			uid = int(stc.unsquashedID(uint32(owner), "file-owner"))
+		}
+	}
+
+	if groupStr, isPresent := metadata[keys.group]; isPresent {
+		if group, err := strconv.ParseUint(groupStr, 10, 32); err == nil {
+			gid = int(stc.unsquashedID(uint32(group), "file-group"))
+		}
+	}
+
+	if uid == -1 && gid == -1 {
+		return
+	}
+
+	if err := os.Lchown(pathname, uid, gid); err != nil {
+		stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to set ownership on %s: %v\n", pathname, err)
+	}
+}
+
+// fileModeFromPermissions converts the Unix permission bits stored in file-permissions, including
+// setuid, setgid, and sticky, to an os.FileMode.
+func fileModeFromPermissions(perms uint32) os.FileMode {
+	mode := os.FileMode(perms & 0777)
+	if perms&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if perms&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if perms&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
@@ -0,0 +1,81 @@
+package s3treeclone
+
+import (
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// retryFailedDelay is how long to wait before the first -retry-failed pass. Each later pass waits
+// that much longer, giving throttling or a brief outage time to clear.
+var retryFailedDelay = 2 * time.Second
+
+// noteFailedJob remembers a walk job whose file failed so it can be retried after the walk.
+func (stc *Cloner) noteFailedJob(job walkJob) {
+	pathname := path.Join(job.dirName, job.filename)
+
+	stc.errorsMutex.Lock()
+	defer stc.errorsMutex.Unlock()
+
+	if stc.failedPaths[pathname] {
+		delete(stc.failedPaths, pathname)
+		stc.failedJobs = append(stc.failedJobs, job)
+	}
+}
+
+// takeFailedJobs returns the jobs to retry and forgets their failures, so only files that fail
+// again are counted.
+func (stc *Cloner) takeFailedJobs() []walkJob {
+	stc.errorsMutex.Lock()
+	defer stc.errorsMutex.Unlock()
+
+	jobs := stc.failedJobs
+	stc.failedJobs = nil
+
+	retried := make(map[string]bool)
+	for _, job := range jobs {
+		retried[path.Join(job.dirName, job.filename)] = true
+	}
+
+	var remaining []PathError
+	for _, pe := range stc.errors {
+		if retried[pe.Path] {
+			atomic.AddInt64(&stc.nFailed, -1)
+		} else {
+			remaining = append(remaining, pe)
+		}
+	}
+
+	stc.errors = remaining
+
+	// The files are examined again, but were already counted.
+	atomic.AddInt64(&stc.nObjects, -int64(len(jobs)))
+	return jobs
+}
+
+// retryFailedJobs handles the files that failed during the walk again, up to -retry-failed more
+// times, waiting a little longer before each pass. Directories aren't descended into again.
+func (stc *Cloner) retryFailedJobs() {
+	stc.noRecurse = true
+	for pass := 1; pass <= stc.retryFailed; pass++ {
+		stc.errorsMutex.Lock()
+		nJobs := len(stc.failedJobs)
+		stc.errorsMutex.Unlock()
+		if nJobs == 0 {
+			return
+		}
+
+		stc.printf(stc.stderr, levelInfo, "Retrying %d failed files (pass %d of %d)\n", nJobs, pass, stc.retryFailed)
+		select {
+		case <-time.After(time.Duration(pass) * retryFailedDelay):
+		case <-stc.ctx.Done():
+			return
+		}
+
+		stc.startWorkers()
+		for _, job := range stc.takeFailedJobs() {
+			stc.queueFile(job.relPath, job.dirName, job.filename, job.ignores)
+		}
+		stc.finishWalk()
+	}
+}
@@ -0,0 +1,60 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestRetryFailed(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-retry-failed-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"flaky.txt", "good.txt"} {
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, []byte("hello"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	oldDelay := retryFailedDelay
+	retryFailedDelay = time.Millisecond
+	defer func() { retryFailedDelay = oldDelay }()
+
+	// flaky.txt fails to upload twice, then succeeds.
+	newFlakyClient := func() *s3TestClient {
+		var mutex sync.Mutex
+		attempts := 0
+
+		client := newS3TestClient()
+		client.createBucket("hello")
+		client.PutObjectError = func(input *s3.PutObjectInput) error {
+			if *input.Key != "flaky.txt" {
+				return nil
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			attempts++
+			if attempts <= 2 {
+				return makeS3Error("PutObject", 500, "Internal Server Error", "InternalError", "We encountered an internal error")
+			}
+			return nil
+		}
+		return client
+	}
+
+	runExpect(t, []string{"-retry-failed", "2", tmpDir + "/", "s3://hello"}, newFlakyClient(), 0, nil, []byte("Objects uploaded:    2"))
+
+	// One more pass isn't enough; the file is still reported once.
+	runExpect(t, []string{"-retry-failed", "1", tmpDir + "/", "s3://hello"}, newFlakyClient(), 1, nil, []byte("1 of 2 objects failed"))
+
+	runExpect(t, []string{"-retry-failed", "-1", tmpDir + "/", "s3://hello"}, newFlakyClient(), 1, nil, []byte("Invalid number of failure retries: -1"))
+	runExpect(t, []string{"-retry-failed", "1", "-stop-on-error", tmpDir + "/", "s3://hello"}, newFlakyClient(), 1, nil, []byte("RetryFailed cannot be used with StopOnError"))
+}
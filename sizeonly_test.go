@@ -0,0 +1,65 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSizeOnly(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-size-only-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.txt"
+	writeFile := func(content string) {
+		if err := ioutil.WriteFile(pathname, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file %s: %v", pathname, err)
+		}
+	}
+
+	writeFile("Hello world")
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	bucket := client.createBucket("hello")
+	args := []string{"-size-only", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	// The file isn't hashed, so no hashes are stored.
+	for _, algorithm := range HashAlgorithms {
+		if value, found := client.putInputs[0].Metadata[algorithm]; found {
+			t.Errorf("Expected no %s metadata: %#v", algorithm, value)
+		}
+	}
+
+	// Different content of the same size, with a different mtime and permissions, isn't noticed.
+	writeFile("Hello WORLD")
+	mtime := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(pathname, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set the times of %s: %v", pathname, err)
+	}
+
+	if err = os.Chmod(pathname, 0600); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+	if len(client.putInputs) != 1 || len(client.copyInputs) != 0 {
+		t.Errorf("Expected nothing to be written: %d PutObject calls, %d CopyObject calls", len(client.putInputs), len(client.copyInputs))
+	}
+
+	// Stored hashes that don't match aren't compared either.
+	bucket.Objects["hello.txt"].Metadata["sha256"] = "0000000000000000000000000000000000000000000000000000000000000000"
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// A different size is.
+	writeFile("Hello world!")
+	runExpect(t, args, client, 0, nil, []byte("Content size mismatch: s3://hello/hello.txt has size 11; "+pathname+" has size 12; will resync"))
+	if body := string(bucket.Objects["hello.txt"].Body); body != "Hello world!" {
+		t.Errorf("Expected the file to be uploaded again: %#v", body)
+	}
+
+	runExpect(t, []string{"-size-only", "-checksum", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("-size-only cannot be used with -checksum, -dedupe, or -verify-parts"))
+}
@@ -1,11 +1,21 @@
-package main
+package s3treeclone
 
-import "syscall"
+import (
+	"os"
+	"syscall"
+)
 
-func getCtime(stat *syscall.Stat_t) int64 {
-	return stat.Ctimespec.Nsec + stat.Ctimespec.Sec*1000000000
-}
-
-func getMtime(stat *syscall.Stat_t) int64 {
-	return stat.Mtimespec.Nsec + stat.Mtimespec.Sec*1000000000
+func getFileStat(fileinfo os.FileInfo) *fileStat {
+	stat := fileinfo.Sys().(*syscall.Stat_t)
+	return &fileStat{
+		Size:  stat.Size,
+		Mode:  uint32(stat.Mode),
+		Uid:   stat.Uid,
+		Gid:   stat.Gid,
+		Ctime: stat.Ctimespec.Nsec + stat.Ctimespec.Sec*1000000000,
+		Mtime: stat.Mtimespec.Nsec + stat.Mtimespec.Sec*1000000000,
+		Dev:   uint64(stat.Dev),
+		Ino:   stat.Ino,
+		Nlink: uint64(stat.Nlink),
+	}
 }
@@ -0,0 +1,18 @@
+package s3treeclone
+
+import (
+	"os"
+	"syscall"
+)
+
+// getFileStat on Windows reports the permission bits Go synthesizes from the file attributes and
+// the creation time as the ctime. Windows has no numeric owner or group, so these are always 0.
+func getFileStat(fileinfo os.FileInfo) *fileStat {
+	stat := fileinfo.Sys().(*syscall.Win32FileAttributeData)
+	return &fileStat{
+		Size:  fileinfo.Size(),
+		Mode:  uint32(fileinfo.Mode().Perm()),
+		Ctime: stat.CreationTime.Nanoseconds(),
+		Mtime: stat.LastWriteTime.Nanoseconds(),
+	}
+}
@@ -0,0 +1,161 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// StorageClassRule uploads files larger than LargerThan bytes, or last modified more than OlderThan
+// before the run started, in StorageClass. If both are set, a file must match both.
+type StorageClassRule struct {
+	LargerThan   int64
+	OlderThan    time.Duration
+	StorageClass s3Types.StorageClass
+}
+
+// ParseStorageClassRules parses a comma-separated list of rules such as
+// "size>1GB:DEEP_ARCHIVE,age>90d:GLACIER". Ages may be given in days, such as "90d", or as a
+// duration such as "36h".
+func ParseStorageClassRules(s string) ([]StorageClassRule, error) {
+	var rules []StorageClassRule
+	for _, ruleString := range strings.Split(s, ",") {
+		colon := strings.LastIndexByte(ruleString, ':')
+		if colon == -1 {
+			return nil, fmt.Errorf("Storage class rule must be in the form size>N:CLASS or age>N:CLASS: %s", ruleString)
+		}
+
+		rule := StorageClassRule{StorageClass: s3Types.StorageClass(ruleString[colon+1:])}
+		condition := ruleString[:colon]
+		var err error
+		switch {
+		case strings.HasPrefix(condition, "size>"):
+			rule.LargerThan, err = parseByteSize(condition[len("size>"):])
+		case strings.HasPrefix(condition, "age>"):
+			rule.OlderThan, err = parseAge(condition[len("age>"):])
+		default:
+			err = fmt.Errorf("Storage class rule must be in the form size>N:CLASS or age>N:CLASS: %s", ruleString)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = validateStorageClassRule(rule); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// ParseStorageClasses parses a -storage-class value: either a single storage class, or a
+// comma-separated list such as "default=STANDARD,.mp4=INTELLIGENT_TIERING" giving the storage class
+// for files with each extension. The default is empty if it isn't given. Extensions are lowercased.
+func ParseStorageClasses(s string) (s3Types.StorageClass, map[string]s3Types.StorageClass, error) {
+	if !strings.Contains(s, "=") {
+		if !validStorageClass(s3Types.StorageClass(s)) {
+			return "", nil, fmt.Errorf("Invalid storage class: %s", s)
+		}
+
+		return s3Types.StorageClass(s), nil, nil
+	}
+
+	var defaultClass s3Types.StorageClass
+	extClasses := make(map[string]s3Types.StorageClass)
+	for _, entry := range strings.Split(s, ",") {
+		equals := strings.IndexByte(entry, '=')
+		if equals == -1 {
+			return "", nil, fmt.Errorf("Storage class entry must be in the form default=CLASS or .ext=CLASS: %s", entry)
+		}
+
+		name, storageClass := entry[:equals], s3Types.StorageClass(entry[equals+1:])
+		if name == "default" {
+			if !validStorageClass(storageClass) {
+				return "", nil, fmt.Errorf("Invalid default storage class: %s", storageClass)
+			}
+
+			defaultClass = storageClass
+			continue
+		}
+
+		if err := validateExtStorageClass(name, storageClass); err != nil {
+			return "", nil, err
+		}
+
+		extClasses[strings.ToLower(name)] = storageClass
+	}
+
+	return defaultClass, extClasses, nil
+}
+
+// validateExtStorageClass makes sure ext is an extension such as ".mp4" and storageClass is usable.
+func validateExtStorageClass(ext string, storageClass s3Types.StorageClass) error {
+	switch {
+	case len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], "./"):
+		return fmt.Errorf("Storage class entry must be for default or an extension such as .mp4: %s", ext)
+	case !validStorageClass(storageClass):
+		return fmt.Errorf("Invalid storage class for %s: %s", ext, storageClass)
+	}
+
+	return nil
+}
+
+// parseAge parses an age given in days, such as "90d", or as a duration, such as "36h".
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid age: %s", s)
+		}
+
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid age: %s", s)
+	}
+
+	return d, nil
+}
+
+// validateStorageClassRule makes sure a rule has a condition and a usable storage class.
+func validateStorageClassRule(rule StorageClassRule) error {
+	switch {
+	case rule.LargerThan < 0 || rule.OlderThan < 0 || (rule.LargerThan == 0 && rule.OlderThan == 0):
+		return fmt.Errorf("Storage class rule must have a positive size or age")
+	case !validStorageClass(rule.StorageClass):
+		return fmt.Errorf("Invalid storage class in storage class rule: %s", rule.StorageClass)
+	}
+
+	return nil
+}
+
+// fileStorageClass returns the storage class to upload a file to key in: that of the first rule it
+// matches, or else that of its extension, or else the default storage class.
+func (stc *Cloner) fileStorageClass(key string, stat *fileStat) s3Types.StorageClass {
+	for _, rule := range stc.storageClassRules {
+		if rule.LargerThan > 0 && stat.Size <= rule.LargerThan {
+			continue
+		}
+
+		if rule.OlderThan > 0 && stc.startTime.Sub(time.Unix(0, stat.Mtime)) <= rule.OlderThan {
+			continue
+		}
+
+		return rule.StorageClass
+	}
+
+	if storageClass, found := stc.extStorageClasses[strings.ToLower(path.Ext(key))]; found {
+		return storageClass
+	}
+
+	return stc.storageClass
+}
@@ -0,0 +1,110 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestStorageClassRules(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-storage-class-rules-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = os.Mkdir(tmpDir+"/d1", 0755); err != nil {
+		t.Fatalf("Failed to create directory %s/d1: %v", tmpDir, err)
+	}
+
+	for filename, content := range map[string]string{"d1/large.bin": strings.Repeat("x", 2000), "d1/old.txt": "old", "d1/new.txt": "new"} {
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	mtime := time.Now().Add(-100 * 24 * time.Hour)
+	if err = os.Chtimes(tmpDir+"/d1/old.txt", mtime, mtime); err != nil {
+		t.Fatalf("Failed to set the times of %s/d1/old.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-storage-class-rules", "size>1KB:DEEP_ARCHIVE,age>90d:GLACIER", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    3"))
+
+	for key, expected := range map[string]s3Types.StorageClass{
+		"d1/":          s3Types.StorageClassStandard,
+		"d1/large.bin": s3Types.StorageClassDeepArchive,
+		"d1/old.txt":   s3Types.StorageClassGlacier,
+		"d1/new.txt":   s3Types.StorageClassStandard,
+	} {
+		if obj := bucket.Objects[key]; obj.StorageClass != expected {
+			t.Errorf("Expected %s in %s: %s", key, expected, obj.StorageClass)
+		}
+	}
+
+	for _, rules := range []string{"size>1KB", "size<1KB:GLACIER", "size>big:GLACIER", "age>ninety:GLACIER", "age>0d:GLACIER", "size>1KB:COLD"} {
+		if _, err = ParseStorageClassRules(rules); err == nil {
+			t.Errorf("Expected %#v to be rejected", rules)
+		}
+	}
+
+	runExpect(t, []string{"-storage-class-rules", "size>1KB:COLD", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -storage-class-rules value: Invalid storage class in storage class rule: COLD"))
+}
+
+func TestExtensionStorageClasses(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-extension-storage-classes-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range []string{"movie.mp4", "scan.TIFF", "notes.txt", "large.mp4"} {
+		content := "Hello world"
+		if filename == "large.mp4" {
+			content = strings.Repeat("x", 2000)
+		}
+
+		if err = ioutil.WriteFile(tmpDir+"/"+filename, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{
+		"-storage-class", "default=STANDARD_IA,.mp4=INTELLIGENT_TIERING,.tiff=INTELLIGENT_TIERING",
+		"-storage-class-rules", "size>1KB:DEEP_ARCHIVE",
+		tmpDir + "/", "s3://hello",
+	}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    4"))
+
+	for key, expected := range map[string]s3Types.StorageClass{
+		"movie.mp4": s3Types.StorageClassIntelligentTiering,
+		"scan.TIFF": s3Types.StorageClassIntelligentTiering,
+		"notes.txt": s3Types.StorageClassStandardIa,
+		"large.mp4": s3Types.StorageClassDeepArchive,
+	} {
+		if obj := bucket.Objects[key]; obj.StorageClass != expected {
+			t.Errorf("Expected %s in %s: %s", key, expected, obj.StorageClass)
+		}
+	}
+
+	// Without a default, everything else is in STANDARD.
+	defaultClass, extClasses, err := ParseStorageClasses(".MP4=GLACIER")
+	if err != nil || defaultClass != "" || len(extClasses) != 1 || extClasses[".mp4"] != s3Types.StorageClassGlacier {
+		t.Errorf("Unexpected result parsing .MP4=GLACIER: %#v, %#v, %v", defaultClass, extClasses, err)
+	}
+
+	for _, value := range []string{"COLD", "default=COLD", ".mp4=COLD", "mp4=GLACIER", ".=GLACIER", ".mp4", "default=STANDARD,"} {
+		if _, _, err = ParseStorageClasses(value); err == nil {
+			t.Errorf("Expected %#v to be rejected", value)
+		}
+	}
+
+	runExpect(t, []string{"-storage-class", "default=STANDARD,.mp4=COLD", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -storage-class value: Invalid storage class for .mp4: COLD"))
+}
@@ -0,0 +1,127 @@
+package s3treeclone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Summary describes how much work a clone did.
+type Summary struct {
+	Examined      int64 // Files and objects examined
+	Uploaded      int64 // Files and symbolic links uploaded
+	Skipped       int64 // Objects already up to date
+	Failed        int64
+	Deleted       int64 // Objects deleted by Delete
+	Conflicts     int64 // Files whose key collides with an object of the other type
+	DirsCreated   int64 // Directory markers uploaded
+	BytesUploaded int64
+	Differences   int // Differences written by Report
+	Elapsed       time.Duration
+
+	// Errors describes each failure, in the order they occurred.
+	Errors []PathError
+}
+
+// PathError describes why a file or object could not be synchronized.
+type PathError struct {
+	Path string // The local path, if known
+	Key  string // The S3 key, if known
+	Err  error
+}
+
+func (pe PathError) Error() string {
+	if pe.Path == "" {
+		return fmt.Sprintf("%s: %v", pe.Key, pe.Err)
+	}
+
+	return fmt.Sprintf("%s: %v", pe.Path, pe.Err)
+}
+
+func (pe PathError) Unwrap() error {
+	return pe.Err
+}
+
+// summary returns the transfer counts so far and the time elapsed since the clone started.
+func (stc *Cloner) summary() Summary {
+	summary := Summary{
+		Examined:      atomic.LoadInt64(&stc.nObjects),
+		Uploaded:      atomic.LoadInt64(&stc.nUploaded),
+		Skipped:       atomic.LoadInt64(&stc.nSkipped),
+		Failed:        atomic.LoadInt64(&stc.nFailed),
+		Deleted:       atomic.LoadInt64(&stc.nDeleted),
+		Conflicts:     atomic.LoadInt64(&stc.nConflicts),
+		DirsCreated:   atomic.LoadInt64(&stc.nDirsCreated),
+		BytesUploaded: atomic.LoadInt64(&stc.bytesUploaded),
+		Elapsed:       time.Since(stc.startTime),
+	}
+
+	stc.errorsMutex.Lock()
+	summary.Errors = append([]PathError(nil), stc.errors...)
+	stc.errorsMutex.Unlock()
+
+	return summary
+}
+
+// add adds another clone's counts, errors, and elapsed time to the summary, for runs that clone
+// several sources in turn.
+func (ts *Summary) add(other Summary) {
+	ts.Examined += other.Examined
+	ts.Uploaded += other.Uploaded
+	ts.Skipped += other.Skipped
+	ts.Failed += other.Failed
+	ts.Deleted += other.Deleted
+	ts.Conflicts += other.Conflicts
+	ts.DirsCreated += other.DirsCreated
+	ts.BytesUploaded += other.BytesUploaded
+	ts.Differences += other.Differences
+	ts.Elapsed += other.Elapsed
+	ts.Errors = append(ts.Errors, other.Errors...)
+}
+
+// Throughput returns the average number of bytes uploaded per second.
+func (ts Summary) Throughput() float64 {
+	if ts.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(ts.BytesUploaded) / ts.Elapsed.Seconds()
+}
+
+// writeSummary writes the summary block to w. In json format, it is written as a single summary
+// event instead.
+func (stc *Cloner) writeSummary(w io.Writer, ts Summary) {
+	if stc.logFormat == LogFormatJSON {
+		line, err := json.Marshal(map[string]interface{}{
+			"event":               "summary",
+			"objects_uploaded":    ts.Uploaded,
+			"objects_skipped":     ts.Skipped,
+			"objects_deleted":     ts.Deleted,
+			"objects_failed":      ts.Failed,
+			"key_conflicts":       ts.Conflicts,
+			"directories_created": ts.DirsCreated,
+			"bytes_uploaded":      ts.BytesUploaded,
+			"elapsed_seconds":     ts.Elapsed.Seconds(),
+			"bytes_per_second":    ts.Throughput(),
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		w.Write(append(line, '\n'))
+		return
+	}
+
+	fmt.Fprintf(w, "Summary:\n")
+	fmt.Fprintf(w, "  Objects uploaded:    %d\n", ts.Uploaded)
+	fmt.Fprintf(w, "  Objects skipped:     %d\n", ts.Skipped)
+	fmt.Fprintf(w, "  Objects deleted:     %d\n", ts.Deleted)
+	fmt.Fprintf(w, "  Objects failed:      %d\n", ts.Failed)
+	fmt.Fprintf(w, "  Key conflicts:       %d\n", ts.Conflicts)
+	fmt.Fprintf(w, "  Directories created: %d\n", ts.DirsCreated)
+	fmt.Fprintf(w, "  Bytes uploaded:      %d\n", ts.BytesUploaded)
+	fmt.Fprintf(w, "  Elapsed time:        %s\n", ts.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "  Throughput:          %.0f bytes/sec\n", ts.Throughput())
+}
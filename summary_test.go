@@ -0,0 +1,172 @@
+package s3treeclone
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestSummary(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-summary-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Mkdir(tmpDir+"/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/sub: %v", tmpDir, err)
+	}
+
+	files := map[string]string{"a.txt": "hello", "sub/b.txt": "hello, world", "sub/empty.txt": ""}
+	for filename, contents := range files {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte(
+		"Summary:\n"+
+			"  Objects uploaded:    3\n"+
+			"  Objects skipped:     0\n"+
+			"  Objects deleted:     0\n"+
+			"  Objects failed:      0\n"+
+			"  Key conflicts:       0\n"+
+			"  Directories created: 1\n"+
+			"  Bytes uploaded:      17\n"))
+
+	// Everything is now up to date.
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte(
+		"Summary:\n"+
+			"  Objects uploaded:    0\n"+
+			"  Objects skipped:     4\n"+
+			"  Objects deleted:     0\n"+
+			"  Objects failed:      0\n"+
+			"  Key conflicts:       0\n"+
+			"  Directories created: 0\n"+
+			"  Bytes uploaded:      0\n"))
+}
+
+func TestSummaryThroughput(t *testing.T) {
+	ts := Summary{BytesUploaded: 3000, Elapsed: 1500 * time.Millisecond}
+	if ts.Throughput() != 2000 {
+		t.Errorf("Expected throughput of 2000 bytes/sec: %f", ts.Throughput())
+	}
+
+	ts.Elapsed = 0
+	if ts.Throughput() != 0 {
+		t.Errorf("Expected throughput of 0 bytes/sec with no elapsed time: %f", ts.Throughput())
+	}
+}
+
+// failingPutClient fails uploads of objects whose key ends with "bad.txt".
+type failingPutClient struct {
+	*s3TestClient
+}
+
+var errPutFailed = errors.New("PutObject failed")
+
+func (c *failingPutClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if strings.HasSuffix(*input.Key, "bad.txt") {
+		return nil, errPutFailed
+	}
+
+	return c.s3TestClient.PutObject(ctx, input, opts...)
+}
+
+func TestSummaryMixedRun(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-summary-mixed-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/unchanged.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/unchanged.txt: %v", tmpDir, err)
+	}
+
+	client := &failingPutClient{newS3TestClient()}
+	bucket := client.createBucket("hello")
+	clone := func() Summary {
+		stc, err := NewCloner(Options{Source: tmpDir + "/", Destination: "s3://hello/backup"}, client)
+		if err != nil {
+			t.Fatalf("NewCloner failed: %v", err)
+		}
+
+		summary, err := stc.Clone(context.Background())
+		if err != nil {
+			t.Fatalf("Clone failed: %v", err)
+		}
+
+		return summary
+	}
+
+	clone()
+
+	// Add a new file and a file whose upload fails.
+	for filename, contents := range map[string]string{"new.txt": "hello, world", "bad.txt": "oops"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	summary := clone()
+	if summary.Uploaded != 1 || summary.Skipped != 1 || summary.Failed != 1 || summary.BytesUploaded != 12 || summary.Deleted != 0 {
+		t.Errorf("Unexpected summary: %#v", summary)
+	}
+
+	if _, found := bucket.Objects["backup/new.txt"]; !found {
+		t.Errorf("Expected backup/new.txt to be uploaded")
+	}
+
+	if len(summary.Errors) != 1 {
+		t.Fatalf("Expected 1 error: %v", summary.Errors)
+	}
+
+	pe := summary.Errors[0]
+	if pe.Path != tmpDir+"/bad.txt" || pe.Key != "backup/bad.txt" {
+		t.Errorf("Unexpected path and key for error: %#v %#v", pe.Path, pe.Key)
+	}
+
+	if !strings.Contains(pe.Error(), errPutFailed.Error()) {
+		t.Errorf("Expected %#v in error: %v", errPutFailed.Error(), pe)
+	}
+}
+
+func TestSummaryDeleted(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-summary-deleted-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	bucket.Objects["backup/stale1.txt"] = &s3TestObject{}
+	bucket.Objects["backup/stale2.txt"] = &s3TestObject{}
+
+	stc, err := NewCloner(Options{Source: tmpDir + "/", Destination: "s3://hello/backup", Delete: true}, client)
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	summary, err := stc.Clone(context.Background())
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if summary.Deleted != 2 || summary.Failed != 0 || len(summary.Errors) != 0 {
+		t.Errorf("Unexpected summary: %#v", summary)
+	}
+}
@@ -0,0 +1,47 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// maxObjectTags is the maximum number of tags S3 allows on an object.
+const maxObjectTags = 10
+
+// metadataTags are the tags added with TagFromMetadata. They are named after the File Gateway
+// metadata keys whatever the metadata scheme.
+var metadataTags = []string{"file-owner", "file-group", "file-permissions"}
+
+// ParseTag parses a tag given as key=value. The value may be empty, but the key may not.
+func ParseTag(tag string) (string, string, error) {
+	equals := strings.IndexByte(tag, '=')
+	if equals <= 0 {
+		return "", "", fmt.Errorf("Tag must be in the form key=value: %s", tag)
+	}
+
+	return tag[:equals], tag[equals+1:], nil
+}
+
+// objectTagging returns the URL-encoded tag set for an object with the given metadata, or nil if
+// the object shouldn't be tagged.
+func (stc *Cloner) objectTagging(metadata map[string]string) *string {
+	if len(stc.tags) == 0 && !stc.tagFromMetadata {
+		return nil
+	}
+
+	tags := url.Values{}
+	for key, value := range stc.tags {
+		tags.Set(key, value)
+	}
+
+	if stc.tagFromMetadata {
+		keys := stc.metadataScheme.keys()
+		for _, name := range metadataTags {
+			tags.Set(name, metadata[keys.key(name)])
+		}
+	}
+
+	tagging := tags.Encode()
+	return &tagging
+}
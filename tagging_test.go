@@ -0,0 +1,99 @@
+package s3treeclone
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseTag(t *testing.T) {
+	for _, tc := range []struct {
+		tag   string
+		key   string
+		value string
+	}{
+		{"env=prod", "env", "prod"},
+		{"empty=", "empty", ""},
+		{"expr=a=b", "expr", "a=b"},
+	} {
+		key, value, err := ParseTag(tc.tag)
+		if err != nil || key != tc.key || value != tc.value {
+			t.Errorf("Expected %#v to parse as %#v=%#v: %#v=%#v %v", tc.tag, tc.key, tc.value, key, value, err)
+		}
+	}
+
+	for _, tag := range []string{"novalue", "=value", ""} {
+		if _, _, err := ParseTag(tag); err == nil {
+			t.Errorf("Expected %#v to be rejected", tag)
+		}
+	}
+}
+
+func TestTagging(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-tagging-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0640)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	err = os.Chmod(tmpDir+"/hello.txt", 0640)
+	if err != nil {
+		t.Fatalf("Failed to chmod %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	runExpect(t, []string{"-tag", "team=data eng", "-tag", "env=prod", tmpDir, "s3://hello"}, client, 0, nil, nil)
+
+	if len(client.putInputs) != 2 {
+		t.Fatalf("Expected 2 PutObject calls: %d", len(client.putInputs))
+	}
+
+	for _, input := range client.putInputs {
+		if aws.ToString(input.Tagging) != "env=prod&team=data+eng" {
+			t.Errorf("Unexpected Tagging for %s: %#v", aws.ToString(input.Key), aws.ToString(input.Tagging))
+		}
+	}
+
+	// With -tag-from-metadata, the ownership and permissions are added.
+	client = &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	runExpect(t, []string{"-tag", "env=prod", "-tag-from-metadata", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	if len(client.putInputs) != 1 {
+		t.Fatalf("Expected 1 PutObject call: %d", len(client.putInputs))
+	}
+
+	stat, err := os.Stat(tmpDir + "/hello.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat %s/hello.txt: %v", tmpDir, err)
+	}
+
+	fileStat := getFileStat(stat)
+	expected := fmt.Sprintf("env=prod&file-group=%d&file-owner=%d&file-permissions=0640", fileStat.Gid, fileStat.Uid)
+	if tagging := aws.ToString(client.putInputs[0].Tagging); tagging != expected {
+		t.Errorf("Expected Tagging %#v: %#v", expected, tagging)
+	}
+}
+
+func TestTaggingInvalid(t *testing.T) {
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	runExpect(t, []string{"-tag", "novalue", ".", "s3://hello"}, client, 1, nil, []byte("Tag must be in the form key=value: novalue"))
+
+	var args []string
+	for i := 0; i < maxObjectTags-1; i++ {
+		args = append(args, "-tag", fmt.Sprintf("tag%d=%d", i, i))
+	}
+
+	runExpect(t, append(args, "-tag-from-metadata", ".", "s3://hello"), client, 1, nil, []byte("Too many tags"))
+}
@@ -0,0 +1,71 @@
+package s3treeclone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// maxThrottleBurst is the most data that can be read at once from a throttled reader.
+const maxThrottleBurst = 1024 * 1024
+
+// newBandwidthLimiter returns a limiter allowing the given number of bytes per second. The burst
+// is at most a second's worth of data so short uploads can't exceed the limit by much.
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	burst := bytesPerSecond
+	if burst > maxThrottleBurst {
+		burst = maxThrottleBurst
+	}
+
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// parseBandwidth parses a -bwlimit value such as "10MiB/s". The "/s" suffix is optional.
+func parseBandwidth(s string) (int64, error) {
+	bytesPerSecond, err := parseByteSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil || bytesPerSecond <= 0 {
+		return 0, fmt.Errorf("Invalid bandwidth: %s", s)
+	}
+
+	return bytesPerSecond, nil
+}
+
+// throttledReader limits the rate data is read from an upload body. The limiter is shared by all
+// uploads so the aggregate rate stays under the limit. Seeking is passed through so the SDK can
+// still rewind the body; data read again after a rewind counts against the limit again.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.ReadSeeker
+	limiter *rate.Limiter
+}
+
+// throttle wraps an upload body in a throttledReader if -bwlimit is in effect.
+func (stc *Cloner) throttle(r io.ReadSeeker) io.ReadSeeker {
+	if stc.limiter == nil {
+		return r
+	}
+
+	return &throttledReader{ctx: stc.ctx, r: r, limiter: stc.limiter}
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > tr.limiter.Burst() {
+		p = p[:tr.limiter.Burst()]
+	}
+
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		if waitErr := tr.limiter.WaitN(tr.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+func (tr *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	return tr.r.Seek(offset, whence)
+}
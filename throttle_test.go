@@ -0,0 +1,61 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected int64
+	}{
+		{"10MiB/s", 10 << 20},
+		{"512K", 512 << 10},
+		{"1000/s", 1000},
+	} {
+		value, err := parseBandwidth(tc.s)
+		if err != nil || value != tc.expected {
+			t.Errorf("Expected %#v to parse as %d: %d %v", tc.s, tc.expected, value, err)
+		}
+	}
+
+	for _, s := range []string{"0", "fast", "10MiB/m"} {
+		if _, err := parseBandwidth(s); err == nil {
+			t.Errorf("Expected %#v to be rejected", s)
+		}
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-bwlimit-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Two 32 KiB files at 32 KiB/s: the first second's worth is allowed as a burst, so the limit
+	// shared by both uploads should hold them to about a second.
+	for _, filename := range []string{"a.bin", "b.bin"} {
+		err = ioutil.WriteFile(tmpDir+"/"+filename, bytes.Repeat([]byte("x"), 32*1024), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, filename, err)
+		}
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+
+	start := time.Now()
+	runExpect(t, []string{"-bwlimit", "32KiB/s", tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+	elapsed := time.Since(start)
+
+	if elapsed < 900*time.Millisecond {
+		t.Errorf("Expected 64 KiB at 32 KiB/s to take about a second: %s", elapsed)
+	}
+
+	runExpect(t, []string{"-bwlimit", "fast", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -bwlimit value: fast"))
+}
@@ -0,0 +1,57 @@
+package s3treeclone
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// streamingOperations are the S3 operations whose response body is read after the request returns,
+// so they can't be given a timeout without cutting the body off.
+var streamingOperations = map[string]bool{
+	"GetObject": true,
+}
+
+// withRequestTimeout returns an S3 client option that limits each attempt at a request, including
+// sending its body, to the given duration. The timeout is applied inside the retry loop, so an
+// attempt that times out is retried with a fresh timeout.
+func withRequestTimeout(timeout time.Duration) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("RequestTimeout", func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				if streamingOperations[awsmiddleware.GetOperationName(ctx)] {
+					return next.HandleFinalize(ctx, in)
+				}
+
+				attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+
+				out, metadata, err := next.HandleFinalize(attemptCtx, in)
+				if err != nil && ctx.Err() == nil && attemptCtx.Err() == context.DeadlineExceeded {
+					// The SDK doesn't retry canceled requests, so replace the error rather than wrap it.
+					err = &requestTimeoutError{timeout: timeout}
+				}
+
+				return out, metadata, err
+			}), "Retry", middleware.After)
+		})
+	}
+}
+
+// requestTimeoutError is returned for an attempt at a request that timed out. It is reported as a
+// connection error so the SDK retries it.
+type requestTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *requestTimeoutError) Error() string {
+	return fmt.Sprintf("request timed out after %v", e.timeout)
+}
+
+func (e *requestTimeoutError) ConnectionError() bool {
+	return true
+}
@@ -0,0 +1,145 @@
+package s3treeclone
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newHangingServer returns a server that doesn't answer the first nHangs requests until the client
+// gives up on them, and answers the rest immediately.
+func newHangingServer(nHangs int64) (*httptest.Server, *int64) {
+	var nRequests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&nRequests, 1) <= nHangs {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+
+		w.Header().Set("Content-Length", "5")
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	}))
+
+	return server, &nRequests
+}
+
+// newTimeoutTestClient returns a client for the server that makes up to three attempts at each
+// request, without waiting between them.
+func newTimeoutTestClient(url string, timeout time.Duration) *s3.Client {
+	return s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: s3.EndpointResolverFromURL(url),
+		UsePathStyle:     true,
+		Retryer: retry.NewStandard(func(opts *retry.StandardOptions) {
+			opts.MaxAttempts = 3
+			opts.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+		}),
+	}, withRequestTimeout(timeout))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// The first attempt hangs and times out; the retry succeeds.
+	server, nRequests := newHangingServer(1)
+	defer server.Close()
+
+	client := newTimeoutTestClient(server.URL, 200*time.Millisecond)
+	hoo, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: aws.String("hello"), Key: aws.String("hello.txt")})
+	if err != nil {
+		t.Fatalf("Expected HeadObject to succeed after a retry: %v", err)
+	}
+
+	if hoo.ContentLength != 5 || atomic.LoadInt64(nRequests) != 2 {
+		t.Errorf("Expected a second attempt to succeed, got %d requests and length %d", atomic.LoadInt64(nRequests), hoo.ContentLength)
+	}
+
+	// Every attempt hangs. Each one gets its own timeout, and the request fails once they run out
+	// rather than blocking.
+	server, nRequests = newHangingServer(3)
+	defer server.Close()
+
+	client = newTimeoutTestClient(server.URL, 200*time.Millisecond)
+	start := time.Now()
+	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String("hello"), Key: aws.String("hello.txt")})
+	if err == nil {
+		t.Fatalf("Expected PutObject to time out")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected PutObject to give up after 3 timeouts, took %v", elapsed)
+	}
+
+	if atomic.LoadInt64(nRequests) != 3 {
+		t.Errorf("Expected 3 attempts, got %d", atomic.LoadInt64(nRequests))
+	}
+}
+
+func TestRequestTimeoutFlag(t *testing.T) {
+	runExpect(t, []string{"-request-timeout", "-1s", ".", "s3://hello"}, newS3TestClient(), 1, nil, []byte("Invalid -request-timeout value: -1s"))
+	runExpect(t, []string{"-request-timeout", "soon", ".", "s3://hello"}, newS3TestClient(), 1, nil, []byte("Invalid -request-timeout value: soon"))
+}
+
+// slowClient doesn't answer PutObject until the request is canceled.
+type slowClient struct {
+	*s3TestClient
+}
+
+func (c *slowClient) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(10 * time.Second):
+	}
+
+	return c.s3TestClient.PutObject(ctx, input, opts...)
+}
+
+func TestDeadline(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-deadline-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for i := 0; i < 20; i++ {
+		filename := fmt.Sprintf("%s/hello%d.txt", tmpDir, i)
+		if err = ioutil.WriteFile(filename, []byte("hello"), 0644); err != nil {
+			t.Fatalf("Failed to write file %s: %v", filename, err)
+		}
+	}
+
+	// Uploads in flight are canceled, the rest aren't started, and the summary is still written.
+	client := &slowClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	start := time.Now()
+	result, _, stderr := runCapture([]string{"-deadline", "200ms", "-max-concurrent", "2", tmpDir + "/", "s3://hello"}, client)
+	if result != deadlineExitCode {
+		t.Errorf("Expected returncode %d, got %d\nStderr: %s", deadlineExitCode, result, stderr)
+	}
+
+	for _, expected := range []string{"Objects uploaded:    0", "Deadline of 200ms exceeded; partial sync"} {
+		if !strings.Contains(string(stderr), expected) {
+			t.Errorf("Expected %#v in stderr: %s", expected, stderr)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the run to stop at the deadline, took %v", elapsed)
+	}
+
+	runExpect(t, []string{"-deadline", "-1s", ".", "s3://hello"}, newS3TestClient(), 1, nil, []byte("Invalid -deadline value: -1s"))
+}
@@ -0,0 +1,124 @@
+package s3treeclone
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minTimestamp and maxTimestamp are the bounds of a timestamp in int64 nanoseconds.
+const (
+	minTimestamp = -1 << 63
+	maxTimestamp = 1<<63 - 1
+)
+
+// formatTimestamp formats a timestamp in nanoseconds since the Unix epoch the way File Gateway
+// writes file-ctime and file-mtime metadata, such as "1700000000000000000ns".
+func formatTimestamp(ns int64) string {
+	return strconv.FormatInt(ns, 10) + "ns"
+}
+
+// timestampDifference returns the absolute difference between two timestamps in nanoseconds. The
+// result is unsigned so it cannot overflow.
+func timestampDifference(a, b int64) uint64 {
+	if a < b {
+		a, b = b, a
+	}
+
+	return uint64(a) - uint64(b)
+}
+
+// parseTimestamp parses file-ctime or file-mtime metadata into nanoseconds since the Unix epoch.
+// It accepts integer nanoseconds with or without an "ns" suffix, as well as RFC 3339 timestamps.
+func parseTimestamp(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+
+	// RFC 3339 timestamps always have a time separated by colons; integers never do.
+	if !strings.Contains(s, ":") {
+		ns, err := strconv.ParseInt(strings.TrimSuffix(s, "ns"), 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("Timestamp is out of range: %s", s)
+		} else if err != nil {
+			return 0, fmt.Errorf("Invalid timestamp: %s", s)
+		}
+
+		return ns, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid timestamp: %s: expected nanoseconds since the Unix epoch or an RFC 3339 time", s)
+	}
+
+	// UnixNano is undefined outside of roughly the years 1678 to 2262.
+	if t.Before(time.Unix(0, minTimestamp)) || t.After(time.Unix(0, maxTimestamp)) {
+		return 0, fmt.Errorf("Timestamp is out of range: %s", s)
+	}
+
+	return t.UnixNano(), nil
+}
+
+// parseModifiedSince parses a -modified-since value: either an RFC 3339 time or a duration such as
+// "24h", which is taken to be that long before now.
+func parseModifiedSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("Invalid duration: %s", s)
+		}
+
+		return now.Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time: %s: expected an RFC 3339 time or a duration", s)
+	}
+
+	return t, nil
+}
+
+// parseRetainUntil parses a -object-lock-retain-until value: either an RFC 3339 time or a duration
+// such as "720h", which is taken to be that long after now.
+func parseRetainUntil(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("Invalid duration: %s", s)
+		}
+
+		return now.Add(d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time: %s: expected an RFC 3339 time or a duration", s)
+	}
+
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("Time is not in the future: %s", s)
+	}
+
+	return t, nil
+}
+
+// parseExpires parses an -expires value: either an RFC 1123 time, such as
+// "Mon, 02 Jan 2006 15:04:05 GMT", or a duration such as "24h". A duration is returned as is so it
+// can be resolved against the start of the run.
+func parseExpires(s string) (time.Time, time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, 0, fmt.Errorf("Invalid duration: %s", s)
+		}
+
+		return time.Time{}, d, nil
+	}
+
+	for _, layout := range []string{time.RFC1123, time.RFC1123Z} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, 0, nil
+		}
+	}
+
+	return time.Time{}, 0, fmt.Errorf("Invalid time: %s: expected an RFC 1123 time or a duration", s)
+}
@@ -0,0 +1,250 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected int64
+	}{
+		{"1700000000000000000ns", 1700000000000000000},
+		{"1700000000000000000", 1700000000000000000},
+		{"0ns", 0},
+		{"-1000000000ns", -1000000000},
+		{"2023-11-14T22:13:20Z", 1700000000000000000},
+		{"2023-11-14T22:13:20.123456789Z", 1700000000123456789},
+		{"2023-11-14T14:13:20-08:00", 1700000000000000000},
+	} {
+		value, err := parseTimestamp(tc.s)
+		if err != nil || value != tc.expected {
+			t.Errorf("Expected %#v to parse as %d: %d %v", tc.s, tc.expected, value, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		s        string
+		expected string
+	}{
+		{"99999999999999999999ns", "Timestamp is out of range: 99999999999999999999ns"},
+		{"3000-01-01T00:00:00Z", "Timestamp is out of range: 3000-01-01T00:00:00Z"},
+		{"1700000000s", "Invalid timestamp: 1700000000s"},
+		{"", "Invalid timestamp: "},
+		{"2023-11-14 22:13:20", "Invalid timestamp: 2023-11-14 22:13:20: expected nanoseconds since the Unix epoch or an RFC 3339 time"},
+	} {
+		if _, err := parseTimestamp(tc.s); err == nil || err.Error() != tc.expected {
+			t.Errorf("Expected %#v to be rejected with %#v: %v", tc.s, tc.expected, err)
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	for _, ns := range []int64{0, 1700000000123456789, -1000000000} {
+		formatted := formatTimestamp(ns)
+		if value, err := parseTimestamp(formatted); err != nil || value != ns {
+			t.Errorf("Expected %s to round-trip to %d: %d %v", formatted, ns, value, err)
+		}
+	}
+
+	if formatted := formatTimestamp(1700000000000000000); formatted != "1700000000000000000ns" {
+		t.Errorf("Expected File Gateway format: %s", formatted)
+	}
+}
+
+func TestTimestampTolerance(t *testing.T) {
+	stc, err := NewCloner(Options{Source: ".", Destination: "s3://hello", TimestampTolerance: time.Second}, newS3TestClient())
+	if err != nil {
+		t.Fatalf("NewCloner failed: %v", err)
+	}
+
+	const local = 1700000000000000000
+	for _, field := range []string{"file-ctime", "file-mtime"} {
+		for _, tc := range []struct {
+			s3Timestamp int64
+			expected    bool
+		}{
+			{local, true},
+			{local + 999999999, true},
+			{local - int64(time.Second), true},
+			{local + int64(time.Second) + 1, false},
+			{local - 5*int64(time.Second), false},
+		} {
+			hoo := &s3.HeadObjectOutput{Metadata: map[string]string{field: formatTimestamp(tc.s3Timestamp)}}
+			if stc.fileTimestampEqual(hoo, local, "key", "path", field) != tc.expected {
+				t.Errorf("Expected %s of %d vs %d with a 1s tolerance to be equal=%v", field, tc.s3Timestamp, int64(local), tc.expected)
+			}
+		}
+	}
+
+	if timestampDifference(minTimestamp, maxTimestamp) != 1<<64-1 {
+		t.Errorf("Expected the difference of the extreme timestamps not to overflow")
+	}
+}
+
+func TestTimestampToleranceFlag(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-timestamp-tolerance-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	// Shift mtime by a fraction of a second, as a filesystem with coarser timestamps might. This
+	// also moves ctime a few milliseconds past the uploaded value.
+	stat, err := os.Stat(tmpDir + "/hello.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat %s/hello.txt: %v", tmpDir, err)
+	}
+
+	mtime := stat.ModTime().Add(-300 * time.Millisecond)
+	if err = os.Chtimes(tmpDir+"/hello.txt", mtime, mtime); err != nil {
+		t.Fatalf("Failed to set times on %s/hello.txt: %v", tmpDir, err)
+	}
+
+	runExpect(t, []string{"-timestamp-tolerance", "1s", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Timestamp mismatch: s3://hello/hello.txt"))
+
+	runExpect(t, []string{"-timestamp-tolerance", "-1s", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -timestamp-tolerance value: -1s"))
+}
+
+func TestIgnoreCtime(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-ignore-ctime-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pathname := tmpDir + "/hello.txt"
+	err = ioutil.WriteFile(pathname, []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	client := newS3TestClient()
+	client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	// Changing the permissions and changing them back only updates ctime.
+	time.Sleep(10 * time.Millisecond)
+	if err = os.Chmod(pathname, 0600); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	if err = os.Chmod(pathname, 0644); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", pathname, err)
+	}
+
+	runExpect(t, []string{"-ignore-ctime", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// Without -ignore-ctime, the same change forces a resync.
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Timestamp mismatch: s3://hello/hello.txt has file-ctime"))
+
+	// mtime is still checked.
+	mtime := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(pathname, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set times on %s: %v", pathname, err)
+	}
+
+	runExpect(t, []string{"-ignore-ctime", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Timestamp mismatch: s3://hello/hello.txt has file-mtime"))
+}
+
+func TestParseModifiedSince(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		s        string
+		expected time.Time
+		valid    bool
+	}{
+		{"24h", time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), true},
+		{"90m", time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC), true},
+		{"2024-01-02T03:04:05Z", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"2024-01-02T03:04:05.5-08:00", time.Date(2024, 1, 2, 11, 4, 5, 500000000, time.UTC), true},
+		{"-1h", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+		{"2024-01-02", time.Time{}, false},
+	} {
+		result, err := parseModifiedSince(tc.s, now)
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("parseModifiedSince(%#v): expected an error, got %v", tc.s, result)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseModifiedSince(%#v): unexpected error: %v", tc.s, err)
+		} else if !result.Equal(tc.expected) {
+			t.Errorf("parseModifiedSince(%#v): expected %v, got %v", tc.s, tc.expected, result)
+		}
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-modified-since-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Mkdir(tmpDir+"/sub", 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s/sub: %v", tmpDir, err)
+	}
+
+	for _, name := range []string{"old.txt", "sub/old.txt", "new.txt", "sub/new.txt"} {
+		err = ioutil.WriteFile(tmpDir+"/"+name, []byte("hello"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write file %s/%s: %v", tmpDir, name, err)
+		}
+	}
+
+	// Put the old files two days in the past; the directories are left alone.
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"old.txt", "sub/old.txt"} {
+		if err = os.Chtimes(tmpDir+"/"+name, old, old); err != nil {
+			t.Fatalf("Failed to set times on %s/%s: %v", tmpDir, name, err)
+		}
+	}
+
+	runExpect(t, []string{"-modified-since", "yesterday", tmpDir + "/", "s3://hello"}, nil, 1, nil, []byte("Invalid -modified-since value: yesterday"))
+
+	for _, cutoff := range []string{"24h", time.Now().Add(-24 * time.Hour).Format(time.RFC3339)} {
+		client := &recordingClient{s3TestClient: newS3TestClient()}
+		bucket := client.createBucket("hello")
+		runExpect(t, []string{"-modified-since", cutoff, tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects skipped:     2"))
+
+		for _, key := range []string{"new.txt", "sub/", "sub/new.txt"} {
+			if _, found := bucket.Objects[key]; !found {
+				t.Errorf("-modified-since %s: expected %s to be present", cutoff, key)
+			}
+		}
+
+		for _, key := range []string{"old.txt", "sub/old.txt"} {
+			if _, found := bucket.Objects[key]; found {
+				t.Errorf("-modified-since %s: expected %s to be skipped", cutoff, key)
+			}
+		}
+
+		// Skipped files aren't looked up in S3.
+		for _, input := range client.headInputs {
+			if key := *input.Key; key == "old.txt" || key == "sub/old.txt" {
+				t.Errorf("-modified-since %s: unexpected HeadObject for %s", cutoff, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// countHeads returns the number of HeadObject calls made for the key.
+func countHeads(client *recordingClient, key string) int {
+	n := 0
+	for _, input := range client.headInputs {
+		if aws.ToString(input.Key) == key {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestTrustMtime(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-trust-mtime-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = os.Mkdir(tmpDir+"/src", 0755); err != nil {
+		t.Fatalf("Failed to create directory %s/src: %v", tmpDir, err)
+	}
+
+	pathname := tmpDir + "/src/hello.txt"
+	if err = ioutil.WriteFile(pathname, []byte("Hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	args := []string{"-trust-mtime", "-hash-cache", tmpDir + "/hashes.json", tmpDir + "/src/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	if n := countHeads(client, "hello.txt"); n != 1 {
+		t.Fatalf("Expected 1 HeadObject call: %d", n)
+	}
+
+	// The upload was recorded, so the object isn't checked again.
+	runExpect(t, append([]string{"-v"}, args...), client, 0, []byte("Skipping "+pathname+"; unchanged since it was synced to s3://hello/hello.txt"), []byte("Objects skipped:     1"))
+	if n := countHeads(client, "hello.txt"); n != 1 {
+		t.Errorf("Expected no more HeadObject calls: %d", n)
+	}
+
+	// Without -trust-mtime, it is.
+	runExpect(t, []string{"-hash-cache", tmpDir + "/hashes.json", tmpDir + "/src/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+	if n := countHeads(client, "hello.txt"); n != 2 {
+		t.Errorf("Expected another HeadObject call: %d", n)
+	}
+
+	// Once the file changes, the object is checked again and updated. The next run trusts the
+	// update.
+	mtime := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(pathname, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set the times of %s: %v", pathname, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Updated the metadata of s3://hello/hello.txt"))
+	runExpect(t, args, client, 0, nil, []byte("Objects skipped:     1"))
+	if n := countHeads(client, "hello.txt"); n != 3 {
+		t.Errorf("Expected one more HeadObject call: %d", n)
+	}
+
+	// A different destination isn't trusted.
+	client.createBucket("world")
+	runExpect(t, []string{"-trust-mtime", "-hash-cache", tmpDir + "/hashes.json", tmpDir + "/src/", "s3://world"}, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	runExpect(t, []string{"-trust-mtime", tmpDir + "/src/", "s3://hello"}, client, 1, nil, []byte("-trust-mtime requires -hash-cache"))
+}
+
+func TestTrustMtimeDirectories(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-trust-mtime-dirs-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = os.MkdirAll(tmpDir+"/src/d1/d2", 0755); err != nil {
+		t.Fatalf("Failed to create directory %s/src/d1/d2: %v", tmpDir, err)
+	}
+
+	if err = ioutil.WriteFile(tmpDir+"/src/d1/d2/hello.txt", []byte("Hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/src/d1/d2/hello.txt: %v", tmpDir, err)
+	}
+
+	client := &recordingClient{s3TestClient: newS3TestClient()}
+	client.createBucket("hello")
+	args := []string{"-prelist", "-trust-mtime", "-hash-cache", tmpDir + "/hashes.json", tmpDir + "/src/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	// The markers are listed and the directories haven't changed, so the markers aren't checked,
+	// but the directories are still walked.
+	nHeads := len(client.headInputs)
+	runExpect(t, args, client, 0, nil, []byte("Objects skipped:     3"))
+	if n := len(client.headInputs); n != nHeads {
+		t.Errorf("Expected no more HeadObject calls: %d", n-nHeads)
+	}
+
+	// Once a directory changes, its marker is checked again and updated.
+	if err = os.Chmod(tmpDir+"/src/d1", 0700); err != nil {
+		t.Fatalf("Failed to change the permissions of %s/src/d1: %v", tmpDir, err)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Uploaded "+tmpDir+"/src/d1 to s3://hello/d1/"))
+	if n := countHeads(client, "d1/"); n != 1 {
+		t.Errorf("Expected a HeadObject call for d1/: %d", n)
+	}
+
+	if n := countHeads(client, "d1/d2/"); n != 0 {
+		t.Errorf("Expected no HeadObject call for d1/d2/: %d", n)
+	}
+
+	// Without -prelist, the markers are always checked.
+	runExpect(t, args[1:], client, 0, nil, nil)
+	if n := countHeads(client, "d1/d2/"); n != 1 {
+		t.Errorf("Expected a HeadObject call for d1/d2/ without -prelist: %d", n)
+	}
+}
@@ -0,0 +1,32 @@
+package s3treeclone
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// Values for the -unicode-normalize flag. macOS stores filenames decomposed (NFD) while Linux
+// keeps whatever form they were created with, usually composed (NFC), so the same name can produce
+// different keys on different hosts.
+const (
+	UnicodeNormalizeNone = "none"
+	UnicodeNormalizeNFC  = "nfc"
+	UnicodeNormalizeNFD  = "nfd"
+)
+
+// validUnicodeNormalize reports whether form is a valid -unicode-normalize value.
+func validUnicodeNormalize(form string) bool {
+	return form == UnicodeNormalizeNone || form == UnicodeNormalizeNFC || form == UnicodeNormalizeNFD
+}
+
+// normalizeUnicode converts part of a key to the Unicode normalization form chosen with
+// -unicode-normalize.
+func (stc *Cloner) normalizeUnicode(s string) string {
+	switch stc.unicodeNormalize {
+	case UnicodeNormalizeNFC:
+		return norm.NFC.String(s)
+	case UnicodeNormalizeNFD:
+		return norm.NFD.String(s)
+	default:
+		return s
+	}
+}
@@ -0,0 +1,64 @@
+package s3treeclone
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUnicodeNormalize(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-unicode-normalize-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The names are decomposed, as macOS stores them.
+	decomposedDir, decomposedFile := "re\u0301sume\u0301", "cafe\u0301.txt"
+	composedDir, composedFile := "r\u00e9sum\u00e9", "caf\u00e9.txt"
+	if err = os.Mkdir(tmpDir+"/"+decomposedDir, 0755); err != nil {
+		t.Fatalf("Failed to create directory %s/%s: %v", tmpDir, decomposedDir, err)
+	}
+
+	pathname := tmpDir + "/" + decomposedDir + "/" + decomposedFile
+	if err = ioutil.WriteFile(pathname, []byte("Hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s: %v", pathname, err)
+	}
+
+	// By default, the names are used as they are.
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1"))
+	if _, found := bucket.Objects[decomposedDir+"/"+decomposedFile]; !found {
+		t.Errorf("Expected the decomposed key to be used: %#v", bucket.Objects)
+	}
+
+	// With nfc, the keys match those created from composed names on another host, so the objects
+	// aren't duplicated or deleted.
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	args := []string{"-unicode-normalize", "nfc", "-delete", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+	if _, found := bucket.Objects[composedDir+"/"+composedFile]; !found {
+		t.Errorf("Expected the composed key to be used: %#v", bucket.Objects)
+	}
+
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+	if len(bucket.Objects) != 2 {
+		t.Errorf("Expected only the directory and file objects: %#v", bucket.Objects)
+	}
+
+	// With nfd, composed names are decomposed.
+	if err = os.Rename(tmpDir+"/"+decomposedDir, tmpDir+"/"+composedDir); err != nil {
+		t.Fatalf("Failed to rename %s/%s: %v", tmpDir, decomposedDir, err)
+	}
+
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	runExpect(t, []string{"-unicode-normalize", "nfd", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1"))
+	if _, found := bucket.Objects[decomposedDir+"/"+decomposedFile]; !found {
+		t.Errorf("Expected the decomposed key to be used: %#v", bucket.Objects)
+	}
+
+	runExpect(t, []string{"-unicode-normalize", "nfkc", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid -unicode-normalize value: nfkc"))
+}
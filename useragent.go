@@ -0,0 +1,40 @@
+package s3treeclone
+
+import (
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Version is the version of s3-tree-clone. Release builds set it with
+// -ldflags "-X github.jpl.nasa.gov/cloud/s3-tree-clone.Version=<version>".
+var Version = "dev"
+
+// DefaultMetadataUserAgent is the name recorded in the user-agent metadata of uploaded objects, and
+// sent in the User-Agent header of S3 requests, if Options.MetadataUserAgent is empty.
+const DefaultMetadataUserAgent = "s3-tree-clone"
+
+// userAgentMetadataKey is the metadata key recording which program uploaded an object. It isn't
+// compared, so changing the name or upgrading never causes objects to be uploaded again.
+const userAgentMetadataKey = "user-agent"
+
+// validMetadataUserAgent determines whether name can be stored in object metadata and sent in a
+// User-Agent header: it must be printable ASCII without spaces or slashes, which separate the name
+// from the version.
+func validMetadataUserAgent(name string) bool {
+	return strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' || r == '/' }) == -1
+}
+
+// metadataUserAgentValue returns the user-agent metadata value for the given name.
+func metadataUserAgentValue(name string) string {
+	return name + "/" + Version
+}
+
+// withUserAgent returns an S3 client option that adds name and the version to the User-Agent
+// header of every request, alongside the SDK's own entries.
+func withUserAgent(name string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, awsmiddleware.AddUserAgentKeyValue(name, Version))
+	}
+}
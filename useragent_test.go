@@ -0,0 +1,69 @@
+package s3treeclone
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestMetadataUserAgent(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-metadata-user-agent-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = ioutil.WriteFile(tmpDir+"/hello.txt", []byte("Hello world"), 0644); err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1"))
+	if value := bucket.Objects["hello.txt"].Metadata["user-agent"]; value != "s3-tree-clone/"+Version {
+		t.Errorf("Expected the default user-agent metadata: %#v", value)
+	}
+
+	// A different name doesn't cause the object to be uploaded again.
+	runExpect(t, []string{"-metadata-user-agent", "backup-tool", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects skipped:     1"))
+
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	runExpect(t, []string{"-metadata-user-agent", "backup-tool", tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    1"))
+	if value := bucket.Objects["hello.txt"].Metadata["user-agent"]; value != "backup-tool/"+Version {
+		t.Errorf("Expected the user-agent metadata to have the given name: %#v", value)
+	}
+
+	runExpect(t, []string{"-metadata-user-agent", "backup tool", tmpDir + "/", "s3://hello"}, client, 1, nil, []byte("Invalid metadata user agent: backup tool"))
+}
+
+func TestUserAgentHeader(t *testing.T) {
+	userAgents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		w.Header().Set("Content-Length", "5")
+	}))
+	defer server.Close()
+
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: s3.EndpointResolverFromURL(server.URL),
+		UsePathStyle:     true,
+	}, withUserAgent("backup-tool"))
+
+	if _, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: aws.String("hello"), Key: aws.String("hello.txt")}); err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+
+	if userAgent := <-userAgents; !strings.Contains(userAgent, "backup-tool/"+Version) || !strings.Contains(userAgent, "aws-sdk-go-v2") {
+		t.Errorf("Expected the User-Agent header to name the tool and the SDK: %#v", userAgent)
+	}
+}
@@ -0,0 +1,141 @@
+package s3treeclone
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// comparePartChecksums compares the local file against the part checksums S3 keeps for a multipart
+// object, computing the checksum of each part of the file using the sizes of the object's parts.
+// Objects uploaded in a single part, compressed objects, and objects uploaded without checksums
+// can't be compared this way and are treated as equal.
+func (stc *Cloner) comparePartChecksums(hoo *s3.HeadObjectOutput, pathname, key string, stat *fileStat) (bool, error) {
+	if !isMultipartETag(aws.ToString(hoo.ETag)) || aws.ToString(hoo.ContentEncoding) == contentEncodingGzip {
+		return true, nil
+	}
+
+	checksum, parts, totalParts, err := stc.getObjectParts(key)
+	if err != nil {
+		return false, err
+	}
+
+	if checksum == nil || totalParts <= 1 {
+		return true, nil
+	}
+
+	// The object's checksum is the checksum of the concatenated part checksums.
+	var algorithm *s3Checksum
+	for i := range s3Checksums {
+		if s3Checksums[i].stored(checksum) != nil {
+			algorithm = &s3Checksums[i]
+			break
+		}
+	}
+
+	if algorithm == nil {
+		return true, nil
+	}
+
+	if len(parts) != totalParts {
+		return false, fmt.Errorf("Expected %d parts for s3://%s/%s; got %d", totalParts, stc.bucket, key, len(parts))
+	}
+
+	var size int64
+	for _, part := range parts {
+		size += part.Size
+	}
+
+	if size != stat.Size {
+		return false, nil
+	}
+
+	fd, err := os.Open(pathname)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+
+	buffer := stc.readBuffers.Get().(*[]byte)
+	defer stc.readBuffers.Put(buffer)
+
+	var partDigests []byte
+	for _, part := range parts {
+		h := algorithm.newHash()
+		if _, err = io.CopyBuffer(h, io.LimitReader(fd, part.Size), *buffer); err != nil {
+			return false, err
+		}
+
+		partDigests = append(partDigests, h.Sum(nil)...)
+	}
+
+	composite := algorithm.newHash()
+	composite.Write(partDigests)
+	expected := base64.StdEncoding.EncodeToString(composite.Sum(nil))
+
+	// The stored checksum may have the number of parts appended.
+	stored := aws.ToString(algorithm.stored(checksum))
+	if i := strings.IndexByte(stored, '-'); i != -1 {
+		stored = stored[:i]
+	}
+
+	return stored == expected, nil
+}
+
+// getObjectParts returns the checksum of an object, its parts in order, and the number of parts it
+// has, using GetObjectAttributes. The parts are only returned for multipart objects.
+func (stc *Cloner) getObjectParts(key string) (*s3Types.Checksum, []s3Types.ObjectPart, int, error) {
+	input := &s3.GetObjectAttributesInput{
+		Bucket:           &stc.bucket,
+		Key:              &key,
+		ObjectAttributes: []s3Types.ObjectAttributes{s3Types.ObjectAttributesChecksum, s3Types.ObjectAttributesObjectParts},
+		RequestPayer:     stc.requestPayer,
+	}
+
+	if stc.encAlg == EncryptionSSEC {
+		input.SSECustomerAlgorithm = &stc.sseCustomerAlgorithm
+		input.SSECustomerKey = &stc.sseCustomerKey
+		input.SSECustomerKeyMD5 = &stc.sseCustomerKeyMD5
+	}
+
+	var checksum *s3Types.Checksum
+	var parts []s3Types.ObjectPart
+	totalParts := 0
+	for {
+		if err := stc.sem.Acquire(stc.ctx, 1); err != nil {
+			return nil, nil, 0, err
+		}
+
+		output, err := stc.s3Client.GetObjectAttributes(stc.ctx, input)
+		stc.sem.Release(1)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		if checksum == nil {
+			checksum = output.Checksum
+		}
+
+		if output.ObjectParts == nil {
+			break
+		}
+
+		totalParts = int(output.ObjectParts.TotalPartsCount)
+		parts = append(parts, output.ObjectParts.Parts...)
+		if !output.ObjectParts.IsTruncated || output.ObjectParts.NextPartNumberMarker == nil {
+			break
+		}
+
+		input.PartNumberMarker = output.ObjectParts.NextPartNumberMarker
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return checksum, parts, totalParts, nil
+}
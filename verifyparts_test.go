@@ -0,0 +1,80 @@
+package s3treeclone
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// compositeChecksum returns the checksum S3 reports for an object uploaded in the given parts.
+func compositeChecksum(newHash func() hash.Hash, parts [][]byte) string {
+	composite := newHash()
+	for _, part := range parts {
+		h := newHash()
+		h.Write(part)
+		composite.Write(h.Sum(nil))
+	}
+
+	return base64.StdEncoding.EncodeToString(composite.Sum(nil))
+}
+
+// makeMultipart turns an object into one that was uploaded in parts of the given sizes with the
+// given checksum.
+func makeMultipart(object *s3TestObject, sizes []int64, checksum *s3Types.Checksum) {
+	object.ETag = aws.String("\"00000000000000000000000000000000-3\"")
+	object.Checksum = checksum
+	object.PartsCount = int32(len(sizes))
+	object.Parts = nil
+	for i, size := range sizes {
+		object.Parts = append(object.Parts, s3Types.ObjectPart{PartNumber: int32(i + 1), Size: size})
+	}
+}
+
+func TestVerifyParts(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-verify-parts-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	parts := [][]byte{[]byte("aaaaa"), []byte("bbbbb"), []byte("ccccc")}
+	err = ioutil.WriteFile(tmpDir+"/hello.bin", []byte("aaaaabbbbbccccc"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.bin: %v", tmpDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	args := []string{"-verify-parts", tmpDir + "/", "s3://hello"}
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    1"))
+
+	// The part checksums match the file. There are more parts than are returned at once.
+	sizes := []int64{5, 5, 5}
+	makeMultipart(bucket.Objects["hello.bin"], sizes, &s3Types.Checksum{ChecksumSHA256: aws.String(compositeChecksum(sha256.New, parts) + "-3")})
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	crc32c := func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }
+	makeMultipart(bucket.Objects["hello.bin"], sizes, &s3Types.Checksum{ChecksumCRC32C: aws.String(compositeChecksum(crc32c, parts))})
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	// Without -verify-parts, a corrupted part isn't noticed.
+	corrupted := [][]byte{[]byte("aaaaa"), []byte("bbbbB"), []byte("ccccc")}
+	makeMultipart(bucket.Objects["hello.bin"], sizes, &s3Types.Checksum{ChecksumSHA256: aws.String(compositeChecksum(sha256.New, corrupted))})
+	runExpect(t, []string{tmpDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+	runExpect(t, args, client, 0, nil, []byte("Part checksums differ for s3://hello/hello.bin"))
+
+	// Parts that don't add up to the file's size differ, too.
+	makeMultipart(bucket.Objects["hello.bin"], []int64{5, 5, 4}, &s3Types.Checksum{ChecksumSHA256: aws.String(compositeChecksum(sha256.New, parts))})
+	runExpect(t, args, client, 0, nil, []byte("Part checksums differ for s3://hello/hello.bin"))
+
+	// Objects uploaded without checksums can't be checked.
+	makeMultipart(bucket.Objects["hello.bin"], sizes, nil)
+	runExpect(t, args, client, 0, nil, []byte("Objects uploaded:    0"))
+}
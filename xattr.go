@@ -0,0 +1,133 @@
+package s3treeclone
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// xattrMetadataPrefix is prepended to an extended attribute's name to form its metadata key. The
+// value is base64-encoded, since extended attributes such as security.capability are binary.
+const xattrMetadataPrefix = "file-xattr-"
+
+// xattrNamespaces are the extended attribute namespaces copied with -xattrs. The system namespace
+// holds ACLs and other attributes managed by the file system itself.
+var xattrNamespaces = []string{"user.", "security.", "trusted."}
+
+// storableXattrName determines whether an extended attribute is copied with -xattrs. It must be in
+// one of xattrNamespaces, and, since S3 lowercases metadata keys, can only use lowercase letters,
+// digits, '.', '-', and '_'.
+func storableXattrName(name string) bool {
+	inNamespace := false
+	for _, namespace := range xattrNamespaces {
+		if strings.HasPrefix(name, namespace) {
+			inNamespace = true
+			break
+		}
+	}
+
+	if !inNamespace {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fileXattrMetadata returns the metadata recording a file's extended attributes. Attributes that
+// can't be stored in metadata are skipped with a warning.
+func (stc *Cloner) fileXattrMetadata(pathname string) (map[string]string, error) {
+	xattrs, err := readXattrs(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string)
+	for name, value := range xattrs {
+		if !storableXattrName(name) {
+			if strings.HasPrefix(name, "system.") {
+				continue
+			}
+
+			stc.logf(stc.stderr, levelInfo, logEvent{Event: eventWarning, Path: pathname, Reason: "unsupported xattr name"}, "Warning: not copying extended attribute %#v of %s: unsupported name\n", name, pathname)
+			continue
+		}
+
+		metadata[xattrMetadataPrefix+name] = base64.StdEncoding.EncodeToString(value)
+	}
+
+	return metadata, nil
+}
+
+// addXattrMetadata adds the metadata recording a file's extended attributes to the metadata of the
+// object being uploaded for it.
+func (stc *Cloner) addXattrMetadata(pathname string, metadata map[string]string) error {
+	xattrMetadata, err := stc.fileXattrMetadata(pathname)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range xattrMetadata {
+		metadata[key] = value
+	}
+
+	return nil
+}
+
+// xattrsEqual compares a file's extended attributes with those recorded in an object's metadata.
+func (stc *Cloner) xattrsEqual(hoo *s3.HeadObjectOutput, pathname, key string) bool {
+	expected, err := stc.fileXattrMetadata(pathname)
+	if err != nil {
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "unreadable xattrs", Error: err.Error()}, "Unable to read extended attributes of %s; will resync: %v\n", pathname, err)
+		return false
+	}
+
+	var mismatched []string
+	for name, value := range expected {
+		if hoo.Metadata[name] != value {
+			mismatched = append(mismatched, strings.TrimPrefix(name, xattrMetadataPrefix))
+		}
+	}
+
+	for name := range hoo.Metadata {
+		if _, found := expected[name]; strings.HasPrefix(name, xattrMetadataPrefix) && !found {
+			mismatched = append(mismatched, strings.TrimPrefix(name, xattrMetadataPrefix))
+		}
+	}
+
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		stc.logf(stc.stderr, levelInfo, logEvent{Event: eventResync, Path: pathname, Key: key, Reason: "xattr mismatch"}, "Extended attribute mismatch: s3://%s/%s and %s differ in %s; will resync\n", stc.bucket, key, pathname, strings.Join(mismatched, ", "))
+		return false
+	}
+
+	return true
+}
+
+// applyXattrs re-applies the extended attributes recorded in an object's metadata to a restored
+// file or directory.
+func (stc *Cloner) applyXattrs(pathname, key string, metadata map[string]string) {
+	for metadataKey, encoded := range metadata {
+		if !strings.HasPrefix(metadataKey, xattrMetadataPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(metadataKey, xattrMetadataPrefix)
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Reason: "invalid " + metadataKey}, "Invalid base64 value for %s for s3://%s/%s: %s\n", metadataKey, stc.bucket, key, encoded)
+			continue
+		}
+
+		if err = writeXattr(pathname, name, value); err != nil {
+			stc.fail(logEvent{Path: pathname, Key: key, Error: err.Error()}, "Unable to set extended attribute %s on %s: %v\n", name, pathname, err)
+		}
+	}
+}
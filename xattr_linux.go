@@ -0,0 +1,71 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrsSupported indicates whether extended attributes can be read and written on this platform.
+const xattrsSupported = true
+
+// readXattrs returns the extended attributes of a file, following symbolic links. File systems
+// without extended attribute support are treated as having none.
+func readXattrs(pathname string) (map[string][]byte, error) {
+	names, err := xattrSyscall(func(dest []byte) (int, error) { return unix.Listxattr(pathname, dest) })
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string][]byte)
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		value, err := xattrSyscall(func(dest []byte) (int, error) { return unix.Getxattr(pathname, string(name), dest) })
+		if errors.Is(err, unix.ENODATA) {
+			// Removed since it was listed.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		xattrs[string(name)] = value
+	}
+
+	return xattrs, nil
+}
+
+// writeXattr sets an extended attribute on a file, following symbolic links.
+func writeXattr(pathname, name string, value []byte) error {
+	return unix.Setxattr(pathname, name, value, 0)
+}
+
+// xattrSyscall calls an xattr system call that fills in a buffer, first with an empty buffer to
+// find the size needed. This is retried if the value grows between the two calls.
+func xattrSyscall(call func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return []byte{}, nil
+		}
+
+		dest := make([]byte, size)
+		size, err = call(dest)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		return dest[:size], nil
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package s3treeclone
+
+import "errors"
+
+// xattrsSupported indicates whether extended attributes can be read and written on this platform.
+const xattrsSupported = false
+
+var errXattrsUnsupported = errors.New("Extended attributes are not supported on this platform")
+
+func readXattrs(pathname string) (map[string][]byte, error) {
+	return nil, errXattrsUnsupported
+}
+
+func writeXattr(pathname, name string, value []byte) error {
+	return errXattrsUnsupported
+}
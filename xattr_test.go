@@ -0,0 +1,107 @@
+package s3treeclone
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStorableXattrName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		expected bool
+	}{
+		{"user.comment", true},
+		{"security.selinux", true},
+		{"security.capability", true},
+		{"trusted.overlay.opaque", true},
+		{"user.mime_type-v2", true},
+		{"system.posix_acl_access", false},
+		{"user.CamelCase", false},
+		{"user.with space", false},
+		{"other.name", false},
+	} {
+		if result := storableXattrName(tc.name); result != tc.expected {
+			t.Errorf("storableXattrName(%#v): expected %v, got %v", tc.name, tc.expected, result)
+		}
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "test-xattrs-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := tmpDir + "/src"
+	err = os.Mkdir(srcDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", srcDir, err)
+	}
+
+	err = ioutil.WriteFile(srcDir+"/hello.txt", []byte("hello"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file %s/hello.txt: %v", srcDir, err)
+	}
+
+	// Not every platform and file system supports user extended attributes.
+	binary := []byte{0x01, 0x00, 0x00, 0x02, 0xff}
+	if err = writeXattr(srcDir+"/hello.txt", "user.comment", []byte("greeting")); err != nil {
+		t.Skipf("Extended attributes are not supported: %v", err)
+	}
+
+	if err = writeXattr(srcDir+"/hello.txt", "user.binary", binary); err != nil {
+		t.Fatalf("Failed to set user.binary on %s/hello.txt: %v", srcDir, err)
+	}
+
+	client := newS3TestClient()
+	bucket := client.createBucket("hello")
+	runExpect(t, []string{"-xattrs", srcDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	metadata := bucket.Objects["hello.txt"].Metadata
+	if value := metadata["file-xattr-user.comment"]; value != base64.StdEncoding.EncodeToString([]byte("greeting")) {
+		t.Errorf("Expected file-xattr-user.comment to be base64 of \"greeting\", got %#v", value)
+	}
+
+	if value := metadata["file-xattr-user.binary"]; value != base64.StdEncoding.EncodeToString(binary) {
+		t.Errorf("Expected file-xattr-user.binary to be base64 of %v, got %#v", binary, value)
+	}
+
+	// Unchanged attributes match; a changed one causes a resync.
+	runExpect(t, []string{"-xattrs", srcDir + "/", "s3://hello"}, client, 0, nil, []byte("Objects uploaded:    0"))
+
+	if err = writeXattr(srcDir+"/hello.txt", "user.comment", []byte("farewell")); err != nil {
+		t.Fatalf("Failed to set user.comment on %s/hello.txt: %v", srcDir, err)
+	}
+
+	runExpect(t, []string{"-xattrs", srcDir + "/", "s3://hello"}, client, 0, nil, []byte("Extended attribute mismatch: s3://hello/hello.txt and "+srcDir+"/hello.txt differ in user.comment; will resync"))
+
+	// Without -xattrs, they're neither stored nor compared.
+	client = newS3TestClient()
+	bucket = client.createBucket("hello")
+	runExpect(t, []string{srcDir + "/", "s3://hello"}, client, 0, nil, nil)
+
+	for key := range bucket.Objects["hello.txt"].Metadata {
+		if key == "file-xattr-user.comment" || key == "file-xattr-user.binary" {
+			t.Errorf("Unexpected metadata %s without -xattrs", key)
+		}
+	}
+
+	// Restoring reapplies them.
+	client = newS3TestClient()
+	client.createBucket("hello")
+	runExpect(t, []string{"-xattrs", srcDir + "/", "s3://hello"}, client, 0, nil, nil)
+	runExpect(t, []string{"-restore", "-xattrs", "s3://hello", tmpDir + "/dest"}, client, 0, nil, nil)
+
+	xattrs, err := readXattrs(tmpDir + "/dest/hello.txt")
+	if err != nil {
+		t.Fatalf("Failed to read extended attributes of %s/dest/hello.txt: %v", tmpDir, err)
+	}
+
+	if !bytes.Equal(xattrs["user.comment"], []byte("farewell")) || !bytes.Equal(xattrs["user.binary"], binary) {
+		t.Errorf("Expected restored extended attributes to match, got %v", xattrs)
+	}
+}